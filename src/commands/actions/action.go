@@ -3,8 +3,10 @@ package actions
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
 	"github.com/spf13/afero"
 
 	"github.com/go-openapi/runtime"
@@ -58,12 +60,12 @@ func New(agentConfig *config.AgentConfig, stepType models.StepType, args []strin
 		models.StepTypeDhcpLeaseAllocate:          {&dhcpLeases{args: args}},
 		models.StepTypeDomainResolution:           {&domainResolution{args: args}},
 		models.StepTypeContainerImageAvailability: {&imageAvailability{args: args, agentConfig: agentConfig}},
-		models.StepTypeStopInstallation:           {&stopInstallation{args: args}},
+		models.StepTypeStopInstallation:           {&stopInstallation{args: args, filesystem: afero.NewOsFs(), executePrivileged: util.ExecutePrivileged, now: time.Now}},
 		models.StepTypeLogsGather:                 {&logsGather{args: args, agentConfig: agentConfig}},
 		models.StepTypeInstall:                    {&install{args: args, filesystem: afero.NewOsFs(), agentConfig: agentConfig, birthTimeFn: defaultBirthTimeFn}},
 		models.StepTypeUpgradeAgent:               {&upgradeAgent{args: args}},
 		models.StepTypeDownloadBootArtifacts:      {&downloadBootArtifacts{args: args, agentConfig: agentConfig}},
-		models.StepTypeRebootForReclaim:           {&rebootForReclaim{args: args}},
+		models.StepTypeRebootForReclaim:           {&rebootForReclaim{args: args, execute: util.Execute}},
 		models.StepTypeVerifyVips:                 {&vipsVerifier{agentConfig: agentConfig, args: args}},
 	}
 
@@ -0,0 +1,363 @@
+package actions
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	stagingFileSuffix  string = ".staging"
+	backupFileSuffix   string = ".backup"
+	previousFileSuffix string = ".previous"
+	manifestFileName   string = "manifest.json"
+	ziplSuccessMessage string = "Done."
+
+	// The previous installation is kept as a fallback entry with a lower version, so that the boot
+	// loader sorts it after the discovery entry and never picks it as the default
+	previousBootLoaderConfigFileName string = "/00-assisted-discovery-previous.conf"
+	previousBootLoaderEntryVersion   string = "998"
+)
+
+// bootArtifact is the size and checksum of an installed boot artifact.
+type bootArtifact struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// bootArtifactsManifest is written next to the installed kernel and initrd, keyed by file name, so
+// that the installation can be verified again right before rebooting into it.
+type bootArtifactsManifest map[string]bootArtifact
+
+// stagedFile is a file that is written under a temporary name and renamed into place once every
+// file of the installation has been written and verified.
+type stagedFile struct {
+	dst    string
+	staged string
+	backup string
+	// committed is set once staged was renamed to dst, and hasBackup if a previous dst was kept
+	committed bool
+	hasBackup bool
+}
+
+func newStagedFile(dst string) *stagedFile {
+	return &stagedFile{dst: dst, staged: dst + stagingFileSuffix, backup: dst + backupFileSuffix}
+}
+
+// installBootArtifacts installs the kernel, initrd and boot loader entry found in srcFolder into the
+// boot folder of the host. All the files are first written under temporary names, synced and
+// verified, and only then renamed into place, the boot loader entry last. If any step fails the
+// files of the previous installation, if there was one, are restored. Once the installation
+// succeeded the previous one is kept as a non default fallback entry, replacing the fallback of the
+// installation before it.
+func installBootArtifacts(srcFolder, hostFsMountDir string) error {
+	mountedArtifactsFolder := getMountedArtifactsFolder(hostFsMountDir)
+	mountedBootLoaderFolder := getMountedBootLoaderFolder(hostFsMountDir)
+
+	kernel := newStagedFile(path.Join(mountedArtifactsFolder, kernelFile))
+	initrd := newStagedFile(path.Join(mountedArtifactsFolder, initrdFile))
+	manifest := newStagedFile(path.Join(mountedArtifactsFolder, manifestFileName))
+	entry := newStagedFile(path.Join(mountedBootLoaderFolder, bootLoaderConfigFileName))
+	// The boot loader entry must be the last one so that it never points to missing files
+	files := []*stagedFile{kernel, initrd, manifest, entry}
+
+	err := stageBootArtifacts(srcFolder, kernel, initrd, manifest, entry)
+	if err == nil {
+		err = commitStagedFiles(files)
+	}
+	if err != nil {
+		rollbackStagedFiles(files)
+		return err
+	}
+
+	for _, folder := range []string{mountedArtifactsFolder, mountedBootLoaderFolder} {
+		if err = syncFolder(folder); err != nil {
+			return err
+		}
+	}
+	if kernel.hasBackup && initrd.hasBackup && entry.hasBackup {
+		keepPreviousInstallation(kernel, initrd, entry)
+	}
+	for _, file := range files {
+		if file.hasBackup {
+			if err = os.Remove(file.backup); err != nil && !os.IsNotExist(err) {
+				log.WithError(err).Warnf("failed to remove backup file %s", file.backup)
+			}
+		}
+	}
+	return nil
+}
+
+// keepPreviousInstallation turns the backups of the kernel, initrd and boot loader entry of the
+// previous installation into the fallback entry. The new installation is already in place, so this
+// is best effort and errors are only logged.
+func keepPreviousInstallation(kernel, initrd, entry *stagedFile) {
+	previousEntry := path.Join(path.Dir(entry.dst), previousBootLoaderConfigFileName)
+	content, err := previousBootLoaderEntry(entry.backup)
+	if err != nil {
+		log.WithError(err).Warn("failed to keep the previous discovery boot entry")
+		return
+	}
+
+	// Remove the older fallback entry first so that it never points to the files replaced below
+	if err = os.Remove(previousEntry); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Warnf("failed to remove the previous discovery boot entry %s", previousEntry)
+		return
+	}
+	for _, file := range []*stagedFile{kernel, initrd} {
+		if err = os.Rename(file.backup, file.dst+previousFileSuffix); err != nil {
+			log.WithError(err).Warnf("failed to keep %s as %s", file.backup, file.dst+previousFileSuffix)
+			return
+		}
+	}
+	staged := previousEntry + stagingFileSuffix
+	if err = writeFileSync(staged, content); err == nil {
+		err = os.Rename(staged, previousEntry)
+	}
+	if err != nil {
+		_ = os.Remove(staged)
+		log.WithError(err).Warnf("failed to write the previous discovery boot entry %s", previousEntry)
+		return
+	}
+	for _, folder := range []string{path.Dir(kernel.dst), path.Dir(entry.dst)} {
+		if err = syncFolder(folder); err != nil {
+			log.WithError(err).Warn("failed to sync the previous discovery boot entry")
+		}
+	}
+}
+
+// previousBootLoaderEntry returns the boot loader entry in entryPath changed to boot the kernel and
+// initrd of the previous installation, with a lower version and a distinct title.
+func previousBootLoaderEntry(entryPath string) ([]byte, error) {
+	content, err := os.ReadFile(entryPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read boot loader entry %s: %w", entryPath, err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	for i, line := range lines {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), " ")
+		value = strings.TrimSpace(value)
+		switch key {
+		case "title":
+			lines[i] = fmt.Sprintf("title %s (previous)", value)
+		case "version":
+			lines[i] = "version " + previousBootLoaderEntryVersion
+		case "linux", "initrd":
+			lines[i] = fmt.Sprintf("%s %s%s", key, value, previousFileSuffix)
+		}
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+func stageBootArtifacts(srcFolder string, kernel, initrd, manifest, entry *stagedFile) error {
+	artifacts := bootArtifactsManifest{}
+	for _, file := range []*stagedFile{kernel, initrd, entry} {
+		src := path.Join(srcFolder, path.Base(file.dst))
+		if err := copyFile(src, file.staged); err != nil {
+			return fmt.Errorf("failed to copy file %s to %s: %w", src, file.staged, err)
+		}
+		expected, err := getBootArtifact(src)
+		if err != nil {
+			return err
+		}
+		if err = verifyBootArtifact(file.staged, expected); err != nil {
+			return err
+		}
+		if file != entry {
+			artifacts[path.Base(file.dst)] = expected
+		}
+	}
+
+	content, err := json.Marshal(artifacts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal boot artifacts manifest: %w", err)
+	}
+	if err = writeFileSync(manifest.staged, content); err != nil {
+		return err
+	}
+	return nil
+}
+
+func commitStagedFiles(files []*stagedFile) error {
+	for _, file := range files {
+		if _, err := os.Stat(file.dst); err == nil {
+			if err = os.Rename(file.dst, file.backup); err != nil {
+				return fmt.Errorf("failed to back up %s: %w", file.dst, err)
+			}
+			file.hasBackup = true
+		}
+		if err := os.Rename(file.staged, file.dst); err != nil {
+			return fmt.Errorf("failed to rename %s to %s: %w", file.staged, file.dst, err)
+		}
+		file.committed = true
+	}
+	return nil
+}
+
+// rollbackStagedFiles removes whatever was written by a failed installation and puts the files of
+// the previous installation back in place. It is best effort, errors are only logged.
+func rollbackStagedFiles(files []*stagedFile) {
+	// Restore in reverse order so that the boot loader entry is never left pointing to new files
+	for i := len(files) - 1; i >= 0; i-- {
+		file := files[i]
+		if file.committed {
+			if err := os.Remove(file.dst); err != nil && !os.IsNotExist(err) {
+				log.WithError(err).Warnf("failed to remove %s during rollback", file.dst)
+			}
+		}
+		if file.hasBackup {
+			if err := os.Rename(file.backup, file.dst); err != nil {
+				log.WithError(err).Errorf("failed to restore %s from %s during rollback", file.dst, file.backup)
+			}
+		}
+		if err := os.Remove(file.staged); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).Warnf("failed to remove staging file %s during rollback", file.staged)
+		}
+	}
+}
+
+// verifyBootEntry checks that the discovery boot loader entry on the host rooted at hostRoot is
+// complete: the entry references the kernel and initrd, and both match the sizes and checksums
+// recorded when they were installed.
+func verifyBootEntry(hostRoot string) error {
+	entryPath := path.Join(getMountedBootLoaderFolder(hostRoot), bootLoaderConfigFileName)
+	entry, err := parseBootLoaderEntry(entryPath)
+	if err != nil {
+		return err
+	}
+
+	manifestPath := path.Join(getMountedArtifactsFolder(hostRoot), manifestFileName)
+	content, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to read boot artifacts manifest %s: %w", manifestPath, err)
+	}
+	var manifest bootArtifactsManifest
+	if err = json.Unmarshal(content, &manifest); err != nil {
+		return fmt.Errorf("failed to parse boot artifacts manifest %s: %w", manifestPath, err)
+	}
+
+	for _, key := range []string{"linux", "initrd"} {
+		value := entry[key]
+		if value == "" {
+			return fmt.Errorf("boot loader entry %s has no %s", entryPath, key)
+		}
+		expected, ok := manifest[path.Base(value)]
+		if !ok {
+			return fmt.Errorf("boot loader entry %s references %s which is missing from the manifest", entryPath, value)
+		}
+		if err = verifyBootArtifact(path.Join(hostRoot, value), expected); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseBootLoaderEntry returns the keys of a boot loader specification entry with their values.
+func parseBootLoaderEntry(entryPath string) (map[string]string, error) {
+	file, err := os.Open(entryPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open boot loader entry %s: %w", entryPath, err)
+	}
+	defer file.Close()
+
+	entry := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		entry[key] = strings.TrimSpace(value)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read boot loader entry %s: %w", entryPath, err)
+	}
+	return entry, nil
+}
+
+func getBootArtifact(filePath string) (bootArtifact, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return bootArtifact{}, fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return bootArtifact{}, fmt.Errorf("failed to read file %s: %w", filePath, err)
+	}
+	return bootArtifact{Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+func verifyBootArtifact(filePath string, expected bootArtifact) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat file %s: %w", filePath, err)
+	}
+	if info.Size() != expected.Size {
+		return fmt.Errorf("file %s has size %d, expected %d", filePath, info.Size(), expected.Size)
+	}
+	actual, err := getBootArtifact(filePath)
+	if err != nil {
+		return err
+	}
+	if actual.SHA256 != expected.SHA256 {
+		return fmt.Errorf("checksum mismatch for %s, expected sha256 %s, got %s", filePath, expected.SHA256, actual.SHA256)
+	}
+	return nil
+}
+
+func writeFileSync(filePath string, content []byte) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	if _, err = file.Write(content); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", filePath, err)
+	}
+	if err = file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file %s: %w", filePath, err)
+	}
+	return nil
+}
+
+// syncFolder flushes the directory entries of folder, so that renames done in it survive a crash.
+func syncFolder(folder string) error {
+	dir, err := os.Open(folder)
+	if err != nil {
+		return fmt.Errorf("failed to open folder %s: %w", folder, err)
+	}
+	defer dir.Close()
+
+	if err = dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync folder %s: %w", folder, err)
+	}
+	return nil
+}
+
+// validateZiplOutput checks that the verbose output of zipl mentions the discovery kernel and ends
+// with the success message, so that a run that didn't install the discovery kernel isn't mistaken
+// for a successful one.
+func validateZiplOutput(output string) error {
+	kernelPath := path.Join(artifactsFolder, kernelFile)
+	if !strings.Contains(output, kernelPath) {
+		return fmt.Errorf("zipl output doesn't mention the kernel %s: %s", kernelPath, output)
+	}
+	if !strings.Contains(output, ziplSuccessMessage) {
+		return fmt.Errorf("zipl didn't report success: %s", output)
+	}
+	return nil
+}
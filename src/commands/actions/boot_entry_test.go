@@ -0,0 +1,205 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("boot entry", func() {
+	var (
+		hostDir   string
+		srcDir    string
+		kernel    string
+		initrd    string
+		entryFile string
+	)
+
+	writeSources := func(kernelContent, initrdContent string) {
+		Expect(os.WriteFile(path.Join(srcDir, kernelFile), []byte(kernelContent), 0600)).To(Succeed())
+		Expect(os.WriteFile(path.Join(srcDir, initrdFile), []byte(initrdContent), 0600)).To(Succeed())
+		entry := fmt.Sprintf(bootLoaderConfigTemplate, "http://test.com/rootfs",
+			path.Join("/boot", artifactsFolder, kernelFile), path.Join("/boot", artifactsFolder, initrdFile))
+		Expect(os.WriteFile(path.Join(srcDir, bootLoaderConfigFileName), []byte(entry), 0600)).To(Succeed())
+	}
+
+	expectNoLeftovers := func() {
+		for _, file := range []string{kernel, initrd, entryFile} {
+			for _, suffix := range []string{stagingFileSuffix, backupFileSuffix} {
+				_, err := os.Stat(file + suffix)
+				Expect(os.IsNotExist(err)).To(BeTrue(), file+suffix)
+			}
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+		hostDir, err = os.MkdirTemp("", "boot-entry-host")
+		Expect(err).NotTo(HaveOccurred())
+		srcDir, err = os.MkdirTemp("", "boot-entry-src")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.MkdirAll(getMountedArtifactsFolder(hostDir), 0755)).To(Succeed())
+		Expect(os.MkdirAll(getMountedBootLoaderFolder(hostDir), 0755)).To(Succeed())
+		kernel = path.Join(getMountedArtifactsFolder(hostDir), kernelFile)
+		initrd = path.Join(getMountedArtifactsFolder(hostDir), initrdFile)
+		entryFile = path.Join(getMountedBootLoaderFolder(hostDir), bootLoaderConfigFileName)
+		writeSources("new kernel", "new initrd")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(hostDir)
+		os.RemoveAll(srcDir)
+	})
+
+	Context("installBootArtifacts", func() {
+		It("installs a verifiable boot entry", func() {
+			Expect(installBootArtifacts(srcDir, hostDir)).To(Succeed())
+			Expect(os.ReadFile(kernel)).To(Equal([]byte("new kernel")))
+			Expect(os.ReadFile(initrd)).To(Equal([]byte("new initrd")))
+			Expect(os.ReadFile(entryFile)).To(ContainSubstring(path.Join(artifactsFolder, kernelFile)))
+			Expect(verifyBootEntry(hostDir)).To(Succeed())
+			expectNoLeftovers()
+		})
+
+		It("replaces a previous installation", func() {
+			writeSources("old kernel", "old initrd")
+			Expect(installBootArtifacts(srcDir, hostDir)).To(Succeed())
+			writeSources("new kernel", "new initrd")
+			Expect(installBootArtifacts(srcDir, hostDir)).To(Succeed())
+			Expect(os.ReadFile(kernel)).To(Equal([]byte("new kernel")))
+			Expect(verifyBootEntry(hostDir)).To(Succeed())
+			expectNoLeftovers()
+		})
+
+		It("keeps the previous installation as a fallback entry", func() {
+			writeSources("old kernel", "old initrd")
+			Expect(installBootArtifacts(srcDir, hostDir)).To(Succeed())
+			writeSources("new kernel", "new initrd")
+			Expect(installBootArtifacts(srcDir, hostDir)).To(Succeed())
+
+			Expect(os.ReadFile(kernel + previousFileSuffix)).To(Equal([]byte("old kernel")))
+			Expect(os.ReadFile(initrd + previousFileSuffix)).To(Equal([]byte("old initrd")))
+			previousEntry, err := parseBootLoaderEntry(path.Join(getMountedBootLoaderFolder(hostDir), previousBootLoaderConfigFileName))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(previousEntry["title"]).To(Equal("Assisted Installer Discovery (previous)"))
+			Expect(previousEntry["version"]).To(Equal(previousBootLoaderEntryVersion))
+			Expect(previousEntry["linux"]).To(Equal(path.Join("/boot", artifactsFolder, kernelFile) + previousFileSuffix))
+			Expect(previousEntry["initrd"]).To(Equal(path.Join("/boot", artifactsFolder, initrdFile) + previousFileSuffix))
+			Expect(previousEntry["options"]).To(ContainSubstring("coreos.live.rootfs_url"))
+			Expect(verifyBootEntry(hostDir)).To(Succeed())
+		})
+
+		It("replaces the fallback entry only on the next successful installation", func() {
+			for _, version := range []string{"first", "second"} {
+				writeSources(version+" kernel", version+" initrd")
+				Expect(installBootArtifacts(srcDir, hostDir)).To(Succeed())
+			}
+			writeSources("third kernel", "third initrd")
+			Expect(os.Remove(path.Join(srcDir, bootLoaderConfigFileName))).To(Succeed())
+			Expect(installBootArtifacts(srcDir, hostDir)).NotTo(Succeed())
+			Expect(os.ReadFile(kernel + previousFileSuffix)).To(Equal([]byte("first kernel")))
+
+			writeSources("third kernel", "third initrd")
+			Expect(installBootArtifacts(srcDir, hostDir)).To(Succeed())
+			Expect(os.ReadFile(kernel)).To(Equal([]byte("third kernel")))
+			Expect(os.ReadFile(kernel + previousFileSuffix)).To(Equal([]byte("second kernel")))
+			Expect(os.ReadFile(initrd + previousFileSuffix)).To(Equal([]byte("second initrd")))
+			expectNoLeftovers()
+		})
+
+		It("leaves the previous installation untouched when staging fails", func() {
+			writeSources("old kernel", "old initrd")
+			Expect(installBootArtifacts(srcDir, hostDir)).To(Succeed())
+			writeSources("new kernel", "new initrd")
+			Expect(os.Remove(path.Join(srcDir, bootLoaderConfigFileName))).To(Succeed())
+
+			Expect(installBootArtifacts(srcDir, hostDir)).NotTo(Succeed())
+			Expect(os.ReadFile(kernel)).To(Equal([]byte("old kernel")))
+			Expect(os.ReadFile(initrd)).To(Equal([]byte("old initrd")))
+			Expect(verifyBootEntry(hostDir)).To(Succeed())
+			expectNoLeftovers()
+		})
+
+		It("rolls back to the previous installation when renaming fails", func() {
+			writeSources("old kernel", "old initrd")
+			Expect(installBootArtifacts(srcDir, hostDir)).To(Succeed())
+			writeSources("new kernel", "new initrd")
+			// A non empty directory in the way of the entry backup makes the last rename fail
+			Expect(os.MkdirAll(path.Join(entryFile+backupFileSuffix, "blocker"), 0755)).To(Succeed())
+
+			Expect(installBootArtifacts(srcDir, hostDir)).NotTo(Succeed())
+			Expect(os.ReadFile(kernel)).To(Equal([]byte("old kernel")))
+			Expect(os.ReadFile(initrd)).To(Equal([]byte("old initrd")))
+			Expect(verifyBootEntry(hostDir)).To(Succeed())
+		})
+
+		It("removes the new files when there is no previous installation", func() {
+			Expect(os.MkdirAll(path.Join(entryFile+stagingFileSuffix, "blocker"), 0755)).To(Succeed())
+
+			Expect(installBootArtifacts(srcDir, hostDir)).NotTo(Succeed())
+			for _, file := range []string{kernel, initrd, entryFile} {
+				_, err := os.Stat(file)
+				Expect(os.IsNotExist(err)).To(BeTrue(), file)
+			}
+		})
+	})
+
+	Context("verifyBootEntry", func() {
+		BeforeEach(func() {
+			Expect(installBootArtifacts(srcDir, hostDir)).To(Succeed())
+		})
+
+		It("fails when the entry is missing", func() {
+			Expect(os.Remove(entryFile)).To(Succeed())
+			Expect(verifyBootEntry(hostDir)).To(MatchError(ContainSubstring("failed to open boot loader entry")))
+		})
+
+		It("fails when the manifest is missing", func() {
+			Expect(os.Remove(path.Join(getMountedArtifactsFolder(hostDir), manifestFileName))).To(Succeed())
+			Expect(verifyBootEntry(hostDir)).To(MatchError(ContainSubstring("manifest")))
+		})
+
+		It("fails when the kernel is missing", func() {
+			Expect(os.Remove(kernel)).To(Succeed())
+			Expect(verifyBootEntry(hostDir)).To(MatchError(ContainSubstring("failed to stat file")))
+		})
+
+		It("fails when the initrd is truncated", func() {
+			Expect(os.WriteFile(initrd, []byte("new"), 0600)).To(Succeed())
+			Expect(verifyBootEntry(hostDir)).To(MatchError(ContainSubstring("has size 3")))
+		})
+
+		It("fails when the kernel is corrupt", func() {
+			Expect(os.WriteFile(kernel, []byte("bad kernel"), 0600)).To(Succeed())
+			Expect(verifyBootEntry(hostDir)).To(MatchError(ContainSubstring("checksum mismatch")))
+		})
+
+		It("fails when the entry has no initrd", func() {
+			Expect(os.WriteFile(entryFile, []byte("title test\nlinux /boot/discovery/vmlinuz\n"), 0600)).To(Succeed())
+			Expect(verifyBootEntry(hostDir)).To(MatchError(ContainSubstring("has no initrd")))
+		})
+	})
+
+	Context("validateZiplOutput", func() {
+		It("accepts a successful run", func() {
+			output := "Building bootmap in '/boot'\n" +
+				"Adding IPL section 'ipl' (default)\n" +
+				"  initial ramdisk...: /discovery/initrd\n" +
+				"  kernel image......: /discovery/vmlinuz\n" +
+				"Preparing boot device: dasda (0120).\n" +
+				"Done.\n"
+			Expect(validateZiplOutput(output)).To(Succeed())
+		})
+
+		It("fails when the kernel isn't mentioned", func() {
+			Expect(validateZiplOutput("kernel image......: /vmlinuz\nDone.\n")).NotTo(Succeed())
+		})
+
+		It("fails when zipl didn't finish", func() {
+			Expect(validateZiplOutput("kernel image......: /discovery/vmlinuz\n")).NotTo(Succeed())
+		})
+	})
+})
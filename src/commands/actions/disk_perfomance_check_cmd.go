@@ -1,8 +1,8 @@
 package actions
 
 import (
-	"fmt"
 	"strconv"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
@@ -30,24 +30,36 @@ func (a *diskPerfCheck) Validate() error {
 	return nil
 }
 
+const diskPerfContainerName = "disk_performance"
+
 func (a *diskPerfCheck) Command() string {
-	return "sh"
+	return "timeout"
 }
 
 func (a *diskPerfCheck) Args() []string {
-	arguments := []string{
-		"-c",
-		"id=`podman ps --quiet --filter \"name=disk_performance\"` ; " +
-			"test ! -z \"$id\" || " +
-			fmt.Sprintf("timeout %s ", a.args[1]) +
-			"podman run --privileged --rm --quiet -v /dev:/dev:rw -v /var/log:/var/log -v /run/systemd/journal/socket:/run/systemd/journal/socket " +
-			"--name disk_performance " +
-			a.agentConfig.AgentVersion + " disk_speed_check '" +
-			a.args[0] + "'",
+	cmd := &podmanRun{
+		flags: []string{"--privileged", "--rm", "--quiet"},
+		mounts: []volumeMount{
+			sameVolumeMount("/dev", "rw"),
+			sameVolumeMount("/var/log"),
+			sameVolumeMount("/run/systemd/journal/socket"),
+		},
+		name:           diskPerfContainerName,
+		image:          a.agentConfig.AgentVersion,
+		entrypointArgs: []string{"disk_speed_check", a.args[0]},
 	}
-	return arguments
+	return append([]string{a.args[1], podman}, cmd.argv()...)
 }
 
 func (a *diskPerfCheck) Run() (stdout, stderr string, exitCode int) {
+	// A previous check may still be running, in which case this one is skipped
+	stdout, stderr, exitCode = util.ExecutePrivileged(podman, "ps", "--quiet", "--filter", "name="+diskPerfContainerName)
+	if exitCode != 0 {
+		return stdout, stderr, exitCode
+	}
+	if strings.TrimSpace(stdout) != "" {
+		log.Infof("%s container is already running", diskPerfContainerName)
+		return "", "", 0
+	}
 	return util.ExecutePrivileged(a.Command(), a.Args()...)
 }
@@ -19,20 +19,29 @@ var _ = Describe("dhcp leases", func() {
 	})
 
 	It("disk performance", func() {
-		action, err := New(&config.AgentConfig{}, models.StepTypeInstallationDiskSpeedCheck, []string{param, timeout})
+		agentConfig := &config.AgentConfig{}
+		agentConfig.AgentVersion = "quay.io/edge-infrastructure/assisted-installer-agent:latest"
+		action, err := New(agentConfig, models.StepTypeInstallationDiskSpeedCheck, []string{param, timeout})
 		Expect(err).NotTo(HaveOccurred())
 
 		args := action.Args()
 		command := action.Command()
-		Expect(command).To(Equal("sh"))
+		Expect(command).To(Equal("timeout"))
 		paths := []string{
 			"/var/log",
 			"/run/systemd/journal/socket",
 			"/dev",
 		}
 		verifyPaths(strings.Join(args, " "), paths)
-		Expect(args[len(args)-1]).To(ContainSubstring(param))
-		Expect(args[len(args)-1]).To(ContainSubstring(timeout))
+		Expect(args).To(Equal([]string{
+			timeout, "podman", "run", "--privileged", "--rm", "--quiet",
+			"-v", "/dev:/dev:rw",
+			"-v", "/var/log:/var/log",
+			"-v", "/run/systemd/journal/socket:/run/systemd/journal/socket",
+			"--name", "disk_performance",
+			"quay.io/edge-infrastructure/assisted-installer-agent:latest",
+			"disk_speed_check", param,
+		}))
 
 	})
 
@@ -1,15 +1,23 @@
 package actions
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"regexp"
 	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -25,7 +33,19 @@ type downloadBootArtifacts struct {
 }
 
 func (a *downloadBootArtifacts) Validate() error {
-	return ValidateCommon("download boot artifacts", 1, a.args, &models.DownloadBootArtifactsRequest{})
+	if err := ValidateCommon("download boot artifacts", 1, a.args, &models.DownloadBootArtifactsRequest{}); err != nil {
+		return err
+	}
+	var req downloadBootArtifactsRequest
+	if err := json.Unmarshal([]byte(a.args[0]), &req); err != nil {
+		return err
+	}
+	for _, checksum := range []string{req.KernelSHA256, req.InitrdSHA256} {
+		if checksum != "" && !sha256Regex.MatchString(checksum) {
+			return fmt.Errorf("invalid sha256 checksum %q", checksum)
+		}
+	}
+	return nil
 }
 
 func (a *downloadBootArtifacts) Run() (stdout, stderr string, exitCode int) {
@@ -62,6 +82,11 @@ func getMountedBootLoaderFolder(hostFsMountDir string) string {
 const (
 	defaultRetryAmount                   = 5
 	defaultRetryDelay                    = 1 * time.Minute
+	defaultDownloadRetryDelay            = 5 * time.Second
+	defaultDownloadStallTimeout          = 2 * time.Minute
+	partialFileSuffix             string = ".part"
+	etagFileSuffix                string = ".etag"
+	checksumFileSuffix            string = ".sha256"
 	artifactsFolder               string = "/discovery"
 	bootLoaderFolder              string = "/loader/entries"
 	tempBootArtifactsFolder       string = "/tmp/boot"
@@ -80,8 +105,20 @@ linux %s
 initrd %s`
 )
 
+var sha256Regex = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// downloadBootArtifactsRequest is the download boot artifacts request model with the optional
+// checksums of the artifacts. Services that don't know about them simply don't send them.
+type downloadBootArtifactsRequest struct {
+	models.DownloadBootArtifactsRequest
+
+	// Hex encoded SHA-256 digests of the kernel and initrd.
+	KernelSHA256 string `json:"kernel_sha256,omitempty"`
+	InitrdSHA256 string `json:"initrd_sha256,omitempty"`
+}
+
 func run(infraEnvId, downloaderRequestStr, caCertPath string) error {
-	var req models.DownloadBootArtifactsRequest
+	var req downloadBootArtifactsRequest
 	if err := json.Unmarshal([]byte(downloaderRequestStr), &req); err != nil {
 		return fmt.Errorf("failed unmarshalling download boot artifacts request: %w", err)
 	}
@@ -123,25 +160,21 @@ func run(infraEnvId, downloaderRequestStr, caCertPath string) error {
 	return nil
 }
 
-// bootArtifactsExist checks if the boot artifacts already exist in the host filesystem
+// bootArtifactsExist checks if complete and verified boot artifacts already exist in the host filesystem
 func bootArtifactsExist(hostFsMountDir string) bool {
-	kernelFilePath := path.Join(getMountedArtifactsFolder(hostFsMountDir), kernelFile)
-	initrdFilePath := path.Join(getMountedArtifactsFolder(hostFsMountDir), initrdFile)
-	bootLoaderConfigFilePath := path.Join(getMountedBootLoaderFolder(hostFsMountDir), bootLoaderConfigFileName)
-	_, err := os.Stat(kernelFilePath)
-	if err != nil {
-		return false
-	}
-	_, err = os.Stat(initrdFilePath)
-	if err != nil {
+	if err := verifyBootEntry(hostFsMountDir); err != nil {
+		log.Debugf("Boot artifacts need to be installed: %s", err.Error())
 		return false
 	}
-	_, err = os.Stat(bootLoaderConfigFilePath)
-	return err == nil
+	return true
 }
 
 func createHTTPClient(caCertPath string) (*http.Client, error) {
-	client := &http.Client{}
+	// The default transport has the proxy settings and the dial, TLS handshake and idle timeouts.
+	// There is no overall timeout because the artifacts are big, stalled downloads are detected
+	// by the artifact downloader instead.
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = defaultDownloadStallTimeout
 	if caCertPath != "" {
 		caCert, err := os.ReadFile(caCertPath)
 		if err != nil {
@@ -152,58 +185,313 @@ func createHTTPClient(caCertPath string) (*http.Client, error) {
 			return nil, fmt.Errorf("failed to append cert %s, %s", caCertPath, err)
 		}
 
-		t := &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs:    caCertPool,
-				MinVersion: tls.VersionTLS12,
-			},
+		t.TLSClientConfig = &tls.Config{
+			RootCAs:    caCertPool,
+			MinVersion: tls.VersionTLS12,
 		}
-		client.Transport = t
 	}
-	return client, nil
+	return &http.Client{Transport: t}, nil
 }
 
-func download(httpClient *http.Client, filePath, url string, retry int) error {
+// artifactDownloader streams boot artifacts to disk. Failed attempts are retried with an
+// exponential backoff and continue from the data already written, using HTTP range requests.
+// An attempt that receives no data for stallTimeout is aborted.
+type artifactDownloader struct {
+	client        *http.Client
+	retries       int
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+	stallTimeout  time.Duration
+}
+
+func newArtifactDownloader(client *http.Client) *artifactDownloader {
+	return &artifactDownloader{
+		client:        client,
+		retries:       defaultRetryAmount,
+		retryDelay:    defaultDownloadRetryDelay,
+		maxRetryDelay: defaultRetryDelay,
+		stallTimeout:  defaultDownloadStallTimeout,
+	}
+}
+
+// download downloads url into filePath. The data is written to a partial file next to filePath
+// which is renamed into place only once the download is complete and, when a checksum is known,
+// verified. If expectedSHA256 is empty the downloader looks for a "<url>.sha256" sidecar file.
+//
+// A partial file left by a previous run is resumed when it can be validated, either by the
+// checksum or by the ETag the server returned for it, which is sent back in an If-Range header
+// so that the server returns the whole artifact if it changed in the meantime.
+func (d *artifactDownloader) download(filePath, url, expectedSHA256 string) error {
+	partialPath := filePath + partialFileSuffix
+	etagPath := partialPath + etagFileSuffix
+
+	if expectedSHA256 == "" {
+		expectedSHA256 = d.getSidecarChecksum(url)
+	}
+
+	if expectedSHA256 == "" && readETag(etagPath) == "" {
+		// Nothing to tell whether a partial file from a previous run belongs to this version of
+		// the artifact
+		if err := removePartialFile(partialPath); err != nil {
+			return err
+		}
+	}
+
 	var downloadErr error
-	var res *http.Response
-	for attempts := 0; attempts < retry; attempts++ {
-		res, downloadErr = httpClient.Get(url)
-		if downloadErr == nil && (res.StatusCode >= 200 && res.StatusCode < 300) {
+	delay := d.retryDelay
+	for attempt := 1; attempt <= d.retries; attempt++ {
+		if attempt > 1 {
+			time.Sleep(delay)
+			delay = min(delay*2, d.maxRetryDelay)
+		}
+
+		downloadErr = d.downloadAttempt(partialPath, url)
+		if downloadErr == nil && expectedSHA256 != "" {
+			if downloadErr = verifySHA256(partialPath, expectedSHA256); downloadErr != nil {
+				// Resuming would only append to the corrupt data, so start over
+				_ = removePartialFile(partialPath)
+			}
+		}
+		if downloadErr == nil {
 			break
 		}
-		downloadErr = fmt.Errorf("failed downloading boot artifact from %s, status code received: %d, attempt %d/%d, download error: %w",
-			url, res.StatusCode, attempts, retry, downloadErr)
-		log.Warn(downloadErr.Error())
-		time.Sleep(defaultRetryDelay)
+		log.Warnf("failed downloading boot artifact from %s, attempt %d/%d: %s", url, attempt, d.retries, downloadErr.Error())
 	}
 
 	if downloadErr != nil {
 		return fmt.Errorf("failed getting %s: %w", url, downloadErr)
 	}
+
+	if err := os.Rename(partialPath, filePath); err != nil {
+		return fmt.Errorf("failed renaming %s to %s: %w", partialPath, filePath, err)
+	}
+	if err := os.Remove(etagPath); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Warnf("failed removing %s", etagPath)
+	}
+	return nil
+}
+
+// removePartialFile removes a partial download together with its ETag
+func removePartialFile(partialPath string) error {
+	for _, p := range []string{partialPath, partialPath + etagFileSuffix} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed removing stale partial file %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// readETag returns the ETag stored in etagPath, or an empty string when there is none
+func readETag(etagPath string) string {
+	b, err := os.ReadFile(etagPath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// saveETag stores the ETag of a response next to the partial file so that a later run can
+// resume it. Weak ETags can't be used with If-Range, so they are treated as no ETag.
+func saveETag(etagPath, etag string) {
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		if err := os.Remove(etagPath); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).Warnf("failed removing %s", etagPath)
+		}
+		return
+	}
+	if err := os.WriteFile(etagPath, []byte(etag), 0644); err != nil { //nolint:gosec
+		log.WithError(err).Warnf("failed writing %s", etagPath)
+	}
+}
+
+// stallReader resets timer every time data is read, so that the timer only fires when the
+// download makes no progress
+type stallReader struct {
+	reader  io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+var errDownloadStalled = errors.New("download stalled")
+
+// downloadAttempt makes a single request for url and appends the response to partialPath. When
+// partialPath already has data, only the missing range is requested.
+func (d *artifactDownloader) downloadAttempt(partialPath, url string) error {
+	var offset int64
+	if info, err := os.Stat(partialPath); err == nil {
+		offset = info.Size()
+	}
+	etagPath := partialPath + etagFileSuffix
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed creating request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if etag := readETag(etagPath); etag != "" {
+			req.Header.Set("If-Range", etag)
+		}
+	}
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case offset > 0 && res.StatusCode == http.StatusPartialContent:
+		start, _, err := parseContentRange(res.Header.Get("Content-Range"))
+		if err != nil || start != offset {
+			_ = os.Remove(partialPath)
+			return fmt.Errorf("server returned unexpected content range %q for offset %d", res.Header.Get("Content-Range"), offset)
+		}
+		flags |= os.O_APPEND
+	case offset > 0 && res.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The previous attempt may have written everything and failed afterwards
+		if _, size, err := parseContentRange(res.Header.Get("Content-Range")); err == nil && size == offset {
+			return nil
+		}
+		_ = os.Remove(partialPath)
+		return fmt.Errorf("server rejected range starting at %d", offset)
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		// Either a fresh download or the server ignored the range request
+		flags |= os.O_TRUNC
+	default:
+		return fmt.Errorf("status code received: %d", res.StatusCode)
+	}
+
+	file, err := os.OpenFile(partialPath, flags, 0644) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("failed opening file %s: %w", partialPath, err)
+	}
+	defer file.Close()
+	saveETag(etagPath, res.Header.Get("ETag"))
+
+	timer := time.AfterFunc(d.stallTimeout, func() { cancel(errDownloadStalled) })
+	defer timer.Stop()
+	if _, err = io.Copy(file, &stallReader{reader: res.Body, timer: timer, timeout: d.stallTimeout}); err != nil {
+		if cause := context.Cause(ctx); cause != nil {
+			err = cause
+		}
+		return fmt.Errorf("failed to read body: %w", err)
+	}
+	if err = file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file %s: %w", partialPath, err)
+	}
+	return nil
+}
+
+// getSidecarChecksum returns the SHA-256 published next to url, or an empty string when there is
+// none. The sidecar is optional, so failures are only logged.
+//
+// The suffix is appended to the path and the query string is kept as is, so that URLs that pass
+// parameters or an API key in the query work. Presigned URLs sign the path, so for those the
+// sidecar request is rejected and the download is only verified by a checksum from the request.
+func (d *artifactDownloader) getSidecarChecksum(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		log.WithError(err).Warnf("failed parsing url %s", rawURL)
+		return ""
+	}
+	u.Path += checksumFileSuffix
+	if u.RawPath != "" {
+		u.RawPath += checksumFileSuffix
+	}
+
+	res, err := d.client.Get(u.String())
+	if err != nil {
+		log.WithError(err).Debugf("failed getting checksum file for %s", rawURL)
+		return ""
+	}
 	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		log.Debugf("no checksum file for %s, status code received: %d", rawURL, res.StatusCode)
+		return ""
+	}
 
-	body, err := io.ReadAll(res.Body)
+	// The file has the format of sha256sum output, "<digest>  <file name>", or just the digest
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1024))
 	if err != nil {
-		return fmt.Errorf("failed to read body while getting url %s: %w", url, err)
+		log.WithError(err).Warnf("failed reading checksum file for %s", rawURL)
+		return ""
+	}
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 || !sha256Regex.MatchString(fields[0]) {
+		log.Warnf("ignoring malformed checksum file for %s", rawURL)
+		return ""
 	}
-	err = os.WriteFile(filePath, body, 0644) //nolint:gosec
+	return strings.ToLower(fields[0])
+}
+
+func verifySHA256(filePath, expected string) error {
+	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("failed writing file %s: %w", filePath, err)
+		return fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return fmt.Errorf("failed to read file %s: %w", filePath, err)
+	}
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("checksum mismatch for %s, expected sha256 %s, got %s", filePath, expected, actual)
 	}
 	return nil
 }
 
-func downloadArtifactsToTempFolder(req models.DownloadBootArtifactsRequest, caCertPath string) error {
+// parseContentRange parses a Content-Range header such as "bytes 100-199/200" or "bytes */200".
+// The start is -1 for the latter form, and the size is -1 when the server doesn't know it.
+func parseContentRange(header string) (start, size int64, err error) {
+	start, size = -1, -1
+	rangeSpec, found := strings.CutPrefix(header, "bytes ")
+	if !found {
+		return start, size, fmt.Errorf("invalid content range %q", header)
+	}
+	byteRange, totalSize, found := strings.Cut(rangeSpec, "/")
+	if !found {
+		return start, size, fmt.Errorf("invalid content range %q", header)
+	}
+	if totalSize != "*" {
+		if size, err = strconv.ParseInt(totalSize, 10, 64); err != nil {
+			return -1, -1, fmt.Errorf("invalid content range %q: %w", header, err)
+		}
+	}
+	if byteRange != "*" {
+		first, _, _ := strings.Cut(byteRange, "-")
+		if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+			return -1, -1, fmt.Errorf("invalid content range %q: %w", header, err)
+		}
+	}
+	return start, size, nil
+}
+
+func downloadArtifactsToTempFolder(req downloadBootArtifactsRequest, caCertPath string) error {
 	httpClient, err := createHTTPClient(caCertPath)
 	if err != nil {
 		return fmt.Errorf("failed creating secure assisted service client: %s", err.Error())
 	}
+	downloader := newArtifactDownloader(httpClient)
 
-	if err := download(httpClient, path.Join(tempBootArtifactsFolder, kernelFile), *req.KernelURL, defaultRetryAmount); err != nil {
+	if err := downloader.download(path.Join(tempBootArtifactsFolder, kernelFile), *req.KernelURL, req.KernelSHA256); err != nil {
 		return fmt.Errorf("failed downloading kernel to host: %s", err.Error())
 	}
 
-	if err := download(httpClient, path.Join(tempBootArtifactsFolder, initrdFile), *req.InitrdURL, defaultRetryAmount); err != nil {
+	if err := downloader.download(path.Join(tempBootArtifactsFolder, initrdFile), *req.InitrdURL, req.InitrdSHA256); err != nil {
 		return fmt.Errorf("failed downloading initrd to host: %s", err.Error())
 	}
 	return nil
@@ -359,16 +647,8 @@ func reclaimBootFolderSpace() error {
 }
 
 func copyFilesToBootFolder(hostFsMountDir string) error {
-	mountedArtifactsFolder := getMountedArtifactsFolder(hostFsMountDir)
-	mountedBootLoaderFolder := getMountedBootLoaderFolder(hostFsMountDir)
-	if err := copyFile(path.Join(tempBootArtifactsFolder, kernelFile), path.Join(mountedArtifactsFolder, kernelFile)); err != nil {
-		return fmt.Errorf("failed to copy file %s to %s: %w", path.Join(tempBootArtifactsFolder, kernelFile), path.Join(mountedArtifactsFolder, kernelFile), err)
-	}
-	if err := copyFile(path.Join(tempBootArtifactsFolder, initrdFile), path.Join(mountedArtifactsFolder, initrdFile)); err != nil {
-		return fmt.Errorf("failed to copy file %s to %s: %w", path.Join(tempBootArtifactsFolder, initrdFile), path.Join(mountedArtifactsFolder, initrdFile), err)
-	}
-	if err := copyFile(path.Join(tempBootArtifactsFolder, bootLoaderConfigFileName), path.Join(mountedBootLoaderFolder, bootLoaderConfigFileName)); err != nil {
-		return fmt.Errorf("failed to copy file %s to %s: %w", path.Join(tempBootArtifactsFolder, bootLoaderConfigFileName), path.Join(mountedBootLoaderFolder, bootLoaderConfigFileName), err)
+	if err := installBootArtifacts(tempBootArtifactsFolder, hostFsMountDir); err != nil {
+		return err
 	}
 
 	log.Info("Successfully copied files to /boot folder.")
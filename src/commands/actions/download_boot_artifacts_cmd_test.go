@@ -1,13 +1,22 @@
 package actions
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"github.com/openshift/assisted-installer-agent/src/config"
 	"github.com/openshift/assisted-service/models"
@@ -45,6 +54,353 @@ var _ = Describe("download boot artifacts cmd", func() {
 		badParamsCommonTests(models.StepTypeDownloadBootArtifacts, []string{param})
 	})
 
+	It("succeeds when given checksums", func() {
+		param := "{\"initrd_url\":\"http://test.com/api/v2/pxe-initrd?api_key=123&arch=x86_64&version=4.10\"," +
+			"\"rootfs_url\":\"http://test.com/rootfs?arch=x86_64&version=4.10\"," +
+			"\"kernel_url\":\"http://test.com/kernel?arch=x86_64&version=4.10\"," +
+			"\"kernel_sha256\":\"" + strings.Repeat("a", 64) + "\"," +
+			"\"host_fs_mount_dir\":\"/host\"}"
+		_, err := New(&config.AgentConfig{}, models.StepTypeDownloadBootArtifacts, []string{param})
+		Expect(err).To(BeNil())
+	})
+
+	It("fails when given a malformed checksum", func() {
+		param := "{\"initrd_url\":\"http://test.com/api/v2/pxe-initrd?api_key=123&arch=x86_64&version=4.10\"," +
+			"\"rootfs_url\":\"http://test.com/rootfs?arch=x86_64&version=4.10\"," +
+			"\"kernel_url\":\"http://test.com/kernel?arch=x86_64&version=4.10\"," +
+			"\"initrd_sha256\":\"not-a-checksum\"," +
+			"\"host_fs_mount_dir\":\"/host\"}"
+		_, err := New(&config.AgentConfig{}, models.StepTypeDownloadBootArtifacts, []string{param})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("artifactDownloader", func() {
+	var (
+		tempDir    string
+		filePath   string
+		content    []byte
+		checksum   string
+		server     *httptest.Server
+		downloader *artifactDownloader
+		lock       sync.Mutex
+		requests   []*http.Request
+		sidecar    string
+		etag       string
+		handler    func(w http.ResponseWriter, r *http.Request)
+	)
+
+	serveContent := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "vmlinuz", time.Time{}, strings.NewReader(string(content)))
+	}
+
+	// dropConnection sends the headers of the full response but only part of the body, and then
+	// closes the connection
+	dropConnection := func(w http.ResponseWriter, written int) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(content[:written])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+
+	artifactRequests := func() []*http.Request {
+		lock.Lock()
+		defer lock.Unlock()
+		var ret []*http.Request
+		for _, r := range requests {
+			if !strings.HasSuffix(r.URL.Path, checksumFileSuffix) {
+				ret = append(ret, r)
+			}
+		}
+		return ret
+	}
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = os.MkdirTemp("", "artifact-downloader")
+		Expect(err).NotTo(HaveOccurred())
+		filePath = path.Join(tempDir, kernelFile)
+		content = []byte(strings.Repeat("kernel content ", 1000))
+		sum := sha256.Sum256(content)
+		checksum = hex.EncodeToString(sum[:])
+		requests = nil
+		sidecar = ""
+		etag = `"v1"`
+		handler = serveContent
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			requests = append(requests, r)
+			lock.Unlock()
+			if strings.HasSuffix(r.URL.Path, checksumFileSuffix) {
+				if sidecar == "" {
+					http.NotFound(w, r)
+					return
+				}
+				_, _ = w.Write([]byte(sidecar))
+				return
+			}
+			handler(w, r)
+		}))
+
+		client, err := createHTTPClient("")
+		Expect(err).NotTo(HaveOccurred())
+		downloader = newArtifactDownloader(client)
+		downloader.retryDelay = time.Millisecond
+		downloader.maxRetryDelay = time.Millisecond
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tempDir)
+	})
+
+	It("downloads the artifact", func() {
+		Expect(downloader.download(filePath, server.URL+"/kernel?arch=x86_64", "")).To(Succeed())
+		Expect(os.ReadFile(filePath)).To(Equal(content))
+		_, err := os.Stat(filePath + partialFileSuffix)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("fails without panicking when the server is unreachable", func() {
+		server.Close()
+		err := downloader.download(filePath, server.URL+"/kernel", "")
+		Expect(err).To(HaveOccurred())
+		_, err = os.Stat(filePath)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("retries on server errors", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if len(artifactRequests()) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			serveContent(w, r)
+		}
+		Expect(downloader.download(filePath, server.URL+"/kernel", "")).To(Succeed())
+		Expect(os.ReadFile(filePath)).To(Equal(content))
+		Expect(artifactRequests()).To(HaveLen(3))
+	})
+
+	It("fails after all the retries", func() {
+		handler = func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		err := downloader.download(filePath, server.URL+"/kernel", "")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("status code received: 500"))
+		Expect(artifactRequests()).To(HaveLen(defaultRetryAmount))
+	})
+
+	It("resumes an interrupted download", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if len(artifactRequests()) == 1 {
+				dropConnection(w, 4000)
+			}
+			serveContent(w, r)
+		}
+		Expect(downloader.download(filePath, server.URL+"/kernel", checksum)).To(Succeed())
+		Expect(os.ReadFile(filePath)).To(Equal(content))
+		reqs := artifactRequests()
+		Expect(reqs).To(HaveLen(2))
+		Expect(reqs[0].Header.Get("Range")).To(BeEmpty())
+		Expect(reqs[1].Header.Get("Range")).To(Equal("bytes=4000-"))
+	})
+
+	It("starts over when the server ignores the range", func() {
+		handler = func(w http.ResponseWriter, _ *http.Request) {
+			if len(artifactRequests()) == 1 {
+				dropConnection(w, 4000)
+			}
+			_, _ = w.Write(content)
+		}
+		Expect(downloader.download(filePath, server.URL+"/kernel", checksum)).To(Succeed())
+		Expect(os.ReadFile(filePath)).To(Equal(content))
+	})
+
+	It("completes when the interrupted attempt already had all the data", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if len(artifactRequests()) == 1 {
+				dropConnection(w, len(content))
+			}
+			serveContent(w, r)
+		}
+		Expect(downloader.download(filePath, server.URL+"/kernel", checksum)).To(Succeed())
+		Expect(os.ReadFile(filePath)).To(Equal(content))
+	})
+
+	It("discards a partial file from a previous run that can't be validated", func() {
+		Expect(os.WriteFile(filePath+partialFileSuffix, []byte("stale"), 0600)).To(Succeed())
+		Expect(downloader.download(filePath, server.URL+"/kernel", "")).To(Succeed())
+		Expect(os.ReadFile(filePath)).To(Equal(content))
+		Expect(artifactRequests()[0].Header.Get("Range")).To(BeEmpty())
+	})
+
+	It("resumes a partial file from a previous run with the same ETag", func() {
+		Expect(os.WriteFile(filePath+partialFileSuffix, content[:4000], 0600)).To(Succeed())
+		Expect(os.WriteFile(filePath+partialFileSuffix+etagFileSuffix, []byte(etag), 0600)).To(Succeed())
+		Expect(downloader.download(filePath, server.URL+"/kernel", "")).To(Succeed())
+		Expect(os.ReadFile(filePath)).To(Equal(content))
+		reqs := artifactRequests()
+		Expect(reqs).To(HaveLen(1))
+		Expect(reqs[0].Header.Get("Range")).To(Equal("bytes=4000-"))
+		Expect(reqs[0].Header.Get("If-Range")).To(Equal(etag))
+		_, err := os.Stat(filePath + partialFileSuffix + etagFileSuffix)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("starts over when the artifact changed since the previous run", func() {
+		Expect(os.WriteFile(filePath+partialFileSuffix, []byte("stale"), 0600)).To(Succeed())
+		Expect(os.WriteFile(filePath+partialFileSuffix+etagFileSuffix, []byte(`"v0"`), 0600)).To(Succeed())
+		Expect(downloader.download(filePath, server.URL+"/kernel", "")).To(Succeed())
+		Expect(os.ReadFile(filePath)).To(Equal(content))
+		Expect(artifactRequests()).To(HaveLen(1))
+	})
+
+	It("resumes a partial file from a previous run when the checksum is known", func() {
+		etag = ""
+		Expect(os.WriteFile(filePath+partialFileSuffix, content[:4000], 0600)).To(Succeed())
+		Expect(downloader.download(filePath, server.URL+"/kernel", checksum)).To(Succeed())
+		Expect(os.ReadFile(filePath)).To(Equal(content))
+		reqs := artifactRequests()
+		Expect(reqs).To(HaveLen(1))
+		Expect(reqs[0].Header.Get("Range")).To(Equal("bytes=4000-"))
+	})
+
+	It("starts over when a resumed partial file doesn't match the checksum", func() {
+		Expect(os.WriteFile(filePath+partialFileSuffix, []byte("stale"), 0600)).To(Succeed())
+		Expect(downloader.download(filePath, server.URL+"/kernel", checksum)).To(Succeed())
+		Expect(os.ReadFile(filePath)).To(Equal(content))
+		reqs := artifactRequests()
+		Expect(reqs).To(HaveLen(2))
+		Expect(reqs[1].Header.Get("Range")).To(BeEmpty())
+	})
+
+	It("aborts and resumes a stalled download", func() {
+		downloader.stallTimeout = 50 * time.Millisecond
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if len(artifactRequests()) == 1 {
+				w.Header().Set("ETag", etag)
+				w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write(content[:4000])
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+				return
+			}
+			serveContent(w, r)
+		}
+		Expect(downloader.download(filePath, server.URL+"/kernel", "")).To(Succeed())
+		Expect(os.ReadFile(filePath)).To(Equal(content))
+		reqs := artifactRequests()
+		Expect(reqs).To(HaveLen(2))
+		Expect(reqs[1].Header.Get("Range")).To(Equal("bytes=4000-"))
+	})
+
+	It("fails when the checksum doesn't match and keeps the existing file", func() {
+		Expect(os.WriteFile(filePath, []byte("old kernel"), 0600)).To(Succeed())
+		err := downloader.download(filePath, server.URL+"/kernel", strings.Repeat("0", 64))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("checksum mismatch"))
+		Expect(os.ReadFile(filePath)).To(Equal([]byte("old kernel")))
+		_, err = os.Stat(filePath + partialFileSuffix)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("recovers when a corrupt download is followed by a good one", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if len(artifactRequests()) == 1 {
+				_, _ = w.Write([]byte("corrupt"))
+				return
+			}
+			serveContent(w, r)
+		}
+		Expect(downloader.download(filePath, server.URL+"/kernel", checksum)).To(Succeed())
+		Expect(os.ReadFile(filePath)).To(Equal(content))
+	})
+
+	Context("with a checksum sidecar", func() {
+		It("verifies the download", func() {
+			sidecar = checksum + "  vmlinuz\n"
+			Expect(downloader.download(filePath, server.URL+"/kernel?arch=x86_64", "")).To(Succeed())
+			Expect(os.ReadFile(filePath)).To(Equal(content))
+		})
+
+		It("fails when the download doesn't match", func() {
+			sidecar = strings.Repeat("f", 64)
+			err := downloader.download(filePath, server.URL+"/kernel?arch=x86_64", "")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("checksum mismatch"))
+		})
+
+		It("ignores a malformed sidecar", func() {
+			sidecar = "<html>not found</html>"
+			Expect(downloader.download(filePath, server.URL+"/kernel?arch=x86_64", "")).To(Succeed())
+		})
+
+		It("keeps the query string of the url", func() {
+			sidecar = checksum
+			Expect(downloader.download(filePath, server.URL+"/kernel?arch=x86_64", "")).To(Succeed())
+			lock.Lock()
+			defer lock.Unlock()
+			Expect(requests[0].URL.Path).To(Equal("/kernel" + checksumFileSuffix))
+			Expect(requests[0].URL.RawQuery).To(Equal("arch=x86_64"))
+		})
+
+		It("prefers the checksum from the request", func() {
+			sidecar = strings.Repeat("f", 64)
+			Expect(downloader.download(filePath, server.URL+"/kernel", checksum)).To(Succeed())
+		})
+	})
+
+	It("honors the proxy settings", func() {
+		for _, caCertPath := range []string{"", "/etc/pki/tls/certs/ca-bundle.crt"} {
+			if caCertPath != "" {
+				if _, err := os.Stat(caCertPath); err != nil {
+					continue
+				}
+			}
+			client, err := createHTTPClient(caCertPath)
+			Expect(err).NotTo(HaveOccurred())
+			transport, ok := client.Transport.(*http.Transport)
+			Expect(ok).To(BeTrue())
+			Expect(transport.Proxy).NotTo(BeNil())
+		}
+	})
+
+	It("keeps the default transport timeouts", func() {
+		client, err := createHTTPClient("")
+		Expect(err).NotTo(HaveOccurred())
+		transport, ok := client.Transport.(*http.Transport)
+		Expect(ok).To(BeTrue())
+		Expect(transport.TLSHandshakeTimeout).To(Equal(http.DefaultTransport.(*http.Transport).TLSHandshakeTimeout))
+		Expect(transport.IdleConnTimeout).To(Equal(http.DefaultTransport.(*http.Transport).IdleConnTimeout))
+		Expect(transport.ResponseHeaderTimeout).To(Equal(defaultDownloadStallTimeout))
+	})
+})
+
+var _ = Describe("parseContentRange", func() {
+	DescribeTable("parses the header",
+		func(header string, expectedStart, expectedSize int64, expectError bool) {
+			start, size, err := parseContentRange(header)
+			if expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(start).To(Equal(expectedStart))
+			Expect(size).To(Equal(expectedSize))
+		},
+		Entry("range with size", "bytes 100-199/200", int64(100), int64(200), false),
+		Entry("range with unknown size", "bytes 100-199/*", int64(100), int64(-1), false),
+		Entry("unsatisfied range", "bytes */200", int64(-1), int64(200), false),
+		Entry("empty", "", int64(0), int64(0), true),
+		Entry("other unit", "items 1-2/3", int64(0), int64(0), true),
+		Entry("garbage", "bytes a-b/c", int64(0), int64(0), true),
+	)
 })
 var _ = Describe("createFolders", func() {
 	const (
@@ -3,7 +3,6 @@ package actions
 import (
 	"fmt"
 	"os"
-	"strings"
 
 	"github.com/openshift/assisted-installer-agent/src/util"
 	"github.com/sirupsen/logrus"
@@ -13,6 +12,30 @@ import (
 	"github.com/openshift/assisted-installer-agent/src/config"
 )
 
+// Where the caches kept between inventory runs are mounted in the inventory container
+const (
+	previousInstallationsCacheFile = "/var/cache/previous-installations.json"
+	lldpCacheFile                  = "/var/cache/lldp-neighbors.json"
+)
+
+const chronyDHCPSourcesDir = "/run/chrony-dhcp"
+
+// hostRootDir gives access to the host's filesystem from within the next-step-runner container,
+// which runs with --pid=host but doesn't mount the network configuration.
+const hostRootDir = "/proc/1/root"
+
+// hostNetworkConfigPaths are the network configuration files and directories of the host. They
+// don't exist on every host, so each is only mounted when present.
+var hostNetworkConfigPaths = []string{
+	"/etc/resolv.conf",
+	"/etc/hosts",
+	"/etc/chrony.conf",
+	"/etc/NetworkManager",
+	"/usr/lib/NetworkManager",
+	"/run/NetworkManager",
+	"/var/lib/NetworkManager",
+}
+
 type inventory struct {
 	args        []string
 	filesystem  afero.Fs
@@ -31,75 +54,144 @@ func (a *inventory) Validate() error {
 }
 
 func (a *inventory) Run() (stdout, stderr string, exitCode int) {
+	// Copying mounts file, which is not available by podman's PID
+	stdout, stderr, exitCode = util.ExecutePrivileged("cp", "/etc/mtab", a.mtabPath())
+	if exitCode != 0 {
+		return stdout, stderr, exitCode
+	}
+	// The caches are kept between runs, they must exist to be mounted
+	stdout, stderr, exitCode = util.ExecutePrivileged("touch", a.previousInstallationsCachePath(), a.lldpCachePath())
+	if exitCode != 0 {
+		return stdout, stderr, exitCode
+	}
+	// The directory of the NTP servers received with DHCP only exists once a lease had some, it
+	// must exist to be mounted
+	stdout, stderr, exitCode = util.ExecutePrivileged("mkdir", "-p", chronyDHCPSourcesDir)
+	if exitCode != 0 {
+		return stdout, stderr, exitCode
+	}
 	return util.ExecutePrivileged(a.Command(), a.Args()...)
 }
 
 func (a *inventory) Command() string {
-	return "sh"
+	return podman
+}
+
+// mtabPath returns the path of the copy of the mounts file that is mounted into the container.
+// We incorporate the host's ID in the copied mtab file path to allow multiple agents
+// to run on the same host during load testing easily without fighting over the same
+// path (each of them has a different fake host ID)
+func (a *inventory) mtabPath() string {
+	return fmt.Sprintf("/root/mtab-%s", a.args[0])
+}
+
+// previousInstallationsCachePath returns the path of the file where the inventory keeps the
+// previous installations found on the disks, unique per host ID like the mounts file.
+func (a *inventory) previousInstallationsCachePath() string {
+	return fmt.Sprintf("/root/previous-installations-%s.json", a.args[0])
+}
+
+// lldpCachePath returns the path of the file where the inventory keeps the LLDP neighbors.
+func (a *inventory) lldpCachePath() string {
+	return fmt.Sprintf("/root/lldp-neighbors-%s.json", a.args[0])
 }
 
 func (a *inventory) Args() []string {
-	// Copying mounts file, which is not available by podman's PID
-	// We incorporate the host's ID in the copied mtab file path to allow multiple agents
-	// to run on the same host during load testing easily without fighting over the same
-	// path (each of them has a different fake host ID)
-	mtabPath := fmt.Sprintf("/root/mtab-%s", a.args[0])
-	mtabCopy := fmt.Sprintf("cp /etc/mtab %s", mtabPath)
-	mtabMount := fmt.Sprintf("%s:/host/etc/mtab:ro", mtabPath)
-
-	podmanRunArgv := []string{
-		podman, "run",
-		"--privileged",
-		"--pid=host",
-		"--net=host",
-		"--rm",
-		"--quiet",
-
-		"-v", "/var/log:/var/log",
-		"-v", "/run/udev:/run/udev",
-		"-v", "/dev/disk:/dev/disk",
-		"-v", "/run/systemd/journal/socket:/run/systemd/journal/socket",
-
-		// Enable capturing host's HW using a different root path for GHW library
-		"-v", "/var/log:/host/var/log:ro",
-		"-v", "/proc/meminfo:/host/proc/meminfo:ro",
-		"-v", "/sys/kernel/mm/hugepages:/host/sys/kernel/mm/hugepages:ro",
-		"-v", "/proc/cpuinfo:/host/proc/cpuinfo:ro",
-		"-v", mtabMount,
-		"-v", "/sys/block:/host/sys/block:ro",
-		"-v", "/sys/devices:/host/sys/devices:ro",
-		"-v", "/sys/bus:/host/sys/bus:ro",
-		"-v", "/sys/class:/host/sys/class:ro",
-		"-v", "/run/udev:/host/run/udev:ro",
-		"-v", "/dev/disk:/host/dev/disk:ro",
+	cmd := &podmanRun{
+		flags: []string{
+			"--privileged",
+			"--pid=host",
+			"--net=host",
+			"--rm",
+			"--quiet",
+		},
+		mounts: []volumeMount{
+			sameVolumeMount("/var/log"),
+			sameVolumeMount("/run/udev"),
+			sameVolumeMount("/dev/disk"),
+			sameVolumeMount("/run/systemd/journal/socket"),
+
+			// Enable capturing host's HW using a different root path for GHW library
+			hostVolumeMount("/var/log"),
+			hostVolumeMount("/proc/meminfo"),
+			hostVolumeMount("/sys/kernel/mm/hugepages"),
+			hostVolumeMount("/proc/cpuinfo"),
+			{source: a.mtabPath(), target: "/host/etc/mtab", options: "ro"},
+			hostVolumeMount("/sys/block"),
+			hostVolumeMount("/sys/devices"),
+			hostVolumeMount("/sys/bus"),
+			hostVolumeMount("/sys/class"),
+			hostVolumeMount("/run/udev"),
+			hostVolumeMount("/dev/disk"),
+			hostVolumeMount(chronyDHCPSourcesDir),
+
+			{source: a.previousInstallationsCachePath(), target: previousInstallationsCacheFile},
+			{source: a.lldpCachePath(), target: lldpCacheFile},
+		},
+		image: a.agentConfig.AgentVersion,
+		entrypointArgs: []string{
+			"inventory",
+			"--previous-installations-cache-file", previousInstallationsCacheFile,
+			"--lldp-cache-file", lldpCacheFile,
+		},
 	}
 
+	// Network configuration of the host, a missing path would make the podman command fail
+	for _, path := range hostNetworkConfigPaths {
+		if a.hostPathExists(path) {
+			cmd.mounts = append(cmd.mounts, hostVolumeMount(path))
+		}
+	}
+
+	// Options given to the agent, with the files they reference
+	for _, file := range a.agentConfig.InventoryOptions.Files() {
+		cmd.mounts = append(cmd.mounts, sameVolumeMount(file, "ro"))
+	}
+	cmd.entrypointArgs = append(cmd.entrypointArgs, a.agentConfig.InventoryOptions.Args()...)
+
 	// The EFI variables files system will not exist for machines that boot in BIOS mode, so we can't add it
 	// unconditionally, as that will make the podman command fail.
 	const efivarsPath = "/sys/firmware/efi/efivars"
-	efivarsLogger := logrus.WithFields(logrus.Fields{
-		"path": efivarsPath,
-	})
-	_, err := a.filesystem.Stat(efivarsPath)
-	if os.IsNotExist(err) {
-		efivarsLogger.Info("EFI variables filesystem isn't mounted")
-	} else if err != nil {
-		efivarsLogger.WithError(err).Info("Failed to check if EFI variables filesystem is mounted")
-	} else {
-		efivarsLogger.Info("EFI variables filesystem is mounted")
-		podmanRunArgv = append(
-			podmanRunArgv,
-			"-v", fmt.Sprintf("%[1]s:/host%[1]s", efivarsPath),
-		)
+	if a.isMounted(efivarsPath, "EFI variables") {
+		cmd.mounts = append(cmd.mounts, volumeMount{source: efivarsPath, target: "/host" + efivarsPath})
+	}
+
+	// The TPM event log is only available in the security file system, which isn't mounted in
+	// containers and may not be mounted on the host either.
+	const securityfsPath = "/sys/kernel/security"
+	if a.isMounted(securityfsPath, "Security") {
+		cmd.mounts = append(cmd.mounts, hostVolumeMount(securityfsPath))
 	}
 
-	podmanRunArgv = append(
-		podmanRunArgv,
-		a.agentConfig.AgentVersion,
-		"inventory",
-	)
+	return cmd.argv()
+}
 
-	podmanRunCmd := strings.Join(podmanRunArgv, " ")
+// hostPathExists checks if the file or directory exists on the host.
+func (a *inventory) hostPathExists(path string) bool {
+	_, err := a.filesystem.Stat(hostRootDir + path)
+	if os.IsNotExist(err) {
+		logrus.WithField("path", path).Info("Path doesn't exist on the host")
+		return false
+	} else if err != nil {
+		logrus.WithError(err).WithField("path", path).Info("Failed to check if path exists on the host")
+		return false
+	}
+	return true
+}
 
-	return []string{"-c", fmt.Sprintf("%v && %v", mtabCopy, podmanRunCmd)}
+// isMounted checks if the file system that should be mounted at the given path exists on the host.
+func (a *inventory) isMounted(path string, name string) bool {
+	logger := logrus.WithFields(logrus.Fields{
+		"path": path,
+	})
+	_, err := a.filesystem.Stat(path)
+	if os.IsNotExist(err) {
+		logger.Infof("%s filesystem isn't mounted", name)
+		return false
+	} else if err != nil {
+		logger.WithError(err).Infof("Failed to check if %s filesystem is mounted", name)
+		return false
+	}
+	logger.Infof("%s filesystem is mounted", name)
+	return true
 }
@@ -2,6 +2,7 @@ package actions
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/google/uuid"
 	. "github.com/onsi/ginkgo"
@@ -35,35 +36,74 @@ var _ = Describe("inventory", func() {
 	})
 
 	It("inventory cmd", func() {
-		args := action.Args()
+		for _, path := range hostNetworkConfigPaths {
+			Expect(filesystem.MkdirAll("/proc/1/root"+path, 0755)).To(Succeed())
+		}
+		action.agentConfig.AgentVersion = "quay.io/edge-infrastructure/assisted-installer-agent:latest"
+		mtabFile := fmt.Sprintf("/root/mtab-%s", hostId)
+		Expect(action.mtabPath()).To(Equal(mtabFile))
+		cacheFile := fmt.Sprintf("/root/previous-installations-%s.json", hostId)
+		Expect(action.previousInstallationsCachePath()).To(Equal(cacheFile))
+		lldpCacheFile := fmt.Sprintf("/root/lldp-neighbors-%s.json", hostId)
+		Expect(action.lldpCachePath()).To(Equal(lldpCacheFile))
 
-		By("running two commands via sh")
-		command := action.Command()
-		Expect(command).To(Equal("sh"))
-		Expect(args[0]).To(Equal("-c"))
-		Expect(args[1]).To(ContainSubstring("&&"))
+		Expect(action.Command()).To(Equal("podman"))
+		Expect(action.Args()).To(Equal([]string{
+			"run", "--privileged", "--pid=host", "--net=host", "--rm", "--quiet",
+			"-v", "/var/log:/var/log",
+			"-v", "/run/udev:/run/udev",
+			"-v", "/dev/disk:/dev/disk",
+			"-v", "/run/systemd/journal/socket:/run/systemd/journal/socket",
+			"-v", "/var/log:/host/var/log:ro",
+			"-v", "/proc/meminfo:/host/proc/meminfo:ro",
+			"-v", "/sys/kernel/mm/hugepages:/host/sys/kernel/mm/hugepages:ro",
+			"-v", "/proc/cpuinfo:/host/proc/cpuinfo:ro",
+			"-v", mtabFile + ":/host/etc/mtab:ro",
+			"-v", "/sys/block:/host/sys/block:ro",
+			"-v", "/sys/devices:/host/sys/devices:ro",
+			"-v", "/sys/bus:/host/sys/bus:ro",
+			"-v", "/sys/class:/host/sys/class:ro",
+			"-v", "/run/udev:/host/run/udev:ro",
+			"-v", "/dev/disk:/host/dev/disk:ro",
+			"-v", "/run/chrony-dhcp:/host/run/chrony-dhcp:ro",
+			"-v", cacheFile + ":/var/cache/previous-installations.json",
+			"-v", lldpCacheFile + ":/var/cache/lldp-neighbors.json",
+			"-v", "/etc/resolv.conf:/host/etc/resolv.conf:ro",
+			"-v", "/etc/hosts:/host/etc/hosts:ro",
+			"-v", "/etc/chrony.conf:/host/etc/chrony.conf:ro",
+			"-v", "/etc/NetworkManager:/host/etc/NetworkManager:ro",
+			"-v", "/usr/lib/NetworkManager:/host/usr/lib/NetworkManager:ro",
+			"-v", "/run/NetworkManager:/host/run/NetworkManager:ro",
+			"-v", "/var/lib/NetworkManager:/host/var/lib/NetworkManager:ro",
+			"quay.io/edge-infrastructure/assisted-installer-agent:latest",
+			"inventory",
+			"--previous-installations-cache-file", "/var/cache/previous-installations.json",
+			"--lldp-cache-file", "/var/cache/lldp-neighbors.json",
+		}))
+	})
 
-		mtabFile := fmt.Sprintf("/root/mtab-%s", hostId)
-		mtabCopy := fmt.Sprintf("cp /etc/mtab %s", mtabFile)
-		mtabMount := fmt.Sprintf("%s:/host/etc/mtab:ro", mtabFile)
-
-		Expect(args[1]).To(ContainSubstring(mtabCopy))
-
-		By("verifying mounts to host's filesystem")
-		Expect(args[1]).To(ContainSubstring(mtabMount))
-		paths := []string{
-			"/proc/meminfo",
-			"/sys/kernel/mm/hugepages",
-			"/proc/cpuinfo",
-			"/sys/block",
-			"/sys/devices",
-			"/sys/bus",
-			"/sys/class",
-			"/run/udev",
-		}
-		for _, path := range paths {
-			Expect(args[1]).To(ContainSubstring(fmt.Sprintf("-v %[1]v:/host%[1]v:ro", path)))
-		}
+	It("only mounts the network configuration that exists on the host", func() {
+		Expect(afero.WriteFile(filesystem, "/proc/1/root/etc/resolv.conf", []byte("nameserver 10.0.0.1\n"), 0644)).To(Succeed())
+		Expect(filesystem.MkdirAll("/proc/1/root/etc/NetworkManager", 0755)).To(Succeed())
+		args := strings.Join(action.Args(), " ")
+		Expect(args).To(ContainSubstring("-v /etc/resolv.conf:/host/etc/resolv.conf:ro "))
+		Expect(args).To(ContainSubstring("-v /etc/NetworkManager:/host/etc/NetworkManager:ro "))
+		Expect(args).ToNot(ContainSubstring("/etc/chrony.conf"))
+		Expect(args).ToNot(ContainSubstring("/etc/hosts"))
+		Expect(args).ToNot(ContainSubstring("/var/lib/NetworkManager"))
+	})
+
+	It("passes the inventory options and mounts their files", func() {
+		action.agentConfig.RedfishCredentialsFile = "/etc/assisted/redfish.yaml"
+		action.agentConfig.AcceleratorConfigFile = "/etc/assisted/accelerators.yaml"
+		action.agentConfig.HostnameReplaceDHCP = true
+		action.agentConfig.PlatformMetadataProbe = true
+		args := strings.Join(action.Args(), " ")
+		Expect(args).To(ContainSubstring("-v /etc/assisted/redfish.yaml:/etc/assisted/redfish.yaml:ro "))
+		Expect(args).To(ContainSubstring("-v /etc/assisted/accelerators.yaml:/etc/assisted/accelerators.yaml:ro "))
+		Expect(args).To(HaveSuffix(" inventory --previous-installations-cache-file /var/cache/previous-installations.json " +
+			"--lldp-cache-file /var/cache/lldp-neighbors.json --redfish-credentials-file /etc/assisted/redfish.yaml " +
+			"--accelerator-config-file /etc/assisted/accelerators.yaml --hostname-replace-dhcp --platform-metadata-probe"))
 	})
 
 	It("inventory cmd wrong args number", func() {
@@ -75,11 +115,25 @@ var _ = Describe("inventory", func() {
 		Expect(err).ToNot(HaveOccurred())
 
 		args := action.Args()
-		Expect(args[1]).To(ContainSubstring("-v /sys/firmware/efi/efivars:/host/sys/firmware/efi/efivars"))
+		Expect(strings.Join(args, " ")).To(ContainSubstring("-v /sys/firmware/efi/efivars:/host/sys/firmware/efi/efivars "))
+		Expect(args[len(args)-5]).To(Equal("inventory"))
 	})
 
 	It("Doesn't add the EFI variables volume if the directory doesn't exist", func() {
 		args := action.Args()
-		Expect(args[1]).ToNot(ContainSubstring("-v /sys/firmware/efi/efivars:/host/sys/firmware/efi/efivars"))
+		Expect(strings.Join(args, " ")).ToNot(ContainSubstring("/sys/firmware/efi/efivars"))
+	})
+
+	It("Adds the security filesystem volume if the directory exists", func() {
+		err := filesystem.MkdirAll("/sys/kernel/security", 0755)
+		Expect(err).ToNot(HaveOccurred())
+
+		args := action.Args()
+		Expect(strings.Join(args, " ")).To(ContainSubstring("-v /sys/kernel/security:/host/sys/kernel/security:ro "))
+	})
+
+	It("Doesn't add the security filesystem volume if the directory doesn't exist", func() {
+		args := action.Args()
+		Expect(strings.Join(args, " ")).ToNot(ContainSubstring("/sys/kernel/security"))
 	})
 })
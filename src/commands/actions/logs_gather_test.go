@@ -5,6 +5,7 @@ import (
 
 	"github.com/jinzhu/copier"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"github.com/openshift/assisted-installer-agent/src/config"
 	"github.com/openshift/assisted-service/models"
@@ -38,7 +39,8 @@ var _ = Describe("Logs gather test", func() {
 			"/root/.ssh",
 		}
 		verifyPaths(strings.Join(args, " "), paths)
-		Expect(strings.Join(args, " ")).To(ContainSubstring("--env PULL_SECRET_TOKEN --name logs-sender --pid=host"))
+		Expect(strings.Join(args, " ")).To(ContainSubstring("--pid=host"))
+		Expect(strings.Join(args, " ")).To(ContainSubstring("--env PULL_SECRET_TOKEN --name logs-sender"))
 		Expect(strings.Join(args, " ")).To(ContainSubstring("-masters-ips=192.168.127.10,192.168.127.12"))
 		Expect(strings.Join(args, " ")).To(ContainSubstring("-bootstrap=true -with-installer-gather-logging=true"))
 	})
@@ -93,4 +95,65 @@ var _ = Describe("Logs gather test", func() {
 		_, err = New(agentConfig, models.StepTypeLogsGather, []string{param})
 		Expect(err).To(HaveOccurred())
 	})
+
+	DescribeTable("Logs gather argv",
+		func(request string, caCertPath string, expected []string) {
+			agentConfig.TargetURL = "https://assisted.example.com:8090"
+			agentConfig.AgentVersion = "quay.io/edge-infrastructure/assisted-installer-agent:latest"
+			agentConfig.CACertificatePath = caCertPath
+			action, err := New(agentConfig, models.StepTypeLogsGather, []string{request})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(action.Command()).To(Equal("timeout"))
+			Expect(action.Args()).To(Equal(expected))
+		},
+		Entry("bootstrap",
+			"{\"bootstrap\":true,\"cluster_id\":\"57a0830c-0d5f-45ad-8513-7d0060c33615\","+
+				"\"host_id\":\"9f45b240-73d5-4390-a04e-7f5a09da44f7\",\"infra_env_id\":\"ea123507-1875-4da2-968a-15bb2d4b1e91\","+
+				"\"installer_gather\":true,\"master_ips\":[\"192.168.127.10\",\"192.168.127.12\"]}",
+			"",
+			[]string{
+				"1h", "podman", "run", "--rm", "--privileged", "--net=host", "--pid=host",
+				"-v", "/run/systemd/journal/socket:/run/systemd/journal/socket",
+				"-v", "/var/log:/var/log",
+				"-v", "/etc/pki:/etc/pki",
+				"-v", "/root/.ssh:/root/.ssh",
+				"-v", "/tmp:/tmp",
+				"--env", "PULL_SECRET_TOKEN",
+				"--name", "logs-sender",
+				"quay.io/edge-infrastructure/assisted-installer-agent:latest",
+				"logs_sender",
+				"-url", "https://assisted.example.com:8090",
+				"-cluster-id", "57a0830c-0d5f-45ad-8513-7d0060c33615",
+				"-host-id", "9f45b240-73d5-4390-a04e-7f5a09da44f7",
+				"-infra-env-id", "ea123507-1875-4da2-968a-15bb2d4b1e91",
+				"--insecure=false",
+				"-bootstrap=true",
+				"-with-installer-gather-logging=true",
+				"-masters-ips=192.168.127.10,192.168.127.12",
+			}),
+		Entry("not bootstrap with a CA path containing spaces and special characters",
+			"{\"bootstrap\":false,\"cluster_id\":\"57a0830c-0d5f-45ad-8513-7d0060c33615\","+
+				"\"host_id\":\"9f45b240-73d5-4390-a04e-7f5a09da44f7\",\"infra_env_id\":\"ea123507-1875-4da2-968a-15bb2d4b1e91\","+
+				"\"installer_gather\":true}",
+			"/etc/assisted/my CA's & <certs>.crt",
+			[]string{
+				"1h", "podman", "run", "--rm", "--privileged", "--net=host", "--pid=host",
+				"-v", "/run/systemd/journal/socket:/run/systemd/journal/socket",
+				"-v", "/var/log:/var/log",
+				"-v", "/etc/pki:/etc/pki",
+				"-v", "/etc/assisted/my CA's & <certs>.crt:/etc/assisted/my CA's & <certs>.crt",
+				"--env", "PULL_SECRET_TOKEN",
+				"--name", "logs-sender",
+				"quay.io/edge-infrastructure/assisted-installer-agent:latest",
+				"logs_sender",
+				"-url", "https://assisted.example.com:8090",
+				"-cluster-id", "57a0830c-0d5f-45ad-8513-7d0060c33615",
+				"-host-id", "9f45b240-73d5-4390-a04e-7f5a09da44f7",
+				"-infra-env-id", "ea123507-1875-4da2-968a-15bb2d4b1e91",
+				"--insecure=false",
+				"-bootstrap=false",
+				"-with-installer-gather-logging=true",
+				"--cacert", "/etc/assisted/my CA's & <certs>.crt",
+			}),
+	)
 })
@@ -53,37 +53,46 @@ func (a *nextStepRunnerAction) cleanupPrevious() {
 func (a *nextStepRunnerAction) Args() []string {
 	a.cleanupPrevious()
 
-	arguments := []string{"run", "--rm", "-ti", "--privileged", "--pid=host", "--uts=host", "--net=host",
-
-		// unlimited number of processes in the container
-		"--pids-limit=0",
-		"-v", "/dev:/dev:rw", "-v", "/opt:/opt:rw",
-		"-v", "/run/systemd/journal/socket:/run/systemd/journal/socket",
-		"-v", "/var/log:/var/log:rw",
-		"-v", "/run/media:/run/media:rw",
-		"-v", "/etc/pki:/etc/pki"}
-
-	if a.agentConfig.CACertificatePath != "" {
-		arguments = append(arguments, "-v", fmt.Sprintf("%s:%s", a.agentConfig.CACertificatePath,
-			a.agentConfig.CACertificatePath))
+	cmd := &podmanRun{
+		flags: []string{"--rm", "-ti", "--privileged", "--pid=host", "--uts=host", "--net=host",
+			// unlimited number of processes in the container
+			"--pids-limit=0",
+		},
+		mounts: []volumeMount{
+			sameVolumeMount("/dev", "rw"),
+			sameVolumeMount("/opt", "rw"),
+			sameVolumeMount("/run/systemd/journal/socket"),
+			sameVolumeMount("/var/log", "rw"),
+			sameVolumeMount("/run/media", "rw"),
+			sameVolumeMount("/etc/pki"),
+		},
+		env: []string{
+			"PULL_SECRET_TOKEN",
+			"CONTAINERS_CONF",
+			"CONTAINERS_STORAGE_CONF",
+			"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY",
+			"http_proxy", "https_proxy", "no_proxy",
+		},
+		name:  containerName,
+		image: swag.StringValue(a.nextStepRunnerParams.AgentVersion),
+		entrypointArgs: []string{
+			"next_step_runner",
+			"--url", a.agentConfig.TargetURL,
+			"--infra-env-id", a.nextStepRunnerParams.InfraEnvID.String(),
+			"--host-id", a.nextStepRunnerParams.HostID.String(),
+			"--agent-version", swag.StringValue(a.nextStepRunnerParams.AgentVersion),
+			fmt.Sprintf("--insecure=%s", strconv.FormatBool(a.agentConfig.InsecureConnection)),
+		},
 	}
 
-	arguments = append(arguments,
-		"--env", "PULL_SECRET_TOKEN",
-		"--env", "CONTAINERS_CONF",
-		"--env", "CONTAINERS_STORAGE_CONF",
-		"--env", "HTTP_PROXY", "--env", "HTTPS_PROXY", "--env", "NO_PROXY",
-		"--env", "http_proxy", "--env", "https_proxy", "--env", "no_proxy",
-		"--name", containerName, swag.StringValue(a.nextStepRunnerParams.AgentVersion), "next_step_runner",
-		"--url", a.agentConfig.TargetURL,
-		"--infra-env-id", a.nextStepRunnerParams.InfraEnvID.String(),
-		"--host-id", a.nextStepRunnerParams.HostID.String(),
-		"--agent-version", swag.StringValue(a.nextStepRunnerParams.AgentVersion),
-		fmt.Sprintf("--insecure=%s", strconv.FormatBool(a.agentConfig.InsecureConnection)))
-
 	if a.agentConfig.CACertificatePath != "" {
-		arguments = append(arguments, "--cacert", a.agentConfig.CACertificatePath)
+		cmd.mounts = append(cmd.mounts, sameVolumeMount(a.agentConfig.CACertificatePath))
+		cmd.entrypointArgs = append(cmd.entrypointArgs, "--cacert", a.agentConfig.CACertificatePath)
 	}
 
-	return arguments
+	// The next step runner only passes the inventory options on, the files they reference are
+	// mounted into the inventory container
+	cmd.entrypointArgs = append(cmd.entrypointArgs, a.agentConfig.InventoryOptions.Args()...)
+
+	return cmd.argv()
 }
@@ -94,6 +94,45 @@ var _ = Describe("next step runner", func() {
 		Expect(strings.Join(args, " ")).To(ContainSubstring("--cacert /ca_cert"))
 	})
 
+	It("next step runner argv", func() {
+		agentConfig.CACertificatePath = "/etc/assisted/service ca.crt"
+		_, args := runNextRunner(params, false)
+		Expect(args).To(Equal([]string{
+			"run", "--rm", "-ti", "--privileged", "--pid=host", "--uts=host", "--net=host", "--pids-limit=0",
+			"-v", "/dev:/dev:rw",
+			"-v", "/opt:/opt:rw",
+			"-v", "/run/systemd/journal/socket:/run/systemd/journal/socket",
+			"-v", "/var/log:/var/log:rw",
+			"-v", "/run/media:/run/media:rw",
+			"-v", "/etc/pki:/etc/pki",
+			"-v", "/etc/assisted/service ca.crt:/etc/assisted/service ca.crt",
+			"--env", "PULL_SECRET_TOKEN",
+			"--env", "CONTAINERS_CONF",
+			"--env", "CONTAINERS_STORAGE_CONF",
+			"--env", "HTTP_PROXY", "--env", "HTTPS_PROXY", "--env", "NO_PROXY",
+			"--env", "http_proxy", "--env", "https_proxy", "--env", "no_proxy",
+			"--name", "next-step-runner",
+			"quay.io/edge-infrastructure/assisted-installer-controller:latest",
+			"next_step_runner",
+			"--url", "http://10.1.178.26:6000",
+			"--infra-env-id", "456eecf6-7aec-402d-b453-f609b19783cb",
+			"--host-id", "f7ac1860-92cf-4ed8-aeec-2d9f20b35bab",
+			"--agent-version", "quay.io/edge-infrastructure/assisted-installer-controller:latest",
+			"--insecure=true",
+			"--cacert", "/etc/assisted/service ca.crt",
+		}))
+	})
+
+	It("passes the inventory options", func() {
+		agentConfig.RedfishCredentialsFile = "/etc/assisted/redfish.yaml"
+		agentConfig.HostnameTemplate = "worker-{mac}"
+		agentConfig.HostnameReverseDNS = true
+		_, args := runNextRunner(params, false)
+		Expect(strings.Join(args, " ")).To(HaveSuffix("--redfish-credentials-file /etc/assisted/redfish.yaml " +
+			"--hostname-template worker-{mac} --hostname-reverse-dns"))
+		Expect(strings.Join(args, " ")).NotTo(ContainSubstring("-v /etc/assisted/redfish.yaml"))
+	})
+
 	It("next step runner insecure false", func() {
 		agentConfig.InsecureConnection = false
 		b, err := json.Marshal(&runnerArgs)
@@ -0,0 +1,57 @@
+package actions
+
+import "strings"
+
+// volumeMount is a bind mount of a host path into a container.
+type volumeMount struct {
+	source  string
+	target  string
+	options string
+}
+
+// sameVolumeMount mounts the given host path at the same path inside the container.
+func sameVolumeMount(path string, options ...string) volumeMount {
+	return volumeMount{source: path, target: path, options: strings.Join(options, ",")}
+}
+
+// hostVolumeMount mounts the given host path read only under /host inside the container.
+func hostVolumeMount(path string) volumeMount {
+	return volumeMount{source: path, target: "/host" + path, options: "ro"}
+}
+
+func (m volumeMount) String() string {
+	if m.options == "" {
+		return m.source + ":" + m.target
+	}
+	return m.source + ":" + m.target + ":" + m.options
+}
+
+// podmanRun describes a podman run command. Every value becomes exactly one argument, so paths
+// and URLs containing spaces or shell special characters are passed to podman unchanged.
+type podmanRun struct {
+	flags  []string
+	mounts []volumeMount
+	// env holds the names of environment variables passed from the host to the container
+	env   []string
+	name  string
+	image string
+	// entrypointArgs are the arguments given to the image entrypoint
+	entrypointArgs []string
+}
+
+// argv returns the podman arguments, without the podman command itself.
+func (p *podmanRun) argv() []string {
+	args := []string{"run"}
+	args = append(args, p.flags...)
+	for _, mount := range p.mounts {
+		args = append(args, "-v", mount.String())
+	}
+	for _, env := range p.env {
+		args = append(args, "--env", env)
+	}
+	if p.name != "" {
+		args = append(args, "--name", p.name)
+	}
+	args = append(args, p.image)
+	return append(args, p.entrypointArgs...)
+}
@@ -0,0 +1,56 @@
+package actions
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("podmanRun", func() {
+	DescribeTable("argv",
+		func(cmd *podmanRun, expected []string) {
+			Expect(cmd.argv()).To(Equal(expected))
+		},
+		Entry("image only",
+			&podmanRun{image: "quay.io/example/agent:latest"},
+			[]string{"run", "quay.io/example/agent:latest"}),
+		Entry("all the parts in order",
+			&podmanRun{
+				flags: []string{"--rm", "--net=host"},
+				mounts: []volumeMount{
+					sameVolumeMount("/var/log"),
+					sameVolumeMount("/dev", "rw"),
+					hostVolumeMount("/sys/block"),
+					{source: "/root/mtab-1", target: "/host/etc/mtab", options: "ro"},
+				},
+				env:            []string{"PULL_SECRET_TOKEN", "HTTP_PROXY"},
+				name:           "test",
+				image:          "quay.io/example/agent:latest",
+				entrypointArgs: []string{"inventory", "--flag=value"},
+			},
+			[]string{
+				"run", "--rm", "--net=host",
+				"-v", "/var/log:/var/log",
+				"-v", "/dev:/dev:rw",
+				"-v", "/sys/block:/host/sys/block:ro",
+				"-v", "/root/mtab-1:/host/etc/mtab:ro",
+				"--env", "PULL_SECRET_TOKEN",
+				"--env", "HTTP_PROXY",
+				"--name", "test",
+				"quay.io/example/agent:latest",
+				"inventory", "--flag=value",
+			}),
+		Entry("values with spaces and special characters are kept as single arguments",
+			&podmanRun{
+				mounts:         []volumeMount{sameVolumeMount("/etc/my certs/ca&.crt")},
+				image:          "quay.io/example/agent:latest",
+				entrypointArgs: []string{"disk_speed_check", `{"path":"/dev/disk/by-path/a b'c"}`},
+			},
+			[]string{
+				"run",
+				"-v", "/etc/my certs/ca&.crt:/etc/my certs/ca&.crt",
+				"quay.io/example/agent:latest",
+				"disk_speed_check", `{"path":"/dev/disk/by-path/a b'c"}`,
+			}),
+	)
+})
@@ -2,18 +2,36 @@ package actions
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"path"
 	"regexp"
 	"runtime"
 	"strings"
 	"syscall"
 
-	"github.com/openshift/assisted-installer-agent/src/util"
 	"github.com/openshift/assisted-service/models"
+	log "github.com/sirupsen/logrus"
 )
 
+const (
+	kexecLockdownPath     = "/sys/kernel/security/lockdown"
+	kexecLoadDisabledPath = "/proc/sys/kernel/kexec_load_disabled"
+)
+
+// rebootForReclaimRequest is the reboot for reclaim request model with the optional fields that
+// newer services may send.
+type rebootForReclaimRequest struct {
+	models.RebootForReclaimRequest
+
+	// Whether the host may kexec directly into the discovery kernel instead of going through a
+	// full reboot and firmware initialization.
+	AllowKexec bool `json:"allow_kexec,omitempty"`
+}
+
 type rebootForReclaim struct {
-	args []string
+	args    []string
+	execute func(command string, args ...string) (stdout string, stderr string, exitCode int)
 }
 
 func (a *rebootForReclaim) Validate() error {
@@ -21,7 +39,7 @@ func (a *rebootForReclaim) Validate() error {
 }
 
 func (a *rebootForReclaim) Run() (stdout, stderr string, exitCode int) {
-	var req models.RebootForReclaimRequest
+	var req rebootForReclaimRequest
 	if err := json.Unmarshal([]byte(a.args[0]), &req); err != nil {
 		return "", fmt.Sprintf("failed unmarshalling reboot for reclaim request: %s", err.Error()), -1
 	}
@@ -30,22 +48,42 @@ func (a *rebootForReclaim) Run() (stdout, stderr string, exitCode int) {
 		return "", err.Error(), -1
 	}
 
+	// Rebooting into an incomplete discovery entry would leave the host unable to boot
+	if err := verifyBootEntry("/"); err != nil {
+		return "", fmt.Sprintf("refusing to reboot, discovery boot entry is not valid: %s", err.Error()), -1
+	}
+
+	return a.reboot(req.AllowKexec)
+}
+
+// reboot boots the host, already chrooted into, into the discovery entry, either with kexec when
+// allowKexec is set and the host supports it, or with a regular reboot.
+func (a *rebootForReclaim) reboot(allowKexec bool) (stdout, stderr string, exitCode int) {
+	kexecErr := a.checkKexec(allowKexec)
+	if kexecErr != nil && allowKexec {
+		log.WithError(kexecErr).Warn("Can't kexec into the discovery kernel, using a regular reboot")
+	}
+	if runtime.GOARCH != "s390x" && kexecErr != nil {
+		return a.execute("systemctl", "reboot")
+	}
+
+	var options string
+	stdout, stderr, exitCode = a.execute("cat", path.Join("/boot", bootLoaderFolder, bootLoaderConfigFileName))
+	if exitCode != 0 {
+		return stdout, stderr, exitCode
+	}
+	lines := strings.Split(stdout, "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(line, "options") {
+			options = strings.TrimSpace(strings.TrimPrefix(line, "options"))
+			break
+		}
+	}
+
 	if runtime.GOARCH == "s390x" {
-		var options string
 		var requiredCmdline string
 
-		stdout, stderr, exitCode = util.Execute("cat", "/boot/loader/entries/00-assisted-discovery.conf")
-		if exitCode != 0 {
-			return stdout, stderr, exitCode
-		}
-		lines := strings.Split(stdout, "\n")
-		for _, line := range lines {
-			if strings.HasPrefix(line, "options") {
-				options = strings.TrimSpace(strings.TrimPrefix(line, "options"))
-				break
-			}
-		}
-		stdout, stderr, exitCode := util.Execute("cat", "/proc/cmdline")
+		stdout, stderr, exitCode := a.execute("cat", "/proc/cmdline")
 		if exitCode != 0 {
 			return stdout, stderr, exitCode
 		}
@@ -73,13 +111,79 @@ func (a *rebootForReclaim) Run() (stdout, stderr string, exitCode int) {
 				options,
 				requiredCmdline),
 		}
-		stdout, stderr, exitCode = util.Execute(unshareCommand, unshareArgs...)
+		stdout, stderr, exitCode = a.execute(unshareCommand, unshareArgs...)
 		if exitCode != 0 {
 			return stdout, stderr, exitCode
 		}
+		if err := validateZiplOutput(stdout); err != nil {
+			return stdout, err.Error(), -1
+		}
+		options = strings.TrimSpace(fmt.Sprintf("%s %s", options, requiredCmdline))
+	}
+
+	if kexecErr == nil {
+		// systemd only queues the kexec job, the discovery kernel takes over once the host is shut
+		// down, so a regular reboot must not be requested as well
+		err := a.kexecIntoDiscovery(options)
+		if err == nil {
+			return "", "", 0
+		}
+		log.WithError(err).Warn("Failed to kexec into the discovery kernel, falling back to a regular reboot")
+	}
+	return a.execute("systemctl", "reboot")
+}
 
+// checkKexec decides between kexec and a regular reboot. It returns an error explaining why the
+// host can't kexec into the discovery kernel, either because the request doesn't allow it or
+// because kernel lockdown, which is enabled with Secure Boot, forbids loading unsigned kernels.
+func (a *rebootForReclaim) checkKexec(allowKexec bool) error {
+	if !allowKexec {
+		return errors.New("kexec isn't allowed by the request")
+	}
+	if stdout, _, exitCode := a.execute("cat", kexecLockdownPath); exitCode == 0 {
+		if mode := getLockdownMode(stdout); mode != "" && mode != "none" {
+			return fmt.Errorf("kernel lockdown is in %s mode", mode)
+		}
+	}
+	if stdout, _, exitCode := a.execute("cat", kexecLoadDisabledPath); exitCode == 0 && strings.TrimSpace(stdout) == "1" {
+		return fmt.Errorf("kexec loading is disabled by %s", kexecLoadDisabledPath)
+	}
+	return nil
+}
+
+// kexecIntoDiscovery loads the discovery kernel and initrd with the given command line and asks
+// systemd to shut down and execute it.
+func (a *rebootForReclaim) kexecIntoDiscovery(commandLine string) error {
+	// Single quotes are understood by the boot loaders but not by the kernel itself
+	commandLine = strings.ReplaceAll(commandLine, "'", "\"")
+	_, stderr, exitCode := a.execute("kexec", "--load",
+		path.Join("/boot", artifactsFolder, kernelFile),
+		fmt.Sprintf("--initrd=%s", path.Join("/boot", artifactsFolder, initrdFile)),
+		fmt.Sprintf("--command-line=%s", commandLine))
+	if exitCode != 0 {
+		return fmt.Errorf("failed to load the discovery kernel, exit code %d: %s", exitCode, stderr)
+	}
+
+	_, stderr, exitCode = a.execute("systemctl", "kexec")
+	if exitCode != 0 {
+		// Don't leave the kernel loaded, a later reboot would otherwise execute it too
+		if _, unloadStderr, unloadExitCode := a.execute("kexec", "--unload"); unloadExitCode != 0 {
+			log.Warnf("Failed to unload the discovery kernel: %s", unloadStderr)
+		}
+		return fmt.Errorf("failed to execute the discovery kernel, exit code %d: %s", exitCode, stderr)
+	}
+	return nil
+}
+
+// getLockdownMode returns the active mode from the content of the lockdown file, where it is the
+// one in brackets, for example "none" in "[none] integrity confidentiality".
+func getLockdownMode(content string) string {
+	for _, field := range strings.Fields(content) {
+		if strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]") {
+			return strings.Trim(field, "[]")
+		}
 	}
-	return util.Execute("systemctl", "reboot")
+	return ""
 }
 
 // Returns the paramsToExtract parameters which are present in cmdlineOutput, if no paramter matched then returns any empty string ''
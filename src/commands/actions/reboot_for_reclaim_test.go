@@ -1,7 +1,12 @@
 package actions
 
 import (
+	"path"
+	"runtime"
+	"strings"
+
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"github.com/openshift/assisted-installer-agent/src/config"
 	"github.com/openshift/assisted-service/models"
@@ -42,4 +47,131 @@ var _ = Describe("reboot_for_reclaim", func() {
 			Expect(requiredCmdline).To(Equal(""))
 		})
 	})
+	Context("kexecIntoDiscovery", func() {
+		type result struct {
+			stdout   string
+			stderr   string
+			exitCode int
+		}
+		var (
+			action   *rebootForReclaim
+			results  map[string]result
+			commands []string
+		)
+		const commandLine = "random.trust_cpu=on ignition.firstboot 'coreos.live.rootfs_url=http://test.com/rootfs?arch=x86_64&version=4.10'"
+
+		BeforeEach(func() {
+			commands = nil
+			results = map[string]result{
+				"cat " + kexecLockdownPath:     {stdout: "[none] integrity confidentiality\n"},
+				"cat " + kexecLoadDisabledPath: {stdout: "0\n"},
+			}
+			action = &rebootForReclaim{
+				args: []string{"{\"host_fs_mount_dir\":\"/host\",\"allow_kexec\":true}"},
+				execute: func(command string, args ...string) (string, string, int) {
+					commands = append(commands, strings.Join(append([]string{command}, args...), " "))
+					for prefix, r := range results {
+						if strings.HasPrefix(commands[len(commands)-1], prefix) {
+							return r.stdout, r.stderr, r.exitCode
+						}
+					}
+					return "", "", 0
+				},
+			}
+		})
+
+		It("validates a request allowing kexec", func() {
+			Expect(action.Validate()).To(Succeed())
+		})
+
+		It("loads and executes the discovery kernel", func() {
+			Expect(action.kexecIntoDiscovery(commandLine)).To(Succeed())
+			Expect(commands).To(Equal([]string{
+				"kexec --load /boot/discovery/vmlinuz --initrd=/boot/discovery/initrd " +
+					"--command-line=random.trust_cpu=on ignition.firstboot \"coreos.live.rootfs_url=http://test.com/rootfs?arch=x86_64&version=4.10\"",
+				"systemctl kexec",
+			}))
+		})
+
+		DescribeTable("decides between kexec and reboot",
+			func(allowKexec bool, lockdown, loadDisabled result, expectedErr string, expectedCommands []string) {
+				results["cat "+kexecLockdownPath] = lockdown
+				results["cat "+kexecLoadDisabledPath] = loadDisabled
+				err := action.checkKexec(allowKexec)
+				if expectedErr == "" {
+					Expect(err).NotTo(HaveOccurred())
+				} else {
+					Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+				}
+				Expect(commands).To(Equal(expectedCommands))
+			},
+			Entry("kexec not allowed", false,
+				result{stdout: "[none] integrity confidentiality\n"}, result{stdout: "0\n"},
+				"isn't allowed", nil),
+			Entry("kexec allowed", true,
+				result{stdout: "[none] integrity confidentiality\n"}, result{stdout: "0\n"},
+				"", []string{"cat " + kexecLockdownPath, "cat " + kexecLoadDisabledPath}),
+			Entry("lockdown state unreadable", true,
+				result{stderr: "No such file or directory", exitCode: 1}, result{stdout: "0\n"},
+				"", []string{"cat " + kexecLockdownPath, "cat " + kexecLoadDisabledPath}),
+			Entry("integrity lockdown", true,
+				result{stdout: "none [integrity] confidentiality\n"}, result{stdout: "0\n"},
+				"lockdown is in integrity mode", []string{"cat " + kexecLockdownPath}),
+			Entry("confidentiality lockdown", true,
+				result{stdout: "none integrity [confidentiality]\n"}, result{stdout: "0\n"},
+				"lockdown is in confidentiality mode", []string{"cat " + kexecLockdownPath}),
+			Entry("kexec loading disabled", true,
+				result{stdout: "[none] integrity confidentiality\n"}, result{stdout: "1\n"},
+				"disabled", []string{"cat " + kexecLockdownPath, "cat " + kexecLoadDisabledPath}),
+		)
+
+		Context("reboot", func() {
+			BeforeEach(func() {
+				if runtime.GOARCH == "s390x" {
+					Skip("zipl is run before rebooting on s390x")
+				}
+				results["cat "+path.Join("/boot", bootLoaderFolder, bootLoaderConfigFileName)] = result{
+					stdout: "title Assisted Installer Discovery\noptions " + commandLine + "\n",
+				}
+			})
+
+			It("doesn't reboot after a successful kexec", func() {
+				_, _, exitCode := action.reboot(true)
+				Expect(exitCode).To(Equal(0))
+				Expect(commands).To(ContainElement("systemctl kexec"))
+				Expect(commands).NotTo(ContainElement("systemctl reboot"))
+			})
+
+			It("reboots when kexec fails", func() {
+				results["systemctl kexec"] = result{stderr: "failed", exitCode: 1}
+				_, _, exitCode := action.reboot(true)
+				Expect(exitCode).To(Equal(0))
+				Expect(commands[len(commands)-1]).To(Equal("systemctl reboot"))
+			})
+
+			It("reboots when kexec isn't allowed", func() {
+				_, _, exitCode := action.reboot(false)
+				Expect(exitCode).To(Equal(0))
+				Expect(commands).To(Equal([]string{"systemctl reboot"}))
+			})
+		})
+
+		It("fails when the kernel can't be loaded", func() {
+			results["kexec --load"] = result{stderr: "Cannot load /boot/discovery/vmlinuz", exitCode: 1}
+			Expect(action.kexecIntoDiscovery(commandLine)).To(MatchError(ContainSubstring("Cannot load")))
+			Expect(commands).NotTo(ContainElement("systemctl kexec"))
+		})
+
+		It("unloads the kernel when it can't be executed", func() {
+			results["systemctl kexec"] = result{stderr: "failed", exitCode: 1}
+			Expect(action.kexecIntoDiscovery(commandLine)).To(HaveOccurred())
+			Expect(commands[len(commands)-1]).To(Equal("kexec --unload"))
+		})
+	})
+
+	It("parses the lockdown mode", func() {
+		Expect(getLockdownMode("[none] integrity confidentiality")).To(Equal("none"))
+		Expect(getLockdownMode("none [integrity] confidentiality\n")).To(Equal("integrity"))
+		Expect(getLockdownMode("")).To(Equal(""))
+	})
 })
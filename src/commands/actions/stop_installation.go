@@ -1,9 +1,72 @@
 package actions
 
-import "github.com/openshift/assisted-installer-agent/src/util"
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+)
+
+const (
+	installerContainerName = "assisted-installer"
+	mustGatherNamePrefix   = "must-gather"
+	// stopInstallationLogDir is on the host, the next-step-runner container mounts /var/log
+	stopInstallationLogDir = "/var/log/assisted-installer-stop"
+)
+
+// diskWriteRegex matches the installer log lines that report that a disk is being written to.
+var diskWriteRegex = regexp.MustCompile(`(?i)(writing|formatting|format disk)`)
+
+// stopInstallationResponse summarizes what stop installation found and cleaned up.
+type stopInstallationResponse struct {
+	// Folder with the logs and exit information of the stopped containers
+	StateDir   string                `json:"state_dir,omitempty"`
+	Containers []stoppedContainer    `json:"containers"`
+	Disks      []installationDiskUse `json:"disks"`
+	Errors     []string              `json:"errors,omitempty"`
+}
+
+type stoppedContainer struct {
+	Name     string `json:"name"`
+	Image    string `json:"image,omitempty"`
+	Status   string `json:"status,omitempty"`
+	ExitCode int    `json:"exit_code"`
+	Removed  bool   `json:"removed"`
+}
+
+// installationDiskUse is a disk the installer was asked to write and whether it had started to.
+type installationDiskUse struct {
+	Path    string `json:"path"`
+	Role    string `json:"role"`
+	Written bool   `json:"written"`
+}
+
+type podmanContainer struct {
+	ID    string   `json:"Id"`
+	Names []string `json:"Names"`
+	Image string   `json:"Image"`
+	State string   `json:"State"`
+}
+
+type podmanInspect struct {
+	Args  []string `json:"Args"`
+	State struct {
+		Status   string `json:"Status"`
+		ExitCode int    `json:"ExitCode"`
+	} `json:"State"`
+}
 
 type stopInstallation struct {
-	args []string
+	args              []string
+	filesystem        afero.Fs
+	executePrivileged func(command string, args ...string) (stdout string, stderr string, exitCode int)
+	now               func() time.Time
 }
 
 func (a *stopInstallation) Validate() error {
@@ -15,7 +78,158 @@ func (a *stopInstallation) Validate() error {
 }
 
 func (a *stopInstallation) Run() (stdout, stderr string, exitCode int) {
-	return util.ExecutePrivileged(a.Command(), a.Args()...)
+	response := stopInstallationResponse{
+		StateDir:   filepath.Join(stopInstallationLogDir, a.now().UTC().Format("20060102-150405")),
+		Containers: []stoppedContainer{},
+		Disks:      []installationDiskUse{},
+	}
+	if err := a.filesystem.MkdirAll(response.StateDir, 0755); err != nil {
+		log.WithError(err).Warnf("Failed to create %s, installation state won't be saved", response.StateDir)
+		response.Errors = append(response.Errors, err.Error())
+		response.StateDir = ""
+	}
+
+	containers, err := a.listRelatedContainers()
+	if err != nil {
+		// Still try to stop the installer, which is what matters most
+		log.WithError(err).Warn("Failed to list containers")
+		response.Errors = append(response.Errors, err.Error())
+		containers = []podmanContainer{{Names: []string{installerContainerName}}}
+	}
+
+	var installerFailed bool
+	for _, container := range containers {
+		stopped, disks, errs := a.stopContainer(container, response.StateDir)
+		response.Containers = append(response.Containers, stopped)
+		response.Disks = append(response.Disks, disks...)
+		response.Errors = append(response.Errors, errs...)
+		if stopped.Name == installerContainerName && !stopped.Removed {
+			installerFailed = true
+		}
+	}
+
+	b, err := json.Marshal(&response)
+	if err != nil {
+		return "", err.Error(), -1
+	}
+	if installerFailed {
+		return string(b), strings.Join(response.Errors, "\n"), -1
+	}
+	return string(b), "", 0
+}
+
+// listRelatedContainers returns the installer container and the must-gather helpers it may have
+// started, in any state.
+func (a *stopInstallation) listRelatedContainers() ([]podmanContainer, error) {
+	stdout, stderr, exitCode := a.executePrivileged(podman, "ps", "--all", "--format", "json")
+	if exitCode != 0 {
+		return nil, fmt.Errorf("podman ps failed with exit code %d: %s", exitCode, stderr)
+	}
+	var containers []podmanContainer
+	if err := json.Unmarshal([]byte(stdout), &containers); err != nil {
+		return nil, fmt.Errorf("failed to parse podman ps output: %w", err)
+	}
+
+	var ret []podmanContainer
+	for _, container := range containers {
+		if slices.ContainsFunc(container.Names, func(name string) bool {
+			return name == installerContainerName || strings.HasPrefix(name, mustGatherNamePrefix)
+		}) {
+			ret = append(ret, container)
+		}
+	}
+	return ret, nil
+}
+
+// stopContainer saves the logs of the container, stops it, saves its exit information and removes
+// it. For the installer container it also returns the disks it was asked to write.
+func (a *stopInstallation) stopContainer(container podmanContainer, stateDir string) (stoppedContainer, []installationDiskUse, []string) {
+	name := container.Names[0]
+	ret := stoppedContainer{Name: name, Image: container.Image, Status: container.State}
+	var errs []string
+
+	logs, stderr, exitCode := a.executePrivileged(podman, "logs", name)
+	if exitCode != 0 {
+		errs = append(errs, fmt.Sprintf("failed to get logs of %s: %s", name, stderr))
+	} else {
+		// podman logs writes what the container wrote to stderr to its own stderr
+		logs += stderr
+		errs = append(errs, a.saveState(stateDir, name+".log", logs)...)
+	}
+
+	if _, stderr, exitCode = a.executePrivileged(podman, "stop", "-i", "-t", "5", name); exitCode != 0 {
+		errs = append(errs, fmt.Sprintf("failed to stop %s: %s", name, stderr))
+	}
+
+	var disks []installationDiskUse
+	inspectOutput, stderr, exitCode := a.executePrivileged(podman, "inspect", name)
+	if exitCode != 0 {
+		errs = append(errs, fmt.Sprintf("failed to inspect %s: %s", name, stderr))
+	} else {
+		errs = append(errs, a.saveState(stateDir, name+"-inspect.json", inspectOutput)...)
+		var inspect []podmanInspect
+		if err := json.Unmarshal([]byte(inspectOutput), &inspect); err != nil || len(inspect) == 0 {
+			errs = append(errs, fmt.Sprintf("failed to parse inspect output of %s", name))
+		} else {
+			ret.Status = inspect[0].State.Status
+			ret.ExitCode = inspect[0].State.ExitCode
+			if name == installerContainerName {
+				disks = getInstallationDisks(inspect[0].Args, logs)
+			}
+		}
+	}
+
+	if _, stderr, exitCode = a.executePrivileged(podman, "rm", "-i", "-f", name); exitCode != 0 {
+		errs = append(errs, fmt.Sprintf("failed to remove %s: %s", name, stderr))
+	} else {
+		ret.Removed = true
+	}
+	return ret, disks, errs
+}
+
+func (a *stopInstallation) saveState(stateDir, fileName, content string) []string {
+	if stateDir == "" {
+		return nil
+	}
+	path := filepath.Join(stateDir, fileName)
+	if err := afero.WriteFile(a.filesystem, path, []byte(content), 0644); err != nil {
+		log.WithError(err).Warnf("Failed to write %s", path)
+		return []string{err.Error()}
+	}
+	return nil
+}
+
+// getInstallationDisks returns the boot device and the disks to format from the arguments of the
+// installer, each marked as written if the installer logs show that it started writing to it.
+func getInstallationDisks(installerArgs []string, logs string) []installationDiskUse {
+	var writeLines []string
+	for _, line := range strings.Split(logs, "\n") {
+		if diskWriteRegex.MatchString(line) {
+			writeLines = append(writeLines, line)
+		}
+	}
+	isWritten := func(disk string) bool {
+		// The word boundary keeps /dev/sda from matching /dev/sdaa
+		diskRegex := regexp.MustCompile(regexp.QuoteMeta(disk) + `\b`)
+		return slices.ContainsFunc(writeLines, diskRegex.MatchString)
+	}
+
+	disks := []installationDiskUse{}
+	for i := 0; i < len(installerArgs)-1; i++ {
+		var role string
+		switch installerArgs[i] {
+		case "--boot-device":
+			role = "boot"
+		case "--format-disk":
+			role = "format"
+		default:
+			continue
+		}
+		disk := installerArgs[i+1]
+		disks = append(disks, installationDiskUse{Path: disk, Role: role, Written: isWritten(disk)})
+		i++
+	}
+	return disks
 }
 
 func (a *stopInstallation) Command() string {
@@ -24,6 +238,6 @@ func (a *stopInstallation) Command() string {
 
 func (a *stopInstallation) Args() []string {
 	return []string{
-		"stop", "-i", "-t", "5", "assisted-installer",
+		"stop", "-i", "-t", "5", installerContainerName,
 	}
 }
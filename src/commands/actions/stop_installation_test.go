@@ -1,12 +1,17 @@
 package actions
 
 import (
+	"encoding/json"
+	"slices"
 	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"github.com/openshift/assisted-installer-agent/src/config"
 	"github.com/openshift/assisted-service/models"
+	"github.com/spf13/afero"
 )
 
 var _ = Describe("stop", func() {
@@ -28,3 +33,145 @@ var _ = Describe("stop", func() {
 		badParamsCommonTests(models.StepTypeStopInstallation, []string{})
 	})
 })
+
+var _ = Describe("stop installation run", func() {
+	const stateDir = "/var/log/assisted-installer-stop/20240102-030405"
+
+	type execResult struct {
+		stdout   string
+		stderr   string
+		exitCode int
+	}
+
+	var (
+		action   *stopInstallation
+		fs       afero.Fs
+		commands []string
+		results  map[string]execResult
+	)
+
+	installerInspect := `[{"Args": ["--role", "master", "--boot-device", "/dev/sda", "--format-disk", "/dev/sdb"],
+		"State": {"Status": "exited", "ExitCode": 137}}]`
+
+	BeforeEach(func() {
+		fs = afero.NewMemMapFs()
+		commands = nil
+		results = map[string]execResult{
+			"podman ps --all --format json": {stdout: `[
+				{"Id": "1", "Names": ["assisted-installer"], "Image": "installer:latest", "State": "running"},
+				{"Id": "2", "Names": ["must-gather-abc"], "Image": "must-gather:latest", "State": "running"},
+				{"Id": "3", "Names": ["next-step-runner"], "Image": "agent:latest", "State": "running"}]`},
+			"podman logs assisted-installer":    {stdout: "Writing image to disk /dev/sda\n", stderr: "starting\n"},
+			"podman inspect assisted-installer": {stdout: installerInspect},
+			"podman inspect must-gather-abc":    {stdout: `[{"State": {"Status": "exited", "ExitCode": 0}}]`},
+		}
+		action = &stopInstallation{
+			filesystem: fs,
+			executePrivileged: func(command string, args ...string) (string, string, int) {
+				cmd := strings.Join(append([]string{command}, args...), " ")
+				commands = append(commands, cmd)
+				res := results[cmd]
+				return res.stdout, res.stderr, res.exitCode
+			},
+			now: func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) },
+		}
+	})
+
+	parseResponse := func(stdout string) stopInstallationResponse {
+		var response stopInstallationResponse
+		Expect(json.Unmarshal([]byte(stdout), &response)).To(Succeed())
+		return response
+	}
+
+	It("saves the state and removes the related containers", func() {
+		stdout, stderr, exitCode := action.Run()
+		Expect(exitCode).To(Equal(0))
+		Expect(stderr).To(BeEmpty())
+
+		response := parseResponse(stdout)
+		Expect(response.StateDir).To(Equal(stateDir))
+		Expect(response.Errors).To(BeEmpty())
+		Expect(response.Containers).To(Equal([]stoppedContainer{
+			{Name: "assisted-installer", Image: "installer:latest", Status: "exited", ExitCode: 137, Removed: true},
+			{Name: "must-gather-abc", Image: "must-gather:latest", Status: "exited", ExitCode: 0, Removed: true},
+		}))
+		Expect(response.Disks).To(Equal([]installationDiskUse{
+			{Path: "/dev/sda", Role: "boot", Written: true},
+			{Path: "/dev/sdb", Role: "format", Written: false},
+		}))
+
+		Expect(afero.ReadFile(fs, stateDir+"/assisted-installer.log")).To(Equal([]byte("Writing image to disk /dev/sda\nstarting\n")))
+		Expect(afero.ReadFile(fs, stateDir+"/assisted-installer-inspect.json")).To(Equal([]byte(installerInspect)))
+		Expect(commands).To(ContainElements(
+			"podman stop -i -t 5 assisted-installer",
+			"podman rm -i -f assisted-installer",
+			"podman stop -i -t 5 must-gather-abc",
+			"podman rm -i -f must-gather-abc",
+		))
+		Expect(strings.Join(commands, "\n")).NotTo(ContainSubstring("next-step-runner"))
+	})
+
+	It("saves the logs before stopping the installer", func() {
+		action.Run()
+		Expect(commands).To(ContainElement("podman logs assisted-installer"))
+		logs := slices.Index(commands, "podman logs assisted-installer")
+		stop := slices.Index(commands, "podman stop -i -t 5 assisted-installer")
+		Expect(logs).To(BeNumerically("<", stop))
+	})
+
+	It("succeeds when there is nothing to stop", func() {
+		results["podman ps --all --format json"] = execResult{stdout: "[]"}
+		stdout, _, exitCode := action.Run()
+		Expect(exitCode).To(Equal(0))
+		response := parseResponse(stdout)
+		Expect(response.Containers).To(BeEmpty())
+		Expect(response.Disks).To(BeEmpty())
+	})
+
+	It("still stops the installer when listing containers fails", func() {
+		results["podman ps --all --format json"] = execResult{stderr: "boom", exitCode: 125}
+		stdout, _, exitCode := action.Run()
+		Expect(exitCode).To(Equal(0))
+		Expect(commands).To(ContainElement("podman stop -i -t 5 assisted-installer"))
+		response := parseResponse(stdout)
+		Expect(response.Containers).To(HaveLen(1))
+		Expect(response.Errors).To(ContainElement(ContainSubstring("podman ps failed")))
+	})
+
+	It("fails when the installer can't be removed", func() {
+		results["podman rm -i -f assisted-installer"] = execResult{stderr: "device busy", exitCode: 125}
+		stdout, stderr, exitCode := action.Run()
+		Expect(exitCode).To(Equal(-1))
+		Expect(stderr).To(ContainSubstring("device busy"))
+		response := parseResponse(stdout)
+		Expect(response.Containers[0].Removed).To(BeFalse())
+	})
+
+	It("keeps going when the state can't be saved", func() {
+		action.filesystem = afero.NewReadOnlyFs(fs)
+		stdout, _, exitCode := action.Run()
+		Expect(exitCode).To(Equal(0))
+		response := parseResponse(stdout)
+		Expect(response.StateDir).To(BeEmpty())
+		Expect(response.Errors).To(HaveLen(1))
+		Expect(response.Containers).To(HaveLen(2))
+	})
+})
+
+var _ = DescribeTable("getInstallationDisks",
+	func(args []string, logs string, expected []installationDiskUse) {
+		Expect(getInstallationDisks(args, logs)).To(Equal(expected))
+	},
+	Entry("no disks", []string{"--role", "worker"}, "", []installationDiskUse{}),
+	Entry("boot device not written", []string{"--boot-device", "/dev/sda"}, "Pulling image\n",
+		[]installationDiskUse{{Path: "/dev/sda", Role: "boot"}}),
+	Entry("boot device written", []string{"--boot-device", "/dev/sda"}, "Writing image to disk /dev/sda\n",
+		[]installationDiskUse{{Path: "/dev/sda", Role: "boot", Written: true}}),
+	Entry("mention without write", []string{"--boot-device", "/dev/sda"}, "Found disk /dev/sda\n",
+		[]installationDiskUse{{Path: "/dev/sda", Role: "boot"}}),
+	Entry("similar disk name", []string{"--boot-device", "/dev/sda"}, "Writing image to disk /dev/sdaa\n",
+		[]installationDiskUse{{Path: "/dev/sda", Role: "boot"}}),
+	Entry("formatted disk", []string{"--boot-device", "/dev/sda", "--format-disk", "/dev/sdb"}, "Formatting disk /dev/sdb\n",
+		[]installationDiskUse{{Path: "/dev/sda", Role: "boot"}, {Path: "/dev/sdb", Role: "format", Written: true}}),
+	Entry("flag without value", []string{"--boot-device"}, "", []installationDiskUse{}),
+)
@@ -13,6 +13,7 @@ type AgentConfig struct {
 	IntervalSecs int
 	HostID       string
 	LoggingConfig
+	InventoryOptions
 }
 
 func printHelpAndExit() {
@@ -29,6 +30,7 @@ func ProcessArgs() *AgentConfig {
 	}
 
 	RegisterLoggingArgs(&ret.LoggingConfig)
+	RegisterInventoryOptionsArgs(&ret.InventoryOptions)
 
 	flag.StringVar(&ret.TargetURL, "url", "", "The target URL, including a scheme and optionally a port (overrides the host and port arguments")
 	flag.StringVar(&ret.InfraEnvID, "infra-env-id", "", "The value of infra-env-id")
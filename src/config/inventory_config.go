@@ -2,21 +2,91 @@ package config
 
 import (
 	"flag"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// InventoryOptions are the inventory settings that the agent receives and passes on to the
+// inventory command
+type InventoryOptions struct {
+	RedfishCredentialsFile string
+	AcceleratorConfigFile  string
+	HostnameTemplate       string
+	HostnameReverseDNS     bool
+	HostnameReplaceDHCP    bool
+	PlatformMetadataProbe  bool
+}
+
+// RegisterInventoryOptionsArgs registers the flags of the inventory options, they have the same
+// names for the agent and the inventory command
+func RegisterInventoryOptionsArgs(options *InventoryOptions) {
+	flag.StringVar(&options.RedfishCredentialsFile, "redfish-credentials-file", "",
+		"YAML file with the username and password used to open a session with the Redfish service of the BMC")
+	flag.StringVar(&options.AcceleratorConfigFile, "accelerator-config-file", "", "Configuration file for accelerator discovery")
+	flag.StringVar(&options.HostnameTemplate, "hostname-template", "",
+		"Template of the host names generated to replace invalid ones, it can contain {mac}, {serial} and {bmc_hostname}, {mac} by default")
+	flag.BoolVar(&options.HostnameReverseDNS, "hostname-reverse-dns", false,
+		"Use the reverse DNS name of the primary IP address before generating a host name")
+	flag.BoolVar(&options.HostnameReplaceDHCP, "hostname-replace-dhcp", false,
+		"Replace host names assigned by DHCP, for networks that give the same name to several hosts")
+	flag.BoolVar(&options.PlatformMetadataProbe, "platform-metadata-probe", false,
+		"Read the instance details from the link local metadata service of the cloud provider")
+}
+
+// Args returns the flags that pass the options that were set to the inventory command
+func (o *InventoryOptions) Args() []string {
+	var ret []string
+	if o.RedfishCredentialsFile != "" {
+		ret = append(ret, "--redfish-credentials-file", o.RedfishCredentialsFile)
+	}
+	if o.AcceleratorConfigFile != "" {
+		ret = append(ret, "--accelerator-config-file", o.AcceleratorConfigFile)
+	}
+	if o.HostnameTemplate != "" {
+		ret = append(ret, "--hostname-template", o.HostnameTemplate)
+	}
+	if o.HostnameReverseDNS {
+		ret = append(ret, "--hostname-reverse-dns")
+	}
+	if o.HostnameReplaceDHCP {
+		ret = append(ret, "--hostname-replace-dhcp")
+	}
+	if o.PlatformMetadataProbe {
+		ret = append(ret, "--platform-metadata-probe")
+	}
+	return ret
+}
+
+// Files returns the files referenced by the options, which have to be mounted into the container
+// that runs the inventory
+func (o *InventoryOptions) Files() []string {
+	var ret []string
+	if o.RedfishCredentialsFile != "" {
+		ret = append(ret, o.RedfishCredentialsFile)
+	}
+	if o.AcceleratorConfigFile != "" {
+		ret = append(ret, o.AcceleratorConfigFile)
+	}
+	return ret
+}
+
 // Inventory command configuration
 type InventoryConfig struct {
 	DryRunConfig
 	LoggingConfig
-	GPUConfigFile string
+	InventoryOptions
+	GPUConfigFile                  string
+	PreviousInstallationsCacheFile string
+	LLDPListenDuration             time.Duration
+	LLDPCacheFile                  string
 }
 
 func ProcessInventoryConfigArgs() *InventoryConfig {
 	ret := &InventoryConfig{}
 
 	RegisterLoggingArgs(&ret.LoggingConfig)
+	RegisterInventoryOptionsArgs(&ret.InventoryOptions)
 
 	err := RegisterDryRunArgs(&ret.DryRunConfig)
 	if err != nil {
@@ -24,6 +94,12 @@ func ProcessInventoryConfigArgs() *InventoryConfig {
 	}
 
 	flag.StringVar(&ret.GPUConfigFile, "gpu-config-file", "", "Configuration file for GPU discovery")
+	flag.StringVar(&ret.PreviousInstallationsCacheFile, "previous-installations-cache-file", "",
+		"File where the previous installations found on the disks are kept, to avoid mounting their partitions on every run")
+	flag.DurationVar(&ret.LLDPListenDuration, "lldp-listen-duration", 5*time.Second,
+		"How long to wait for LLDP frames on each interface")
+	flag.StringVar(&ret.LLDPCacheFile, "lldp-cache-file", "",
+		"File where the LLDP neighbors are kept between runs, to report them without listening for a whole LLDP interval")
 	h := flag.Bool("help", false, "Help message")
 	flag.Parse()
 
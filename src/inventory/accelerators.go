@@ -0,0 +1,196 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jaypipes/ghw"
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Accelerator types of the default rules
+const (
+	AcceleratorTypeGPU      = "gpu"
+	AcceleratorTypeFPGA     = "fpga"
+	AcceleratorTypeDPU      = "dpu"
+	AcceleratorTypeSmartNIC = "smartnic"
+	AcceleratorTypeCrypto   = "crypto"
+	AcceleratorTypeAI       = "ai"
+)
+
+// AcceleratorRule selects the PCI devices of an accelerator type, with the same class, vendor and
+// model filters as the GPU configuration.
+type AcceleratorRule struct {
+	Type      string `yaml:"type"`
+	GPUConfig `yaml:",inline"`
+}
+
+type AcceleratorConfig struct {
+	Rules []AcceleratorRule `yaml:"rules"`
+}
+
+var defaultAcceleratorConfig = AcceleratorConfig{
+	Rules: []AcceleratorRule{
+		{Type: AcceleratorTypeGPU, GPUConfig: GPUConfig{
+			Classes: []string{PCI_CLASS_DISPLAY_VGA, PCI_CLASS_DISPLAY_3D, PCI_CLASS_DISPLAY_CONTROLLER},
+		}},
+		{Type: AcceleratorTypeFPGA, GPUConfig: GPUConfig{
+			// Xilinx and Altera cards, and the Intel programmable acceleration cards N3000 and D5005
+			Vendors: []string{"1200 10ee", "0580 10ee", "1200 1172"},
+			Models:  []string{"1200 8086 0b30", "1200 8086 0b2b"},
+		}},
+		{Type: AcceleratorTypeDPU, GPUConfig: GPUConfig{
+			// NVIDIA BlueField-2 and BlueField-3 network functions
+			Models: []string{"0200 15b3 a2d6", "0200 15b3 a2dc"},
+		}},
+		{Type: AcceleratorTypeSmartNIC, GPUConfig: GPUConfig{
+			// Netronome and Napatech cards
+			Vendors: []string{"0200 19ee", "0200 18f4"},
+		}},
+		{Type: AcceleratorTypeCrypto, GPUConfig: GPUConfig{
+			// Intel QuickAssist co-processors
+			Vendors: []string{"0b40 8086"},
+		}},
+		{Type: AcceleratorTypeAI, GPUConfig: GPUConfig{
+			Classes: []string{PCI_CLASS_PROCESSING_ACCELERATOR},
+		}},
+	},
+}
+
+// Accelerator is a PCI device that offloads computation from the CPUs.
+type Accelerator struct {
+	Type       string `json:"type"`
+	Address    string `json:"address"`
+	Class      string `json:"class"`
+	VendorID   string `json:"vendor_id"`
+	DeviceID   string `json:"device_id"`
+	Vendor     string `json:"vendor,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Driver     string `json:"driver,omitempty"`
+	IOMMUGroup string `json:"iommu_group,omitempty"`
+	NUMANode   *int64 `json:"numa_node,omitempty"`
+	// PCIe link, for example 16.0 GT/s PCIe and 16 lanes
+	LinkSpeed    string `json:"link_speed,omitempty"`
+	LinkWidth    int64  `json:"link_width,omitempty"`
+	MaxLinkSpeed string `json:"max_link_speed,omitempty"`
+	MaxLinkWidth int64  `json:"max_link_width,omitempty"`
+}
+
+// readAcceleratorConfiguration reads a configuration yaml file with the accelerator rules
+func readAcceleratorConfiguration(path string) (AcceleratorConfig, error) {
+	if path == "" {
+		return defaultAcceleratorConfig, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return defaultAcceleratorConfig, fmt.Errorf("failed to read file: %v", err)
+	}
+	var ret AcceleratorConfig
+	if err = yaml.Unmarshal(data, &ret); err != nil {
+		return defaultAcceleratorConfig, fmt.Errorf("failed to unmarshal YAML: %v", err)
+	}
+	for _, rule := range ret.Rules {
+		if rule.Type == "" {
+			return defaultAcceleratorConfig, fmt.Errorf("accelerator rule without type")
+		}
+	}
+	return ret, nil
+}
+
+func containsPCIID(ids []string, id string) bool {
+	for _, pciID := range ids {
+		if id == strings.ReplaceAll(pciID, " ", "") {
+			return true
+		}
+	}
+	return false
+}
+
+// acceleratorType returns the type of the first rule that matches the device, looking for the most
+// specific match first: model, then vendor, then class.
+func (c *AcceleratorConfig) acceleratorType(class, vendor, model string) string {
+	for _, match := range []func(rule *AcceleratorRule) bool{
+		func(rule *AcceleratorRule) bool { return containsPCIID(rule.Models, model) },
+		func(rule *AcceleratorRule) bool { return containsPCIID(rule.Vendors, vendor) },
+		func(rule *AcceleratorRule) bool { return containsPCIID(rule.Classes, class) },
+	} {
+		for i := range c.Rules {
+			if match(&c.Rules[i]) {
+				return c.Rules[i].Type
+			}
+		}
+	}
+	return ""
+}
+
+type accelerators struct {
+	sysfsReader
+	config AcceleratorConfig
+}
+
+func newAccelerators(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) *accelerators {
+	acceleratorConfig, err := readAcceleratorConfiguration(inventoryConfig.AcceleratorConfigFile)
+	if err != nil {
+		logrus.Warnf("Error getting accelerator configuration: %s", err)
+		logrus.Info("Using default accelerator discovery configuration")
+	}
+	return &accelerators{sysfsReader: sysfsReader{dependencies: dependencies}, config: acceleratorConfig}
+}
+
+// pciDeviceAttribute returns the path of an attribute of the PCI device in sysfs.
+func pciDeviceAttribute(address, field string) string {
+	return fmt.Sprintf("/sys/bus/pci/devices/%s/%s", address, field)
+}
+
+func (a *accelerators) newAccelerator(device *ghw.PCIDevice, acceleratorType, class string) *Accelerator {
+	ret := &Accelerator{
+		Type:       acceleratorType,
+		Address:    device.Address,
+		Class:      class,
+		VendorID:   device.Vendor.ID,
+		DeviceID:   device.Product.ID,
+		Vendor:     device.Vendor.Name,
+		Name:       device.Product.Name,
+		Driver:     a.readLink(pciDeviceAttribute(device.Address, "driver")),
+		IOMMUGroup: a.readLink(pciDeviceAttribute(device.Address, "iommu_group")),
+	}
+	if numaNode, ok := a.readInt(pciDeviceAttribute(device.Address, "numa_node")); ok && numaNode >= 0 {
+		ret.NUMANode = &numaNode
+	}
+	ret.LinkSpeed = a.readString(pciDeviceAttribute(device.Address, "current_link_speed"))
+	ret.LinkWidth, _ = a.readInt(pciDeviceAttribute(device.Address, "current_link_width"))
+	ret.MaxLinkSpeed = a.readString(pciDeviceAttribute(device.Address, "max_link_speed"))
+	ret.MaxLinkWidth, _ = a.readInt(pciDeviceAttribute(device.Address, "max_link_width"))
+	return ret
+}
+
+func (a *accelerators) getAccelerators() []*Accelerator {
+	ret := make([]*Accelerator, 0)
+	pciInfo, err := a.dependencies.PCI()
+	if err != nil {
+		logrus.Warnf("Error getting PCI info: %s", err)
+		return ret
+	}
+	for _, device := range pciInfo.Devices {
+		if device.Class == nil || device.Subclass == nil || device.Vendor == nil || device.Product == nil {
+			continue
+		}
+		class := device.Class.ID + device.Subclass.ID
+		vendor := class + device.Vendor.ID
+		model := vendor + device.Product.ID
+		if acceleratorType := a.config.acceleratorType(class, vendor, model); acceleratorType != "" {
+			ret = append(ret, a.newAccelerator(device, acceleratorType, class))
+		}
+	}
+	return ret
+}
+
+// GetAccelerators discovers the GPUs, FPGAs, DPUs, SmartNICs, crypto and AI accelerators of the
+// system
+func GetAccelerators(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) []*Accelerator {
+	return newAccelerators(inventoryConfig, dependencies).getAccelerators()
+}
@@ -0,0 +1,134 @@
+package inventory
+
+import (
+	"errors"
+	"os"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/pcidb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/stretchr/testify/mock"
+)
+
+var (
+	bluefield = ghw.PCIDevice{
+		Address:  "0000:03:00.0",
+		Class:    &pcidb.Class{ID: "02", Name: "Network controller"},
+		Subclass: &pcidb.Subclass{ID: "00", Name: "Ethernet controller"},
+		Product:  &pcidb.Product{VendorID: "15b3", ID: "a2dc", Name: "BlueField-3 integrated ConnectX-7 network controller"},
+		Vendor:   &pcidb.Vendor{ID: "15b3", Name: "Mellanox Technologies"},
+	}
+	connectx = ghw.PCIDevice{
+		Address:  "0000:04:00.0",
+		Class:    &pcidb.Class{ID: "02", Name: "Network controller"},
+		Subclass: &pcidb.Subclass{ID: "00", Name: "Ethernet controller"},
+		Product:  &pcidb.Product{VendorID: "15b3", ID: "1017", Name: "MT27800 Family [ConnectX-5]"},
+		Vendor:   &pcidb.Vendor{ID: "15b3", Name: "Mellanox Technologies"},
+	}
+	alveo = ghw.PCIDevice{
+		Address:  "0000:05:00.0",
+		Class:    &pcidb.Class{ID: "12", Name: "Processing accelerators"},
+		Subclass: &pcidb.Subclass{ID: "00", Name: "Processing accelerators"},
+		Product:  &pcidb.Product{VendorID: "10ee", ID: "5050", Name: "Alveo U250"},
+		Vendor:   &pcidb.Vendor{ID: "10ee", Name: "Xilinx Corporation"},
+	}
+	qat = ghw.PCIDevice{
+		Address:  "0000:6b:00.0",
+		Class:    &pcidb.Class{ID: "0b", Name: "Processor"},
+		Subclass: &pcidb.Subclass{ID: "40", Name: "Co-processor"},
+		Product:  &pcidb.Product{VendorID: "8086", ID: "4940", Name: "4xxx Series QAT"},
+		Vendor:   &pcidb.Vendor{ID: "8086", Name: "Intel Corporation"},
+	}
+)
+
+var _ = Describe("Accelerators", func() {
+	var dependencies *util.MockIDependencies
+	var inventoryConfig *config.InventoryConfig
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+		inventoryConfig = &config.InventoryConfig{}
+	})
+
+	AfterEach(func() {
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	It("reports the accelerators with their sysfs details", func() {
+		dependencies.On("PCI").Return(&ghw.PCIInfo{Devices: []*ghw.PCIDevice{&card1, &card2, &card3, &bluefield, &connectx, &alveo, &qat}}, nil).Once()
+		mockSysfs(dependencies, map[string]string{
+			"/sys/bus/pci/devices/0000:00:03.0/numa_node":          "1\n",
+			"/sys/bus/pci/devices/0000:00:03.0/current_link_speed": "16.0 GT/s PCIe\n",
+			"/sys/bus/pci/devices/0000:00:03.0/current_link_width": "8\n",
+			"/sys/bus/pci/devices/0000:00:03.0/max_link_speed":     "16.0 GT/s PCIe\n",
+			"/sys/bus/pci/devices/0000:00:03.0/max_link_width":     "16\n",
+			"/sys/bus/pci/devices/0000:03:00.0/numa_node":          "-1\n",
+		})
+		dependencies.On("EvalSymlinks", "/sys/bus/pci/devices/0000:00:03.0/driver").Return("/sys/bus/pci/drivers/nvidia", nil)
+		dependencies.On("EvalSymlinks", "/sys/bus/pci/devices/0000:00:03.0/iommu_group").Return("/sys/kernel/iommu_groups/42", nil)
+		dependencies.On("EvalSymlinks", "/sys/bus/pci/devices/0000:03:00.0/driver").Return("/sys/bus/pci/drivers/mlx5_core", nil)
+		dependencies.On("EvalSymlinks", mock.Anything).Return("", errors.New("no such file or directory"))
+
+		numaNode := int64(1)
+		accelerators := GetAccelerators(inventoryConfig, dependencies)
+		Expect(accelerators).To(Equal([]*Accelerator{
+			{Type: AcceleratorTypeGPU, Address: "0000:00:02.0", Class: "0300", VendorID: "8086", DeviceID: "3ea0",
+				Vendor: "Intel Corporation", Name: "UHD Graphics 620 (Whiskey Lake)"},
+			{Type: AcceleratorTypeGPU, Address: "0000:00:03.0", Class: "0302", VendorID: "10de", DeviceID: "20f1",
+				Vendor: "NVIDIA Corporation", Name: "GA100 [A100 PCIe 40GB]", Driver: "nvidia", IOMMUGroup: "42", NUMANode: &numaNode,
+				LinkSpeed: "16.0 GT/s PCIe", LinkWidth: 8, MaxLinkSpeed: "16.0 GT/s PCIe", MaxLinkWidth: 16},
+			{Type: AcceleratorTypeAI, Address: "0000:00:04.0", Class: "1200", VendorID: "1da3", DeviceID: "1020",
+				Vendor: "Habana Labs Ltd.", Name: "Gaudi2 AI Training Accelerator"},
+			{Type: AcceleratorTypeDPU, Address: "0000:03:00.0", Class: "0200", VendorID: "15b3", DeviceID: "a2dc",
+				Vendor: "Mellanox Technologies", Name: "BlueField-3 integrated ConnectX-7 network controller", Driver: "mlx5_core"},
+			{Type: AcceleratorTypeFPGA, Address: "0000:05:00.0", Class: "1200", VendorID: "10ee", DeviceID: "5050",
+				Vendor: "Xilinx Corporation", Name: "Alveo U250"},
+			{Type: AcceleratorTypeCrypto, Address: "0000:6b:00.0", Class: "0b40", VendorID: "8086", DeviceID: "4940",
+				Vendor: "Intel Corporation", Name: "4xxx Series QAT"},
+		}))
+	})
+
+	It("uses the rules of the configuration file", func() {
+		f, err := os.CreateTemp("", "accelerators-*.yaml")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Remove(f.Name())
+		_, err = f.WriteString(`---
+rules:
+  - type: smartnic
+    vendors:
+      - '0200 15b3'
+  - type: gpu
+    models:
+      - '0302 10de 20f1'
+`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+		inventoryConfig.AcceleratorConfigFile = f.Name()
+
+		dependencies.On("PCI").Return(&ghw.PCIInfo{Devices: []*ghw.PCIDevice{&card1, &card2, &bluefield, &connectx}}, nil).Once()
+		mockSysfs(dependencies, map[string]string{})
+		dependencies.On("EvalSymlinks", mock.Anything).Return("", errors.New("no such file or directory"))
+
+		accelerators := GetAccelerators(inventoryConfig, dependencies)
+		Expect(accelerators).To(HaveLen(3))
+		Expect(accelerators[0].Type).To(Equal(AcceleratorTypeGPU))
+		Expect(accelerators[0].Address).To(Equal(card2.Address))
+		Expect(accelerators[1].Type).To(Equal(AcceleratorTypeSmartNIC))
+		Expect(accelerators[1].Address).To(Equal(bluefield.Address))
+		Expect(accelerators[2].Address).To(Equal(connectx.Address))
+	})
+
+	It("uses the default rules when the configuration file is invalid", func() {
+		config, err := readAcceleratorConfiguration("/does/not/exist.yaml")
+		Expect(err).To(HaveOccurred())
+		Expect(config).To(Equal(defaultAcceleratorConfig))
+	})
+
+	It("returns no accelerators when PCI fails", func() {
+		dependencies.On("PCI").Return(nil, errors.New("boom")).Once()
+		Expect(GetAccelerators(inventoryConfig, dependencies)).To(BeEmpty())
+	})
+})
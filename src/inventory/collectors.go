@@ -0,0 +1,124 @@
+package inventory
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCollectorTimeout = 30 * time.Second
+	// The BMC collectors may need one ipmitool call per channel, each of them can take seconds
+	bmcCollectorTimeout = 90 * time.Second
+	// noCollectorTimeout is for the parts of the inventory the service can't do without, a slow
+	// inventory is better than one that is missing them
+	noCollectorTimeout time.Duration = 0
+
+	CollectorStatusSuccess = "success"
+	CollectorStatusTimeout = "timeout"
+	CollectorStatusError   = "error"
+)
+
+// CollectorStatus is the outcome of one of the collectors that build the inventory.
+type CollectorStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Duration is in milliseconds, for a timed out collector it is the timeout
+	Duration int64  `json:"duration_ms"`
+	Error    string `json:"error,omitempty"`
+}
+
+// collector gathers one part of the inventory. collect returns a function that stores what was
+// gathered into the inventory, so that the inventory is only modified by the goroutine that runs
+// the collectors and never by a collector that timed out.
+type collector struct {
+	name    string
+	timeout time.Duration
+	collect func() func(*Inventory)
+}
+
+// newCollector creates a collector that stores the value returned by get in the inventory using
+// set.
+func newCollector[T any](name string, timeout time.Duration, get func() T, set func(*Inventory, T)) collector {
+	return collector{
+		name:    name,
+		timeout: timeout,
+		collect: func() func(*Inventory) {
+			value := get()
+			return func(inventory *Inventory) { set(inventory, value) }
+		},
+	}
+}
+
+type collectorResult struct {
+	index  int
+	status *CollectorStatus
+	set    func(*Inventory)
+}
+
+// runCollectors runs all the collectors concurrently and stores the results of those that finished
+// in time in the inventory. It returns the status of every collector, in the order they were given.
+// A collector that times out is abandoned: it keeps running in the background, any command it
+// started is killed when the inventory process exits. A collector without a timeout is always
+// waited for.
+func runCollectors(inventory *Inventory, collectors []collector) []*CollectorStatus {
+	results := make(chan collectorResult, len(collectors))
+	for i, c := range collectors {
+		go func() {
+			results <- runCollector(i, c)
+		}()
+	}
+
+	statuses := make([]*CollectorStatus, len(collectors))
+	for range collectors {
+		result := <-results
+		statuses[result.index] = result.status
+		if result.set != nil {
+			result.set(inventory)
+		}
+	}
+	return statuses
+}
+
+func runCollector(index int, c collector) collectorResult {
+	start := time.Now()
+	done := make(chan collectorResult, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- collectorResult{status: &CollectorStatus{Status: CollectorStatusError, Error: fmt.Sprint(r)}}
+			}
+		}()
+		done <- collectorResult{status: &CollectorStatus{Status: CollectorStatusSuccess}, set: c.collect()}
+	}()
+
+	var timeout <-chan time.Time
+	if c.timeout != noCollectorTimeout {
+		timeout = time.After(c.timeout)
+	}
+	var result collectorResult
+	select {
+	case result = <-done:
+		result.status.Duration = time.Since(start).Milliseconds()
+	case <-timeout:
+		result = collectorResult{status: &CollectorStatus{
+			Status:   CollectorStatusTimeout,
+			Duration: c.timeout.Milliseconds(),
+			Error:    fmt.Sprintf("timed out after %s", c.timeout),
+		}}
+	}
+	result.index = index
+	result.status.Name = c.name
+
+	entry := logrus.WithFields(logrus.Fields{
+		"collector": c.name,
+		"duration":  time.Since(start),
+	})
+	if result.status.Status == CollectorStatusSuccess {
+		entry.Debug("Inventory collector finished")
+	} else {
+		entry.Warnf("Inventory collector failed: %s", result.status.Error)
+	}
+	return result
+}
@@ -0,0 +1,114 @@
+package inventory
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-service/models"
+)
+
+var _ = Describe("Inventory collectors", func() {
+	hostname := func(value string, delay time.Duration) collector {
+		return newCollector("hostname", time.Second,
+			func() string {
+				time.Sleep(delay)
+				return value
+			},
+			func(i *Inventory, v string) { i.Hostname = v })
+	}
+
+	It("stores the results of all the collectors", func() {
+		inventory := Inventory{}
+		statuses := runCollectors(&inventory, []collector{
+			hostname("myhost", 0),
+			newCollector("cpu", time.Second,
+				func() *models.CPU { return &models.CPU{Count: 4} },
+				func(i *Inventory, v *models.CPU) { i.Inventory.CPU = v }),
+		})
+		Expect(inventory.Hostname).To(Equal("myhost"))
+		Expect(inventory.Inventory.CPU.Count).To(BeEquivalentTo(4))
+		Expect(statuses).To(HaveLen(2))
+		Expect(statuses[0].Name).To(Equal("hostname"))
+		Expect(statuses[0].Status).To(Equal(CollectorStatusSuccess))
+		Expect(statuses[1].Name).To(Equal("cpu"))
+		Expect(statuses[1].Status).To(Equal(CollectorStatusSuccess))
+	})
+
+	It("runs the collectors concurrently", func() {
+		collectors := []collector{}
+		for range 5 {
+			collectors = append(collectors, hostname("myhost", 200*time.Millisecond))
+		}
+		start := time.Now()
+		runCollectors(&Inventory{}, collectors)
+		Expect(time.Since(start)).To(BeNumerically("<", 600*time.Millisecond))
+	})
+
+	It("returns a partial inventory when a collector times out", func() {
+		block := make(chan struct{})
+		defer close(block)
+		inventory := Inventory{}
+		start := time.Now()
+		statuses := runCollectors(&inventory, []collector{
+			hostname("myhost", 0),
+			newCollector("disks", 100*time.Millisecond,
+				func() []*models.Disk {
+					<-block
+					return []*models.Disk{{Name: "sda"}}
+				},
+				func(i *Inventory, v []*models.Disk) { i.Inventory.Disks = v }),
+		})
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(inventory.Hostname).To(Equal("myhost"))
+		Expect(inventory.Inventory.Disks).To(BeNil())
+		Expect(statuses[1].Status).To(Equal(CollectorStatusTimeout))
+		Expect(statuses[1].Duration).To(BeEquivalentTo(100))
+		Expect(statuses[1].Error).To(ContainSubstring("timed out"))
+	})
+
+	It("waits for a collector without a timeout", func() {
+		inventory := Inventory{}
+		statuses := runCollectors(&inventory, []collector{
+			hostname("myhost", 0),
+			newCollector("disks", noCollectorTimeout,
+				func() []*models.Disk {
+					time.Sleep(100 * time.Millisecond)
+					return []*models.Disk{{Name: "sda"}}
+				},
+				func(i *Inventory, v []*models.Disk) { i.Inventory.Disks = v }),
+		})
+		Expect(inventory.Inventory.Disks).To(HaveLen(1))
+		Expect(statuses[1].Status).To(Equal(CollectorStatusSuccess))
+	})
+
+	It("reports a collector that panics as an error", func() {
+		inventory := Inventory{}
+		statuses := runCollectors(&inventory, []collector{
+			hostname("myhost", 0),
+			newCollector("memory", time.Second,
+				func() *models.Memory { panic("boom") },
+				func(i *Inventory, v *models.Memory) { i.Inventory.Memory = v }),
+		})
+		Expect(inventory.Hostname).To(Equal("myhost"))
+		Expect(inventory.Inventory.Memory).To(BeNil())
+		Expect(statuses[1].Status).To(Equal(CollectorStatusError))
+		Expect(statuses[1].Error).To(Equal("boom"))
+	})
+
+	It("serializes the model fields at the top level", func() {
+		inventory := Inventory{
+			Inventory:         models.Inventory{Hostname: "myhost"},
+			CollectorStatuses: []*CollectorStatus{{Name: "hostname", Status: CollectorStatusSuccess, Duration: 3}},
+		}
+		b, err := json.Marshal(&inventory)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(b)).To(ContainSubstring(`"hostname":"myhost"`))
+		Expect(string(b)).To(ContainSubstring(`"collector_statuses":[{"name":"hostname","status":"success","duration_ms":3}]`))
+
+		var parsed models.Inventory
+		Expect(json.Unmarshal(b, &parsed)).To(Succeed())
+		Expect(parsed.Hostname).To(Equal("myhost"))
+	})
+})
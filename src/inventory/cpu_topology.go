@@ -0,0 +1,191 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/openshift/assisted-service/models"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	numaNodeRegex        = regexp.MustCompile(`^node(\d+)$`)
+	hugePagesRegex       = regexp.MustCompile(`^hugepages-(\d+)kB$`)
+	nodeMemTotalRegex    = regexp.MustCompile(`(?m)^Node \d+ MemTotal:\s+(\d+) kB$`)
+	x86MicroarchLevels   = []string{"x86-64-v1", "x86-64-v2", "x86-64-v3", "x86-64-v4"}
+	x86MicroarchFeatures = [][]string{
+		// Flags as named in /proc/cpuinfo, pni is SSE3 and abm includes LZCNT
+		{"lm", "cmov", "cx8", "fpu", "fxsr", "mmx", "syscall", "sse", "sse2"},
+		{"cx16", "lahf_lm", "popcnt", "pni", "sse4_1", "sse4_2", "ssse3"},
+		{"avx", "avx2", "bmi1", "bmi2", "f16c", "fma", "abm", "movbe", "xsave"},
+		{"avx512f", "avx512bw", "avx512cd", "avx512dq", "avx512vl"},
+	}
+)
+
+// CPU is the CPU of the inventory with the details that the model doesn't have.
+type CPU struct {
+	*models.CPU
+	Topology *CPUTopology `json:"topology,omitempty"`
+	// MicroarchitectureLevel is the x86-64 microarchitecture level supported by the CPU, from
+	// x86-64-v1 to x86-64-v4. It is only set on x86_64 hosts.
+	MicroarchitectureLevel string `json:"microarchitecture_level,omitempty"`
+}
+
+type CPUTopology struct {
+	Sockets        int64 `json:"sockets,omitempty"`
+	CoresPerSocket int64 `json:"cores_per_socket,omitempty"`
+	ThreadsPerCore int64 `json:"threads_per_core,omitempty"`
+	// Virtualization is the hardware virtualization extension, VT-x or AMD-V
+	Virtualization   string       `json:"virtualization,omitempty"`
+	HypervisorVendor string       `json:"hypervisor_vendor,omitempty"`
+	NUMANodes        []*NUMANode  `json:"numa_nodes,omitempty"`
+	HugePages        []*HugePages `json:"huge_pages,omitempty"`
+}
+
+type NUMANode struct {
+	ID int64 `json:"id"`
+	// CPUs is the list of the CPUs of the node, in the kernel list format, for example 0-7,16-23
+	CPUs        string `json:"cpus"`
+	MemoryBytes int64  `json:"memory_bytes,omitempty"`
+}
+
+type HugePages struct {
+	SizeKiB int64 `json:"size_kib"`
+	Total   int64 `json:"total"`
+	Free    int64 `json:"free"`
+}
+
+type cpuTopology struct {
+	sysfsReader
+}
+
+func newCPUTopology(dependencies util.IDependencies) *cpuTopology {
+	return &cpuTopology{sysfsReader: sysfsReader{dependencies: dependencies}}
+}
+
+func (c *cpuTopology) readLscpu(ret *CPUTopology) {
+	o, e, exitCode := c.dependencies.Execute("lscpu", "-J")
+	if exitCode != 0 {
+		logrus.Warnf("Error running lscpu: %s", e)
+		return
+	}
+	var l lscpu
+	if err := json.Unmarshal([]byte(o), &l); err != nil {
+		logrus.Warnf("Error unmarshaling lscpu: %s", err.Error())
+		return
+	}
+	for _, f := range l.Lscpu {
+		switch strings.TrimSuffix(f.Field, ":") {
+		case "Socket(s)":
+			ret.Sockets, _ = strconv.ParseInt(f.Data, 10, 64)
+		case "Core(s) per socket":
+			ret.CoresPerSocket, _ = strconv.ParseInt(f.Data, 10, 64)
+		case "Thread(s) per core":
+			ret.ThreadsPerCore, _ = strconv.ParseInt(f.Data, 10, 64)
+		case "Virtualization":
+			ret.Virtualization = f.Data
+		case "Hypervisor vendor":
+			ret.HypervisorVendor = f.Data
+		}
+	}
+}
+
+func (c *cpuTopology) getNUMANodes() []*NUMANode {
+	files, err := c.dependencies.ReadDir("/sys/devices/system/node")
+	if err != nil {
+		logrus.WithError(err).Debug("Listing NUMA nodes")
+		return nil
+	}
+	var ret []*NUMANode
+	for _, file := range files {
+		matches := numaNodeRegex.FindStringSubmatch(file.Name())
+		if matches == nil {
+			continue
+		}
+		node := &NUMANode{}
+		node.ID, _ = strconv.ParseInt(matches[1], 10, 64)
+		dir := fmt.Sprintf("/sys/devices/system/node/%s", file.Name())
+		node.CPUs = c.readString(dir + "/cpulist")
+		if b, err := c.dependencies.ReadFile(dir + "/meminfo"); err == nil {
+			if matches := nodeMemTotalRegex.FindStringSubmatch(string(b)); matches != nil {
+				kib, _ := strconv.ParseInt(matches[1], 10, 64)
+				node.MemoryBytes = kib * 1024
+			}
+		}
+		ret = append(ret, node)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+func (c *cpuTopology) getHugePages() []*HugePages {
+	files, err := c.dependencies.ReadDir("/sys/kernel/mm/hugepages")
+	if err != nil {
+		logrus.WithError(err).Debug("Listing huge page sizes")
+		return nil
+	}
+	var ret []*HugePages
+	for _, file := range files {
+		matches := hugePagesRegex.FindStringSubmatch(file.Name())
+		if matches == nil {
+			continue
+		}
+		pages := &HugePages{}
+		pages.SizeKiB, _ = strconv.ParseInt(matches[1], 10, 64)
+		dir := fmt.Sprintf("/sys/kernel/mm/hugepages/%s", file.Name())
+		var ok bool
+		if pages.Total, ok = c.readInt(dir + "/nr_hugepages"); !ok {
+			logrus.Debugf("Could not read the huge pages of %d kB", pages.SizeKiB)
+			continue
+		}
+		pages.Free, _ = c.readInt(dir + "/free_hugepages")
+		ret = append(ret, pages)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].SizeKiB < ret[j].SizeKiB })
+	return ret
+}
+
+// GetCPUTopology returns the sockets, cores, threads, NUMA nodes and huge pages of the host.
+func GetCPUTopology(dependencies util.IDependencies) *CPUTopology {
+	c := newCPUTopology(dependencies)
+	ret := &CPUTopology{}
+	c.readLscpu(ret)
+	ret.NUMANodes = c.getNUMANodes()
+	ret.HugePages = c.getHugePages()
+	return ret
+}
+
+// x86MicroarchitectureLevel returns the highest x86-64 microarchitecture level whose features are
+// all in the CPU flags, or an empty string if the CPU doesn't even have the baseline ones.
+func x86MicroarchitectureLevel(flags []string) string {
+	has := map[string]bool{}
+	for _, flag := range flags {
+		has[flag] = true
+	}
+	ret := ""
+	for i, features := range x86MicroarchFeatures {
+		for _, feature := range features {
+			if !has[feature] {
+				return ret
+			}
+		}
+		ret = x86MicroarchLevels[i]
+	}
+	return ret
+}
+
+// applyCPUTopology attaches the topology and the microarchitecture level to the CPU.
+func applyCPUTopology(inventory *Inventory) {
+	if inventory.Inventory.CPU == nil {
+		return
+	}
+	inventory.CPU = &CPU{CPU: inventory.Inventory.CPU, Topology: inventory.cpuTopology}
+	if inventory.CPU.Architecture == "x86_64" {
+		inventory.CPU.MicroarchitectureLevel = x86MicroarchitectureLevel(inventory.CPU.Flags)
+	}
+}
@@ -0,0 +1,113 @@
+package inventory
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/openshift/assisted-service/models"
+)
+
+const nodeMeminfo = `Node 1 MemTotal:       65700432 kB
+Node 1 MemFree:        60016684 kB
+Node 1 MemUsed:         5683748 kB
+Node 1 HugePages_Total:     8
+Node 1 HugePages_Free:      8
+`
+
+var _ = Describe("CPU topology", func() {
+	var dependencies *util.MockIDependencies
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+	})
+
+	AfterEach(func() {
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	It("reports the sockets, NUMA nodes and huge pages", func() {
+		dependencies.On("Execute", "lscpu", "-J").Return(goodLscpuOutput, "", 0).Once()
+		mockSysfs(dependencies, map[string]string{
+			"/sys/devices/system/node/has_cpu":                            "0-1\n",
+			"/sys/devices/system/node/node0/cpulist":                      "0-3\n",
+			"/sys/devices/system/node/node0/meminfo":                      strings.ReplaceAll(nodeMeminfo, "Node 1", "Node 0"),
+			"/sys/devices/system/node/node1/cpulist":                      "4-7\n",
+			"/sys/devices/system/node/node1/meminfo":                      nodeMeminfo,
+			"/sys/kernel/mm/hugepages/hugepages-1048576kB/nr_hugepages":   "8\n",
+			"/sys/kernel/mm/hugepages/hugepages-1048576kB/free_hugepages": "6\n",
+			"/sys/kernel/mm/hugepages/hugepages-2048kB/nr_hugepages":      "0\n",
+			"/sys/kernel/mm/hugepages/hugepages-2048kB/free_hugepages":    "0\n",
+		})
+
+		Expect(GetCPUTopology(dependencies)).To(Equal(&CPUTopology{
+			Sockets:        1,
+			CoresPerSocket: 4,
+			ThreadsPerCore: 2,
+			Virtualization: "VT-x",
+			NUMANodes: []*NUMANode{
+				{ID: 0, CPUs: "0-3", MemoryBytes: 65700432 * 1024},
+				{ID: 1, CPUs: "4-7", MemoryBytes: 65700432 * 1024},
+			},
+			HugePages: []*HugePages{
+				{SizeKiB: 2048},
+				{SizeKiB: 1048576, Total: 8, Free: 6},
+			},
+		}))
+	})
+
+	It("reports the hypervisor", func() {
+		dependencies.On("Execute", "lscpu", "-J").Return(s390xLscpuOutput, "", 0).Once()
+		mockSysfs(dependencies, map[string]string{})
+		Expect(GetCPUTopology(dependencies)).To(Equal(&CPUTopology{
+			Sockets:          1,
+			CoresPerSocket:   1,
+			ThreadsPerCore:   1,
+			HypervisorVendor: "IBM",
+		}))
+	})
+
+	It("reports what sysfs has when lscpu fails", func() {
+		dependencies.On("Execute", "lscpu", "-J").Return("", "lscpu: not found", 127).Once()
+		mockSysfs(dependencies, map[string]string{"/sys/devices/system/node/node0/cpulist": "0-1"})
+		Expect(GetCPUTopology(dependencies)).To(Equal(&CPUTopology{NUMANodes: []*NUMANode{{ID: 0, CPUs: "0-1"}}}))
+	})
+
+	baseline := "fpu cx8 cmov mmx fxsr sse sse2 syscall lm"
+	v2 := baseline + " pni ssse3 cx16 sse4_1 sse4_2 popcnt lahf_lm"
+	v3 := v2 + " fma movbe xsave avx f16c abm bmi1 avx2 bmi2"
+
+	DescribeTable("x86MicroarchitectureLevel",
+		func(flags, expected string) {
+			Expect(x86MicroarchitectureLevel(strings.Fields(flags))).To(Equal(expected))
+		},
+		Entry("no flags", "", ""),
+		Entry("32-bit", "fpu cx8 cmov mmx fxsr sse sse2", ""),
+		Entry("v1", baseline, "x86-64-v1"),
+		Entry("v1 without popcnt", strings.ReplaceAll(v2, " popcnt", ""), "x86-64-v1"),
+		Entry("v2", v2, "x86-64-v2"),
+		Entry("v3", v3, "x86-64-v3"),
+		Entry("v3 without avx512vl", v3+" avx512f avx512bw avx512cd avx512dq", "x86-64-v3"),
+		Entry("v4", v3+" avx512f avx512bw avx512cd avx512dq avx512vl", "x86-64-v4"),
+	)
+
+	It("attaches the topology and the microarchitecture level to the CPU", func() {
+		topology := &CPUTopology{Sockets: 2}
+		inventory := &Inventory{
+			Inventory:   models.Inventory{CPU: &models.CPU{Architecture: "x86_64", Flags: strings.Fields(v2)}},
+			cpuTopology: topology,
+		}
+		applyCPUTopology(inventory)
+		Expect(inventory.CPU.Count).To(BeZero())
+		Expect(inventory.CPU.Topology).To(Equal(topology))
+		Expect(inventory.CPU.MicroarchitectureLevel).To(Equal("x86-64-v2"))
+	})
+
+	It("doesn't compute a microarchitecture level on other architectures", func() {
+		inventory := &Inventory{Inventory: models.Inventory{CPU: &models.CPU{Architecture: "aarch64", Flags: strings.Fields(v3)}}}
+		applyCPUTopology(inventory)
+		Expect(inventory.CPU.MicroarchitectureLevel).To(BeEmpty())
+	})
+})
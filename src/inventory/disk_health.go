@@ -0,0 +1,252 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DiskHealthOK       = "ok"
+	DiskHealthWarning  = "warning"
+	DiskHealthCritical = "critical"
+
+	// smartctl sets these exit status bits when it couldn't parse its arguments or open the device,
+	// the other bits report the health of the disk
+	smartctlCommandLineError = 1 << 0
+	smartctlOpenError        = 1 << 1
+
+	// Wear level, as a percentage of the rated endurance, from which a disk gets a warning
+	diskWearWarningPercentage = 90
+)
+
+// DiskHealth is a summary of the S.M.A.R.T. data of a disk, or of the SMART log of an NVMe drive.
+// Counters that the drive doesn't report are omitted.
+type DiskHealth struct {
+	Source             string   `json:"source"`
+	Status             string   `json:"status"`
+	Passed             *bool    `json:"passed,omitempty"`
+	ReallocatedSectors *int64   `json:"reallocated_sectors,omitempty"`
+	PendingSectors     *int64   `json:"pending_sectors,omitempty"`
+	MediaErrors        *int64   `json:"media_errors,omitempty"`
+	PercentageUsed     *int64   `json:"percentage_used,omitempty"`
+	TemperatureCelsius *int64   `json:"temperature_celsius,omitempty"`
+	Reasons            []string `json:"reasons,omitempty"`
+
+	// smart is the output of smartctl, which the service expects in the smart field of the disk
+	smart string
+}
+
+type smartctlOutput struct {
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	AtaSmartAttributes *struct {
+		Table []struct {
+			ID  int `json:"id"`
+			Raw struct {
+				Value int64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NvmeSmartHealthInformationLog *nvmeSmartLog `json:"nvme_smart_health_information_log"`
+	ScsiGrownDefectList           *int64        `json:"scsi_grown_defect_list"`
+	Temperature                   *struct {
+		Current *int64 `json:"current"`
+	} `json:"temperature"`
+}
+
+type nvmeSmartLog struct {
+	CriticalWarning int64  `json:"critical_warning"`
+	Temperature     *int64 `json:"temperature"`
+	PercentageUsed  *int64 `json:"percentage_used"`
+	MediaErrors     *int64 `json:"media_errors"`
+}
+
+// nvmeCLISmartLog is the output of nvme smart-log, which reports the temperature in kelvins
+type nvmeCLISmartLog struct {
+	CriticalWarning int64  `json:"critical_warning"`
+	Temperature     *int64 `json:"temperature"`
+	PercentUsed     *int64 `json:"percent_used"`
+	MediaErrors     *int64 `json:"media_errors"`
+}
+
+const (
+	ataReallocatedSectorCount = 5
+	ataCurrentPendingSector   = 197
+)
+
+type diskHealth struct {
+	dependencies    util.IDependencies
+	inventoryConfig *config.InventoryConfig
+}
+
+func newDiskHealth(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) *diskHealth {
+	return &diskHealth{dependencies: dependencies, inventoryConfig: inventoryConfig}
+}
+
+func (h *diskHealth) getSmartctlHealth(path string) (*DiskHealth, error) {
+	// -n standby avoids spinning up disks only to read their health
+	stdout, stderr, exitCode := h.dependencies.Execute("smartctl", "-j", "-H", "-A", "-n", "standby", path)
+	if exitCode < 0 || exitCode&(smartctlCommandLineError|smartctlOpenError) != 0 {
+		return nil, fmt.Errorf("smartctl failed with exit code %d: %s", exitCode, stderr)
+	}
+	var output smartctlOutput
+	if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+		return nil, fmt.Errorf("failed to parse smartctl output: %w", err)
+	}
+	if output.SmartStatus == nil && output.NvmeSmartHealthInformationLog == nil && output.AtaSmartAttributes == nil {
+		return nil, fmt.Errorf("smartctl didn't report any health data")
+	}
+
+	ret := &DiskHealth{Source: "smartctl"}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(stdout)); err == nil {
+		ret.smart = compact.String()
+	}
+	if output.SmartStatus != nil {
+		passed := output.SmartStatus.Passed
+		ret.Passed = &passed
+	}
+	if output.AtaSmartAttributes != nil {
+		for _, attribute := range output.AtaSmartAttributes.Table {
+			value := attribute.Raw.Value
+			switch attribute.ID {
+			case ataReallocatedSectorCount:
+				ret.ReallocatedSectors = &value
+			case ataCurrentPendingSector:
+				ret.PendingSectors = &value
+			}
+		}
+	}
+	if output.ScsiGrownDefectList != nil {
+		ret.ReallocatedSectors = output.ScsiGrownDefectList
+	}
+	if output.Temperature != nil {
+		ret.TemperatureCelsius = output.Temperature.Current
+	}
+	if log := output.NvmeSmartHealthInformationLog; log != nil {
+		applyNvmeSmartLog(ret, log)
+		if log.Temperature != nil {
+			ret.TemperatureCelsius = log.Temperature
+		}
+	}
+	return ret, nil
+}
+
+func (h *diskHealth) getNvmeHealth(path string) (*DiskHealth, error) {
+	stdout, stderr, exitCode := h.dependencies.Execute("nvme", "smart-log", "-o", "json", path)
+	if exitCode != 0 {
+		return nil, fmt.Errorf("nvme smart-log failed with exit code %d: %s", exitCode, stderr)
+	}
+	var output nvmeCLISmartLog
+	if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+		return nil, fmt.Errorf("failed to parse nvme smart-log output: %w", err)
+	}
+	ret := &DiskHealth{Source: "nvme"}
+	applyNvmeSmartLog(ret, &nvmeSmartLog{
+		CriticalWarning: output.CriticalWarning,
+		PercentageUsed:  output.PercentUsed,
+		MediaErrors:     output.MediaErrors,
+	})
+	if output.Temperature != nil {
+		celsius := *output.Temperature - 273
+		ret.TemperatureCelsius = &celsius
+	}
+	return ret, nil
+}
+
+func applyNvmeSmartLog(health *DiskHealth, log *nvmeSmartLog) {
+	health.PercentageUsed = log.PercentageUsed
+	health.MediaErrors = log.MediaErrors
+	if log.CriticalWarning != 0 {
+		health.Reasons = append(health.Reasons, fmt.Sprintf("NVMe critical warning 0x%02x", log.CriticalWarning))
+	}
+}
+
+// setStatus sets the status of the health summary, and the reasons for it when it isn't ok.
+func (health *DiskHealth) setStatus() {
+	critical := len(health.Reasons) > 0
+	if health.Passed != nil && !*health.Passed {
+		health.Reasons = append(health.Reasons, "SMART overall health self-assessment failed")
+		critical = true
+	}
+
+	var warnings []string
+	if health.ReallocatedSectors != nil && *health.ReallocatedSectors > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d reallocated sectors", *health.ReallocatedSectors))
+	}
+	if health.PendingSectors != nil && *health.PendingSectors > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d sectors pending reallocation", *health.PendingSectors))
+	}
+	if health.MediaErrors != nil && *health.MediaErrors > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d media errors", *health.MediaErrors))
+	}
+	if health.PercentageUsed != nil && *health.PercentageUsed >= diskWearWarningPercentage {
+		warnings = append(warnings, fmt.Sprintf("%d%% of the rated endurance used", *health.PercentageUsed))
+	}
+	health.Reasons = append(health.Reasons, warnings...)
+
+	switch {
+	case critical:
+		health.Status = DiskHealthCritical
+	case len(warnings) > 0:
+		health.Status = DiskHealthWarning
+	default:
+		health.Status = DiskHealthOK
+	}
+}
+
+func (h *diskHealth) getDiskHealth() map[string]*DiskHealth {
+	if h.inventoryConfig.DryRunEnabled {
+		return nil
+	}
+	ret := map[string]*DiskHealth{}
+	for _, path := range listPhysicalDisks(h.dependencies) {
+		health, err := h.getSmartctlHealth(path)
+		if err != nil && strings.HasPrefix(filepath.Base(path), "nvme") {
+			logrus.WithError(err).Debugf("Falling back to the NVMe SMART log of %s", path)
+			health, err = h.getNvmeHealth(path)
+		}
+		if err != nil {
+			logrus.WithError(err).Debugf("No health data for %s", path)
+			continue
+		}
+		health.setStatus()
+		ret[path] = health
+	}
+	return ret
+}
+
+// GetDiskHealth returns the health summary of the disks of the host that report one, keyed by
+// device path.
+func GetDiskHealth(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) map[string]*DiskHealth {
+	return newDiskHealth(inventoryConfig, dependencies).getDiskHealth()
+}
+
+// applyDiskHealth attaches the health summaries and the smartctl output to the disks. Disks that
+// aren't healthy get a warning, whether to install on them is left to the user.
+func applyDiskHealth(inventory *Inventory) {
+	for _, disk := range inventory.Disks {
+		health, ok := inventory.diskHealth[disk.Path]
+		if !ok {
+			continue
+		}
+		disk.Health = health
+		if disk.Smart == "" {
+			disk.Smart = health.smart
+		}
+		switch health.Status {
+		case DiskHealthCritical:
+			disk.Warnings = append(disk.Warnings, fmt.Sprintf("Disk is failing: %s", strings.Join(health.Reasons, ", ")))
+		case DiskHealthWarning:
+			disk.Warnings = append(disk.Warnings, fmt.Sprintf("Disk health warning: %s", strings.Join(health.Reasons, ", ")))
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/openshift/assisted-service/models"
+)
+
+const (
+	smartctlATAHealthy = `{
+  "json_format_version": [1, 0],
+  "smartctl": {"version": [7, 2], "exit_status": 0},
+  "device": {"name": "/dev/sda", "type": "sat", "protocol": "ATA"},
+  "smart_status": {"passed": true},
+  "ata_smart_attributes": {
+    "revision": 16,
+    "table": [
+      {"id": 5, "name": "Reallocated_Sector_Ct", "value": 100, "raw": {"value": 0, "string": "0"}},
+      {"id": 9, "name": "Power_On_Hours", "value": 95, "raw": {"value": 21345, "string": "21345"}},
+      {"id": 194, "name": "Temperature_Celsius", "value": 67, "raw": {"value": 33, "string": "33 (Min/Max 18/45)"}},
+      {"id": 197, "name": "Current_Pending_Sector", "value": 100, "raw": {"value": 0, "string": "0"}}
+    ]
+  },
+  "temperature": {"current": 33}
+}`
+	smartctlATAReallocated = `{
+  "device": {"name": "/dev/sdb", "type": "sat", "protocol": "ATA"},
+  "smart_status": {"passed": true},
+  "ata_smart_attributes": {
+    "table": [
+      {"id": 5, "name": "Reallocated_Sector_Ct", "raw": {"value": 24}},
+      {"id": 197, "name": "Current_Pending_Sector", "raw": {"value": 8}}
+    ]
+  },
+  "temperature": {"current": 41}
+}`
+	smartctlATAFailed = `{
+  "device": {"name": "/dev/sdc", "type": "sat", "protocol": "ATA"},
+  "smart_status": {"passed": false},
+  "ata_smart_attributes": {"table": [{"id": 5, "name": "Reallocated_Sector_Ct", "raw": {"value": 2011}}]}
+}`
+	smartctlNVMe = `{
+  "device": {"name": "/dev/nvme0n1", "type": "nvme", "protocol": "NVMe"},
+  "smart_status": {"passed": true, "nvme": {"value": 0}},
+  "nvme_smart_health_information_log": {
+    "critical_warning": 0,
+    "temperature": 38,
+    "available_spare": 100,
+    "percentage_used": 3,
+    "media_errors": 0,
+    "num_err_log_entries": 12
+  },
+  "temperature": {"current": 38}
+}`
+	smartctlNVMeCriticalWarning = `{
+  "device": {"name": "/dev/nvme0n1", "type": "nvme", "protocol": "NVMe"},
+  "smart_status": {"passed": false, "nvme": {"value": 4}},
+  "nvme_smart_health_information_log": {"critical_warning": 4, "temperature": 45, "percentage_used": 100, "media_errors": 3}
+}`
+	smartctlSCSI = `{
+  "device": {"name": "/dev/sdd", "type": "scsi", "protocol": "SCSI"},
+  "smart_status": {"passed": true},
+  "scsi_grown_defect_list": 0,
+  "temperature": {"current": 29}
+}`
+	smartctlNoHealth = `{
+  "device": {"name": "/dev/sde", "type": "scsi", "protocol": "SCSI"},
+  "smartctl": {"messages": [{"string": "Device does not support SMART", "severity": "information"}]}
+}`
+	nvmeCLISmartLogOutput = `{
+  "critical_warning" : 0,
+  "temperature" : 310,
+  "avail_spare" : 100,
+  "spare_thresh" : 10,
+  "percent_used" : 92,
+  "media_errors" : 0
+}`
+)
+
+var _ = Describe("Disk health", func() {
+	var (
+		dependencies    *util.MockIDependencies
+		inventoryConfig *config.InventoryConfig
+	)
+
+	int64Ptr := func(value int64) *int64 { return &value }
+	boolPtr := func(value bool) *bool { return &value }
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+		inventoryConfig = &config.InventoryConfig{}
+	})
+
+	AfterEach(func() {
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	mockSmartctl := func(path, output string, exitCode int) {
+		dependencies.On("Execute", "smartctl", "-j", "-H", "-A", "-n", "standby", path).Return(output, "", exitCode).Once()
+	}
+
+	compactJSON := func(s string) string {
+		var b bytes.Buffer
+		Expect(json.Compact(&b, []byte(s))).To(Succeed())
+		return b.String()
+	}
+
+	It("summarizes the health of every physical disk", func() {
+		dependencies.On("ReadDir", "/sys/block").Return(mockFileInfos("dm-0", "loop0", "md127", "nvme0n1", "sda", "sdb", "sr0"), nil).Once()
+		mockSmartctl("/dev/nvme0n1", smartctlNVMe, 0)
+		mockSmartctl("/dev/sda", smartctlATAHealthy, 0)
+		// Bit 6 reports errors in the device error log, it doesn't prevent reading the health data
+		mockSmartctl("/dev/sdb", smartctlATAReallocated, 64)
+
+		Expect(GetDiskHealth(inventoryConfig, dependencies)).To(Equal(map[string]*DiskHealth{
+			"/dev/nvme0n1": {
+				Source:             "smartctl",
+				Status:             DiskHealthOK,
+				Passed:             boolPtr(true),
+				MediaErrors:        int64Ptr(0),
+				PercentageUsed:     int64Ptr(3),
+				TemperatureCelsius: int64Ptr(38),
+				smart:              compactJSON(smartctlNVMe),
+			},
+			"/dev/sda": {
+				Source:             "smartctl",
+				Status:             DiskHealthOK,
+				Passed:             boolPtr(true),
+				ReallocatedSectors: int64Ptr(0),
+				PendingSectors:     int64Ptr(0),
+				TemperatureCelsius: int64Ptr(33),
+				smart:              compactJSON(smartctlATAHealthy),
+			},
+			"/dev/sdb": {
+				Source:             "smartctl",
+				Status:             DiskHealthWarning,
+				Passed:             boolPtr(true),
+				ReallocatedSectors: int64Ptr(24),
+				PendingSectors:     int64Ptr(8),
+				TemperatureCelsius: int64Ptr(41),
+				Reasons:            []string{"24 reallocated sectors", "8 sectors pending reallocation"},
+				smart:              compactJSON(smartctlATAReallocated),
+			},
+		}))
+	})
+
+	It("falls back to the NVMe SMART log", func() {
+		dependencies.On("ReadDir", "/sys/block").Return(mockFileInfos("nvme0n1"), nil).Once()
+		dependencies.On("Execute", "smartctl", "-j", "-H", "-A", "-n", "standby", "/dev/nvme0n1").Return("", "executable file not found", -1).Once()
+		dependencies.On("Execute", "nvme", "smart-log", "-o", "json", "/dev/nvme0n1").Return(nvmeCLISmartLogOutput, "", 0).Once()
+
+		Expect(GetDiskHealth(inventoryConfig, dependencies)).To(Equal(map[string]*DiskHealth{
+			"/dev/nvme0n1": {
+				Source:             "nvme",
+				Status:             DiskHealthWarning,
+				MediaErrors:        int64Ptr(0),
+				PercentageUsed:     int64Ptr(92),
+				TemperatureCelsius: int64Ptr(37),
+				Reasons:            []string{"92% of the rated endurance used"},
+			},
+		}))
+	})
+
+	It("skips disks without health data", func() {
+		dependencies.On("ReadDir", "/sys/block").Return(mockFileInfos("sda", "sdb", "sde"), nil).Once()
+		// Exit status 2 is returned for disks in standby
+		mockSmartctl("/dev/sda", "", 2)
+		mockSmartctl("/dev/sdb", "not json", 0)
+		mockSmartctl("/dev/sde", smartctlNoHealth, 4)
+		Expect(GetDiskHealth(inventoryConfig, dependencies)).To(BeEmpty())
+	})
+
+	It("is skipped in dry run", func() {
+		inventoryConfig.DryRunEnabled = true
+		Expect(GetDiskHealth(inventoryConfig, dependencies)).To(BeNil())
+	})
+
+	DescribeTable("status",
+		func(output string, exitCode int, expectedStatus string, expectedReasons []string) {
+			dependencies.On("ReadDir", "/sys/block").Return(mockFileInfos("sdx"), nil).Once()
+			mockSmartctl("/dev/sdx", output, exitCode)
+			health := GetDiskHealth(inventoryConfig, dependencies)["/dev/sdx"]
+			Expect(health).NotTo(BeNil())
+			Expect(health.Status).To(Equal(expectedStatus))
+			Expect(health.Reasons).To(Equal(expectedReasons))
+		},
+		Entry("healthy SCSI disk", smartctlSCSI, 0, DiskHealthOK, nil),
+		Entry("failed self-assessment", smartctlATAFailed, 8, DiskHealthCritical,
+			[]string{"SMART overall health self-assessment failed", "2011 reallocated sectors"}),
+		Entry("NVMe critical warning", smartctlNVMeCriticalWarning, 8, DiskHealthCritical,
+			[]string{"NVMe critical warning 0x04", "SMART overall health self-assessment failed", "3 media errors",
+				"100% of the rated endurance used"}),
+	)
+
+	Context("applyDiskHealth", func() {
+		It("warns about unhealthy disks and reports their SMART data", func() {
+			inventory := &Inventory{
+				Disks: []*Disk{
+					{Disk: &models.Disk{Path: "/dev/sda", InstallationEligibility: models.DiskInstallationEligibility{Eligible: true}}},
+					{Disk: &models.Disk{Path: "/dev/sdb", InstallationEligibility: models.DiskInstallationEligibility{Eligible: true}}},
+					{Disk: &models.Disk{Path: "/dev/sdc", InstallationEligibility: models.DiskInstallationEligibility{Eligible: true}}},
+				},
+				diskHealth: map[string]*DiskHealth{
+					"/dev/sda": {Status: DiskHealthWarning, Reasons: []string{"24 reallocated sectors"}, smart: `{"smart_status":{"passed":true}}`},
+					"/dev/sdb": {Status: DiskHealthCritical, Reasons: []string{"SMART overall health self-assessment failed"}},
+				},
+			}
+			applyDiskHealth(inventory)
+
+			Expect(inventory.Disks[0].Health.Status).To(Equal(DiskHealthWarning))
+			Expect(inventory.Disks[0].Smart).To(Equal(`{"smart_status":{"passed":true}}`))
+			Expect(inventory.Disks[0].Warnings).To(ConsistOf("Disk health warning: 24 reallocated sectors"))
+			Expect(inventory.Disks[0].InstallationEligibility.Eligible).To(BeTrue())
+			Expect(inventory.Disks[1].Warnings).To(ConsistOf("Disk is failing: SMART overall health self-assessment failed"))
+			Expect(inventory.Disks[1].InstallationEligibility.Eligible).To(BeTrue())
+			Expect(inventory.Disks[1].InstallationEligibility.NotEligibleReasons).To(BeEmpty())
+			Expect(inventory.Disks[2].Health).To(BeNil())
+			Expect(inventory.Disks[2].Warnings).To(BeEmpty())
+			Expect(inventory.Disks[2].InstallationEligibility.Eligible).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,174 @@
+package inventory
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/sirupsen/logrus"
+)
+
+// blkid exits with this code when it didn't find any signature on the device
+const blkidNothingFound = 2
+
+// DiskContent describes the data found on a disk: its partition table and the signatures of the
+// file systems, RAID members, LVM physical volumes and encrypted volumes on it and its partitions.
+type DiskContent struct {
+	PartitionTable string           `json:"partition_table,omitempty"`
+	Signatures     []*DataSignature `json:"signatures,omitempty"`
+}
+
+// DataSignature is the signature found on a disk or on one of its partitions.
+type DataSignature struct {
+	Device string `json:"device"`
+	// Type is the type reported by blkid, for example xfs, LVM2_member, linux_raid_member,
+	// crypto_LUKS, zfs_member or ceph_bluestore
+	Type string `json:"type,omitempty"`
+	// Usage is the kind of signature: filesystem, raid, crypto or other
+	Usage          string `json:"usage,omitempty"`
+	Label          string `json:"label,omitempty"`
+	PartitionLabel string `json:"partition_label,omitempty"`
+}
+
+type diskSignatures struct {
+	dependencies util.IDependencies
+}
+
+func newDiskSignatures(dependencies util.IDependencies) *diskSignatures {
+	return &diskSignatures{dependencies: dependencies}
+}
+
+// probe returns the values reported by blkid for the device. It uses low level probing, which reads
+// the device directly instead of the blkid cache, and never writes to it.
+func (s *diskSignatures) probe(path string) (map[string]string, error) {
+	stdout, stderr, exitCode := s.dependencies.Execute("blkid", "-p", "-o", "export", path)
+	if exitCode == blkidNothingFound {
+		return map[string]string{}, nil
+	}
+	if exitCode != 0 {
+		return nil, fmt.Errorf("blkid failed with exit code %d: %s", exitCode, stderr)
+	}
+	return parseBlkidExport(stdout), nil
+}
+
+// parseBlkidExport parses the KEY=value lines of blkid -o export.
+func parseBlkidExport(output string) map[string]string {
+	ret := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if found {
+			ret[key] = value
+		}
+	}
+	return ret
+}
+
+func (s *diskSignatures) getPartitions(diskPath string) []string {
+	name := filepath.Base(diskPath)
+	files, err := s.dependencies.ReadDir(filepath.Join("/sys/block", name))
+	if err != nil {
+		logrus.WithError(err).Warnf("Failed to list the partitions of %s", diskPath)
+		return nil
+	}
+	var ret []string
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), name) {
+			ret = append(ret, filepath.Join("/dev", file.Name()))
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (s *diskSignatures) getDiskContent(diskPath string) *DiskContent {
+	values, err := s.probe(diskPath)
+	if err != nil {
+		logrus.WithError(err).Warnf("Failed to probe %s", diskPath)
+		return nil
+	}
+	ret := &DiskContent{PartitionTable: values["PTTYPE"]}
+	if signature := newDataSignature(diskPath, values); signature != nil {
+		ret.Signatures = append(ret.Signatures, signature)
+	}
+	for _, partition := range s.getPartitions(diskPath) {
+		values, err = s.probe(partition)
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to probe %s", partition)
+			continue
+		}
+		if signature := newDataSignature(partition, values); signature != nil {
+			ret.Signatures = append(ret.Signatures, signature)
+		}
+	}
+	if ret.PartitionTable == "" && len(ret.Signatures) == 0 {
+		return nil
+	}
+	return ret
+}
+
+func newDataSignature(device string, values map[string]string) *DataSignature {
+	ret := &DataSignature{
+		Device:         device,
+		Type:           values["TYPE"],
+		Usage:          values["USAGE"],
+		Label:          values["LABEL"],
+		PartitionLabel: values["PART_ENTRY_NAME"],
+	}
+	if ret.Type == "" && ret.PartitionLabel == "" {
+		return nil
+	}
+	return ret
+}
+
+func (s *diskSignatures) getSignatures() map[string]*DiskContent {
+	ret := map[string]*DiskContent{}
+	for _, path := range listPhysicalDisks(s.dependencies) {
+		if content := s.getDiskContent(path); content != nil {
+			ret[path] = content
+		}
+	}
+	return ret
+}
+
+// GetDiskContents returns the partition table and data signatures of the disks of the host that
+// aren't empty, keyed by device path.
+func GetDiskContents(dependencies util.IDependencies) map[string]*DiskContent {
+	return newDiskSignatures(dependencies).getSignatures()
+}
+
+// dataWarning returns a description of the data found on the disk, or an empty string if there is
+// none. Partition labels alone aren't data.
+func (c *DiskContent) dataWarning() string {
+	var found []string
+	for _, signature := range c.Signatures {
+		if signature.Type == "" {
+			continue
+		}
+		description := signature.Type
+		if signature.Label != "" {
+			description += fmt.Sprintf(" '%s'", signature.Label)
+		}
+		found = append(found, fmt.Sprintf("%s on %s", description, signature.Device))
+	}
+	if len(found) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Disk contains data that will be erased by the installation: %s", strings.Join(found, ", "))
+}
+
+// applyDiskContents attaches the contents to the disks, and warns about the disks that contain data.
+// The warning doesn't make the disk ineligible, wiping the disk may be intended.
+func applyDiskContents(inventory *Inventory) {
+	for _, disk := range inventory.Disks {
+		content, ok := inventory.diskContents[disk.Path]
+		if !ok {
+			continue
+		}
+		disk.Content = content
+		if warning := content.dataWarning(); warning != "" && !disk.IsInstallationMedia {
+			disk.Warnings = append(disk.Warnings, warning)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package inventory
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/openshift/assisted-service/models"
+)
+
+const (
+	blkidGPTDisk = `DEVNAME=/dev/sda
+PTUUID=5a8e6c1f-3b52-4b3c-9a1e-2f4f8d6d1c11
+PTTYPE=gpt
+`
+	blkidXFSPartition = `DEVNAME=/dev/sda1
+UUID=0b2a3c4d-1111-4222-8333-944455556666
+BLOCK_SIZE=512
+TYPE=xfs
+USAGE=filesystem
+PART_ENTRY_SCHEME=gpt
+PART_ENTRY_NAME=root
+PART_ENTRY_UUID=1c0f2e3d-aaaa-4bbb-8ccc-ddddeeeeffff
+PART_ENTRY_TYPE=0fc63daf-8483-4772-8e79-3d69d8477de4
+PART_ENTRY_NUMBER=1
+PART_ENTRY_OFFSET=2048
+PART_ENTRY_SIZE=1048576
+PART_ENTRY_DISK=8:0
+`
+	blkidLVMPartition = `DEVNAME=/dev/sda2
+UUID=Gw1nXx-0mAz-Aa1b-Bb2c-Cc3d-Dd4e-Ee5f6g
+VERSION=LVM2 001
+TYPE=LVM2_member
+USAGE=raid
+PART_ENTRY_SCHEME=gpt
+PART_ENTRY_NAME=vg-data
+PART_ENTRY_NUMBER=2
+`
+	blkidEmptyPartition = `DEVNAME=/dev/sda3
+PART_ENTRY_SCHEME=gpt
+PART_ENTRY_NAME=reserved
+PART_ENTRY_NUMBER=3
+`
+	blkidCephDisk = `DEVNAME=/dev/sdb
+TYPE=ceph_bluestore
+USAGE=other
+`
+	blkidZFSDisk = `DEVNAME=/dev/sdc
+LABEL=tank
+UUID=13198746205113462788
+UUID_SUB=17026931153453624018
+BLOCK_SIZE=4096
+VERSION=5000
+TYPE=zfs_member
+USAGE=filesystem
+`
+	blkidLUKSDisk = `DEVNAME=/dev/nvme0n1
+UUID=3f2b1a00-9e8d-4c7b-a6f5-e4d3c2b1a099
+VERSION=2
+TYPE=crypto_LUKS
+USAGE=crypto
+`
+	blkidMDRaidDisk = `DEVNAME=/dev/sdd
+UUID=7c1f0a52-66e1-2b3c-4d5e-6f708192a3b4
+UUID_SUB=a1b2c3d4-e5f6-0718-293a-4b5c6d7e8f90
+LABEL=localhost:0
+VERSION=1.2
+TYPE=linux_raid_member
+USAGE=raid
+`
+	blkidNTFSPartition = `DEVNAME=/dev/sde2
+LABEL=Windows
+UUID=1A2B3C4D5E6F7A8B
+BLOCK_SIZE=512
+TYPE=ntfs
+USAGE=filesystem
+PART_ENTRY_SCHEME=dos
+PART_ENTRY_TYPE=0x7
+PART_ENTRY_FLAGS=0x80
+PART_ENTRY_NUMBER=2
+`
+	blkidDOSDisk = `DEVNAME=/dev/sde
+PTUUID=8a9b0c1d
+PTTYPE=dos
+`
+)
+
+var _ = Describe("Disk contents", func() {
+	var dependencies *util.MockIDependencies
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+		mockSysfs(dependencies, map[string]string{
+			"/sys/block/loop0/size":            "",
+			"/sys/block/nvme0n1/size":          "",
+			"/sys/block/sda/size":              "",
+			"/sys/block/sda/sda1/partition":    "1",
+			"/sys/block/sda/sda2/partition":    "2",
+			"/sys/block/sda/sda3/partition":    "3",
+			"/sys/block/sdb/size":              "",
+			"/sys/block/sdc/size":              "",
+			"/sys/block/sdd/size":              "",
+			"/sys/block/sde/size":              "",
+			"/sys/block/sde/sde1/partition":    "1",
+			"/sys/block/sde/sde2/partition":    "2",
+			"/sys/block/sdf/size":              "",
+			"/sys/block/sdf/queue/rotational":  "1",
+			"/sys/block/sdf/holders/README.md": "",
+		})
+	})
+
+	AfterEach(func() {
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	mockBlkid := func(path, output string, exitCode int) {
+		dependencies.On("Execute", "blkid", "-p", "-o", "export", path).Return(output, "", exitCode).Once()
+	}
+
+	It("reports the signatures of every disk and partition", func() {
+		mockBlkid("/dev/nvme0n1", blkidLUKSDisk, 0)
+		mockBlkid("/dev/sda", blkidGPTDisk, 0)
+		mockBlkid("/dev/sda1", blkidXFSPartition, 0)
+		mockBlkid("/dev/sda2", blkidLVMPartition, 0)
+		mockBlkid("/dev/sda3", blkidEmptyPartition, 0)
+		mockBlkid("/dev/sdb", blkidCephDisk, 0)
+		mockBlkid("/dev/sdc", blkidZFSDisk, 0)
+		mockBlkid("/dev/sdd", blkidMDRaidDisk, 0)
+		mockBlkid("/dev/sde", blkidDOSDisk, 0)
+		mockBlkid("/dev/sde1", "", 2)
+		mockBlkid("/dev/sde2", blkidNTFSPartition, 0)
+		mockBlkid("/dev/sdf", "", 2)
+
+		Expect(GetDiskContents(dependencies)).To(Equal(map[string]*DiskContent{
+			"/dev/nvme0n1": {Signatures: []*DataSignature{{Device: "/dev/nvme0n1", Type: "crypto_LUKS", Usage: "crypto"}}},
+			"/dev/sda": {
+				PartitionTable: "gpt",
+				Signatures: []*DataSignature{
+					{Device: "/dev/sda1", Type: "xfs", Usage: "filesystem", PartitionLabel: "root"},
+					{Device: "/dev/sda2", Type: "LVM2_member", Usage: "raid", PartitionLabel: "vg-data"},
+					{Device: "/dev/sda3", PartitionLabel: "reserved"},
+				},
+			},
+			"/dev/sdb": {Signatures: []*DataSignature{{Device: "/dev/sdb", Type: "ceph_bluestore", Usage: "other"}}},
+			"/dev/sdc": {Signatures: []*DataSignature{{Device: "/dev/sdc", Type: "zfs_member", Usage: "filesystem", Label: "tank"}}},
+			"/dev/sdd": {Signatures: []*DataSignature{{Device: "/dev/sdd", Type: "linux_raid_member", Usage: "raid", Label: "localhost:0"}}},
+			"/dev/sde": {
+				PartitionTable: "dos",
+				Signatures:     []*DataSignature{{Device: "/dev/sde2", Type: "ntfs", Usage: "filesystem", Label: "Windows"}},
+			},
+		}))
+	})
+
+	It("skips disks that can't be probed", func() {
+		mockBlkid("/dev/nvme0n1", "", 4)
+		mockBlkid("/dev/sda", blkidGPTDisk, 0)
+		mockBlkid("/dev/sda1", "", 4)
+		mockBlkid("/dev/sda2", blkidLVMPartition, 0)
+		mockBlkid("/dev/sda3", "", 2)
+		for _, disk := range []string{"/dev/sdb", "/dev/sdc", "/dev/sdd", "/dev/sde", "/dev/sdf"} {
+			mockBlkid(disk, "", 2)
+		}
+		mockBlkid("/dev/sde1", "", 2)
+		mockBlkid("/dev/sde2", "", 2)
+
+		Expect(GetDiskContents(dependencies)).To(Equal(map[string]*DiskContent{
+			"/dev/sda": {
+				PartitionTable: "gpt",
+				Signatures:     []*DataSignature{{Device: "/dev/sda2", Type: "LVM2_member", Usage: "raid", PartitionLabel: "vg-data"}},
+			},
+		}))
+	})
+
+	Context("applyDiskContents", func() {
+		It("warns about disks with data without making them ineligible", func() {
+			eligible := models.DiskInstallationEligibility{Eligible: true}
+			inventory := &Inventory{
+				Disks: []*Disk{
+					{Disk: &models.Disk{Path: "/dev/sda", InstallationEligibility: eligible}},
+					{Disk: &models.Disk{Path: "/dev/sdb", InstallationEligibility: eligible}},
+					{Disk: &models.Disk{Path: "/dev/sdc", InstallationEligibility: eligible, IsInstallationMedia: true}},
+					{Disk: &models.Disk{Path: "/dev/sdd", InstallationEligibility: eligible}},
+				},
+				diskContents: map[string]*DiskContent{
+					"/dev/sda": {PartitionTable: "gpt", Signatures: []*DataSignature{
+						{Device: "/dev/sda1", Type: "xfs", Usage: "filesystem", PartitionLabel: "root"},
+						{Device: "/dev/sda2", Type: "zfs_member", Usage: "filesystem", Label: "tank"},
+					}},
+					"/dev/sdb": {PartitionTable: "gpt", Signatures: []*DataSignature{{Device: "/dev/sdb1", PartitionLabel: "empty"}}},
+					"/dev/sdc": {Signatures: []*DataSignature{{Device: "/dev/sdc", Type: "iso9660", Usage: "filesystem"}}},
+				},
+			}
+			applyDiskContents(inventory)
+
+			Expect(inventory.Disks[0].Content.PartitionTable).To(Equal("gpt"))
+			Expect(inventory.Disks[0].Warnings).To(ConsistOf(
+				"Disk contains data that will be erased by the installation: xfs on /dev/sda1, zfs_member 'tank' on /dev/sda2"))
+			Expect(inventory.Disks[0].InstallationEligibility.Eligible).To(BeTrue())
+			Expect(inventory.Disks[1].Content).NotTo(BeNil())
+			Expect(inventory.Disks[1].Warnings).To(BeEmpty())
+			Expect(inventory.Disks[2].Warnings).To(BeEmpty())
+			Expect(inventory.Disks[3].Content).To(BeNil())
+		})
+	})
+})
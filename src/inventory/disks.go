@@ -1,9 +1,13 @@
 package inventory
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/jaypipes/ghw"
@@ -550,6 +554,28 @@ func (d *disks) getISCSIHostIPAddress(diskName string) string {
 	return ipAddress
 }
 
+// listPhysicalDisks returns the paths of the block devices of the host that are backed by hardware.
+// Virtual devices like device mapper, software RAID or loop devices are skipped, as well as the per
+// path devices of native NVMe multipath.
+func listPhysicalDisks(dependencies util.IDependencies) []string {
+	files, err := dependencies.ReadDir("/sys/block")
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to list block devices")
+		return nil
+	}
+	var ret []string
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasPrefix(name, "dm-") || strings.HasPrefix(name, "md") || strings.HasPrefix(name, "loop") ||
+			strings.HasPrefix(name, "zram") || strings.HasPrefix(name, "ram") || strings.HasPrefix(name, "sr") ||
+			strings.HasPrefix(name, "nbd") || nvmePathRegex.MatchString(name) {
+			continue
+		}
+		ret = append(ret, filepath.Join("/dev", name))
+	}
+	return ret
+}
+
 func GetDisks(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) []*models.Disk {
 	return newDisks(inventoryConfig, dependencies).getDisks()
 }
@@ -650,3 +676,402 @@ func (d *disks) getISCSISession(diskName string) (string, error) {
 	return d.getISCSIProperty(diskName, sessionIndex)
 
 }
+
+const (
+	rhcosBootLabel = "boot"
+	rhcosRootLabel = "root"
+)
+
+var (
+	ostreeEntryRegex     = regexp.MustCompile(`^ostree-(\d+)-.*\.conf$`)
+	kcmPodResourcesRegex = regexp.MustCompile(`^kube-controller-manager-pod-(\d+)$`)
+	kcmClusterName       = regexp.MustCompile(`"cluster-name":\s*\[\s*"([^"]+)"`)
+	ostreeDeploymentArg  = regexp.MustCompile(`(?:^|\s)ostree=(\S+)`)
+	kubeconfigServer     = regexp.MustCompile(`(?m)^\s*server:\s*(\S+)\s*$`)
+	legacyRHCOSVersion   = regexp.MustCompile(`^4(\d{1,2})\.\d+\.`)
+	osReleaseValueQuotes = "\"'"
+)
+
+// PreviousInstallation is an RHCOS deployment found on a disk, left by a previous installation of
+// OpenShift.
+type PreviousInstallation struct {
+	BootPartition    string `json:"boot_partition"`
+	RootPartition    string `json:"root_partition,omitempty"`
+	OSVersion        string `json:"os_version,omitempty"`
+	OpenShiftVersion string `json:"openshift_version,omitempty"`
+	// Deployment is the path of the ostree deployment the boot loader boots by default
+	Deployment string `json:"deployment,omitempty"`
+	// The cluster the node belonged to, identified by the API server in the kubelet kubeconfig
+	APIServer     string `json:"api_server,omitempty"`
+	ClusterName   string `json:"cluster_name,omitempty"`
+	ClusterDomain string `json:"cluster_domain,omitempty"`
+	// InfraID is the infrastructure ID that identifies the cluster, its name followed by a random
+	// suffix. It is only found on control plane nodes, in the kube-controller-manager configuration.
+	// The cluster version ID is only stored in etcd.
+	InfraID string `json:"infra_id,omitempty"`
+}
+
+// coreOSPartition is a partition that may belong to an RHCOS installation, as probed by blkid.
+type coreOSPartition struct {
+	path   string
+	fsType string
+	uuid   string
+	// name is the GPT partition name
+	name string
+}
+
+// previousInstallationCacheEntry is what was found on a disk, valid as long as the partitions
+// and their file systems identified by the key don't change.
+type previousInstallationCacheEntry struct {
+	Key          string                `json:"key"`
+	Installation *PreviousInstallation `json:"installation,omitempty"`
+}
+
+type previousInstallations struct {
+	dependencies    util.IDependencies
+	inventoryConfig *config.InventoryConfig
+	signatures      *diskSignatures
+	cache           map[string]*previousInstallationCacheEntry
+	updatedCache    map[string]*previousInstallationCacheEntry
+}
+
+func newPreviousInstallations(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) *previousInstallations {
+	return &previousInstallations{
+		dependencies:    dependencies,
+		inventoryConfig: inventoryConfig,
+		signatures:      newDiskSignatures(dependencies),
+	}
+}
+
+// mount mounts the partition read only in a temporary directory, without replaying the journal so
+// that nothing is written to it. It returns a function that unmounts it.
+func (r *previousInstallations) mount(partition string, fsType string) (string, func(), error) {
+	stdout, stderr, exitCode := r.dependencies.Execute("mktemp", "-d", "/tmp/previous-installation.XXXXXX")
+	if exitCode != 0 {
+		return "", nil, fmt.Errorf("failed to create mount point: %s", stderr)
+	}
+	dir := strings.TrimSpace(stdout)
+	options := "ro"
+	switch fsType {
+	case "ext4", "ext3":
+		options = "ro,noload"
+	case "xfs":
+		options = "ro,norecovery"
+	}
+	removeDir := func() {
+		if _, stderr, exitCode := r.dependencies.Execute("rmdir", dir); exitCode != 0 {
+			logrus.Warnf("Failed to remove %s: %s", dir, stderr)
+		}
+	}
+	if _, stderr, exitCode = r.dependencies.Execute("mount", "-o", options, partition, dir); exitCode != 0 {
+		removeDir()
+		return "", nil, fmt.Errorf("failed to mount %s: %s", partition, stderr)
+	}
+	return dir, func() {
+		if _, stderr, exitCode := r.dependencies.Execute("umount", dir); exitCode != 0 {
+			logrus.Warnf("Failed to unmount %s: %s", dir, stderr)
+			return
+		}
+		removeDir()
+	}, nil
+}
+
+// readDefaultEntry returns the values of the default ostree boot loader entry, the one with the
+// highest index.
+func (r *previousInstallations) readDefaultEntry(bootDir string) (map[string]string, error) {
+	entriesDir := filepath.Join(bootDir, "loader", "entries")
+	files, err := r.dependencies.ReadDir(entriesDir)
+	if err != nil {
+		return nil, err
+	}
+	var entry string
+	highest := -1
+	for _, file := range files {
+		matches := ostreeEntryRegex.FindStringSubmatch(file.Name())
+		if matches == nil {
+			continue
+		}
+		index, _ := strconv.Atoi(matches[1])
+		if index > highest {
+			highest = index
+			entry = file.Name()
+		}
+	}
+	if entry == "" {
+		return nil, fmt.Errorf("no ostree boot loader entry in %s", entriesDir)
+	}
+	content, err := r.dependencies.ReadFile(filepath.Join(entriesDir, entry))
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), " ")
+		if key != "" && !strings.HasPrefix(key, "#") {
+			ret[key] = strings.TrimSpace(value)
+		}
+	}
+	return ret, nil
+}
+
+func parseOSRelease(content string) map[string]string {
+	ret := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if found {
+			ret[key] = strings.Trim(value, osReleaseValueQuotes)
+		}
+	}
+	return ret
+}
+
+// openShiftVersionFromRHCOS returns the OpenShift version encoded in RHCOS versions before 4.19,
+// for example 414.92.202310210434-0 for OpenShift 4.14 or 49.84.202110081407-0 for OpenShift 4.9.
+func openShiftVersionFromRHCOS(version string) string {
+	matches := legacyRHCOSVersion.FindStringSubmatch(version)
+	if matches == nil {
+		return ""
+	}
+	minor, _ := strconv.Atoi(matches[1])
+	return fmt.Sprintf("4.%d", minor)
+}
+
+// readRoot reads the OpenShift version and cluster of the ostree deployment from the root
+// partition mounted at rootDir.
+func (r *previousInstallations) readRoot(rootDir string, installation *PreviousInstallation) {
+	deployment, err := r.dependencies.EvalSymlinks(filepath.Join(rootDir, installation.Deployment))
+	if err != nil {
+		logrus.WithError(err).Warnf("Failed to resolve ostree deployment %s", installation.Deployment)
+		return
+	}
+	if content, err := r.dependencies.ReadFile(filepath.Join(deployment, "usr", "lib", "os-release")); err == nil {
+		osRelease := parseOSRelease(string(content))
+		if version := osRelease["OPENSHIFT_VERSION"]; version != "" {
+			installation.OpenShiftVersion = version
+		}
+		if installation.OSVersion == "" {
+			installation.OSVersion = osRelease["VERSION"]
+		}
+	}
+
+	// The kubelet kubeconfig is in /etc after the node joined the cluster, and in /var before
+	for _, kubeconfig := range []string{
+		filepath.Join(deployment, "etc", "kubernetes", "kubeconfig"),
+		filepath.Join(rootDir, "ostree", "deploy", "rhcos", "var", "lib", "kubelet", "kubeconfig"),
+	} {
+		content, err := r.dependencies.ReadFile(kubeconfig)
+		if err != nil {
+			continue
+		}
+		matches := kubeconfigServer.FindStringSubmatch(string(content))
+		if matches == nil {
+			continue
+		}
+		installation.APIServer = matches[1]
+		if u, err := url.Parse(matches[1]); err == nil {
+			domain := strings.TrimPrefix(u.Hostname(), "api-int.")
+			installation.ClusterDomain = strings.TrimPrefix(domain, "api.")
+			// The cluster domain is the cluster name followed by the base domain
+			if name, _, found := strings.Cut(installation.ClusterDomain, "."); found {
+				installation.ClusterName = name
+			}
+		}
+		break
+	}
+	installation.InfraID = r.readInfraID(deployment)
+}
+
+// readInfraID returns the infrastructure ID of the cluster from the configuration of the latest
+// revision of the kube-controller-manager static pod, which only control plane nodes have.
+func (r *previousInstallations) readInfraID(deployment string) string {
+	resourcesDir := filepath.Join(deployment, "etc", "kubernetes", "static-pod-resources")
+	files, err := r.dependencies.ReadDir(resourcesDir)
+	if err != nil {
+		return ""
+	}
+	var latest string
+	highest := -1
+	for _, file := range files {
+		matches := kcmPodResourcesRegex.FindStringSubmatch(file.Name())
+		if matches == nil {
+			continue
+		}
+		revision, _ := strconv.Atoi(matches[1])
+		if revision > highest {
+			highest = revision
+			latest = file.Name()
+		}
+	}
+	if latest == "" {
+		return ""
+	}
+	content, err := r.dependencies.ReadFile(filepath.Join(resourcesDir, latest, "configmaps", "config", "config.yaml"))
+	if err != nil {
+		return ""
+	}
+	if matches := kcmClusterName.FindStringSubmatch(string(content)); matches != nil {
+		return matches[1]
+	}
+	return ""
+}
+
+// readPreviousInstallation mounts the boot and root partitions to read the RHCOS installation. The
+// root partition is only mounted once the boot partition has an ostree entry. The partitions are
+// unmounted before returning, which is why the collector has no timeout. The second value tells
+// whether all the partitions could be read, so that the result can be cached.
+func (r *previousInstallations) readPreviousInstallation(boot, root *coreOSPartition) (*PreviousInstallation, bool) {
+	bootDir, unmountBoot, err := r.mount(boot.path, boot.fsType)
+	if err != nil {
+		logrus.WithError(err).Warnf("Failed to read boot partition %s", boot.path)
+		return nil, false
+	}
+	defer unmountBoot()
+	entry, err := r.readDefaultEntry(bootDir)
+	if err != nil {
+		logrus.WithError(err).Debugf("Partition %s isn't an RHCOS boot partition", boot.path)
+		return nil, true
+	}
+	ret := &PreviousInstallation{
+		BootPartition: boot.path,
+		RootPartition: root.path,
+		OSVersion:     entry["version"],
+	}
+	ret.OpenShiftVersion = openShiftVersionFromRHCOS(ret.OSVersion)
+	if matches := ostreeDeploymentArg.FindStringSubmatch(entry["options"]); matches != nil {
+		ret.Deployment = matches[1]
+	}
+	if ret.Deployment == "" {
+		return ret, true
+	}
+
+	rootDir, unmountRoot, err := r.mount(root.path, root.fsType)
+	if err != nil {
+		logrus.WithError(err).Warnf("Failed to read root partition %s", root.path)
+		return ret, false
+	}
+	defer unmountRoot()
+	r.readRoot(rootDir, ret)
+	return ret, true
+}
+
+// getPreviousInstallation returns the RHCOS installation on the disk, or nil if there is none.
+// Other distributions also label their file systems boot and root, so the partitions are only
+// mounted when the GPT partitions have the names RHCOS gives them too, and only when they changed
+// since the installation was cached.
+func (r *previousInstallations) getPreviousInstallation(diskPath string) *PreviousInstallation {
+	var boot, root *coreOSPartition
+	for _, partition := range r.signatures.getPartitions(diskPath) {
+		values, err := r.signatures.probe(partition)
+		if err != nil {
+			continue
+		}
+		candidate := &coreOSPartition{
+			path:   partition,
+			fsType: values["TYPE"],
+			uuid:   values["UUID"],
+			name:   values["PART_ENTRY_NAME"],
+		}
+		switch values["LABEL"] {
+		case rhcosBootLabel:
+			boot = candidate
+		case rhcosRootLabel:
+			root = candidate
+		}
+	}
+	if boot == nil || root == nil || boot.name != rhcosBootLabel || root.name != rhcosRootLabel {
+		return nil
+	}
+
+	// Installing creates new file systems, so their UUIDs tell when the content changed
+	var key string
+	if boot.uuid != "" {
+		key = strings.Join([]string{boot.path, boot.uuid, root.path, root.uuid}, ",")
+	}
+	if entry, ok := r.cache[diskPath]; ok && key != "" && entry.Key == key {
+		r.updatedCache[diskPath] = entry
+		return entry.Installation
+	}
+	ret, complete := r.readPreviousInstallation(boot, root)
+	if complete && key != "" {
+		r.updatedCache[diskPath] = &previousInstallationCacheEntry{Key: key, Installation: ret}
+	}
+	return ret
+}
+
+// readCache reads the previous installations found by the previous runs. A missing or invalid
+// cache is the same as an empty one.
+func (r *previousInstallations) readCache() {
+	r.cache = map[string]*previousInstallationCacheEntry{}
+	r.updatedCache = map[string]*previousInstallationCacheEntry{}
+	path := r.inventoryConfig.PreviousInstallationsCacheFile
+	if path == "" {
+		return
+	}
+	content, err := os.ReadFile(path)
+	if err != nil || len(content) == 0 {
+		return
+	}
+	if err = json.Unmarshal(content, &r.cache); err != nil {
+		logrus.WithError(err).Warnf("Ignoring invalid previous installations cache %s", path)
+		r.cache = map[string]*previousInstallationCacheEntry{}
+	}
+}
+
+// writeCache replaces the cache with the disks found in this run.
+func (r *previousInstallations) writeCache() {
+	path := r.inventoryConfig.PreviousInstallationsCacheFile
+	if path == "" {
+		return
+	}
+	content, err := json.Marshal(r.updatedCache)
+	if err != nil {
+		return
+	}
+	if err = os.WriteFile(path, content, 0600); err != nil {
+		logrus.WithError(err).Warnf("Failed to write previous installations cache %s", path)
+	}
+}
+
+func (r *previousInstallations) getPreviousInstallations() map[string]*PreviousInstallation {
+	if r.inventoryConfig.DryRunEnabled {
+		return nil
+	}
+	r.readCache()
+	ret := map[string]*PreviousInstallation{}
+	for _, path := range listPhysicalDisks(r.dependencies) {
+		if installation := r.getPreviousInstallation(path); installation != nil {
+			ret[path] = installation
+		}
+	}
+	r.writeCache()
+	return ret
+}
+
+// GetPreviousInstallations returns the RHCOS installations found on the disks of the host, keyed
+// by device path.
+func GetPreviousInstallations(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) map[string]*PreviousInstallation {
+	return newPreviousInstallations(inventoryConfig, dependencies).getPreviousInstallations()
+}
+
+// applyPreviousInstallations attaches the previous installations to the disks, and warns that
+// installing will erase them.
+func applyPreviousInstallations(inventory *Inventory) {
+	for _, disk := range inventory.Disks {
+		installation, ok := inventory.previousInstallations[disk.Path]
+		if !ok {
+			continue
+		}
+		disk.PreviousInstallation = installation
+		warning := "Disk holds a previous OpenShift installation"
+		if installation.OpenShiftVersion != "" {
+			warning += " of version " + installation.OpenShiftVersion
+		}
+		if installation.ClusterDomain != "" {
+			warning += " from cluster " + installation.ClusterDomain
+		}
+		if installation.InfraID != "" {
+			warning += fmt.Sprintf(" (infrastructure ID %s)", installation.InfraID)
+		}
+		disk.Warnings = append(disk.Warnings, warning)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/jaypipes/ghw"
 	"github.com/jaypipes/ghw/pkg/block"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"github.com/openshift/assisted-installer-agent/src/config"
 	"github.com/openshift/assisted-installer-agent/src/util"
@@ -1512,3 +1513,277 @@ var _ = Describe("Disks test", func() {
 		))
 	})
 })
+
+const (
+	blkidRHCOSBoot = `DEVNAME=/dev/sda3
+LABEL=boot
+UUID=6b2b8f4e-21c7-4d8f-9a5c-2f0c1e0a7b11
+TYPE=ext4
+USAGE=filesystem
+PART_ENTRY_NAME=boot
+`
+	blkidRHCOSRoot = `DEVNAME=/dev/sda4
+LABEL=root
+UUID=0b2a3c4d-5e6f-4a1b-8c2d-3e4f5a6b7c8d
+TYPE=xfs
+USAGE=filesystem
+PART_ENTRY_NAME=root
+`
+	rhcosDeployment = "/ostree/boot.1/rhcos/3f1c5e2a/0"
+	rhcosBLSEntry   = `title Red Hat Enterprise Linux CoreOS 414.92.202310210434-0 (Plow) (ostree:0)
+version 414.92.202310210434-0
+options ignition.platform.id=metal ostree=` + rhcosDeployment + ` root=UUID=0b2a3c4d rw
+linux /ostree/rhcos-3f1c5e2a/vmlinuz-5.14.0-284.36.1.el9_2.x86_64
+`
+	rhcosOSRelease = `NAME="Red Hat Enterprise Linux CoreOS"
+VERSION="414.92.202310210434-0 (Plow)"
+ID="rhcos"
+OPENSHIFT_VERSION="4.14"
+`
+	kcmConfig         = `{"apiVersion":"kubecontrolplane.config.openshift.io/v1","extendedArguments":{"cluster-name":["mycluster-x7k2p"],"leader-elect":["true"]}}`
+	kubeletKubeconfig = `apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: LS0tLS1CRUdJTg==
+    server: https://api-int.mycluster.example.com:6443
+  name: local
+`
+)
+
+var _ = Describe("Previous installations", func() {
+	const (
+		bootDir = "/tmp/previous-installation.boot"
+		rootDir = "/tmp/previous-installation.root"
+		deploy  = rootDir + "/ostree/deploy/rhcos/deploy/8e2f1a.0"
+	)
+
+	var (
+		dependencies    *util.MockIDependencies
+		inventoryConfig *config.InventoryConfig
+		files           map[string]string
+	)
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+		inventoryConfig = &config.InventoryConfig{}
+		files = map[string]string{
+			"/sys/block/sda/size":                           "",
+			"/sys/block/sda/sda3/partition":                 "3",
+			"/sys/block/sda/sda4/partition":                 "4",
+			"/sys/block/sdb/size":                           "",
+			"/sys/block/sdb/sdb1/partition":                 "1",
+			bootDir + "/loader/entries/ostree-1-rhcos.conf": "version 413.92.202307260246-0\n",
+			bootDir + "/loader/entries/ostree-2-rhcos.conf": rhcosBLSEntry,
+		}
+	})
+
+	AfterEach(func() {
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	mockBlkid := func(path, output string, exitCode int) {
+		dependencies.On("Execute", "blkid", "-p", "-o", "export", path).Return(output, "", exitCode).Once()
+	}
+
+	mockMount := func(partition, options, dir string) {
+		dependencies.On("Execute", "mktemp", "-d", "/tmp/previous-installation.XXXXXX").Return(dir+"\n", "", 0).Once()
+		dependencies.On("Execute", "mount", "-o", options, partition, dir).Return("", "", 0).Once()
+		dependencies.On("Execute", "umount", dir).Return("", "", 0).Once()
+		dependencies.On("Execute", "rmdir", dir).Return("", "", 0).Once()
+	}
+
+	mockDisks := func() {
+		mockBlkid("/dev/sda3", blkidRHCOSBoot, 0)
+		mockBlkid("/dev/sda4", blkidRHCOSRoot, 0)
+		mockBlkid("/dev/sdb1", blkidXFSPartition, 0)
+		mockMount("/dev/sda3", "ro,noload", bootDir)
+	}
+
+	It("reports the version and cluster of an RHCOS installation", func() {
+		files[deploy+"/usr/lib/os-release"] = rhcosOSRelease
+		files[deploy+"/etc/kubernetes/kubeconfig"] = kubeletKubeconfig
+		mockSysfs(dependencies, files)
+		mockDisks()
+		mockMount("/dev/sda4", "ro,norecovery", rootDir)
+		dependencies.On("EvalSymlinks", rootDir+rhcosDeployment).Return(deploy, nil).Once()
+
+		Expect(GetPreviousInstallations(inventoryConfig, dependencies)).To(Equal(map[string]*PreviousInstallation{
+			"/dev/sda": {
+				BootPartition:    "/dev/sda3",
+				RootPartition:    "/dev/sda4",
+				OSVersion:        "414.92.202310210434-0",
+				OpenShiftVersion: "4.14",
+				Deployment:       rhcosDeployment,
+				APIServer:        "https://api-int.mycluster.example.com:6443",
+				ClusterName:      "mycluster",
+				ClusterDomain:    "mycluster.example.com",
+			},
+		}))
+	})
+
+	Context("with a cache", func() {
+		var cacheFile string
+
+		BeforeEach(func() {
+			f, err := os.CreateTemp("", "previous-installations-*.json")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f.Close()).To(Succeed())
+			cacheFile = f.Name()
+			inventoryConfig.PreviousInstallationsCacheFile = cacheFile
+			files[deploy+"/usr/lib/os-release"] = rhcosOSRelease
+			files[deploy+"/etc/kubernetes/kubeconfig"] = kubeletKubeconfig
+			mockSysfs(dependencies, files)
+		})
+
+		AfterEach(func() {
+			os.Remove(cacheFile)
+		})
+
+		It("doesn't mount the partitions again", func() {
+			mockDisks()
+			mockMount("/dev/sda4", "ro,norecovery", rootDir)
+			dependencies.On("EvalSymlinks", rootDir+rhcosDeployment).Return(deploy, nil).Once()
+			installations := GetPreviousInstallations(inventoryConfig, dependencies)
+			Expect(installations).To(HaveKey("/dev/sda"))
+
+			mockBlkid("/dev/sda3", blkidRHCOSBoot, 0)
+			mockBlkid("/dev/sda4", blkidRHCOSRoot, 0)
+			mockBlkid("/dev/sdb1", blkidXFSPartition, 0)
+			Expect(GetPreviousInstallations(inventoryConfig, dependencies)).To(Equal(installations))
+		})
+
+		It("mounts the partitions again when their file systems changed", func() {
+			Expect(os.WriteFile(cacheFile, []byte(`{"/dev/sda": {"key": "/dev/sda3,1c0e2f3a,/dev/sda4,9d8c7b6a"}}`), 0600)).To(Succeed())
+			mockDisks()
+			mockMount("/dev/sda4", "ro,norecovery", rootDir)
+			dependencies.On("EvalSymlinks", rootDir+rhcosDeployment).Return(deploy, nil).Once()
+			installations := GetPreviousInstallations(inventoryConfig, dependencies)
+			Expect(installations).To(HaveKey("/dev/sda"))
+			Expect(installations["/dev/sda"].ClusterName).To(Equal("mycluster"))
+		})
+
+		It("doesn't cache the partitions that couldn't be mounted", func() {
+			mockDisks()
+			dependencies.On("Execute", "mktemp", "-d", "/tmp/previous-installation.XXXXXX").Return(rootDir, "", 0).Once()
+			dependencies.On("Execute", "mount", "-o", "ro,norecovery", "/dev/sda4", rootDir).Return("", "bad superblock", 32).Once()
+			dependencies.On("Execute", "rmdir", rootDir).Return("", "", 0).Once()
+			GetPreviousInstallations(inventoryConfig, dependencies)
+
+			content, err := os.ReadFile(cacheFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal("{}"))
+		})
+	})
+
+	It("reports the infrastructure ID found on control plane nodes", func() {
+		resources := deploy + "/etc/kubernetes/static-pod-resources"
+		files[deploy+"/etc/kubernetes/kubeconfig"] = kubeletKubeconfig
+		files[resources+"/kube-controller-manager-pod-6/configmaps/config/config.yaml"] = `{"extendedArguments":{"cluster-name":["outdated"]}}`
+		files[resources+"/kube-controller-manager-pod-12/configmaps/config/config.yaml"] = kcmConfig
+		files[resources+"/kube-controller-manager-certs/secrets/csr-signer/tls.crt"] = ""
+		mockSysfs(dependencies, files)
+		mockDisks()
+		mockMount("/dev/sda4", "ro,norecovery", rootDir)
+		dependencies.On("EvalSymlinks", rootDir+rhcosDeployment).Return(deploy, nil).Once()
+
+		installations := GetPreviousInstallations(inventoryConfig, dependencies)
+		Expect(installations).To(HaveKey("/dev/sda"))
+		Expect(installations["/dev/sda"].ClusterName).To(Equal("mycluster"))
+		Expect(installations["/dev/sda"].InfraID).To(Equal("mycluster-x7k2p"))
+	})
+
+	It("doesn't mount partitions that aren't laid out like RHCOS", func() {
+		mockSysfs(dependencies, files)
+		mockBlkid("/dev/sda3", strings.Replace(blkidRHCOSBoot, "PART_ENTRY_NAME=boot", "PART_ENTRY_NAME=EFI", 1), 0)
+		mockBlkid("/dev/sda4", blkidRHCOSRoot, 0)
+		mockBlkid("/dev/sdb1", blkidXFSPartition, 0)
+
+		Expect(GetPreviousInstallations(inventoryConfig, dependencies)).To(BeEmpty())
+	})
+
+	It("doesn't mount a boot partition without a root partition", func() {
+		delete(files, "/sys/block/sda/sda4/partition")
+		mockSysfs(dependencies, files)
+		mockBlkid("/dev/sda3", blkidRHCOSBoot, 0)
+		mockBlkid("/dev/sdb1", blkidXFSPartition, 0)
+
+		Expect(GetPreviousInstallations(inventoryConfig, dependencies)).To(BeEmpty())
+	})
+
+	It("reads the kubelet kubeconfig of nodes that didn't join the cluster", func() {
+		files[rootDir+"/ostree/deploy/rhcos/var/lib/kubelet/kubeconfig"] = kubeletKubeconfig
+		mockSysfs(dependencies, files)
+		mockDisks()
+		mockMount("/dev/sda4", "ro,norecovery", rootDir)
+		dependencies.On("EvalSymlinks", rootDir+rhcosDeployment).Return(deploy, nil).Once()
+
+		installations := GetPreviousInstallations(inventoryConfig, dependencies)
+		Expect(installations).To(HaveKey("/dev/sda"))
+		Expect(installations["/dev/sda"].OpenShiftVersion).To(Equal("4.14"))
+		Expect(installations["/dev/sda"].ClusterDomain).To(Equal("mycluster.example.com"))
+	})
+
+	It("reports what the boot partition tells when the root partition can't be mounted", func() {
+		mockSysfs(dependencies, files)
+		mockDisks()
+		dependencies.On("Execute", "mktemp", "-d", "/tmp/previous-installation.XXXXXX").Return(rootDir, "", 0).Once()
+		dependencies.On("Execute", "mount", "-o", "ro,norecovery", "/dev/sda4", rootDir).Return("", "bad superblock", 32).Once()
+		dependencies.On("Execute", "rmdir", rootDir).Return("", "", 0).Once()
+
+		Expect(GetPreviousInstallations(inventoryConfig, dependencies)).To(Equal(map[string]*PreviousInstallation{
+			"/dev/sda": {
+				BootPartition:    "/dev/sda3",
+				RootPartition:    "/dev/sda4",
+				OSVersion:        "414.92.202310210434-0",
+				OpenShiftVersion: "4.14",
+				Deployment:       rhcosDeployment,
+			},
+		}))
+	})
+
+	It("ignores boot partitions without ostree entries", func() {
+		delete(files, bootDir+"/loader/entries/ostree-1-rhcos.conf")
+		delete(files, bootDir+"/loader/entries/ostree-2-rhcos.conf")
+		files[bootDir+"/loader/entries/fedora.conf"] = "version 6.5.6\n"
+		mockSysfs(dependencies, files)
+		mockDisks()
+
+		Expect(GetPreviousInstallations(inventoryConfig, dependencies)).To(BeEmpty())
+	})
+
+	It("doesn't mount anything in dry run", func() {
+		inventoryConfig.DryRunEnabled = true
+		Expect(GetPreviousInstallations(inventoryConfig, dependencies)).To(BeNil())
+	})
+
+	DescribeTable("openShiftVersionFromRHCOS",
+		func(version, expected string) {
+			Expect(openShiftVersionFromRHCOS(version)).To(Equal(expected))
+		},
+		Entry("4.14", "414.92.202310210434-0", "4.14"),
+		Entry("4.9", "49.84.202110081407-0", "4.9"),
+		Entry("4.10", "410.84.202205191234-0", "4.10"),
+		Entry("RHEL based version", "9.6.20250523-0", ""),
+	)
+
+	It("warns about the disks holding a previous installation", func() {
+		inventory := &Inventory{
+			Disks: []*Disk{
+				{Disk: &models.Disk{Path: "/dev/sda"}},
+				{Disk: &models.Disk{Path: "/dev/sdb"}},
+				{Disk: &models.Disk{Path: "/dev/sdc"}},
+			},
+			previousInstallations: map[string]*PreviousInstallation{
+				"/dev/sda": {BootPartition: "/dev/sda3", OpenShiftVersion: "4.14", ClusterDomain: "mycluster.example.com", InfraID: "mycluster-x7k2p"},
+				"/dev/sdb": {BootPartition: "/dev/sdb3"},
+			},
+		}
+		applyPreviousInstallations(inventory)
+
+		Expect(inventory.Disks[0].PreviousInstallation.BootPartition).To(Equal("/dev/sda3"))
+		Expect(inventory.Disks[0].Warnings).To(ConsistOf(
+			"Disk holds a previous OpenShift installation of version 4.14 from cluster mycluster.example.com (infrastructure ID mycluster-x7k2p)"))
+		Expect(inventory.Disks[1].Warnings).To(ConsistOf("Disk holds a previous OpenShift installation"))
+		Expect(inventory.Disks[2].PreviousInstallation).To(BeNil())
+	})
+})
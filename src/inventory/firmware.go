@@ -0,0 +1,312 @@
+package inventory
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// efivarsPath is the directory of the EFI variables of the host, like secureBootEfivarsPath
+	efivarsPath = "/host/sys/firmware/efi/efivars"
+
+	// efiGlobalVariableGUID is the vendor of the EFI variables defined by the UEFI specification
+	efiGlobalVariableGUID = "8be4df61-93ca-11d2-aa0d-00e098032b8c"
+
+	// efiLoadOptionActive is the attribute of the boot entries that the firmware tries
+	efiLoadOptionActive = 0x1
+
+	// efiVariableAttributesLength is the length of the attributes that precede the value of the EFI
+	// variables in efivarfs
+	efiVariableAttributesLength = 4
+)
+
+var (
+	efiBootEntryRegex = regexp.MustCompile(`^Boot([0-9A-F]{4})-` + efiGlobalVariableGUID + `$`)
+	ipmiFirmwareRegex = regexp.MustCompile(`^Firmware Revision\s*:\s*(\S+)\s*$`)
+)
+
+// Firmware is the system firmware and the firmware of the BMC.
+type Firmware struct {
+	BIOS *BIOS `json:"bios,omitempty"`
+	// BMCVersion is the firmware version reported by the BMC, through IPMI or Redfish
+	BMCVersion string    `json:"bmc_version,omitempty"`
+	UEFI       *UEFIBoot `json:"uefi,omitempty"`
+}
+
+type BIOS struct {
+	Vendor      string `json:"vendor,omitempty"`
+	Version     string `json:"version,omitempty"`
+	ReleaseDate string `json:"release_date,omitempty"`
+}
+
+// UEFIBoot is the boot configuration of the UEFI firmware, as found in its EFI variables. Entries
+// are identified by their 4 hexadecimal digits number, for example 0001.
+type UEFIBoot struct {
+	BootCurrent string   `json:"boot_current,omitempty"`
+	BootOrder   []string `json:"boot_order,omitempty"`
+	// BootNext is the entry that the firmware boots once instead of following the boot order, it
+	// is set by tools like efibootmgr --bootnext or by the BMC
+	BootNext        string           `json:"boot_next,omitempty"`
+	OneShotOverride bool             `json:"one_shot_override"`
+	Entries         []*UEFIBootEntry `json:"entries,omitempty"`
+}
+
+type UEFIBootEntry struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	// DevicePath is the device path of the entry in the text format of the UEFI specification, for
+	// example PciRoot(0x0)/Pci(0x1,0x0)/Pci(0x0,0x0)/NVMe(0x1,00-00-00-00-00-00-00-00)/HD(1,GPT,...)
+	DevicePath string `json:"device_path,omitempty"`
+	Active     bool   `json:"active"`
+}
+
+type firmware struct {
+	sysfsReader
+	inventoryConfig *config.InventoryConfig
+}
+
+func newFirmware(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) *firmware {
+	return &firmware{sysfsReader: sysfsReader{dependencies: dependencies}, inventoryConfig: inventoryConfig}
+}
+
+func (f *firmware) getBIOS() *BIOS {
+	ret := &BIOS{
+		Vendor:      f.readString("/sys/class/dmi/id/bios_vendor"),
+		Version:     f.readString("/sys/class/dmi/id/bios_version"),
+		ReleaseDate: f.readString("/sys/class/dmi/id/bios_date"),
+	}
+	if *ret == (BIOS{}) {
+		return nil
+	}
+	return ret
+}
+
+func (f *firmware) getBMCVersion() string {
+	if f.inventoryConfig.DryRunEnabled {
+		return ""
+	}
+	o, e, exitCode := f.dependencies.Execute("ipmitool", "mc", "info")
+	if exitCode != 0 {
+		logrus.Debugf("Could not get the BMC firmware version: %s", e)
+		return ""
+	}
+	for _, line := range strings.Split(o, "\n") {
+		if matches := ipmiFirmwareRegex.FindStringSubmatch(line); matches != nil {
+			return matches[1]
+		}
+	}
+	return ""
+}
+
+// readEFIVariable returns the value of an EFI variable of the global vendor, without its
+// attributes.
+func (f *firmware) readEFIVariable(name string) ([]byte, error) {
+	b, err := f.dependencies.ReadFile(fmt.Sprintf("%s/%s-%s", efivarsPath, name, efiGlobalVariableGUID))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < efiVariableAttributesLength {
+		return nil, fmt.Errorf("EFI variable %s is too short", name)
+	}
+	return b[efiVariableAttributesLength:], nil
+}
+
+func (f *firmware) readEFIBootNumber(name string) string {
+	b, err := f.readEFIVariable(name)
+	if err != nil || len(b) < 2 {
+		return ""
+	}
+	return fmt.Sprintf("%04X", binary.LittleEndian.Uint16(b))
+}
+
+func (f *firmware) getUEFIBoot() *UEFIBoot {
+	files, err := f.dependencies.ReadDir(efivarsPath)
+	if err != nil {
+		logrus.WithError(err).Debug("No EFI variables")
+		return nil
+	}
+	ret := &UEFIBoot{
+		BootCurrent: f.readEFIBootNumber("BootCurrent"),
+		BootNext:    f.readEFIBootNumber("BootNext"),
+	}
+	ret.OneShotOverride = ret.BootNext != ""
+	if b, err := f.readEFIVariable("BootOrder"); err == nil {
+		for i := 0; i+1 < len(b); i += 2 {
+			ret.BootOrder = append(ret.BootOrder, fmt.Sprintf("%04X", binary.LittleEndian.Uint16(b[i:])))
+		}
+	}
+	for _, file := range files {
+		matches := efiBootEntryRegex.FindStringSubmatch(file.Name())
+		if matches == nil {
+			continue
+		}
+		b, err := f.readEFIVariable("Boot" + matches[1])
+		if err != nil {
+			logrus.WithError(err).Debugf("Reading boot entry %s", matches[1])
+			continue
+		}
+		entry, err := parseEFILoadOption(b)
+		if err != nil {
+			logrus.WithError(err).Warnf("Parsing boot entry %s", matches[1])
+			continue
+		}
+		entry.ID = matches[1]
+		ret.Entries = append(ret.Entries, entry)
+	}
+	sort.Slice(ret.Entries, func(i, j int) bool { return ret.Entries[i].ID < ret.Entries[j].ID })
+	return ret
+}
+
+// decodeUCS2 decodes a null terminated UCS-2 string, and returns it with the number of bytes it
+// took including the terminator.
+func decodeUCS2(b []byte) (string, int) {
+	var chars []uint16
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			return string(utf16.Decode(chars)), i + 2
+		}
+		chars = append(chars, c)
+	}
+	return string(utf16.Decode(chars)), len(b)
+}
+
+// parseEFILoadOption parses an EFI_LOAD_OPTION: the attributes, the length of the device path, the
+// description and the device path.
+func parseEFILoadOption(b []byte) (*UEFIBootEntry, error) {
+	if len(b) < 6 {
+		return nil, fmt.Errorf("load option is too short: %d bytes", len(b))
+	}
+	attributes := binary.LittleEndian.Uint32(b)
+	pathLength := int(binary.LittleEndian.Uint16(b[4:]))
+	description, n := decodeUCS2(b[6:])
+	pathStart := 6 + n
+	if pathStart+pathLength > len(b) {
+		return nil, fmt.Errorf("device path of %d bytes exceeds the load option", pathLength)
+	}
+	return &UEFIBootEntry{
+		Description: description,
+		DevicePath:  formatEFIDevicePath(b[pathStart : pathStart+pathLength]),
+		Active:      attributes&efiLoadOptionActive != 0,
+	}, nil
+}
+
+// formatEFIGUID formats a GUID stored with its first three fields in little endian.
+func formatEFIGUID(b []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x", binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint16(b[4:]),
+		binary.LittleEndian.Uint16(b[6:]), b[8:10], b[10:16])
+}
+
+// formatEFIDevicePathNode formats the nodes that boot entries use in the text format of the UEFI
+// specification, and the others in the generic Path(type,subtype,data) format.
+func formatEFIDevicePathNode(nodeType, subType byte, data []byte) string {
+	switch {
+	case nodeType == 0x01 && subType == 0x01 && len(data) >= 2:
+		return fmt.Sprintf("Pci(0x%x,0x%x)", data[1], data[0])
+	case nodeType == 0x02 && subType == 0x01 && len(data) >= 8:
+		hid := binary.LittleEndian.Uint32(data)
+		uid := binary.LittleEndian.Uint32(data[4:])
+		// PNP0A03 and PNP0A08 are PCI and PCI Express root bridges
+		if hid == 0x0a0341d0 || hid == 0x0a0841d0 {
+			return fmt.Sprintf("PciRoot(0x%x)", uid)
+		}
+		return fmt.Sprintf("Acpi(0x%08x,0x%x)", hid, uid)
+	case nodeType == 0x03 && subType == 0x02 && len(data) >= 4:
+		return fmt.Sprintf("Scsi(0x%x,0x%x)", binary.LittleEndian.Uint16(data), binary.LittleEndian.Uint16(data[2:]))
+	case nodeType == 0x03 && subType == 0x05 && len(data) >= 2:
+		return fmt.Sprintf("USB(0x%x,0x%x)", data[0], data[1])
+	case nodeType == 0x03 && subType == 0x0b && len(data) >= 33:
+		return fmt.Sprintf("MAC(%x,0x%x)", data[:6], data[32])
+	case nodeType == 0x03 && subType == 0x0c && len(data) >= 8:
+		return fmt.Sprintf("IPv4(%s)", net.IP(data[4:8]))
+	case nodeType == 0x03 && subType == 0x0d && len(data) >= 32:
+		return fmt.Sprintf("IPv6(%s)", net.IP(data[16:32]))
+	case nodeType == 0x03 && subType == 0x12 && len(data) >= 6:
+		return fmt.Sprintf("Sata(0x%x,0x%x,0x%x)", binary.LittleEndian.Uint16(data), binary.LittleEndian.Uint16(data[2:]),
+			binary.LittleEndian.Uint16(data[4:]))
+	case nodeType == 0x03 && subType == 0x17 && len(data) >= 12:
+		eui := make([]string, 0, 8)
+		for _, c := range data[4:12] {
+			eui = append(eui, fmt.Sprintf("%02x", c))
+		}
+		return fmt.Sprintf("NVMe(0x%x,%s)", binary.LittleEndian.Uint32(data), strings.Join(eui, "-"))
+	case nodeType == 0x03 && subType == 0x18:
+		return fmt.Sprintf("Uri(%s)", data)
+	case nodeType == 0x04 && subType == 0x01 && len(data) >= 38:
+		number := binary.LittleEndian.Uint32(data)
+		start := binary.LittleEndian.Uint64(data[4:])
+		size := binary.LittleEndian.Uint64(data[12:])
+		switch data[37] {
+		case 0x01:
+			return fmt.Sprintf("HD(%d,MBR,0x%08x,0x%x,0x%x)", number, binary.LittleEndian.Uint32(data[20:]), start, size)
+		case 0x02:
+			return fmt.Sprintf("HD(%d,GPT,%s,0x%x,0x%x)", number, formatEFIGUID(data[20:36]), start, size)
+		}
+		return fmt.Sprintf("HD(%d,0x%x,0x%x)", number, start, size)
+	case nodeType == 0x04 && subType == 0x02 && len(data) >= 4:
+		return fmt.Sprintf("CDROM(0x%x)", binary.LittleEndian.Uint32(data))
+	case nodeType == 0x04 && subType == 0x04:
+		path, _ := decodeUCS2(data)
+		return fmt.Sprintf("File(%s)", path)
+	case nodeType == 0x04 && subType == 0x06 && len(data) >= 16:
+		return fmt.Sprintf("FvFile(%s)", formatEFIGUID(data))
+	case nodeType == 0x04 && subType == 0x07 && len(data) >= 16:
+		return fmt.Sprintf("Fv(%s)", formatEFIGUID(data))
+	}
+	return fmt.Sprintf("Path(%d,%d,%x)", nodeType, subType, data)
+}
+
+// formatEFIDevicePath formats a binary device path, a list of nodes with a type, a subtype and a
+// length, ending with an end of device path node.
+func formatEFIDevicePath(b []byte) string {
+	var nodes []string
+	for len(b) >= 4 {
+		nodeType, subType := b[0], b[1]
+		length := int(binary.LittleEndian.Uint16(b[2:]))
+		if length < 4 || length > len(b) {
+			break
+		}
+		if nodeType == 0x7f {
+			// End of this instance of the path, or of the whole path
+			if subType == 0xff {
+				break
+			}
+			nodes = append(nodes, ",")
+		} else {
+			nodes = append(nodes, formatEFIDevicePathNode(nodeType, subType, b[4:length]))
+		}
+		b = b[length:]
+	}
+	return strings.ReplaceAll(strings.Join(nodes, "/"), "/,/", ",")
+}
+
+func (f *firmware) getFirmware() *Firmware {
+	return &Firmware{
+		BIOS:       f.getBIOS(),
+		BMCVersion: f.getBMCVersion(),
+		UEFI:       f.getUEFIBoot(),
+	}
+}
+
+// GetFirmware returns the BIOS and BMC firmware versions and the UEFI boot entries of the host.
+func GetFirmware(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) *Firmware {
+	return newFirmware(inventoryConfig, dependencies).getFirmware()
+}
+
+// applyFirmware completes the BMC firmware version with the one reported by Redfish, when IPMI
+// doesn't report it.
+func applyFirmware(inventory *Inventory) {
+	if inventory.Firmware == nil || inventory.Firmware.BMCVersion != "" || inventory.Bmc == nil {
+		return
+	}
+	inventory.Firmware.BMCVersion = inventory.Bmc.FirmwareVersion
+}
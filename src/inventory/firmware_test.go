@@ -0,0 +1,168 @@
+package inventory
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+)
+
+const ipmitoolMcInfo = `Device ID                 : 32
+Device Revision           : 1
+Firmware Revision         : 4.40
+IPMI Version              : 2.0
+Manufacturer ID           : 674
+Manufacturer Name         : DELL Inc
+Product ID                : 256 (0x0100)
+Product Name              : Unknown (0x100)
+Device Available          : yes
+`
+
+// efiVariable returns the content of an efivarfs file: the attributes followed by the value.
+func efiVariable(value ...byte) string {
+	return string(append([]byte{0x07, 0x00, 0x00, 0x00}, value...))
+}
+
+func efiUCS2(s string) []byte {
+	var ret []byte
+	for _, c := range append(utf16.Encode([]rune(s)), 0) {
+		ret = binary.LittleEndian.AppendUint16(ret, c)
+	}
+	return ret
+}
+
+func efiDevicePathNode(nodeType, subType byte, data ...byte) []byte {
+	ret := []byte{nodeType, subType}
+	ret = binary.LittleEndian.AppendUint16(ret, uint16(len(data)+4))
+	return append(ret, data...)
+}
+
+func efiLoadOption(attributes uint32, description string, nodes ...[]byte) string {
+	var path []byte
+	for _, node := range append(nodes, efiDevicePathNode(0x7f, 0xff)) {
+		path = append(path, node...)
+	}
+	ret := binary.LittleEndian.AppendUint32(nil, attributes)
+	ret = binary.LittleEndian.AppendUint16(ret, uint16(len(path)))
+	ret = append(ret, efiUCS2(description)...)
+	return efiVariable(append(ret, path...)...)
+}
+
+var (
+	efiPciRoot = efiDevicePathNode(0x02, 0x01, 0xd0, 0x41, 0x03, 0x0a, 0, 0, 0, 0)
+	efiPci     = efiDevicePathNode(0x01, 0x01, 0x00, 0x1d)
+	efiNVMe    = efiDevicePathNode(0x03, 0x17, 1, 0, 0, 0, 0x00, 0x25, 0x38, 0x5a, 0x91, 0xb0, 0x12, 0x34)
+	efiGPT     = efiDevicePathNode(0x04, 0x01, append([]byte{
+		1, 0, 0, 0,
+		0x00, 0x08, 0, 0, 0, 0, 0, 0,
+		0x00, 0x00, 0x10, 0, 0, 0, 0, 0,
+		0x28, 0x73, 0x2a, 0xc1, 0x1f, 0xf8, 0xd2, 0x11, 0xba, 0x4b, 0x00, 0xa0, 0xc9, 0x3e, 0xc9, 0x3b,
+	}, 0x02, 0x02)...)
+	efiShim = efiDevicePathNode(0x04, 0x04, efiUCS2(`\EFI\redhat\shimx64.efi`)...)
+	efiMAC  = efiDevicePathNode(0x03, 0x0b, append([]byte{0xa8, 0xcd, 0x16, 0xae, 0x79, 0x01}, make([]byte, 27)...)...)
+	efiIPv4 = efiDevicePathNode(0x03, 0x0c, make([]byte, 23)...)
+)
+
+var _ = Describe("Firmware", func() {
+	var dependencies *util.MockIDependencies
+	var inventoryConfig *config.InventoryConfig
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+		inventoryConfig = &config.InventoryConfig{}
+	})
+
+	AfterEach(func() {
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	It("reports the BIOS, the BMC and the UEFI boot entries", func() {
+		dependencies.On("Execute", "ipmitool", "mc", "info").Return(ipmitoolMcInfo, "", 0).Once()
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/dmi/id/bios_vendor":                       "Dell Inc.\n",
+			"/sys/class/dmi/id/bios_version":                      "2.12.2\n",
+			"/sys/class/dmi/id/bios_date":                         "07/09/2021\n",
+			efivarsPath + "/BootCurrent-" + efiGlobalVariableGUID: efiVariable(0x01, 0x00),
+			efivarsPath + "/BootNext-" + efiGlobalVariableGUID:    efiVariable(0x02, 0x00),
+			efivarsPath + "/BootOrder-" + efiGlobalVariableGUID:   efiVariable(0x01, 0x00, 0x02, 0x00, 0x0a, 0x00),
+			efivarsPath + "/Boot0001-" + efiGlobalVariableGUID: efiLoadOption(efiLoadOptionActive, "Red Hat Enterprise Linux",
+				efiPciRoot, efiPci, efiNVMe, efiGPT, efiShim),
+			efivarsPath + "/Boot0002-" + efiGlobalVariableGUID: efiLoadOption(efiLoadOptionActive, "PXE IPv4",
+				efiPciRoot, efiPci, efiMAC, efiIPv4),
+			efivarsPath + "/Boot000A-" + efiGlobalVariableGUID:   efiLoadOption(0, "Old entry", efiPciRoot),
+			efivarsPath + "/SecureBoot-" + efiGlobalVariableGUID: efiVariable(0x01),
+		})
+
+		Expect(GetFirmware(inventoryConfig, dependencies)).To(Equal(&Firmware{
+			BIOS:       &BIOS{Vendor: "Dell Inc.", Version: "2.12.2", ReleaseDate: "07/09/2021"},
+			BMCVersion: "4.40",
+			UEFI: &UEFIBoot{
+				BootCurrent:     "0001",
+				BootOrder:       []string{"0001", "0002", "000A"},
+				BootNext:        "0002",
+				OneShotOverride: true,
+				Entries: []*UEFIBootEntry{
+					{ID: "0001", Description: "Red Hat Enterprise Linux", Active: true,
+						DevicePath: `PciRoot(0x0)/Pci(0x1d,0x0)/NVMe(0x1,00-25-38-5a-91-b0-12-34)/` +
+							`HD(1,GPT,c12a7328-f81f-11d2-ba4b-00a0c93ec93b,0x800,0x100000)/File(\EFI\redhat\shimx64.efi)`},
+					{ID: "0002", Description: "PXE IPv4", Active: true,
+						DevicePath: "PciRoot(0x0)/Pci(0x1d,0x0)/MAC(a8cd16ae7901,0x0)/IPv4(0.0.0.0)"},
+					{ID: "000A", Description: "Old entry", DevicePath: "PciRoot(0x0)"},
+				},
+			},
+		}))
+	})
+
+	It("reports legacy BIOS hosts without UEFI boot entries", func() {
+		inventoryConfig.DryRunEnabled = true
+		mockSysfs(dependencies, map[string]string{"/sys/class/dmi/id/bios_version": "1.16.0-1"})
+		Expect(GetFirmware(inventoryConfig, dependencies)).To(Equal(&Firmware{BIOS: &BIOS{Version: "1.16.0-1"}}))
+	})
+
+	It("skips boot entries that can't be parsed", func() {
+		dependencies.On("Execute", "ipmitool", "mc", "info").Return("", "Could not open device at /dev/ipmi0", 1).Once()
+		mockSysfs(dependencies, map[string]string{
+			efivarsPath + "/Boot0000-" + efiGlobalVariableGUID: efiVariable(0x01, 0x00, 0x00, 0x00, 0xff, 0x00),
+		})
+		Expect(GetFirmware(inventoryConfig, dependencies)).To(Equal(&Firmware{UEFI: &UEFIBoot{}}))
+	})
+
+	DescribeTable("formatEFIDevicePath",
+		func(expected string, nodes ...[]byte) {
+			var path []byte
+			for _, node := range nodes {
+				path = append(path, node...)
+			}
+			Expect(formatEFIDevicePath(path)).To(Equal(expected))
+		},
+		Entry("empty", ""),
+		Entry("SATA and MBR partition", "PciRoot(0x1)/Sata(0x0,0xffff,0x0)/HD(2,MBR,0x1234abcd,0x800,0x200)",
+			efiDevicePathNode(0x02, 0x01, 0xd0, 0x41, 0x08, 0x0a, 1, 0, 0, 0),
+			efiDevicePathNode(0x03, 0x12, 0, 0, 0xff, 0xff, 0, 0),
+			efiDevicePathNode(0x04, 0x01, append([]byte{2, 0, 0, 0, 0x00, 0x08, 0, 0, 0, 0, 0, 0, 0x00, 0x02, 0, 0, 0, 0, 0, 0,
+				0xcd, 0xab, 0x34, 0x12}, append(make([]byte, 12), 0x01, 0x01)...)...),
+			efiDevicePathNode(0x7f, 0xff)),
+		Entry("multiple instances", "PciRoot(0x0),Pci(0x1d,0x0)",
+			efiPciRoot, efiDevicePathNode(0x7f, 0x01), efiPci, efiDevicePathNode(0x7f, 0xff)),
+		Entry("unknown node", "Path(5,1,0102)", efiDevicePathNode(0x05, 0x01, 0x01, 0x02)),
+		Entry("truncated node", "PciRoot(0x0)", efiPciRoot, []byte{0x01, 0x01, 0x10, 0x00}),
+	)
+
+	Context("applyFirmware", func() {
+		It("uses the Redfish firmware version when IPMI doesn't report it", func() {
+			inventory := &Inventory{Firmware: &Firmware{}, Bmc: &BMC{FirmwareVersion: "TGBT48K"}}
+			applyFirmware(inventory)
+			Expect(inventory.Firmware.BMCVersion).To(Equal("TGBT48K"))
+		})
+
+		It("keeps the IPMI firmware version", func() {
+			inventory := &Inventory{Firmware: &Firmware{BMCVersion: "4.40"}, Bmc: &BMC{FirmwareVersion: "4.40.00.00"}}
+			applyFirmware(inventory)
+			Expect(inventory.Firmware.BMCVersion).To(Equal("4.40"))
+		})
+	})
+})
@@ -0,0 +1,407 @@
+package inventory
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/sirupsen/logrus"
+)
+
+const hwmonPath = "/sys/class/hwmon"
+
+// The types of the hwmon sensors
+const (
+	sensorTypeTemperature = "temperature"
+	sensorTypeFan         = "fan"
+	sensorTypeVoltage     = "voltage"
+)
+
+// hwmonTypes maps the prefixes of the hwmon attributes to the sensor types and the divisors that
+// convert their values to degrees Celsius, RPM and volts.
+var hwmonTypes = map[string]struct {
+	sensorType string
+	divisor    float64
+}{
+	"temp": {sensorType: sensorTypeTemperature, divisor: 1000},
+	"fan":  {sensorType: sensorTypeFan, divisor: 1},
+	"in":   {sensorType: sensorTypeVoltage, divisor: 1000},
+}
+
+var hwmonInputRegex = regexp.MustCompile(`^(temp|fan|in)(\d+)_input$`)
+
+// psuFailureEvents are the states of the IPMI power supply sensors that mean that the power supply
+// doesn't deliver power.
+var psuFailureEvents = []string{
+	"failure detected",
+	"predictive failure",
+	"power supply ac lost",
+	"ac lost or out-of-range",
+	"ac out-of-range",
+	"config error",
+}
+
+const (
+	// selClearedEvent is logged when the system event log is cleared
+	selClearedEvent = "Log area reset/cleared"
+	selDateFormat   = "01/02/2006 15:04:05"
+	// selFaultMaxAge is how long a fault that wasn't deasserted is reported
+	selFaultMaxAge = 7 * 24 * time.Hour
+)
+
+// selFaultEvents are the SEL events that are reported as faults while they are asserted. The
+// critical thresholds are matched with their direction, the non-critical ones are only warnings of
+// the BMC.
+var selFaultEvents = []string{
+	"failure",
+	"ac lost",
+	"out-of-range",
+	"config error",
+	"upper critical",
+	"lower critical",
+	"non-recoverable",
+	"intrusion",
+	"redundancy lost",
+}
+
+// HardwareHealth is the state of the sensors of the host and, when it has a BMC, of its power
+// supplies and chassis.
+type HardwareHealth struct {
+	Sensors       []*Sensor      `json:"sensors,omitempty"`
+	PowerSupplies []*PowerSupply `json:"power_supplies,omitempty"`
+	// PowerRedundancy is the state of the redundancy sensor of the power supplies, for example
+	// "Fully Redundant" or "Redundancy Lost"
+	PowerRedundancy  string `json:"power_redundancy,omitempty"`
+	ChassisIntrusion bool   `json:"chassis_intrusion"`
+	// Faults are the fault events of the system event log of the BMC that were never deasserted
+	Faults []*HardwareFault `json:"faults,omitempty"`
+	// Warnings are the failures and the sensors in alarm that the user should fix before
+	// installing, for example failed power supplies or overheating CPUs
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Sensor is a hwmon sensor. Temperatures are in degrees Celsius, fan speeds in RPM and voltages in
+// volts. The thresholds are omitted when the driver doesn't report them.
+type Sensor struct {
+	Chip     string  `json:"chip"`
+	Label    string  `json:"label"`
+	Type     string  `json:"type"`
+	Value    float64 `json:"value"`
+	Min      float64 `json:"min,omitempty"`
+	Max      float64 `json:"max,omitempty"`
+	Critical float64 `json:"critical,omitempty"`
+	// Alarm is true when the chip raised an alarm or a fault, or the value crossed the maximum or
+	// critical threshold. Minimums are left to the chip, which knows whether a fan is connected.
+	Alarm bool `json:"alarm"`
+}
+
+type PowerSupply struct {
+	Name string `json:"name"`
+	// Status is the status of the sensor reported by ipmitool, like ok or ns when there is no reading
+	Status  string   `json:"status"`
+	Present bool     `json:"present"`
+	Failed  bool     `json:"failed"`
+	Events  []string `json:"events,omitempty"`
+}
+
+type HardwareFault struct {
+	Date   string `json:"date"`
+	Time   string `json:"time"`
+	Sensor string `json:"sensor"`
+	Event  string `json:"event"`
+}
+
+// ipmiSensor is a line of the output of ipmitool sdr type, for example:
+//
+//	PS2 Status       | 65h | ok  | 10.2 | Presence detected, Failure detected
+type ipmiSensor struct {
+	name   string
+	status string
+	events []string
+}
+
+type hardwareHealth struct {
+	sysfsReader
+	inventoryConfig *config.InventoryConfig
+	now             func() time.Time
+}
+
+func newHardwareHealth(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) *hardwareHealth {
+	return &hardwareHealth{sysfsReader: sysfsReader{dependencies: dependencies}, inventoryConfig: inventoryConfig, now: time.Now}
+}
+
+func (h *hardwareHealth) readSensor(dir, chip, prefix, index string) *Sensor {
+	hwmonType := hwmonTypes[prefix]
+	attribute := func(name string) string {
+		return path.Join(dir, fmt.Sprintf("%s%s_%s", prefix, index, name))
+	}
+	value, ok := h.readFloat(attribute("input"), hwmonType.divisor)
+	if !ok {
+		return nil
+	}
+	alarm := false
+	for _, name := range []string{"alarm", "min_alarm", "max_alarm", "crit_alarm", "fault"} {
+		alarm = alarm || h.readString(attribute(name)) == "1"
+	}
+	// Chips have more inputs than the board uses, the headers without a fan or a probe read 0
+	if value == 0 && !alarm {
+		return nil
+	}
+	ret := &Sensor{
+		Chip:  chip,
+		Label: h.readString(attribute("label")),
+		Type:  hwmonType.sensorType,
+		Value: value,
+	}
+	if ret.Label == "" {
+		ret.Label = prefix + index
+	}
+	ret.Min, _ = h.readFloat(attribute("min"), hwmonType.divisor)
+	ret.Max, _ = h.readFloat(attribute("max"), hwmonType.divisor)
+	ret.Critical, _ = h.readFloat(attribute("crit"), hwmonType.divisor)
+	ret.Alarm = alarm || (ret.Max > 0 && ret.Value >= ret.Max) || (ret.Critical > 0 && ret.Value >= ret.Critical)
+	return ret
+}
+
+// getSensors returns the temperature, fan and voltage sensors of all the hwmon chips.
+func (h *hardwareHealth) getSensors() []*Sensor {
+	chips, err := h.dependencies.ReadDir(hwmonPath)
+	if err != nil {
+		logrus.WithError(err).Debug("Could not list the hwmon chips")
+		return nil
+	}
+	var ret []*Sensor
+	for _, chip := range chips {
+		dir := path.Join(hwmonPath, chip.Name())
+		files, err := h.dependencies.ReadDir(dir)
+		if err != nil {
+			logrus.WithError(err).Debugf("Could not list the attributes of hwmon chip %s", chip.Name())
+			continue
+		}
+		name := h.readString(path.Join(dir, "name"))
+		if name == "" {
+			name = chip.Name()
+		}
+		for _, file := range files {
+			matches := hwmonInputRegex.FindStringSubmatch(file.Name())
+			if matches == nil {
+				continue
+			}
+			if sensor := h.readSensor(dir, name, matches[1], matches[2]); sensor != nil {
+				ret = append(ret, sensor)
+			}
+		}
+	}
+	return ret
+}
+
+// parseIPMISensors parses the output of ipmitool sdr type.
+func parseIPMISensors(output string) []*ipmiSensor {
+	var ret []*ipmiSensor
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) != 5 {
+			continue
+		}
+		sensor := &ipmiSensor{
+			name:   strings.TrimSpace(fields[0]),
+			status: strings.TrimSpace(fields[2]),
+		}
+		for _, event := range strings.Split(fields[4], ",") {
+			if event = strings.TrimSpace(event); event != "" {
+				sensor.events = append(sensor.events, event)
+			}
+		}
+		ret = append(ret, sensor)
+	}
+	return ret
+}
+
+func containsAny(s string, substrings []string) bool {
+	s = strings.ToLower(s)
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *hardwareHealth) getIPMISensors(sensorType string) ([]*ipmiSensor, bool) {
+	o, e, exitCode := h.dependencies.Execute("ipmitool", "sdr", "type", sensorType)
+	if exitCode != 0 {
+		logrus.Debugf("Could not get the %s sensors of the BMC: %s", sensorType, e)
+		return nil, false
+	}
+	return parseIPMISensors(o), true
+}
+
+// applyPowerSupplies sets the power supplies and their redundancy from the IPMI sensors. Returns
+// false when the BMC can't be queried.
+func (h *hardwareHealth) applyPowerSupplies(ret *HardwareHealth) bool {
+	sensors, ok := h.getIPMISensors("Power Supply")
+	if !ok {
+		return false
+	}
+	for _, sensor := range sensors {
+		if strings.Contains(strings.ToLower(sensor.name), "redundan") ||
+			(len(sensor.events) > 0 && strings.Contains(strings.ToLower(sensor.events[0]), "redundan")) {
+			if len(sensor.events) > 0 {
+				ret.PowerRedundancy = sensor.events[0]
+			}
+			continue
+		}
+		psu := &PowerSupply{
+			Name:   sensor.name,
+			Status: sensor.status,
+			Events: sensor.events,
+		}
+		for _, event := range sensor.events {
+			if strings.EqualFold(event, "Presence detected") {
+				psu.Present = true
+			}
+			if containsAny(event, psuFailureEvents) {
+				psu.Failed = true
+			}
+		}
+		ret.PowerSupplies = append(ret.PowerSupplies, psu)
+	}
+	return true
+}
+
+func (h *hardwareHealth) applyChassisIntrusion(ret *HardwareHealth) {
+	sensors, _ := h.getIPMISensors("Physical Security")
+	for _, sensor := range sensors {
+		for _, event := range sensor.events {
+			if strings.Contains(strings.ToLower(event), "intrusion") {
+				ret.ChassisIntrusion = true
+				ret.Warnings = append(ret.Warnings, fmt.Sprintf("Chassis intrusion detected by sensor %s: %s", sensor.name, event))
+			}
+		}
+	}
+}
+
+// parseSELFaults returns the fault events of the output of ipmitool sel elist that weren't
+// deasserted by a later event, for example:
+//
+//	5 | 03/14/2024 | 10:40:55 | Temperature CPU1 Temp | Upper Critical going high | Asserted
+//
+// The log keeps the whole history and many BMCs never deassert events, so the events before the
+// last time the log was cleared and the ones older than since are ignored. Events logged before
+// the BMC clock was set, with a Pre-Init timestamp, are kept.
+func parseSELFaults(output string, since time.Time) []*HardwareFault {
+	var ret []*HardwareFault
+	asserted := map[string]int{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) < 6 {
+			continue
+		}
+		fault := &HardwareFault{
+			Date:   strings.TrimSpace(fields[1]),
+			Time:   strings.TrimSpace(fields[2]),
+			Sensor: strings.TrimSpace(fields[3]),
+			Event:  strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(fields[4]), "()")),
+		}
+		if strings.EqualFold(fault.Event, selClearedEvent) {
+			ret = nil
+			asserted = map[string]int{}
+			continue
+		}
+		if !containsAny(fault.Event, selFaultEvents) {
+			continue
+		}
+		if date, err := time.Parse(selDateFormat, fault.Date+" "+fault.Time); err == nil && date.Before(since) {
+			continue
+		}
+		key := fault.Sensor + "|" + fault.Event
+		switch strings.TrimSpace(fields[5]) {
+		case "Asserted":
+			if _, ok := asserted[key]; !ok {
+				asserted[key] = len(ret)
+				ret = append(ret, fault)
+			}
+		case "Deasserted":
+			if i, ok := asserted[key]; ok {
+				ret[i] = nil
+				delete(asserted, key)
+			}
+		}
+	}
+	var faults []*HardwareFault
+	for _, fault := range ret {
+		if fault != nil {
+			faults = append(faults, fault)
+		}
+	}
+	return faults
+}
+
+func (h *hardwareHealth) getSELFaults() []*HardwareFault {
+	o, e, exitCode := h.dependencies.Execute("ipmitool", "sel", "elist")
+	if exitCode != 0 {
+		logrus.Debugf("Could not get the system event log of the BMC: %s", e)
+		return nil
+	}
+	return parseSELFaults(o, h.now().Add(-selFaultMaxAge))
+}
+
+func sensorWarning(sensor *Sensor) string {
+	name := sensor.Chip + " " + sensor.Label
+	switch sensor.Type {
+	case sensorTypeTemperature:
+		if sensor.Critical > 0 && sensor.Value >= sensor.Critical {
+			return fmt.Sprintf("Temperature of %s is %g°C, above the critical threshold of %g°C", name, sensor.Value, sensor.Critical)
+		}
+		if sensor.Max > 0 && sensor.Value >= sensor.Max {
+			return fmt.Sprintf("Temperature of %s is %g°C, above the maximum of %g°C", name, sensor.Value, sensor.Max)
+		}
+		return fmt.Sprintf("Temperature of %s is %g°C and in alarm", name, sensor.Value)
+	case sensorTypeFan:
+		return fmt.Sprintf("Fan %s is in alarm at %g RPM", name, sensor.Value)
+	}
+	return fmt.Sprintf("Voltage of %s is in alarm at %gV", name, sensor.Value)
+}
+
+func (h *hardwareHealth) getHardwareHealth() *HardwareHealth {
+	ret := &HardwareHealth{Sensors: h.getSensors()}
+	for _, sensor := range ret.Sensors {
+		if sensor.Alarm {
+			ret.Warnings = append(ret.Warnings, sensorWarning(sensor))
+		}
+	}
+
+	// Querying the BMC is too slow for dry run
+	if !h.inventoryConfig.DryRunEnabled && h.applyPowerSupplies(ret) {
+		for _, psu := range ret.PowerSupplies {
+			if psu.Failed {
+				ret.Warnings = append(ret.Warnings, fmt.Sprintf("Power supply %s failed: %s", psu.Name, strings.Join(psu.Events, ", ")))
+			}
+		}
+		if ret.PowerRedundancy != "" && !strings.EqualFold(ret.PowerRedundancy, "Fully Redundant") {
+			ret.Warnings = append(ret.Warnings, fmt.Sprintf("Power supplies are not redundant: %s", ret.PowerRedundancy))
+		}
+		h.applyChassisIntrusion(ret)
+		ret.Faults = h.getSELFaults()
+		for _, fault := range ret.Faults {
+			ret.Warnings = append(ret.Warnings, fmt.Sprintf("System event log fault of %s since %s %s: %s",
+				fault.Sensor, fault.Date, fault.Time, fault.Event))
+		}
+	}
+
+	if len(ret.Sensors) == 0 && len(ret.PowerSupplies) == 0 && ret.PowerRedundancy == "" &&
+		!ret.ChassisIntrusion && len(ret.Faults) == 0 {
+		return nil
+	}
+	return ret
+}
+
+// GetHardwareHealth returns the hwmon sensors and, when the host has a BMC, the state of the power
+// supplies, the chassis intrusion sensor and the faults of the system event log.
+func GetHardwareHealth(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) *HardwareHealth {
+	return newHardwareHealth(inventoryConfig, dependencies).getHardwareHealth()
+}
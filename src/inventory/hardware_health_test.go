@@ -0,0 +1,179 @@
+package inventory
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+)
+
+const (
+	// Captured on a Dell PowerEdge R640 with a failed second power supply
+	ipmiSdrPowerSupplyDell = `PS1 Status       | 63h | ok  | 10.1 | Presence detected
+PS2 Status       | 64h | ok  | 10.2 | Presence detected, Failure detected
+PS Redundancy    | 74h | ok  |  7.1 | Redundancy Lost
+`
+
+	// Captured on an HPE ProLiant DL380 Gen10
+	ipmiSdrPowerSupplyHPE = `Power Supply 1   | 42h | ok  | 10.1 | Presence detected
+Power Supply 2   | 43h | ns  | 10.2 | No Reading
+Power Supplies   | 4Ah | ok  | 19.1 | Fully Redundant
+`
+
+	ipmiSdrPhysicalSecurity = `Intrusion        | 73h | ok  |  7.1 | General Chassis intrusion
+`
+
+	ipmiSelElist = `   1 | 03/14/2024 | 09:12:44 | Event Logging Disabled #0x72 | Log area reset/cleared | Asserted
+   2 | 03/14/2024 | 09:20:01 | Power Supply PS2 Status | Failure detected () | Asserted
+   3 | 03/14/2024 | 09:25:13 | Power Supply PS2 Status | Failure detected () | Deasserted
+   4 | 03/14/2024 | 09:40:55 | Temperature CPU1 Temp | Upper Critical going high | Asserted
+   5 | 03/14/2024 | 10:02:17 | Power Supply PS2 Status | Power Supply AC lost | Asserted
+   6 | 03/14/2024 | 10:05:42 | Power Supply PS1 Status | Presence detected | Asserted
+   7 | 03/14/2024 | 10:40:55 | Temperature CPU1 Temp | Upper Critical going high | Deasserted
+   8 | 03/14/2024 | 11:02:03 | Temperature Inlet Temp | Upper Non-critical going high | Asserted
+   9 | 03/14/2024 | 11:05:31 | Voltage PS1 Voltage | Lower Non-critical going low | Asserted
+`
+)
+
+var _ = Describe("Hardware health", func() {
+	var (
+		dependencies    *util.MockIDependencies
+		inventoryConfig *config.InventoryConfig
+	)
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+		inventoryConfig = &config.InventoryConfig{}
+	})
+
+	AfterEach(func() {
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	// The day after the events of the system event log fixture
+	getHardwareHealth := func() *HardwareHealth {
+		h := newHardwareHealth(inventoryConfig, dependencies)
+		h.now = func() time.Time { return time.Date(2024, 3, 15, 8, 0, 0, 0, time.UTC) }
+		return h.getHardwareHealth()
+	}
+
+	mockNoBMC := func() {
+		dependencies.On("Execute", "ipmitool", "sdr", "type", "Power Supply").Return("", "Could not open device at /dev/ipmi0", 1).Once()
+	}
+
+	It("reports the hwmon sensors and warns about the ones in alarm", func() {
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/hwmon/hwmon0/name":        "coretemp\n",
+			"/sys/class/hwmon/hwmon0/temp1_input": "99000\n",
+			"/sys/class/hwmon/hwmon0/temp1_label": "Package id 0\n",
+			"/sys/class/hwmon/hwmon0/temp1_max":   "84000\n",
+			"/sys/class/hwmon/hwmon0/temp1_crit":  "100000\n",
+			"/sys/class/hwmon/hwmon0/temp2_input": "45000\n",
+			"/sys/class/hwmon/hwmon0/temp2_label": "Core 0\n",
+			"/sys/class/hwmon/hwmon0/temp2_max":   "84000\n",
+			"/sys/class/hwmon/hwmon1/name":        "nct6775\n",
+			"/sys/class/hwmon/hwmon1/fan1_input":  "0\n",
+			"/sys/class/hwmon/hwmon1/fan1_min":    "600\n",
+			"/sys/class/hwmon/hwmon1/fan2_input":  "0\n",
+			"/sys/class/hwmon/hwmon1/fan2_min":    "600\n",
+			"/sys/class/hwmon/hwmon1/fan2_alarm":  "1\n",
+			"/sys/class/hwmon/hwmon1/fan3_input":  "540\n",
+			"/sys/class/hwmon/hwmon1/fan3_min":    "600\n",
+			"/sys/class/hwmon/hwmon1/in0_input":   "1192\n",
+			"/sys/class/hwmon/hwmon1/in0_label":   "Vcore\n",
+			"/sys/class/hwmon/hwmon1/in0_alarm":   "0\n",
+		})
+		mockNoBMC()
+
+		Expect(GetHardwareHealth(inventoryConfig, dependencies)).To(Equal(&HardwareHealth{
+			Sensors: []*Sensor{
+				{Chip: "coretemp", Label: "Package id 0", Type: sensorTypeTemperature, Value: 99, Max: 84, Critical: 100, Alarm: true},
+				{Chip: "coretemp", Label: "Core 0", Type: sensorTypeTemperature, Value: 45, Max: 84},
+				{Chip: "nct6775", Label: "fan2", Type: sensorTypeFan, Value: 0, Min: 600, Alarm: true},
+				{Chip: "nct6775", Label: "fan3", Type: sensorTypeFan, Value: 540, Min: 600},
+				{Chip: "nct6775", Label: "Vcore", Type: sensorTypeVoltage, Value: 1.192},
+			},
+			Warnings: []string{
+				"Temperature of coretemp Package id 0 is 99°C, above the maximum of 84°C",
+				"Fan nct6775 fan2 is in alarm at 0 RPM",
+			},
+		}))
+	})
+
+	It("reports failed power supplies, lost redundancy, intrusion and faults", func() {
+		mockSysfs(dependencies, map[string]string{})
+		dependencies.On("Execute", "ipmitool", "sdr", "type", "Power Supply").Return(ipmiSdrPowerSupplyDell, "", 0).Once()
+		dependencies.On("Execute", "ipmitool", "sdr", "type", "Physical Security").Return(ipmiSdrPhysicalSecurity, "", 0).Once()
+		dependencies.On("Execute", "ipmitool", "sel", "elist").Return(ipmiSelElist, "", 0).Once()
+
+		Expect(getHardwareHealth()).To(Equal(&HardwareHealth{
+			PowerSupplies: []*PowerSupply{
+				{Name: "PS1 Status", Status: "ok", Present: true, Events: []string{"Presence detected"}},
+				{Name: "PS2 Status", Status: "ok", Present: true, Failed: true, Events: []string{"Presence detected", "Failure detected"}},
+			},
+			PowerRedundancy:  "Redundancy Lost",
+			ChassisIntrusion: true,
+			Faults: []*HardwareFault{
+				{Date: "03/14/2024", Time: "10:02:17", Sensor: "Power Supply PS2 Status", Event: "Power Supply AC lost"},
+			},
+			Warnings: []string{
+				"Power supply PS2 Status failed: Presence detected, Failure detected",
+				"Power supplies are not redundant: Redundancy Lost",
+				"Chassis intrusion detected by sensor Intrusion: General Chassis intrusion",
+				"System event log fault of Power Supply PS2 Status since 03/14/2024 10:02:17: Power Supply AC lost",
+			},
+		}))
+	})
+
+	It("reports redundant power supplies without warnings", func() {
+		mockSysfs(dependencies, map[string]string{})
+		dependencies.On("Execute", "ipmitool", "sdr", "type", "Power Supply").Return(ipmiSdrPowerSupplyHPE, "", 0).Once()
+		dependencies.On("Execute", "ipmitool", "sdr", "type", "Physical Security").Return("", "", 0).Once()
+		dependencies.On("Execute", "ipmitool", "sel", "elist").Return("", "SEL has no entries", 0).Once()
+
+		Expect(GetHardwareHealth(inventoryConfig, dependencies)).To(Equal(&HardwareHealth{
+			PowerSupplies: []*PowerSupply{
+				{Name: "Power Supply 1", Status: "ok", Present: true, Events: []string{"Presence detected"}},
+				{Name: "Power Supply 2", Status: "ns", Events: []string{"No Reading"}},
+			},
+			PowerRedundancy: "Fully Redundant",
+		}))
+	})
+
+	It("doesn't query the BMC in dry run", func() {
+		inventoryConfig.DryRunEnabled = true
+		mockSysfs(dependencies, map[string]string{})
+		Expect(GetHardwareHealth(inventoryConfig, dependencies)).To(BeNil())
+	})
+
+	It("returns nothing without sensors and BMC", func() {
+		mockSysfs(dependencies, map[string]string{})
+		mockNoBMC()
+		Expect(GetHardwareHealth(inventoryConfig, dependencies)).To(BeNil())
+	})
+
+	Context("parseSELFaults", func() {
+		since := time.Date(2024, 3, 8, 8, 0, 0, 0, time.UTC)
+
+		It("ignores the faults before the log was cleared", func() {
+			output := `   1 | 03/12/2024 | 16:30:12 | Power Supply PS1 Status | Failure detected () | Asserted
+   2 | 03/13/2024 | 08:01:40 | Event Logging Disabled #0x72 | Log area reset/cleared | Asserted
+   3 | 03/14/2024 | 09:20:01 | Power Supply PS2 Status | Failure detected () | Asserted
+`
+			Expect(parseSELFaults(output, since)).To(Equal([]*HardwareFault{
+				{Date: "03/14/2024", Time: "09:20:01", Sensor: "Power Supply PS2 Status", Event: "Failure detected"},
+			}))
+		})
+
+		It("ignores the old faults and keeps the ones logged before the clock was set", func() {
+			output := `   1 | 11/02/2023 | 04:12:55 | Fan FAN3 | Lower Critical going low | Asserted
+   2 | Pre-Init |  0000000012 | Power Supply PS2 Status | Power Supply AC lost | Asserted
+`
+			Expect(parseSELFaults(output, since)).To(Equal([]*HardwareFault{
+				{Date: "Pre-Init", Time: "0000000012", Sensor: "Power Supply PS2 Status", Event: "Power Supply AC lost"},
+			}))
+		})
+	})
+})
@@ -1,12 +1,66 @@
 package inventory
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
 	"strings"
+	"time"
 
+	"github.com/openshift/assisted-installer-agent/src/config"
 	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/openshift/assisted-service/models"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// defaultHostnameTemplate generates host names from the MAC address of the primary interface,
+	// for example a8-cd-16-ae-79-01
+	defaultHostnameTemplate = "{mac}"
+
+	reverseDNSTimeout = 5 * time.Second
+
+	// maxHostnameLabelLength and maxHostnameLength are the limits of RFC 1123
+	maxHostnameLabelLength = 63
+	maxHostnameLength      = 253
+)
+
+// The sources of the host name sent to the service
+const (
+	hostnameSourceSystem     = "system"
+	hostnameSourceReverseDNS = "reverse_dns"
+	hostnameSourceTemplate   = "template"
+)
+
+// The reasons to replace the host name of the system
+const (
+	hostnameReasonForbidden = "forbidden"
+	hostnameReasonInvalid   = "invalid"
+	hostnameReasonDHCP      = "dhcp"
+)
+
+var (
+	hostnameInvalidCharsRegex   = regexp.MustCompile(`[^a-z0-9-]+`)
+	hostnameTemplateFieldsRegex = regexp.MustCompile(`\{([a-z_]*)\}`)
+)
+
+// errNoHostnameTemplateValue is returned when the host has nothing to fill a field of the host
+// name template with, for example a MAC address when it has no usable interface yet. It is
+// expected until the network is configured, so the original host name is kept without an error.
+var errNoHostnameTemplateValue = errors.New("no value for field")
+
+// HostnameDetails describes how the host name of the inventory was chosen.
+type HostnameDetails struct {
+	Original   string `json:"original"`
+	Calculated string `json:"calculated"`
+	// Source is where the calculated host name comes from: system, reverse_dns or template
+	Source string `json:"source"`
+	// Reason is why the original host name was replaced: forbidden, invalid or dhcp
+	Reason string `json:"reason,omitempty"`
+}
+
 func GetHostname(dependencies util.IDependencies) string {
 	h, err := dependencies.Hostname()
 	if err != nil {
@@ -15,3 +69,201 @@ func GetHostname(dependencies util.IDependencies) string {
 	}
 	return strings.TrimSpace(h)
 }
+
+// hostnamePolicy decides which host name is sent to the service.
+type hostnamePolicy struct {
+	template    string
+	reverseDNS  bool
+	replaceDHCP bool
+	lookupAddr  func(ctx context.Context, addr string) ([]string, error)
+}
+
+func newHostnamePolicy(inventoryConfig *config.InventoryConfig) *hostnamePolicy {
+	ret := &hostnamePolicy{
+		template:    inventoryConfig.HostnameTemplate,
+		reverseDNS:  inventoryConfig.HostnameReverseDNS && !inventoryConfig.DryRunEnabled,
+		replaceDHCP: inventoryConfig.HostnameReplaceDHCP,
+		lookupAddr:  net.DefaultResolver.LookupAddr,
+	}
+	if ret.template == "" {
+		ret.template = defaultHostnameTemplate
+	}
+	return ret
+}
+
+// normalizeHostname converts the given name to a valid RFC 1123 host name: it is converted to lower
+// case, invalid characters like underscores are replaced with hyphens, labels are truncated to 63
+// characters and the name to 253. Returns an empty string if nothing valid is left.
+func normalizeHostname(name string) string {
+	var labels []string
+	length := -1
+	for _, label := range strings.Split(strings.ToLower(name), ".") {
+		label = strings.Trim(hostnameInvalidCharsRegex.ReplaceAllString(label, "-"), "-")
+		if len(label) > maxHostnameLabelLength {
+			label = strings.TrimRight(label[:maxHostnameLabelLength], "-")
+		}
+		if label == "" {
+			continue
+		}
+		if length+1+len(label) > maxHostnameLength {
+			break
+		}
+		length += 1 + len(label)
+		labels = append(labels, label)
+	}
+	return strings.Join(labels, ".")
+}
+
+// isDHCPHostname checks if the given host name was assigned by one of the DHCP leases of the host.
+func isDHCPHostname(hostname string, inventory *Inventory) bool {
+	if inventory.NetworkConfiguration == nil {
+		return false
+	}
+	short, _, _ := strings.Cut(hostname, ".")
+	for _, lease := range inventory.NetworkConfiguration.DHCPLeases {
+		leaseShort, _, _ := strings.Cut(lease.Hostname, ".")
+		if lease.Hostname != "" && strings.EqualFold(short, leaseShort) {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceReason returns why the original host name needs to be replaced, or an empty string if it
+// can be kept once normalized.
+func (p *hostnamePolicy) replaceReason(inventory *Inventory) string {
+	switch {
+	case isForbiddenHostname(inventory.Hostname):
+		return hostnameReasonForbidden
+	case isForbiddenHostname(normalizeHostname(inventory.Hostname)) || normalizeHostname(inventory.Hostname) == "":
+		return hostnameReasonInvalid
+	case p.replaceDHCP && isDHCPHostname(inventory.Hostname, inventory):
+		return hostnameReasonDHCP
+	}
+	return ""
+}
+
+// primaryIP returns the first global address of the interface used to generate host names.
+func primaryIP(nic *models.Interface) string {
+	for _, cidr := range append(append([]string{}, nic.IPV4Addresses...), nic.IPV6Addresses...) {
+		if ok, err := isGlobalCIDR(cidr); err == nil && ok {
+			ip, _, _ := net.ParseCIDR(cidr)
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// lookupHostname returns the name that the reverse DNS gives to the primary IP address of the host.
+func (p *hostnamePolicy) lookupHostname(nic *models.Interface) (string, error) {
+	ip := primaryIP(nic)
+	if ip == "" {
+		return "", fmt.Errorf("interface %s has no global address", nic.Name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), reverseDNSTimeout)
+	defer cancel()
+	names, err := p.lookupAddr(ctx, ip)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		if hostname := normalizeHostname(name); hostname != "" && !isForbiddenHostname(hostname) {
+			return hostname, nil
+		}
+	}
+	return "", fmt.Errorf("address %s has no usable name", ip)
+}
+
+// expandTemplate replaces the fields of the host name template with the values of the inventory.
+// The supported fields are {mac}, the MAC address of the primary interface with hyphens, {serial},
+// the serial number of the system, and {bmc_hostname}, the host name of the BMC.
+func (p *hostnamePolicy) expandTemplate(inventory *Inventory, nic *models.Interface) (string, error) {
+	var err error
+	result := hostnameTemplateFieldsRegex.ReplaceAllStringFunc(p.template, func(field string) string {
+		var value string
+		switch field[1 : len(field)-1] {
+		case "mac":
+			if nic != nil {
+				value = strings.ReplaceAll(nic.MacAddress, ":", "-")
+			}
+		case "serial":
+			if inventory.SystemVendor != nil {
+				value = inventory.SystemVendor.SerialNumber
+			}
+		case "bmc_hostname":
+			if inventory.Bmc != nil {
+				value = inventory.Bmc.Hostname
+			}
+		default:
+			err = fmt.Errorf("unknown field %s in host name template %q", field, p.template)
+		}
+		if value == "" && err == nil {
+			err = fmt.Errorf("%w %s of host name template %q", errNoHostnameTemplateValue, field, p.template)
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	hostname := normalizeHostname(result)
+	if hostname == "" || isForbiddenHostname(hostname) {
+		return "", fmt.Errorf("host name template %q generated invalid name %q", p.template, result)
+	}
+	return hostname, nil
+}
+
+// calculateHostname generates a host name to replace the original one, from the reverse DNS when
+// enabled and otherwise from the template.
+func (p *hostnamePolicy) calculateHostname(inventory *Inventory) (string, string, error) {
+	nic, err := findUsableNIC(&inventory.Inventory)
+	if err != nil {
+		return "", "", err
+	}
+	if p.reverseDNS && nic != nil {
+		hostname, err := p.lookupHostname(nic)
+		if err == nil {
+			return hostname, hostnameSourceReverseDNS, nil
+		}
+		logrus.WithError(err).Info("Could not find the host name in the reverse DNS, will use the template")
+	}
+	hostname, err := p.expandTemplate(inventory, nic)
+	if err != nil {
+		return "", "", err
+	}
+	return hostname, hostnameSourceTemplate, nil
+}
+
+// apply chooses the host name of the inventory, normalizing the original one or replacing it when
+// it is forbidden, invalid or assigned by DHCP, and returns the details of the choice.
+func (p *hostnamePolicy) apply(inventory *Inventory) *HostnameDetails {
+	ret := &HostnameDetails{
+		Original: inventory.Hostname,
+		Source:   hostnameSourceSystem,
+		Reason:   p.replaceReason(inventory),
+	}
+	if normalized := normalizeHostname(inventory.Hostname); normalized != "" && !isForbiddenHostname(normalized) {
+		inventory.Hostname = normalized
+	}
+	if ret.Reason != "" {
+		hostname, source, err := p.calculateHostname(inventory)
+		logger := logrus.WithError(err).WithFields(logrus.Fields{
+			"original": ret.Original,
+			"reason":   ret.Reason,
+		})
+		if errors.Is(err, errNoHostnameTemplateValue) {
+			logger.Debug("Can't generate hostname yet, will use the original one")
+		} else if err != nil {
+			logger.Error("Failed to generate hostname, will use the original one")
+		} else {
+			logrus.WithFields(logrus.Fields{
+				"original":   ret.Original,
+				"calculated": hostname,
+				"reason":     ret.Reason,
+			}).Info("Replaced original hostname with calculated one")
+			inventory.Hostname = hostname
+			ret.Source = source
+		}
+	}
+	ret.Calculated = inventory.Hostname
+	return ret
+}
@@ -1,11 +1,18 @@
 package inventory
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"strings"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/config"
 	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/openshift/assisted-service/models"
 )
 
 var _ = Describe("Hostname test", func() {
@@ -36,3 +43,150 @@ var _ = Describe("Hostname test", func() {
 		Expect(ret).To(Equal("myhostname.com"))
 	})
 })
+
+var _ = Describe("Hostname policy", func() {
+	var (
+		inventory *Inventory
+		policy    *hostnamePolicy
+	)
+
+	BeforeEach(func() {
+		inventory = &Inventory{
+			Inventory: models.Inventory{
+				Hostname: "localhost",
+				Interfaces: []*models.Interface{{
+					Name:          "eno1",
+					Type:          "physical",
+					MacAddress:    "A8:CD:16:AE:79:01",
+					IPV4Addresses: []string{"192.168.122.10/24"},
+				}},
+				SystemVendor: &models.SystemVendor{SerialNumber: "J30A1B2C"},
+			},
+			Bmc: &BMC{Hostname: "XCC-7X06-J30A1B2C"},
+		}
+		policy = newHostnamePolicy(&config.InventoryConfig{})
+	})
+
+	DescribeTable("normalizes host names to RFC 1123",
+		func(name, expected string) {
+			Expect(normalizeHostname(name)).To(Equal(expected))
+		},
+		Entry("keeps valid names", "master-0.example.com", "master-0.example.com"),
+		Entry("converts to lower case", "Master-0.Example.COM", "master-0.example.com"),
+		Entry("replaces underscores", "master_0", "master-0"),
+		Entry("trims hyphens of labels", "-master-.-example-", "master.example"),
+		Entry("removes empty labels", "master-0.example.com.", "master-0.example.com"),
+		Entry("truncates long labels", strings.Repeat("a", 62)+"_b_c.example.com", strings.Repeat("a", 62)+".example.com"),
+		Entry("truncates long names", strings.Repeat(strings.Repeat("a", 63)+".", 4)+"com", strings.Repeat("a", 63)+"."+strings.Repeat("a", 63)+"."+strings.Repeat("a", 63)),
+		Entry("returns empty for invalid names", "___", ""),
+	)
+
+	It("reports the original and the calculated host names", func() {
+		processInventory(inventory, policy)
+		Expect(inventory.Hostname).To(Equal("a8-cd-16-ae-79-01"))
+		Expect(inventory.HostnameDetails).To(Equal(&HostnameDetails{
+			Original:   "localhost",
+			Calculated: "a8-cd-16-ae-79-01",
+			Source:     hostnameSourceTemplate,
+			Reason:     hostnameReasonForbidden,
+		}))
+	})
+
+	It("normalizes valid names without replacing them", func() {
+		inventory.Hostname = "Worker_0.Example.com"
+		processInventory(inventory, policy)
+		Expect(inventory.HostnameDetails).To(Equal(&HostnameDetails{
+			Original:   "Worker_0.Example.com",
+			Calculated: "worker-0.example.com",
+			Source:     hostnameSourceSystem,
+		}))
+	})
+
+	It("replaces names that can't be normalized", func() {
+		inventory.Hostname = "LOCALHOST"
+		processInventory(inventory, policy)
+		Expect(inventory.Hostname).To(Equal("a8-cd-16-ae-79-01"))
+		Expect(inventory.HostnameDetails.Reason).To(Equal(hostnameReasonInvalid))
+	})
+
+	DescribeTable("expands the templates",
+		func(template, expected string) {
+			policy.template = template
+			processInventory(inventory, policy)
+			Expect(inventory.Hostname).To(Equal(expected))
+		},
+		Entry("MAC with prefix", "worker-{mac}", "worker-a8-cd-16-ae-79-01"),
+		Entry("system serial", "node-{serial}", "node-j30a1b2c"),
+		Entry("BMC host name", "{bmc_hostname}", "xcc-7x06-j30a1b2c"),
+		Entry("unknown field", "{uuid}", "localhost"),
+	)
+
+	It("keeps the original name when the template has no value", func() {
+		inventory.Bmc = nil
+		policy.template = "{bmc_hostname}"
+		processInventory(inventory, policy)
+		Expect(inventory.HostnameDetails).To(Equal(&HostnameDetails{
+			Original:   "localhost",
+			Calculated: "localhost",
+			Source:     hostnameSourceSystem,
+			Reason:     hostnameReasonForbidden,
+		}))
+	})
+
+	It("keeps the original name when there is no usable interface yet", func() {
+		inventory.Inventory.Interfaces = nil
+		_, err := policy.expandTemplate(inventory, nil)
+		Expect(errors.Is(err, errNoHostnameTemplateValue)).To(BeTrue())
+
+		processInventory(inventory, policy)
+		Expect(inventory.Hostname).To(Equal("localhost"))
+		Expect(inventory.HostnameDetails.Source).To(Equal(hostnameSourceSystem))
+	})
+
+	It("uses the reverse DNS of the primary IP address", func() {
+		policy.reverseDNS = true
+		policy.lookupAddr = func(_ context.Context, addr string) ([]string, error) {
+			Expect(addr).To(Equal("192.168.122.10"))
+			return []string{"localhost.", "Master-0.Example.com."}, nil
+		}
+		processInventory(inventory, policy)
+		Expect(inventory.Hostname).To(Equal("master-0.example.com"))
+		Expect(inventory.HostnameDetails.Source).To(Equal(hostnameSourceReverseDNS))
+	})
+
+	It("falls back to the template when the reverse DNS fails", func() {
+		policy.reverseDNS = true
+		policy.lookupAddr = func(context.Context, string) ([]string, error) {
+			return nil, &net.DNSError{Err: "no such host", IsNotFound: true}
+		}
+		processInventory(inventory, policy)
+		Expect(inventory.Hostname).To(Equal("a8-cd-16-ae-79-01"))
+		Expect(inventory.HostnameDetails.Source).To(Equal(hostnameSourceTemplate))
+	})
+
+	Context("DHCP host names", func() {
+		BeforeEach(func() {
+			inventory.Hostname = "node.example.com"
+			inventory.NetworkConfiguration = &NetworkConfiguration{
+				DHCPLeases: []*DHCPLease{{Interface: "eno1", Hostname: "node"}},
+			}
+		})
+
+		It("keeps them by default", func() {
+			processInventory(inventory, policy)
+			Expect(inventory.Hostname).To(Equal("node.example.com"))
+		})
+
+		It("replaces them when configured", func() {
+			policy.replaceDHCP = true
+			policy.template = "{serial}"
+			processInventory(inventory, policy)
+			Expect(inventory.HostnameDetails).To(Equal(&HostnameDetails{
+				Original:   "node.example.com",
+				Calculated: "j30a1b2c",
+				Source:     hostnameSourceTemplate,
+				Reason:     hostnameReasonDHCP,
+			}))
+		})
+	})
+})
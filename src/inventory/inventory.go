@@ -10,36 +10,206 @@ import (
 	"github.com/openshift/assisted-installer-agent/src/config"
 	"github.com/openshift/assisted-installer-agent/src/util"
 	"github.com/openshift/assisted-service/models"
-	"github.com/sirupsen/logrus"
 )
 
-func ReadInventory(inventoryConfig *config.InventoryConfig, c *Options) *models.Inventory {
+// Inventory is the inventory sent to the service. It extends the model with details that the
+// service doesn't know about, the service ignores them.
+type Inventory struct {
+	models.Inventory
+	Bmc                  *BMC                  `json:"bmc,omitempty"`
+	CPU                  *CPU                  `json:"cpu,omitempty"`
+	Memory               *Memory               `json:"memory,omitempty"`
+	Disks                []*Disk               `json:"disks"`
+	Interfaces           []*Interface          `json:"interfaces"`
+	Accelerators         []*Accelerator        `json:"accelerators,omitempty"`
+	Firmware             *Firmware             `json:"firmware,omitempty"`
+	TPM                  *TPM                  `json:"tpm,omitempty"`
+	Routing              *Routing              `json:"routing,omitempty"`
+	NetworkConfiguration *NetworkConfiguration `json:"network_configuration,omitempty"`
+	HostnameDetails      *HostnameDetails      `json:"hostname_details,omitempty"`
+	Platform             *Platform             `json:"platform,omitempty"`
+	HardwareHealth       *HardwareHealth       `json:"hardware_health,omitempty"`
+	RAID                 *RAID                 `json:"raid,omitempty"`
+	CollectorStatuses    []*CollectorStatus    `json:"collector_statuses,omitempty"`
+
+	// Details gathered by their own collectors, attached to the parts of the inventory they belong
+	// to once all the collectors finished
+	diskHealth            map[string]*DiskHealth
+	nvmeNamespaces        map[string]*NVMeNamespace
+	diskContents          map[string]*DiskContent
+	previousInstallations map[string]*PreviousInstallation
+	lldpNeighbors         map[string]*LLDPNeighbor
+	networkDevices        map[string]*NetworkDevice
+	topology              map[string]*InterfaceTopology
+	cpuTopology           *CPUTopology
+	memoryDetails         *MemoryDetails
+}
+
+// Disk is a disk of the inventory with the details that the model doesn't have.
+type Disk struct {
+	*models.Disk
+	Health               *DiskHealth           `json:"health,omitempty"`
+	NVMe                 *NVMeNamespace        `json:"nvme,omitempty"`
+	Content              *DiskContent          `json:"content,omitempty"`
+	PreviousInstallation *PreviousInstallation `json:"previous_installation,omitempty"`
+	RAID                 *LogicalDisk          `json:"raid,omitempty"`
+	// Warnings are issues that don't prevent installing on the disk but that the user should know
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Interface is a network interface of the inventory with the details that the model doesn't have.
+type Interface struct {
+	*models.Interface
+	LLDP     *LLDPNeighbor      `json:"lldp,omitempty"`
+	Device   *NetworkDevice     `json:"device,omitempty"`
+	Topology *InterfaceTopology `json:"topology,omitempty"`
+}
+
+func ReadInventory(inventoryConfig *config.InventoryConfig, c *Options) *Inventory {
 	d := util.NewDependencies(&inventoryConfig.DryRunConfig, c.GhwChrootRoot)
-	ret := models.Inventory{
-		BmcAddress:   GetBmcAddress(inventoryConfig, d),
-		BmcV6address: GetBmcV6Address(inventoryConfig, d),
-		Boot:         GetBoot(d),
-		CPU:          GetCPU(d),
-		Disks:        GetDisks(inventoryConfig, d),
-		Gpus:         GetGPUs(inventoryConfig, d),
-		Hostname:     GetHostname(d),
-		Interfaces:   GetInterfaces(d),
-		Memory:       GetMemory(d),
-		SystemVendor: GetVendor(d),
-		Routes:       GetRoutes(d),
-		TpmVersion:   GetTPM(d),
-	}
-	processInventory(&ret)
+	ret := Inventory{}
+	ret.CollectorStatuses = runCollectors(&ret, newCollectors(inventoryConfig, d))
+	completeInventory(inventoryConfig, &ret)
 	return &ret
 }
 
-func CreateInventoryInfo(inventoryConfig *config.InventoryConfig) []byte {
-	in := ReadInventory(inventoryConfig, &Options{GhwChrootRoot: "/host"})
-
+// completeInventory combines what the collectors found. The dry run configuration is applied
+// first, so that the detailed interfaces are only built for the interface it keeps.
+func completeInventory(inventoryConfig *config.InventoryConfig, inventory *Inventory) {
 	if inventoryConfig.DryRunEnabled {
-		applyDryRunConfig(inventoryConfig, in)
+		applyDryRunConfig(inventoryConfig, &inventory.Inventory)
+	}
+	applyCollectedDetails(inventory)
+	processInventory(inventory, newHostnamePolicy(inventoryConfig))
+}
+
+// newCollectors returns the collectors of all the parts of the inventory.
+func newCollectors(inventoryConfig *config.InventoryConfig, d util.IDependencies) []collector {
+	return []collector{
+		newCollector("bmc", bmcCollectorTimeout,
+			func() *bmcAddresses { return getBMCAddresses(inventoryConfig, d) },
+			func(i *Inventory, v *bmcAddresses) {
+				i.Bmc = v.bmc
+				i.BmcAddress = v.v4Address
+				i.BmcV6address = v.v6Address
+			}),
+		newCollector("boot", defaultCollectorTimeout,
+			func() *models.Boot { return GetBoot(d) },
+			func(i *Inventory, v *models.Boot) { i.Boot = v }),
+		newCollector("firmware", bmcCollectorTimeout,
+			func() *Firmware { return GetFirmware(inventoryConfig, d) },
+			func(i *Inventory, v *Firmware) { i.Firmware = v }),
+		newCollector("cpu", defaultCollectorTimeout,
+			func() *models.CPU { return GetCPU(d) },
+			func(i *Inventory, v *models.CPU) { i.Inventory.CPU = v }),
+		newCollector("cpu_topology", defaultCollectorTimeout,
+			func() *CPUTopology { return GetCPUTopology(d) },
+			func(i *Inventory, v *CPUTopology) { i.cpuTopology = v }),
+		// Without the disks the service can't pick an installation disk
+		newCollector("disks", noCollectorTimeout,
+			func() []*models.Disk { return GetDisks(inventoryConfig, d) },
+			func(i *Inventory, v []*models.Disk) { i.Inventory.Disks = v }),
+		newCollector("disk_health", defaultCollectorTimeout,
+			func() map[string]*DiskHealth { return GetDiskHealth(inventoryConfig, d) },
+			func(i *Inventory, v map[string]*DiskHealth) { i.diskHealth = v }),
+		newCollector("nvme", defaultCollectorTimeout,
+			func() map[string]*NVMeNamespace { return GetNVMeNamespaces(d) },
+			func(i *Inventory, v map[string]*NVMeNamespace) { i.nvmeNamespaces = v }),
+		newCollector("disk_contents", defaultCollectorTimeout,
+			func() map[string]*DiskContent { return GetDiskContents(d) },
+			func(i *Inventory, v map[string]*DiskContent) { i.diskContents = v }),
+		// Mounted partitions are always unmounted before the collector returns
+		newCollector("previous_installations", noCollectorTimeout,
+			func() map[string]*PreviousInstallation { return GetPreviousInstallations(inventoryConfig, d) },
+			func(i *Inventory, v map[string]*PreviousInstallation) { i.previousInstallations = v }),
+		newCollector("raid", defaultCollectorTimeout,
+			func() *RAID { return GetRAID(inventoryConfig, d) },
+			func(i *Inventory, v *RAID) { i.RAID = v }),
+		newCollector("gpus", defaultCollectorTimeout,
+			func() []*models.Gpu { return GetGPUs(inventoryConfig, d) },
+			func(i *Inventory, v []*models.Gpu) { i.Gpus = v }),
+		newCollector("accelerators", defaultCollectorTimeout,
+			func() []*Accelerator { return GetAccelerators(inventoryConfig, d) },
+			func(i *Inventory, v []*Accelerator) { i.Accelerators = v }),
+		newCollector("hostname", defaultCollectorTimeout,
+			func() string { return GetHostname(d) },
+			func(i *Inventory, v string) { i.Hostname = v }),
+		newCollector("interfaces", defaultCollectorTimeout,
+			func() []*models.Interface { return GetInterfaces(d) },
+			func(i *Inventory, v []*models.Interface) { i.Inventory.Interfaces = v }),
+		newCollector("lldp", getLLDPCollectorTimeout(inventoryConfig),
+			func() map[string]*LLDPNeighbor { return GetLLDPNeighbors(inventoryConfig, d) },
+			func(i *Inventory, v map[string]*LLDPNeighbor) { i.lldpNeighbors = v }),
+		newCollector("network_devices", defaultCollectorTimeout,
+			func() map[string]*NetworkDevice { return GetNetworkDevices(d) },
+			func(i *Inventory, v map[string]*NetworkDevice) { i.networkDevices = v }),
+		newCollector("topology", defaultCollectorTimeout,
+			func() map[string]*InterfaceTopology { return GetTopology(d) },
+			func(i *Inventory, v map[string]*InterfaceTopology) { i.topology = v }),
+		newCollector("memory", defaultCollectorTimeout,
+			func() *models.Memory { return GetMemory(d) },
+			func(i *Inventory, v *models.Memory) { i.Inventory.Memory = v }),
+		newCollector("memory_details", defaultCollectorTimeout,
+			func() *MemoryDetails { return GetMemoryDetails(d) },
+			func(i *Inventory, v *MemoryDetails) { i.memoryDetails = v }),
+		newCollector("system_vendor", defaultCollectorTimeout,
+			func() *models.SystemVendor { return GetVendor(d) },
+			func(i *Inventory, v *models.SystemVendor) { i.SystemVendor = v }),
+		newCollector("platform", defaultCollectorTimeout,
+			func() *Platform { return GetPlatform(inventoryConfig, d) },
+			func(i *Inventory, v *Platform) { i.Platform = v }),
+		newCollector("hardware_health", bmcCollectorTimeout,
+			func() *HardwareHealth { return GetHardwareHealth(inventoryConfig, d) },
+			func(i *Inventory, v *HardwareHealth) { i.HardwareHealth = v }),
+		newCollector("routes", defaultCollectorTimeout,
+			func() []*models.Route { return GetRoutes(d) },
+			func(i *Inventory, v []*models.Route) { i.Routes = v }),
+		newCollector("routing", defaultCollectorTimeout,
+			GetRouting,
+			func(i *Inventory, v *Routing) { i.Routing = v }),
+		newCollector("network_configuration", defaultCollectorTimeout,
+			func() *NetworkConfiguration { return GetNetworkConfiguration(d) },
+			func(i *Inventory, v *NetworkConfiguration) { i.NetworkConfiguration = v }),
+		newCollector("tpm", defaultCollectorTimeout,
+			func() string { return GetTPM(d) },
+			func(i *Inventory, v string) { i.TpmVersion = v }),
+		newCollector("tpm_details", defaultCollectorTimeout,
+			func() *TPM { return GetTPMDetails(d) },
+			func(i *Inventory, v *TPM) { i.TPM = v }),
+	}
+}
+
+// applyCollectedDetails combines the results of collectors that describe the same parts of the host.
+func applyCollectedDetails(inventory *Inventory) {
+	applyFirmware(inventory)
+	applyCPUTopology(inventory)
+	applyMemoryDetails(inventory)
+
+	if inventory.Inventory.Disks != nil {
+		inventory.Disks = make([]*Disk, 0, len(inventory.Inventory.Disks))
+		for _, disk := range inventory.Inventory.Disks {
+			inventory.Disks = append(inventory.Disks, &Disk{Disk: disk})
+		}
 	}
+	applyDiskHealth(inventory)
+	applyNVMeNamespaces(inventory)
+	applyDiskContents(inventory)
+	applyPreviousInstallations(inventory)
+	applyRAID(inventory)
+
+	if inventory.Inventory.Interfaces != nil {
+		inventory.Interfaces = make([]*Interface, 0, len(inventory.Inventory.Interfaces))
+		for _, in := range inventory.Inventory.Interfaces {
+			inventory.Interfaces = append(inventory.Interfaces, &Interface{Interface: in})
+		}
+	}
+	applyLLDPNeighbors(inventory)
+	applyNetworkDevices(inventory)
+	applyTopology(inventory)
+}
 
+func CreateInventoryInfo(inventoryConfig *config.InventoryConfig) []byte {
+	in := ReadInventory(inventoryConfig, &Options{GhwChrootRoot: "/host"})
 	b, _ := json.Marshal(&in)
 	return b
 }
@@ -50,21 +220,8 @@ type Options struct {
 
 // processInventory processes the inventory before sending it to the service. For example, it
 // replaces forbidden host names with automatically generated ones.
-func processInventory(inventory *models.Inventory) {
-	if isForbiddenHostname(inventory.Hostname) {
-		calculatedHostname, err := calculateHostname(inventory)
-		if err != nil {
-			logrus.WithError(err).WithFields(logrus.Fields{
-				"original": inventory.Hostname,
-			}).Error("Failed to generate hostname, will use the original forbidden one")
-		} else {
-			logrus.WithFields(logrus.Fields{
-				"original":   inventory.Hostname,
-				"calculated": calculatedHostname,
-			}).Info("Replaced original forbidden hostname with calculated one")
-			inventory.Hostname = calculatedHostname
-		}
-	}
+func processInventory(inventory *Inventory, policy *hostnamePolicy) {
+	inventory.HostnameDetails = policy.apply(inventory)
 }
 
 // forbidenHostnames is the set of host names that are forbidden and need to be replaced with
@@ -84,22 +241,6 @@ func isForbiddenHostname(hostname string) bool {
 	return slices.Contains(forbiddenHostnames, hostname)
 }
 
-// calculateHostname calculates a hostname from the MAC address of one of the network interfaces of
-// the given inventory. For example, if the MAC address of the network interface is
-// A8:CD:16:AE:79:01 the result will be a8-cd-16-ae-79-01.
-func calculateHostname(inventory *models.Inventory) (result string, err error) {
-	nic, err := findUsableNIC(inventory)
-	if err != nil {
-		return
-	}
-	if nic == nil {
-		result = inventory.Hostname
-		return
-	}
-	result = strings.ToLower(strings.ReplaceAll(nic.MacAddress, ":", "-"))
-	return
-}
-
 // findUsableNIC returns a physical network interface card of the given host inventory that has a
 // MAC address and a non-local IP address. Returns nil if the host doesn't have such network
 // interface card, and an error if the process fails, for example if some of the IP addresses of the
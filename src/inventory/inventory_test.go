@@ -1,9 +1,12 @@
 package inventory
 
 import (
+	"encoding/json"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/config"
 	"github.com/openshift/assisted-service/models"
 )
 
@@ -12,19 +15,19 @@ var _ = Describe("Hostname processing", func() {
 		"Rename behaviour",
 		func(original string, interfaces []*models.Interface, expected string) {
 			// Create the inventory:
-			inventory := &models.Inventory{
+			inventory := &Inventory{Inventory: models.Inventory{
 				Hostname: original,
-			}
-			inventory.Interfaces = []*models.Interface{{
+			}}
+			inventory.Inventory.Interfaces = []*models.Interface{{
 				Name:          "lo",
 				MacAddress:    "",
 				IPV4Addresses: []string{"127.0.0.1/32"},
 				IPV6Addresses: []string{"::1/128"},
 			}}
-			inventory.Interfaces = append(inventory.Interfaces, interfaces...)
+			inventory.Inventory.Interfaces = append(inventory.Inventory.Interfaces, interfaces...)
 
 			// Process the inventory and check the results:
-			processInventory(inventory)
+			processInventory(inventory, newHostnamePolicy(&config.InventoryConfig{}))
 			Expect(inventory.Hostname).To(Equal(expected))
 		},
 		Entry(
@@ -236,3 +239,30 @@ var _ = Describe("Hostname processing", func() {
 		),
 	)
 })
+
+var _ = Describe("Dry run", func() {
+	It("only sends the forced interface", func() {
+		inventoryConfig := &config.InventoryConfig{}
+		inventoryConfig.DryRunEnabled = true
+		inventoryConfig.ForcedMacAddress = "02:00:00:a1:b2:c3"
+		inventoryConfig.ForcedHostIPv4 = "10.1.2.3/16"
+		inventory := &Inventory{Inventory: models.Inventory{
+			Hostname: "fake-host-1",
+			Interfaces: []*models.Interface{
+				{Name: "lo", IPV6Addresses: []string{"::1/128"}},
+				{Name: "eth0", Type: "physical", MacAddress: "71:a0:a4:6f:be:c8", IPV4Addresses: []string{"192.168.0.1/24"}},
+				{Name: "eth1", Type: "physical", MacAddress: "71:a0:a4:6f:be:c9", IPV4Addresses: []string{"192.168.1.1/24"}},
+			},
+		}}
+		completeInventory(inventoryConfig, inventory)
+
+		b, err := json.Marshal(inventory)
+		Expect(err).NotTo(HaveOccurred())
+		var sent models.Inventory
+		Expect(json.Unmarshal(b, &sent)).To(Succeed())
+		Expect(sent.Interfaces).To(HaveLen(1))
+		Expect(sent.Interfaces[0].Name).To(Equal("eth0"))
+		Expect(sent.Interfaces[0].MacAddress).To(Equal("02:00:00:a1:b2:c3"))
+		Expect(sent.Interfaces[0].IPV4Addresses).To(Equal([]string{"10.1.2.3/16"}))
+	})
+})
@@ -0,0 +1,388 @@
+package inventory
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Switches send LLDP frames every 30 seconds by default, so a short window misses most of them.
+	// The neighbors are cached between runs instead of listening for a whole interval every time.
+	defaultLLDPListenDuration = 5 * time.Second
+	lldpNeighborMaxAge        = 10 * time.Minute
+	lldpEtherType             = 0x88cc
+	vlanEtherType             = 0x8100
+	ethernetHeaderLength      = 14
+	pcapHeaderLength          = 24
+	pcapRecordHeaderLength    = 16
+
+	lldpTLVEnd                  = 0
+	lldpTLVChassisID            = 1
+	lldpTLVPortID               = 2
+	lldpTLVPortDescription      = 4
+	lldpTLVSystemName           = 5
+	lldpTLVSystemDescription    = 6
+	lldpTLVManagementAddress    = 8
+	lldpTLVOrganizationSpecific = 127
+
+	lldpIEEE8021PortVLANID = 1
+	lldpIEEE8021VLANName   = 3
+
+	ianaAddressFamilyIPv4 = 1
+	ianaAddressFamilyIPv6 = 2
+)
+
+var (
+	lldpIEEE8021OUI = [3]byte{0x00, 0x80, 0xc2}
+
+	// Names of the chassis ID subtypes, the others are free form strings
+	lldpChassisIDSubtypes = map[byte]string{
+		1: "chassis_component",
+		2: "interface_alias",
+		3: "port_component",
+		4: "mac_address",
+		5: "network_address",
+		6: "interface_name",
+		7: "local",
+	}
+	lldpPortIDSubtypes = map[byte]string{
+		1: "interface_alias",
+		2: "port_component",
+		3: "mac_address",
+		4: "network_address",
+		5: "interface_name",
+		6: "agent_circuit_id",
+		7: "local",
+	}
+)
+
+// LLDPNeighbor is the switch port an interface is cabled to, as announced by the switch with LLDP.
+type LLDPNeighbor struct {
+	ChassisID           string      `json:"chassis_id"`
+	ChassisIDType       string      `json:"chassis_id_type,omitempty"`
+	PortID              string      `json:"port_id"`
+	PortIDType          string      `json:"port_id_type,omitempty"`
+	PortDescription     string      `json:"port_description,omitempty"`
+	SystemName          string      `json:"system_name,omitempty"`
+	SystemDescription   string      `json:"system_description,omitempty"`
+	ManagementAddresses []string    `json:"management_addresses,omitempty"`
+	PortVLANID          int         `json:"port_vlan_id,omitempty"`
+	VLANs               []*LLDPVLAN `json:"vlans,omitempty"`
+}
+
+// LLDPVLAN is a VLAN configured on the switch port.
+type LLDPVLAN struct {
+	ID   int    `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// formatLLDPID formats chassis and port IDs: MAC addresses and network addresses are binary, the
+// other subtypes are strings.
+func formatLLDPID(subtype string, value []byte) string {
+	switch subtype {
+	case "mac_address":
+		return net.HardwareAddr(value).String()
+	case "network_address":
+		if address := formatIANAAddress(value); address != "" {
+			return address
+		}
+	}
+	return string(value)
+}
+
+// formatIANAAddress formats an address prefixed by its IANA address family.
+func formatIANAAddress(value []byte) string {
+	if len(value) < 1 {
+		return ""
+	}
+	switch {
+	case value[0] == ianaAddressFamilyIPv4 && len(value) == 1+net.IPv4len,
+		value[0] == ianaAddressFamilyIPv6 && len(value) == 1+net.IPv6len:
+		return net.IP(value[1:]).String()
+	}
+	return ""
+}
+
+// parseLLDPDU parses the TLVs of an LLDP data unit.
+func parseLLDPDU(data []byte) (*LLDPNeighbor, error) {
+	ret := &LLDPNeighbor{}
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("truncated TLV header")
+		}
+		header := binary.BigEndian.Uint16(data)
+		tlvType := header >> 9
+		length := int(header & 0x1ff)
+		if len(data) < 2+length {
+			return nil, fmt.Errorf("TLV of type %d is truncated", tlvType)
+		}
+		value := data[2 : 2+length]
+		data = data[2+length:]
+
+		switch tlvType {
+		case lldpTLVEnd:
+			data = nil
+		case lldpTLVChassisID, lldpTLVPortID:
+			if length < 2 {
+				return nil, fmt.Errorf("TLV of type %d is too short", tlvType)
+			}
+			subtypes := lldpChassisIDSubtypes
+			if tlvType == lldpTLVPortID {
+				subtypes = lldpPortIDSubtypes
+			}
+			subtype := subtypes[value[0]]
+			if subtype == "" {
+				subtype = strconv.Itoa(int(value[0]))
+			}
+			if tlvType == lldpTLVChassisID {
+				ret.ChassisIDType, ret.ChassisID = subtype, formatLLDPID(subtype, value[1:])
+			} else {
+				ret.PortIDType, ret.PortID = subtype, formatLLDPID(subtype, value[1:])
+			}
+		case lldpTLVPortDescription:
+			ret.PortDescription = string(value)
+		case lldpTLVSystemName:
+			ret.SystemName = string(value)
+		case lldpTLVSystemDescription:
+			ret.SystemDescription = string(value)
+		case lldpTLVManagementAddress:
+			// The address string length includes the address family
+			if length < 1 || int(value[0]) > length-1 {
+				continue
+			}
+			if address := formatIANAAddress(value[1 : 1+int(value[0])]); address != "" {
+				ret.ManagementAddresses = append(ret.ManagementAddresses, address)
+			}
+		case lldpTLVOrganizationSpecific:
+			if length < 4 || [3]byte(value[:3]) != lldpIEEE8021OUI {
+				continue
+			}
+			ret.parseIEEE8021(value[3], value[4:])
+		}
+	}
+	if ret.ChassisID == "" || ret.PortID == "" {
+		return nil, fmt.Errorf("LLDP data unit without chassis ID or port ID")
+	}
+	return ret, nil
+}
+
+// parseIEEE8021 parses the 802.1 organizationally specific TLVs, which carry the VLANs of the port.
+func (n *LLDPNeighbor) parseIEEE8021(subtype byte, value []byte) {
+	switch subtype {
+	case lldpIEEE8021PortVLANID:
+		if len(value) >= 2 {
+			n.PortVLANID = int(binary.BigEndian.Uint16(value))
+		}
+	case lldpIEEE8021VLANName:
+		if len(value) < 3 || len(value) < 3+int(value[2]) {
+			return
+		}
+		n.VLANs = append(n.VLANs, &LLDPVLAN{
+			ID:   int(binary.BigEndian.Uint16(value)),
+			Name: string(value[3 : 3+int(value[2])]),
+		})
+	}
+}
+
+// parseLLDPFrame parses an Ethernet frame carrying an LLDP data unit.
+func parseLLDPFrame(frame []byte) (*LLDPNeighbor, error) {
+	if len(frame) < ethernetHeaderLength {
+		return nil, fmt.Errorf("truncated Ethernet frame")
+	}
+	etherType := binary.BigEndian.Uint16(frame[12:])
+	payload := frame[ethernetHeaderLength:]
+	if etherType == vlanEtherType && len(payload) >= 4 {
+		etherType = binary.BigEndian.Uint16(payload[2:])
+		payload = payload[4:]
+	}
+	if etherType != lldpEtherType {
+		return nil, fmt.Errorf("frame of type 0x%04x isn't an LLDP frame", etherType)
+	}
+	return parseLLDPDU(payload)
+}
+
+// parsePcapFrames returns the frames of a capture in the pcap format.
+func parsePcapFrames(capture []byte) ([][]byte, error) {
+	if len(capture) < pcapHeaderLength {
+		return nil, fmt.Errorf("truncated pcap header")
+	}
+	var byteOrder binary.ByteOrder
+	switch binary.LittleEndian.Uint32(capture) {
+	// Microsecond and nanosecond timestamps
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		byteOrder = binary.LittleEndian
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		byteOrder = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a pcap capture")
+	}
+	var ret [][]byte
+	data := capture[pcapHeaderLength:]
+	for len(data) >= pcapRecordHeaderLength {
+		length := int(byteOrder.Uint32(data[8:]))
+		data = data[pcapRecordHeaderLength:]
+		if len(data) < length {
+			return ret, fmt.Errorf("truncated pcap record")
+		}
+		ret = append(ret, data[:length])
+		data = data[length:]
+	}
+	return ret, nil
+}
+
+// lldpCacheEntry is a neighbor received by a previous run and when it was received.
+type lldpCacheEntry struct {
+	Neighbor *LLDPNeighbor `json:"neighbor"`
+	SeenAt   time.Time     `json:"seen_at"`
+}
+
+type lldp struct {
+	dependencies    util.IDependencies
+	inventoryConfig *config.InventoryConfig
+	now             func() time.Time
+}
+
+func newLLDP(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) *lldp {
+	return &lldp{dependencies: dependencies, inventoryConfig: inventoryConfig, now: time.Now}
+}
+
+// getLLDPListenDuration returns how long to wait for LLDP frames on each interface.
+func getLLDPListenDuration(inventoryConfig *config.InventoryConfig) time.Duration {
+	if inventoryConfig.LLDPListenDuration <= 0 {
+		return defaultLLDPListenDuration
+	}
+	return inventoryConfig.LLDPListenDuration
+}
+
+// getLLDPCollectorTimeout leaves time to start the captures in addition to the listening window.
+func getLLDPCollectorTimeout(inventoryConfig *config.InventoryConfig) time.Duration {
+	return getLLDPListenDuration(inventoryConfig) + 10*time.Second
+}
+
+// readCache returns the neighbors received by the previous runs, keyed by interface name.
+func (l *lldp) readCache() map[string]*lldpCacheEntry {
+	ret := map[string]*lldpCacheEntry{}
+	path := l.inventoryConfig.LLDPCacheFile
+	if path == "" {
+		return ret
+	}
+	content, err := os.ReadFile(path)
+	if err != nil || len(content) == 0 {
+		return ret
+	}
+	if err = json.Unmarshal(content, &ret); err != nil {
+		logrus.WithError(err).Warnf("Ignoring invalid LLDP neighbors cache %s", path)
+		return map[string]*lldpCacheEntry{}
+	}
+	return ret
+}
+
+func (l *lldp) writeCache(cache map[string]*lldpCacheEntry) {
+	path := l.inventoryConfig.LLDPCacheFile
+	if path == "" {
+		return
+	}
+	content, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+	if err = os.WriteFile(path, content, 0600); err != nil {
+		logrus.WithError(err).Warnf("Failed to write LLDP neighbors cache %s", path)
+	}
+}
+
+// listen waits for an LLDP frame on the interface, without sending anything.
+func (l *lldp) listen(name string) (*LLDPNeighbor, error) {
+	seconds := int(math.Ceil(getLLDPListenDuration(l.inventoryConfig).Seconds()))
+	stdout, stderr, exitCode := l.dependencies.Execute("timeout", strconv.Itoa(seconds),
+		"tcpdump", "-i", name, "-c", "1", "-U", "-w", "-", "-s", "1518", "ether", "proto", "0x88cc")
+	if exitCode != 0 && exitCode != util.TimeoutExitCode {
+		return nil, fmt.Errorf("tcpdump failed with exit code %d: %s", exitCode, stderr)
+	}
+	frames, err := parsePcapFrames([]byte(stdout))
+	if err != nil && len(frames) == 0 {
+		return nil, err
+	}
+	for _, frame := range frames {
+		neighbor, err := parseLLDPFrame(frame)
+		if err != nil {
+			logrus.WithError(err).Debugf("Ignoring frame received on %s", name)
+			continue
+		}
+		return neighbor, nil
+	}
+	return nil, nil
+}
+
+func (l *lldp) getNeighbors() map[string]*LLDPNeighbor {
+	if l.inventoryConfig.DryRunEnabled {
+		return nil
+	}
+	ins, err := l.dependencies.Interfaces()
+	if err != nil {
+		logrus.WithError(err).Warn("Retrieving interfaces")
+		return nil
+	}
+	interfaces := newInterfaces(l.dependencies)
+	cache := l.readCache()
+	updatedCache := map[string]*lldpCacheEntry{}
+	now := l.now()
+	ret := map[string]*LLDPNeighbor{}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, in := range ins {
+		name := in.Name()
+		if !in.IsPhysical() || !interfaces.hasCarrier(name) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			neighbor, err := l.listen(name)
+			if err != nil {
+				logrus.WithError(err).Warnf("Failed to listen for LLDP on %s", name)
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			entry := cache[name]
+			if neighbor != nil {
+				entry = &lldpCacheEntry{Neighbor: neighbor, SeenAt: now}
+			}
+			if entry == nil || entry.Neighbor == nil || now.Sub(entry.SeenAt) > lldpNeighborMaxAge {
+				return
+			}
+			updatedCache[name] = entry
+			ret[name] = entry.Neighbor
+		}()
+	}
+	wg.Wait()
+	l.writeCache(updatedCache)
+	return ret
+}
+
+// GetLLDPNeighbors listens for LLDP on the physical interfaces that have a carrier, and returns
+// the neighbors that announced themselves keyed by interface name. Neighbors that didn't announce
+// themselves during the listening window are taken from the cache when they were recently seen.
+func GetLLDPNeighbors(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) map[string]*LLDPNeighbor {
+	return newLLDP(inventoryConfig, dependencies).getNeighbors()
+}
+
+// applyLLDPNeighbors attaches the LLDP neighbors to the interfaces.
+func applyLLDPNeighbors(inventory *Inventory) {
+	for _, in := range inventory.Interfaces {
+		if neighbor, ok := inventory.lldpNeighbors[in.Name]; ok {
+			in.LLDP = neighbor
+		}
+	}
+}
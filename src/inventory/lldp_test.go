@@ -0,0 +1,259 @@
+package inventory
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/openshift/assisted-service/models"
+)
+
+const (
+	// Frame sent by an Arista switch, with 802.1 VLAN TLVs and an LLDP-MED TLV that isn't decoded
+	aristaLLDPFrame = "0180c200000e444ca812345788cc020704444ca8123456040b0545746865726e" +
+		"6574313206020078081075706c696e6b20746f207261636b20370a0b6c656166" +
+		"2d30372e6463310c23417269737461204e6574776f726b7320454f5320766572" +
+		"73696f6e20342e32382e334d100c05010a00000702000f424000fe060080c201" +
+		"0064fe0b0080c20300640470726f64fe0e0080c20300c80773746f72616765fe" +
+		"0900120f01036c0000100000"
+	// VLAN tagged frame with an IPv6 chassis ID and a MAC address port ID
+	taggedLLDPFrame = "0180c200000e0011223344558100006488cc02120502" +
+		"20010db8000000000000000000000001040703001122334455060200780000"
+)
+
+func decodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	Expect(err).NotTo(HaveOccurred())
+	return b
+}
+
+// pcapCapture returns the frames in the pcap format written by tcpdump -w.
+func pcapCapture(frames ...[]byte) string {
+	ret := binary.LittleEndian.AppendUint32(nil, 0xa1b2c3d4)
+	ret = binary.LittleEndian.AppendUint16(ret, 2)
+	ret = binary.LittleEndian.AppendUint16(ret, 4)
+	ret = append(ret, make([]byte, 8)...)
+	ret = binary.LittleEndian.AppendUint32(ret, 1518)
+	ret = binary.LittleEndian.AppendUint32(ret, 1)
+	for _, frame := range frames {
+		ret = binary.LittleEndian.AppendUint32(ret, 1700000000)
+		ret = binary.LittleEndian.AppendUint32(ret, 0)
+		ret = binary.LittleEndian.AppendUint32(ret, uint32(len(frame)))
+		ret = binary.LittleEndian.AppendUint32(ret, uint32(len(frame)))
+		ret = append(ret, frame...)
+	}
+	return string(ret)
+}
+
+var _ = Describe("LLDP", func() {
+	aristaNeighbor := &LLDPNeighbor{
+		ChassisID:           "44:4c:a8:12:34:56",
+		ChassisIDType:       "mac_address",
+		PortID:              "Ethernet12",
+		PortIDType:          "interface_name",
+		PortDescription:     "uplink to rack 7",
+		SystemName:          "leaf-07.dc1",
+		SystemDescription:   "Arista Networks EOS version 4.28.3M",
+		ManagementAddresses: []string{"10.0.0.7"},
+		PortVLANID:          100,
+		VLANs:               []*LLDPVLAN{{ID: 100, Name: "prod"}, {ID: 200, Name: "storage"}},
+	}
+
+	Context("parseLLDPFrame", func() {
+		It("decodes the TLVs of a captured frame", func() {
+			Expect(parseLLDPFrame(decodeHex(aristaLLDPFrame))).To(Equal(aristaNeighbor))
+		})
+
+		It("decodes VLAN tagged frames and binary IDs", func() {
+			Expect(parseLLDPFrame(decodeHex(taggedLLDPFrame))).To(Equal(&LLDPNeighbor{
+				ChassisID:     "2001:db8::1",
+				ChassisIDType: "network_address",
+				PortID:        "00:11:22:33:44:55",
+				PortIDType:    "mac_address",
+			}))
+		})
+
+		DescribeTable("rejects invalid frames",
+			func(frame string) {
+				_, err := parseLLDPFrame(decodeHex(frame))
+				Expect(err).To(HaveOccurred())
+			},
+			Entry("truncated Ethernet header", "0180c200000e4444"),
+			Entry("not LLDP", "ffffffffffff444ca81234570806000102030405"),
+			Entry("truncated TLV", aristaLLDPFrame[:60]),
+			Entry("no port ID", "0180c200000e444ca812345788cc020704444ca81234560000"),
+		)
+	})
+
+	Context("parsePcapFrames", func() {
+		It("returns the frames of the capture", func() {
+			frames, err := parsePcapFrames([]byte(pcapCapture([]byte{1, 2, 3}, []byte{4})))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(frames).To(Equal([][]byte{{1, 2, 3}, {4}}))
+		})
+
+		It("fails on something else than a capture", func() {
+			_, err := parsePcapFrames([]byte("tcpdump: listening on eth0, link-type EN10MB (Ethernet)"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("GetLLDPNeighbors", func() {
+		var (
+			dependencies    *util.MockIDependencies
+			inventoryConfig *config.InventoryConfig
+		)
+
+		BeforeEach(func() {
+			dependencies = newDependenciesMock()
+			inventoryConfig = &config.InventoryConfig{}
+		})
+
+		AfterEach(func() {
+			dependencies.AssertExpectations(GinkgoT())
+		})
+
+		mockInterface := func(name string, physical bool) util.Interface {
+			in := &util.MockInterface{}
+			in.On("Name").Return(name)
+			in.On("IsPhysical").Return(physical)
+			return in
+		}
+
+		mockTcpdumpFor := func(seconds, name, stdout string, exitCode int) {
+			dependencies.On("Execute", "timeout", seconds, "tcpdump", "-i", name, "-c", "1", "-U", "-w", "-", "-s", "1518",
+				"ether", "proto", "0x88cc").Return(stdout, "", exitCode).Once()
+		}
+
+		mockTcpdump := func(name, stdout string, exitCode int) {
+			mockTcpdumpFor("5", name, stdout, exitCode)
+		}
+
+		mockCarrierInterfaces := func() {
+			dependencies.On("Interfaces").Return([]util.Interface{
+				mockInterface("eth0", true),
+				mockInterface("eth1", true),
+				mockInterface("eth2", true),
+			}, nil).Once()
+			mockSysfs(dependencies, map[string]string{
+				"/sys/class/net/eth0/carrier": "1\n",
+				"/sys/class/net/eth1/carrier": "1\n",
+				"/sys/class/net/eth2/carrier": "1\n",
+			})
+		}
+
+		It("listens on the physical interfaces with a carrier", func() {
+			dependencies.On("Interfaces").Return([]util.Interface{
+				mockInterface("eth0", true),
+				mockInterface("eth1", true),
+				mockInterface("eth2", true),
+				mockInterface("eth3", true),
+				mockInterface("bond0", false),
+			}, nil).Once()
+			mockSysfs(dependencies, map[string]string{
+				"/sys/class/net/eth0/carrier":  "1\n",
+				"/sys/class/net/eth1/carrier":  "1\n",
+				"/sys/class/net/eth2/carrier":  "1\n",
+				"/sys/class/net/eth3/carrier":  "0\n",
+				"/sys/class/net/bond0/carrier": "1\n",
+			})
+			mockTcpdump("eth0", pcapCapture(decodeHex(aristaLLDPFrame)), 0)
+			// No LLDP frame during the listening window
+			mockTcpdump("eth1", pcapCapture(), util.TimeoutExitCode)
+			mockTcpdump("eth2", "", 1)
+
+			Expect(GetLLDPNeighbors(inventoryConfig, dependencies)).To(Equal(map[string]*LLDPNeighbor{
+				"eth0": aristaNeighbor,
+			}))
+		})
+
+		It("listens for the configured duration", func() {
+			inventoryConfig.LLDPListenDuration = 35 * time.Second
+			dependencies.On("Interfaces").Return([]util.Interface{mockInterface("eth0", true)}, nil).Once()
+			mockSysfs(dependencies, map[string]string{"/sys/class/net/eth0/carrier": "1\n"})
+			mockTcpdumpFor("35", "eth0", pcapCapture(decodeHex(aristaLLDPFrame)), 0)
+
+			Expect(GetLLDPNeighbors(inventoryConfig, dependencies)).To(HaveKey("eth0"))
+		})
+
+		Context("with a cache", func() {
+			var (
+				cacheFile string
+				now       time.Time
+			)
+
+			BeforeEach(func() {
+				f, err := os.CreateTemp("", "lldp-neighbors-*.json")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+				cacheFile = f.Name()
+				inventoryConfig.LLDPCacheFile = cacheFile
+				now = time.Date(2024, 3, 14, 10, 0, 0, 0, time.UTC)
+			})
+
+			AfterEach(func() {
+				os.Remove(cacheFile)
+			})
+
+			getNeighbors := func() map[string]*LLDPNeighbor {
+				l := newLLDP(inventoryConfig, dependencies)
+				l.now = func() time.Time { return now }
+				return l.getNeighbors()
+			}
+
+			It("reports the neighbors seen recently by the previous runs", func() {
+				mockCarrierInterfaces()
+				mockTcpdump("eth0", pcapCapture(decodeHex(aristaLLDPFrame)), 0)
+				mockTcpdump("eth1", pcapCapture(), util.TimeoutExitCode)
+				mockTcpdump("eth2", pcapCapture(), util.TimeoutExitCode)
+				Expect(getNeighbors()).To(Equal(map[string]*LLDPNeighbor{"eth0": aristaNeighbor}))
+
+				now = now.Add(5 * time.Minute)
+				mockCarrierInterfaces()
+				mockTcpdump("eth0", pcapCapture(), util.TimeoutExitCode)
+				mockTcpdump("eth1", pcapCapture(decodeHex(aristaLLDPFrame)), 0)
+				mockTcpdump("eth2", pcapCapture(), util.TimeoutExitCode)
+				Expect(getNeighbors()).To(Equal(map[string]*LLDPNeighbor{"eth0": aristaNeighbor, "eth1": aristaNeighbor}))
+			})
+
+			It("forgets the neighbors that weren't seen for a while", func() {
+				mockCarrierInterfaces()
+				mockTcpdump("eth0", pcapCapture(decodeHex(aristaLLDPFrame)), 0)
+				mockTcpdump("eth1", pcapCapture(), util.TimeoutExitCode)
+				mockTcpdump("eth2", pcapCapture(), util.TimeoutExitCode)
+				Expect(getNeighbors()).To(HaveKey("eth0"))
+
+				now = now.Add(lldpNeighborMaxAge + time.Minute)
+				mockCarrierInterfaces()
+				mockTcpdump("eth0", pcapCapture(), util.TimeoutExitCode)
+				mockTcpdump("eth1", pcapCapture(), util.TimeoutExitCode)
+				mockTcpdump("eth2", pcapCapture(), util.TimeoutExitCode)
+				Expect(getNeighbors()).To(BeEmpty())
+			})
+		})
+
+		It("doesn't listen in dry run", func() {
+			inventoryConfig.DryRunEnabled = true
+			Expect(GetLLDPNeighbors(inventoryConfig, dependencies)).To(BeNil())
+		})
+	})
+
+	It("attaches the neighbors to the interfaces", func() {
+		inventory := &Inventory{
+			Interfaces: []*Interface{
+				{Interface: &models.Interface{Name: "eth0"}},
+				{Interface: &models.Interface{Name: "eth1"}},
+			},
+			lldpNeighbors: map[string]*LLDPNeighbor{"eth0": aristaNeighbor},
+		}
+		applyLLDPNeighbors(inventory)
+		Expect(inventory.Interfaces[0].LLDP).To(Equal(aristaNeighbor))
+		Expect(inventory.Interfaces[1].LLDP).To(BeNil())
+	})
+})
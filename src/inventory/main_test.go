@@ -1,12 +1,18 @@
 package inventory
 
 import (
+	"io/fs"
+	"os"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/openshift/assisted-installer-agent/src/util"
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/mock"
 )
 
 func newDependenciesMock() *util.MockIDependencies {
@@ -19,6 +25,47 @@ func mockGetGhwChrootRoot(dependencies *util.MockIDependencies) {
 	dependencies.On("GetGhwChrootRoot").Return("/host").Maybe()
 }
 
+// mockFileInfos returns file infos with the given names, as returned by ReadDir.
+func mockFileInfos(names ...string) []os.FileInfo {
+	var ret []os.FileInfo
+	for _, name := range names {
+		fileInfo := &MockFileInfo{}
+		fileInfo.On("Name").Return(name).Maybe()
+		ret = append(ret, fileInfo)
+	}
+	return ret
+}
+
+// mockSysfs makes ReadFile and ReadDir serve the given files, keyed by path. The directories are
+// the ones the paths are in.
+func mockSysfs(dependencies *util.MockIDependencies, files map[string]string) {
+	dependencies.On("ReadFile", mock.Anything).Return(func(fname string) ([]byte, error) {
+		content, ok := files[fname]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		return []byte(content), nil
+	}).Maybe()
+	dependencies.On("ReadDir", mock.Anything).Return(func(dirname string) ([]os.FileInfo, error) {
+		var names []string
+		for path := range files {
+			rest, ok := strings.CutPrefix(path, dirname+"/")
+			if !ok {
+				continue
+			}
+			name, _, _ := strings.Cut(rest, "/")
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return nil, fs.ErrNotExist
+		}
+		sort.Strings(names)
+		return mockFileInfos(names...), nil
+	}).Maybe()
+}
+
 func TestInventory(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Inventory unit tests")
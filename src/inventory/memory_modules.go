@@ -0,0 +1,313 @@
+package inventory
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/openshift/assisted-service/models"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	dmidecodeSizeRegex  = regexp.MustCompile(`^([0-9]+)\s+([a-zA-Z]+)$`)
+	dmidecodeWidthRegex = regexp.MustCompile(`^([0-9]+) bits$`)
+	edacControllerRegex = regexp.MustCompile(`^mc\d+$`)
+	edacDIMMRegex       = regexp.MustCompile(`^(dimm|rank)\d+$`)
+)
+
+// Memory is the memory of the inventory with the details that the model doesn't have.
+type Memory struct {
+	*models.Memory
+	Modules []*MemoryModule `json:"modules,omitempty"`
+	// ErrorCorrection is the error correction of the memory arrays reported by SMBIOS, like
+	// Single-bit ECC or None
+	ErrorCorrection string `json:"error_correction,omitempty"`
+	// ECC is true when the memory controller corrects errors, not only when the modules could
+	ECC bool `json:"ecc"`
+	// EDAC are the error counters of the memory controllers
+	EDAC []*MemoryController `json:"edac,omitempty"`
+	// Warnings are memory issues, like failing or unbalanced modules, that don't prevent the
+	// installation
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// MemoryModule is a memory device reported by SMBIOS, populated or not.
+type MemoryModule struct {
+	Locator      string `json:"locator"`
+	BankLocator  string `json:"bank_locator,omitempty"`
+	SizeBytes    int64  `json:"size_bytes"`
+	Type         string `json:"type,omitempty"`
+	Speed        string `json:"speed,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	PartNumber   string `json:"part_number,omitempty"`
+	SerialNumber string `json:"serial_number,omitempty"`
+	// ECCCapable is true when the module is wider than its data, the extra bits can hold an error
+	// correction code. Whether it is used depends on the memory controller.
+	ECCCapable bool `json:"ecc_capable"`
+	// Error counters of the module, when EDAC reports them by module
+	CorrectedErrors   *int64 `json:"corrected_errors,omitempty"`
+	UncorrectedErrors *int64 `json:"uncorrected_errors,omitempty"`
+}
+
+type MemoryController struct {
+	Name              string      `json:"name"`
+	CorrectedErrors   int64       `json:"corrected_errors"`
+	UncorrectedErrors int64       `json:"uncorrected_errors"`
+	DIMMs             []*EDACDIMM `json:"dimms,omitempty"`
+}
+
+// EDACDIMM is a module as seen by EDAC. Its label is set by the EDAC driver, and matches the SMBIOS
+// locator on most servers.
+type EDACDIMM struct {
+	Name              string `json:"name"`
+	Label             string `json:"label,omitempty"`
+	CorrectedErrors   int64  `json:"corrected_errors"`
+	UncorrectedErrors int64  `json:"uncorrected_errors"`
+}
+
+// MemoryDetails are the modules, error correction and error counters of the memory.
+type MemoryDetails struct {
+	Modules         []*MemoryModule
+	ErrorCorrection string
+	EDAC            []*MemoryController
+}
+
+// eccErrorCorrections are the SMBIOS error correction types that correct errors, parity only
+// detects them.
+var eccErrorCorrections = []string{"Single-bit ECC", "Multi-bit ECC", "CRC"}
+
+// dmidecodeSections returns the key values of the dmidecode sections that have the given title.
+func dmidecodeSections(output string, title string) []map[string]string {
+	var ret []map[string]string
+	for _, block := range strings.Split(output, "\n\n") {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		if len(lines) < 2 || strings.TrimSpace(lines[1]) != title {
+			continue
+		}
+		section := map[string]string{}
+		for _, line := range lines[2:] {
+			key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+			if found {
+				section[key] = strings.TrimSpace(value)
+			}
+		}
+		ret = append(ret, section)
+	}
+	return ret
+}
+
+// dmidecodeValue returns the value unless it is one of the placeholders that dmidecode and vendors
+// use for missing values.
+func dmidecodeValue(value string) string {
+	switch strings.ToLower(value) {
+	case "unknown", "not specified", "none", "no dimm", "not provided", "0000", "00000000":
+		return ""
+	}
+	return value
+}
+
+func parseDmidecodeWidth(value string) int64 {
+	matches := dmidecodeWidthRegex.FindStringSubmatch(value)
+	if matches == nil {
+		return 0
+	}
+	ret, _ := strconv.ParseInt(matches[1], 10, 64)
+	return ret
+}
+
+// parseMemoryModules parses the memory devices of dmidecode -t 17.
+func parseMemoryModules(output string) []*MemoryModule {
+	var ret []*MemoryModule
+	for _, section := range dmidecodeSections(output, "Memory Device") {
+		module := &MemoryModule{
+			Locator:      section["Locator"],
+			BankLocator:  dmidecodeValue(section["Bank Locator"]),
+			Type:         dmidecodeValue(section["Type"]),
+			Manufacturer: dmidecodeValue(section["Manufacturer"]),
+			PartNumber:   dmidecodeValue(section["Part Number"]),
+			SerialNumber: dmidecodeValue(section["Serial Number"]),
+		}
+		if matches := dmidecodeSizeRegex.FindStringSubmatch(section["Size"]); matches != nil {
+			value, _ := strconv.ParseInt(matches[1], 10, 64)
+			module.SizeBytes = value * multiplierMap[strings.ToLower(matches[2])]
+		}
+		// The configured speed is the speed the module runs at, it is lower than its rated speed
+		// when the memory controller can't run faster
+		for _, key := range []string{"Configured Memory Speed", "Configured Clock Speed", "Speed"} {
+			if speed := dmidecodeValue(section[key]); speed != "" {
+				module.Speed = speed
+				break
+			}
+		}
+		totalWidth := parseDmidecodeWidth(section["Total Width"])
+		dataWidth := parseDmidecodeWidth(section["Data Width"])
+		module.ECCCapable = module.SizeBytes > 0 && dataWidth > 0 && totalWidth > dataWidth
+		ret = append(ret, module)
+	}
+	return ret
+}
+
+// parseErrorCorrection returns the error correction type of the system memory arrays of
+// dmidecode -t 16. Servers have an array per socket, all configured the same way.
+func parseErrorCorrection(output string) string {
+	for _, section := range dmidecodeSections(output, "Physical Memory Array") {
+		if section["Use"] != "System Memory" {
+			continue
+		}
+		if value := section["Error Correction Type"]; value != "" && value != "Unknown" {
+			return value
+		}
+	}
+	return ""
+}
+
+type memoryModules struct {
+	sysfsReader
+}
+
+func newMemoryModules(dependencies util.IDependencies) *memoryModules {
+	return &memoryModules{sysfsReader: sysfsReader{dependencies: dependencies}}
+}
+
+// readCount returns the error count of the attribute, 0 when the driver doesn't report it.
+func (m *memoryModules) readCount(fname string) int64 {
+	ret, _ := m.readInt(fname)
+	return ret
+}
+
+func (m *memoryModules) getEDAC() []*MemoryController {
+	const edacDir = "/sys/devices/system/edac/mc"
+	files, err := m.dependencies.ReadDir(edacDir)
+	if err != nil {
+		logrus.WithError(err).Debug("No EDAC memory controller")
+		return nil
+	}
+	var ret []*MemoryController
+	for _, file := range files {
+		if !edacControllerRegex.MatchString(file.Name()) {
+			continue
+		}
+		dir := fmt.Sprintf("%s/%s", edacDir, file.Name())
+		controller := &MemoryController{
+			Name:              file.Name(),
+			CorrectedErrors:   m.readCount(dir + "/ce_count"),
+			UncorrectedErrors: m.readCount(dir + "/ue_count"),
+		}
+		// Drivers report modules as dimmN, or as rankN when they only know the ranks
+		dimms, err := m.dependencies.ReadDir(dir)
+		if err != nil {
+			logrus.WithError(err).Debugf("Listing the modules of %s", file.Name())
+		}
+		for _, dimm := range dimms {
+			if !edacDIMMRegex.MatchString(dimm.Name()) {
+				continue
+			}
+			dimmDir := fmt.Sprintf("%s/%s", dir, dimm.Name())
+			controller.DIMMs = append(controller.DIMMs, &EDACDIMM{
+				Name:              dimm.Name(),
+				Label:             m.readString(dimmDir + "/dimm_label"),
+				CorrectedErrors:   m.readCount(dimmDir + "/dimm_ce_count"),
+				UncorrectedErrors: m.readCount(dimmDir + "/dimm_ue_count"),
+			})
+		}
+		ret = append(ret, controller)
+	}
+	return ret
+}
+
+func (m *memoryModules) getDetails() *MemoryDetails {
+	ret := &MemoryDetails{EDAC: m.getEDAC()}
+	o, e, exitCode := m.dependencies.Execute("dmidecode", "-t", "16,17")
+	if exitCode != 0 {
+		logrus.Warnf("Could not run dmidecode: %s", e)
+	} else {
+		ret.Modules = parseMemoryModules(o)
+		ret.ErrorCorrection = parseErrorCorrection(o)
+	}
+	return ret
+}
+
+// GetMemoryDetails returns the memory modules and error correction reported by SMBIOS and the EDAC
+// error counters.
+func GetMemoryDetails(dependencies util.IDependencies) *MemoryDetails {
+	return newMemoryModules(dependencies).getDetails()
+}
+
+// memoryWarnings returns the issues of the memory: modules with errors, modules that don't all
+// have the same size, type and speed, and ECC modules without error correction.
+func (m *Memory) memoryWarnings() []string {
+	var ret []string
+	var corrected, uncorrected int64
+	for _, controller := range m.EDAC {
+		corrected += controller.CorrectedErrors
+		uncorrected += controller.UncorrectedErrors
+	}
+	if uncorrected > 0 {
+		ret = append(ret, fmt.Sprintf("Memory has %d uncorrected errors", uncorrected))
+	}
+	if corrected > 0 {
+		ret = append(ret, fmt.Sprintf("Memory has %d corrected errors", corrected))
+	}
+	for _, module := range m.Modules {
+		if module.UncorrectedErrors != nil && *module.UncorrectedErrors > 0 {
+			ret = append(ret, fmt.Sprintf("Memory module %s has %d uncorrected errors", module.Locator, *module.UncorrectedErrors))
+		}
+	}
+
+	sizes := map[string]bool{}
+	var populated, eccCapable int
+	for _, module := range m.Modules {
+		if module.SizeBytes > 0 {
+			populated++
+			if module.ECCCapable {
+				eccCapable++
+			}
+			sizes[fmt.Sprintf("%d GiB %s %s", module.SizeBytes/GbMultiplier, module.Type, module.Speed)] = true
+		}
+	}
+	if len(sizes) > 1 {
+		kinds := make([]string, 0, len(sizes))
+		for kind := range sizes {
+			kinds = append(kinds, strings.TrimSpace(kind))
+		}
+		sort.Strings(kinds)
+		ret = append(ret, fmt.Sprintf("Memory modules are mixed: %s", strings.Join(kinds, ", ")))
+	}
+	if m.ErrorCorrection == "None" && populated > 0 && eccCapable == populated {
+		ret = append(ret, "Memory modules support ECC but error correction is disabled")
+	}
+	return ret
+}
+
+// applyMemoryDetails attaches the modules and error counters to the memory. EDAC modules are
+// matched to the SMBIOS modules by their label.
+func applyMemoryDetails(inventory *Inventory) {
+	if inventory.Inventory.Memory == nil {
+		return
+	}
+	inventory.Memory = &Memory{Memory: inventory.Inventory.Memory}
+	details := inventory.memoryDetails
+	if details == nil {
+		return
+	}
+	inventory.Memory.Modules = details.Modules
+	inventory.Memory.ErrorCorrection = details.ErrorCorrection
+	inventory.Memory.ECC = slices.Contains(eccErrorCorrections, details.ErrorCorrection)
+	inventory.Memory.EDAC = details.EDAC
+	for _, controller := range details.EDAC {
+		for _, dimm := range controller.DIMMs {
+			for _, module := range details.Modules {
+				if dimm.Label != "" && dimm.Label == module.Locator {
+					module.CorrectedErrors = &dimm.CorrectedErrors
+					module.UncorrectedErrors = &dimm.UncorrectedErrors
+				}
+			}
+		}
+	}
+	inventory.Memory.Warnings = inventory.Memory.memoryWarnings()
+}
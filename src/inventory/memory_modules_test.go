@@ -0,0 +1,223 @@
+package inventory
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/openshift/assisted-service/models"
+)
+
+const dmidecodeServerModules = `# dmidecode 3.3
+Getting SMBIOS data from sysfs.
+SMBIOS 3.2 present.
+
+Handle 0x1000, DMI type 16, 23 bytes
+Physical Memory Array
+	Location: System Board Or Motherboard
+	Use: System Memory
+	Error Correction Type: Multi-bit ECC
+	Maximum Capacity: 3 TB
+	Error Information Handle: Not Provided
+	Number Of Devices: 12
+
+Handle 0x1100, DMI type 17, 84 bytes
+Memory Device
+	Array Handle: 0x1000
+	Error Information Handle: Not Provided
+	Total Width: 72 bits
+	Data Width: 64 bits
+	Size: 32 GB
+	Form Factor: DIMM
+	Set: 1
+	Locator: A1
+	Bank Locator: Not Specified
+	Type: DDR4
+	Type Detail: Synchronous Registered (Buffered)
+	Speed: 3200 MT/s
+	Manufacturer: 00AD063200AD
+	Serial Number: 4A1B2C3D
+	Asset Tag: 01211163
+	Part Number: HMA84GR7CJR4N-XN
+	Rank: 2
+	Configured Memory Speed: 2933 MT/s
+	Minimum Voltage: 1.2 V
+	Maximum Voltage: 1.2 V
+	Configured Voltage: 1.2 V
+
+Handle 0x1101, DMI type 17, 84 bytes
+Memory Device
+	Array Handle: 0x1000
+	Error Information Handle: Not Provided
+	Total Width: 72 bits
+	Data Width: 64 bits
+	Size: 32 GB
+	Form Factor: DIMM
+	Set: 1
+	Locator: A2
+	Bank Locator: Not Specified
+	Type: DDR4
+	Type Detail: Synchronous Registered (Buffered)
+	Speed: 3200 MT/s
+	Manufacturer: 00AD063200AD
+	Serial Number: 4A1B2C3E
+	Part Number: HMA84GR7CJR4N-XN
+	Rank: 2
+	Configured Memory Speed: 2933 MT/s
+
+Handle 0x1102, DMI type 17, 84 bytes
+Memory Device
+	Array Handle: 0x1000
+	Error Information Handle: Not Provided
+	Total Width: Unknown
+	Data Width: Unknown
+	Size: No Module Installed
+	Form Factor: Unknown
+	Set: 2
+	Locator: A3
+	Bank Locator: Not Specified
+	Type: Unknown
+	Type Detail: Unknown
+	Speed: Unknown
+	Manufacturer: Not Specified
+	Serial Number: Not Specified
+	Part Number: Not Specified
+	Rank: Unknown
+	Configured Memory Speed: Unknown
+`
+
+var _ = Describe("Memory details", func() {
+	var dependencies *util.MockIDependencies
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+	})
+
+	AfterEach(func() {
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	It("reports the modules and the EDAC counters", func() {
+		dependencies.On("Execute", "dmidecode", "-t", "16,17").Return(dmidecodeServerModules, "", 0).Once()
+		mockSysfs(dependencies, map[string]string{
+			"/sys/devices/system/edac/mc/power/control":           "auto",
+			"/sys/devices/system/edac/mc/mc0/ce_count":            "5\n",
+			"/sys/devices/system/edac/mc/mc0/ue_count":            "0\n",
+			"/sys/devices/system/edac/mc/mc0/mc_name":             "Skylake Socket#0 IMC#0",
+			"/sys/devices/system/edac/mc/mc0/dimm0/dimm_label":    "A1\n",
+			"/sys/devices/system/edac/mc/mc0/dimm0/dimm_ce_count": "5\n",
+			"/sys/devices/system/edac/mc/mc0/dimm0/dimm_ue_count": "0\n",
+			"/sys/devices/system/edac/mc/mc0/dimm1/dimm_label":    "A2\n",
+			"/sys/devices/system/edac/mc/mc0/dimm1/dimm_ce_count": "0\n",
+			"/sys/devices/system/edac/mc/mc0/dimm1/dimm_ue_count": "0\n",
+			"/sys/devices/system/edac/mc/mc1/ce_count":            "0\n",
+			"/sys/devices/system/edac/mc/mc1/ue_count":            "0\n",
+			"/sys/devices/system/edac/mc/mc1/rank0/dimm_label":    "mc#1csrow#0channel#0\n",
+			"/sys/devices/system/edac/mc/mc1/rank0/dimm_ce_count": "0\n",
+			"/sys/devices/system/edac/mc/mc1/rank0/dimm_ue_count": "0\n",
+			"/sys/devices/system/edac/mc/mc1/max_location":        "csrow 1 channel 1",
+		})
+
+		Expect(GetMemoryDetails(dependencies)).To(Equal(&MemoryDetails{
+			ErrorCorrection: "Multi-bit ECC",
+			Modules: []*MemoryModule{
+				{Locator: "A1", SizeBytes: 32 * GbMultiplier, Type: "DDR4", Speed: "2933 MT/s", Manufacturer: "00AD063200AD",
+					PartNumber: "HMA84GR7CJR4N-XN", SerialNumber: "4A1B2C3D", ECCCapable: true},
+				{Locator: "A2", SizeBytes: 32 * GbMultiplier, Type: "DDR4", Speed: "2933 MT/s", Manufacturer: "00AD063200AD",
+					PartNumber: "HMA84GR7CJR4N-XN", SerialNumber: "4A1B2C3E", ECCCapable: true},
+				{Locator: "A3"},
+			},
+			EDAC: []*MemoryController{
+				{Name: "mc0", CorrectedErrors: 5, DIMMs: []*EDACDIMM{
+					{Name: "dimm0", Label: "A1", CorrectedErrors: 5},
+					{Name: "dimm1", Label: "A2"},
+				}},
+				{Name: "mc1", DIMMs: []*EDACDIMM{{Name: "rank0", Label: "mc#1csrow#0channel#0"}}},
+			},
+		}))
+	})
+
+	It("parses modules without ECC", func() {
+		modules := parseMemoryModules(dmidecodeOutputMB)
+		Expect(modules).To(HaveLen(2))
+		Expect(modules[1]).To(Equal(&MemoryModule{Locator: "ChannelB-DIMM0", BankLocator: "BANK 2", SizeBytes: 16 * GbMultiplier, Type: "DDR4"}))
+	})
+
+	It("reports the EDAC counters when dmidecode fails", func() {
+		dependencies.On("Execute", "dmidecode", "-t", "16,17").Return("", "/dev/mem: No such file or directory", 1).Once()
+		mockSysfs(dependencies, map[string]string{"/sys/devices/system/edac/mc/mc0/ue_count": "1"})
+		Expect(GetMemoryDetails(dependencies)).To(Equal(&MemoryDetails{EDAC: []*MemoryController{{Name: "mc0", UncorrectedErrors: 1}}}))
+	})
+
+	Context("applyMemoryDetails", func() {
+		module := func(locator string, size int64, speed string) *MemoryModule {
+			return &MemoryModule{Locator: locator, SizeBytes: size * GbMultiplier, Type: "DDR4", Speed: speed}
+		}
+
+		It("matches the EDAC modules and warns about errors and mixed modules", func() {
+			inventory := &Inventory{
+				Inventory: models.Inventory{Memory: &models.Memory{PhysicalBytes: 80 * GbMultiplier}},
+				memoryDetails: &MemoryDetails{
+					Modules: []*MemoryModule{
+						module("A1", 32, "3200 MT/s"), module("A2", 32, "3200 MT/s"), module("A3", 16, "2933 MT/s"), {Locator: "A4"},
+					},
+					EDAC: []*MemoryController{{Name: "mc0", CorrectedErrors: 12, UncorrectedErrors: 2, DIMMs: []*EDACDIMM{
+						{Name: "dimm0", Label: "A1", CorrectedErrors: 12, UncorrectedErrors: 2},
+					}}},
+				},
+			}
+			applyMemoryDetails(inventory)
+
+			Expect(inventory.Memory.PhysicalBytes).To(Equal(80 * GbMultiplier))
+			Expect(inventory.Memory.Modules).To(HaveLen(4))
+			Expect(*inventory.Memory.Modules[0].CorrectedErrors).To(BeEquivalentTo(12))
+			Expect(inventory.Memory.Modules[1].CorrectedErrors).To(BeNil())
+			Expect(inventory.Memory.Warnings).To(Equal([]string{
+				"Memory has 2 uncorrected errors",
+				"Memory has 12 corrected errors",
+				"Memory module A1 has 2 uncorrected errors",
+				"Memory modules are mixed: 16 GiB DDR4 2933 MT/s, 32 GiB DDR4 3200 MT/s",
+			}))
+		})
+
+		It("reports whether error correction is active", func() {
+			inventory := &Inventory{
+				Inventory:     models.Inventory{Memory: &models.Memory{}},
+				memoryDetails: &MemoryDetails{ErrorCorrection: "Single-bit ECC"},
+			}
+			applyMemoryDetails(inventory)
+			Expect(inventory.Memory.ErrorCorrection).To(Equal("Single-bit ECC"))
+			Expect(inventory.Memory.ECC).To(BeTrue())
+		})
+
+		It("warns about ECC modules without error correction", func() {
+			eccModule := module("A1", 32, "3200 MT/s")
+			eccModule.ECCCapable = true
+			inventory := &Inventory{
+				Inventory:     models.Inventory{Memory: &models.Memory{}},
+				memoryDetails: &MemoryDetails{ErrorCorrection: "None", Modules: []*MemoryModule{eccModule}},
+			}
+			applyMemoryDetails(inventory)
+			Expect(inventory.Memory.ECC).To(BeFalse())
+			Expect(inventory.Memory.Warnings).To(Equal([]string{"Memory modules support ECC but error correction is disabled"}))
+		})
+
+		It("doesn't warn about healthy balanced memory", func() {
+			inventory := &Inventory{
+				Inventory: models.Inventory{Memory: &models.Memory{}},
+				memoryDetails: &MemoryDetails{
+					Modules: []*MemoryModule{module("A1", 32, "3200 MT/s"), module("B1", 32, "3200 MT/s")},
+					EDAC:    []*MemoryController{{Name: "mc0"}},
+				},
+			}
+			applyMemoryDetails(inventory)
+			Expect(inventory.Memory.Warnings).To(BeEmpty())
+		})
+
+		It("wraps the memory without details", func() {
+			inventory := &Inventory{Inventory: models.Inventory{Memory: &models.Memory{UsableBytes: 1}}}
+			applyMemoryDetails(inventory)
+			Expect(inventory.Memory.UsableBytes).To(BeEquivalentTo(1))
+			Expect(inventory.Memory.Modules).To(BeNil())
+		})
+	})
+})
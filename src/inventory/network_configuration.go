@@ -0,0 +1,437 @@
+package inventory
+
+import (
+	"bufio"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/sirupsen/logrus"
+)
+
+// hostRootPath is where the network configuration files of the host are mounted in the inventory
+// container, like the other host files
+const hostRootPath = "/host"
+
+const (
+	dnsSourceSystemdResolved = "systemd-resolved"
+	dnsSourceNetworkManager  = "NetworkManager"
+
+	networkManagerLeasesDir = "/var/lib/NetworkManager"
+)
+
+var (
+	resolvectlLinkRegex      = regexp.MustCompile(`^Link \d+ \(([^)]+)\):\s*(.*)$`)
+	nmcliKeyIndexRegex       = regexp.MustCompile(`\[\d+\]$`)
+	networkManagerLeaseRegex = regexp.MustCompile(`^internal-[0-9a-f-]+-(.+)\.lease$`)
+	iniSectionRegex          = regexp.MustCompile(`^\[(.+)\]$`)
+)
+
+// NetworkConfiguration is how the host resolves names and keeps time.
+type NetworkConfiguration struct {
+	DNS *DNSConfiguration `json:"dns,omitempty"`
+	// Hosts are the entries of /etc/hosts, without the loopback ones that all hosts have
+	Hosts      []*HostsEntry     `json:"hosts,omitempty"`
+	NTP        *NTPConfiguration `json:"ntp,omitempty"`
+	DHCPLeases []*DHCPLease      `json:"dhcp_leases,omitempty"`
+}
+
+type DNSConfiguration struct {
+	// Nameservers, Searches and Options are the ones of /etc/resolv.conf
+	Nameservers []string `json:"nameservers,omitempty"`
+	Searches    []string `json:"searches,omitempty"`
+	Options     []string `json:"options,omitempty"`
+	// NetworkManagerMode and NetworkManagerRCManager are the dns and rc-manager settings of
+	// NetworkManager, that decide who writes /etc/resolv.conf
+	NetworkManagerMode      string     `json:"network_manager_mode,omitempty"`
+	NetworkManagerRCManager string     `json:"network_manager_rc_manager,omitempty"`
+	Links                   []*DNSLink `json:"links,omitempty"`
+}
+
+// DNSLink is the DNS configuration of an interface, as known by systemd-resolved or NetworkManager.
+type DNSLink struct {
+	Interface   string   `json:"interface"`
+	Source      string   `json:"source"`
+	Nameservers []string `json:"nameservers,omitempty"`
+	Domains     []string `json:"domains,omitempty"`
+}
+
+type HostsEntry struct {
+	IP    string   `json:"ip"`
+	Names []string `json:"names"`
+}
+
+type NTPConfiguration struct {
+	Sources []*NTPSource `json:"sources,omitempty"`
+}
+
+// NTPSource is a time source of chrony, from its configuration file or from the files of its
+// sourcedir directories, where the DHCP NTP servers are written.
+type NTPSource struct {
+	// Type is server, pool or peer
+	Type    string   `json:"type"`
+	Address string   `json:"address"`
+	Options []string `json:"options,omitempty"`
+	File    string   `json:"file"`
+}
+
+// DHCPLease is the active DHCPv4 lease of an interface managed by NetworkManager.
+type DHCPLease struct {
+	Interface    string   `json:"interface"`
+	Address      string   `json:"address,omitempty"`
+	Server       string   `json:"server,omitempty"`
+	Routers      []string `json:"routers,omitempty"`
+	Nameservers  []string `json:"nameservers,omitempty"`
+	NTPServers   []string `json:"ntp_servers,omitempty"`
+	DomainName   string   `json:"domain_name,omitempty"`
+	DomainSearch []string `json:"domain_search,omitempty"`
+	Hostname     string   `json:"hostname,omitempty"`
+	LeaseSeconds int64    `json:"lease_seconds,omitempty"`
+}
+
+type networkConfiguration struct {
+	dependencies util.IDependencies
+}
+
+func newNetworkConfiguration(dependencies util.IDependencies) *networkConfiguration {
+	return &networkConfiguration{dependencies: dependencies}
+}
+
+// readHostFile returns the lines of a file of the host, without comments and empty lines.
+func (n *networkConfiguration) readHostFile(path string) ([]string, error) {
+	b, err := n.dependencies.ReadFile(hostRootPath + path)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	scanner := bufio.NewScanner(strings.NewReader(string(b)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		ret = append(ret, line)
+	}
+	return ret, nil
+}
+
+func (n *networkConfiguration) readResolvConf(ret *DNSConfiguration) {
+	lines, err := n.readHostFile("/etc/resolv.conf")
+	if err != nil {
+		logrus.WithError(err).Debug("Reading resolv.conf")
+		return
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "nameserver":
+			ret.Nameservers = append(ret.Nameservers, fields[1:]...)
+		case "search", "domain":
+			// The last search or domain line wins
+			ret.Searches = fields[1:]
+		case "options":
+			ret.Options = append(ret.Options, fields[1:]...)
+		}
+	}
+}
+
+// readNetworkManagerSettings reads the dns and rc-manager settings of the main section of the
+// NetworkManager configuration, the files of conf.d override the main file in alphabetical order.
+func (n *networkConfiguration) readNetworkManagerSettings(ret *DNSConfiguration) {
+	paths := []string{"/etc/NetworkManager/NetworkManager.conf"}
+	for _, dir := range []string{"/usr/lib/NetworkManager/conf.d", "/run/NetworkManager/conf.d", "/etc/NetworkManager/conf.d"} {
+		files, err := n.dependencies.ReadDir(hostRootPath + dir)
+		if err != nil {
+			continue
+		}
+		var names []string
+		for _, file := range files {
+			if strings.HasSuffix(file.Name(), ".conf") {
+				names = append(names, file.Name())
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			paths = append(paths, dir+"/"+name)
+		}
+	}
+	for _, path := range paths {
+		lines, err := n.readHostFile(path)
+		if err != nil {
+			continue
+		}
+		section := ""
+		for _, line := range lines {
+			if matches := iniSectionRegex.FindStringSubmatch(line); matches != nil {
+				section = matches[1]
+				continue
+			}
+			key, value, found := strings.Cut(line, "=")
+			if !found || section != "main" {
+				continue
+			}
+			switch strings.TrimSpace(key) {
+			case "dns":
+				ret.NetworkManagerMode = strings.TrimSpace(value)
+			case "rc-manager":
+				ret.NetworkManagerRCManager = strings.TrimSpace(value)
+			}
+		}
+	}
+}
+
+// parseResolvectl parses the output of resolvectl dns or resolvectl domain, a line per link.
+func parseResolvectl(output string) map[string][]string {
+	ret := map[string][]string{}
+	for _, line := range strings.Split(output, "\n") {
+		if matches := resolvectlLinkRegex.FindStringSubmatch(strings.TrimSpace(line)); matches != nil {
+			if values := strings.Fields(matches[2]); len(values) > 0 {
+				ret[matches[1]] = values
+			}
+		}
+	}
+	return ret
+}
+
+func (n *networkConfiguration) getResolvedLinks() []*DNSLink {
+	dns, e, exitCode := n.dependencies.ExecutePrivileged("resolvectl", "dns")
+	if exitCode != 0 {
+		logrus.Debugf("systemd-resolved isn't available: %s", e)
+		return nil
+	}
+	domains, _, _ := n.dependencies.ExecutePrivileged("resolvectl", "domain")
+	nameservers := parseResolvectl(dns)
+	searches := parseResolvectl(domains)
+	var names []string
+	for name := range nameservers {
+		names = append(names, name)
+	}
+	for name := range searches {
+		if _, ok := nameservers[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var ret []*DNSLink
+	for _, name := range names {
+		ret = append(ret, &DNSLink{
+			Interface:   name,
+			Source:      dnsSourceSystemdResolved,
+			Nameservers: nameservers[name],
+			Domains:     searches[name],
+		})
+	}
+	return ret
+}
+
+// parseNmcliDevices parses the terse output of nmcli device show, a block per device with
+// colon separated keys and values, where the colons of the values are escaped.
+func parseNmcliDevices(output string) []*DNSLink {
+	var ret []*DNSLink
+	var link *DNSLink
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if !found {
+			continue
+		}
+		value = strings.ReplaceAll(strings.ReplaceAll(value, `\:`, ":"), `\\`, `\`)
+		switch nmcliKeyIndexRegex.ReplaceAllString(key, "") {
+		case "GENERAL.DEVICE":
+			link = &DNSLink{Interface: value, Source: dnsSourceNetworkManager}
+			ret = append(ret, link)
+		case "IP4.DNS", "IP6.DNS":
+			if link != nil && value != "" {
+				link.Nameservers = append(link.Nameservers, value)
+			}
+		case "IP4.DOMAIN", "IP6.DOMAIN":
+			if link != nil && value != "" && !slices.Contains(link.Domains, value) {
+				link.Domains = append(link.Domains, value)
+			}
+		}
+	}
+	return slices.DeleteFunc(ret, func(link *DNSLink) bool {
+		return link.Nameservers == nil && link.Domains == nil
+	})
+}
+
+func (n *networkConfiguration) getNetworkManagerLinks() []*DNSLink {
+	o, e, exitCode := n.dependencies.ExecutePrivileged("nmcli", "-t", "-f",
+		"GENERAL.DEVICE,IP4.DNS,IP4.DOMAIN,IP6.DNS,IP6.DOMAIN", "device", "show")
+	if exitCode != 0 {
+		logrus.Debugf("NetworkManager isn't available: %s", e)
+		return nil
+	}
+	return parseNmcliDevices(o)
+}
+
+func (n *networkConfiguration) getDNS() *DNSConfiguration {
+	ret := &DNSConfiguration{}
+	n.readResolvConf(ret)
+	n.readNetworkManagerSettings(ret)
+	ret.Links = append(n.getResolvedLinks(), n.getNetworkManagerLinks()...)
+	return ret
+}
+
+// isLoopbackHostsEntry returns true for the localhost entries that all hosts have.
+func isLoopbackHostsEntry(entry *HostsEntry) bool {
+	if entry.IP != "127.0.0.1" && entry.IP != "::1" {
+		return false
+	}
+	for _, name := range entry.Names {
+		if !isForbiddenHostname(name) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *networkConfiguration) getHosts() []*HostsEntry {
+	lines, err := n.readHostFile("/etc/hosts")
+	if err != nil {
+		logrus.WithError(err).Debug("Reading hosts")
+		return nil
+	}
+	var ret []*HostsEntry
+	for _, line := range lines {
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		entry := &HostsEntry{IP: fields[0], Names: fields[1:]}
+		if !isLoopbackHostsEntry(entry) {
+			ret = append(ret, entry)
+		}
+	}
+	return ret
+}
+
+// parseChronySources returns the sources of chrony configuration lines, and the directories of
+// their sourcedir directives.
+func parseChronySources(lines []string, file string) ([]*NTPSource, []string) {
+	var sources []*NTPSource
+	var dirs []string
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "server", "pool", "peer":
+			if len(fields) < 2 {
+				continue
+			}
+			source := &NTPSource{Type: fields[0], Address: fields[1], File: file}
+			if len(fields) > 2 {
+				source.Options = fields[2:]
+			}
+			sources = append(sources, source)
+		case "sourcedir":
+			dirs = append(dirs, fields[1:]...)
+		}
+	}
+	return sources, dirs
+}
+
+func (n *networkConfiguration) getNTP() *NTPConfiguration {
+	const chronyConf = "/etc/chrony.conf"
+	lines, err := n.readHostFile(chronyConf)
+	if err != nil {
+		logrus.WithError(err).Debug("Reading chrony configuration")
+		return nil
+	}
+	ret := &NTPConfiguration{}
+	sources, dirs := parseChronySources(lines, chronyConf)
+	ret.Sources = sources
+	for _, dir := range dirs {
+		files, err := n.dependencies.ReadDir(hostRootPath + dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if !strings.HasSuffix(file.Name(), ".sources") {
+				continue
+			}
+			path := dir + "/" + file.Name()
+			lines, err := n.readHostFile(path)
+			if err != nil {
+				continue
+			}
+			sources, _ := parseChronySources(lines, path)
+			ret.Sources = append(ret.Sources, sources...)
+		}
+	}
+	return ret
+}
+
+// parseNetworkManagerLease parses a lease of the internal DHCP client of NetworkManager, KEY=value
+// lines with space separated lists.
+func parseNetworkManagerLease(content string, iface string) *DHCPLease {
+	ret := &DHCPLease{Interface: iface}
+	for _, line := range strings.Split(content, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if !found || strings.HasPrefix(key, "#") {
+			continue
+		}
+		switch key {
+		case "ADDRESS":
+			ret.Address = value
+		case "SERVER_ADDRESS":
+			ret.Server = value
+		case "ROUTER":
+			ret.Routers = strings.Fields(value)
+		case "DNS":
+			ret.Nameservers = strings.Fields(value)
+		case "NTP":
+			ret.NTPServers = strings.Fields(value)
+		case "DOMAINNAME":
+			ret.DomainName = value
+		case "DOMAIN_SEARCH_LIST":
+			ret.DomainSearch = strings.Fields(value)
+		case "HOSTNAME":
+			ret.Hostname = value
+		case "LIFETIME":
+			ret.LeaseSeconds, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return ret
+}
+
+func (n *networkConfiguration) getDHCPLeases() []*DHCPLease {
+	files, err := n.dependencies.ReadDir(hostRootPath + networkManagerLeasesDir)
+	if err != nil {
+		logrus.WithError(err).Debug("Listing DHCP leases")
+		return nil
+	}
+	var ret []*DHCPLease
+	for _, file := range files {
+		matches := networkManagerLeaseRegex.FindStringSubmatch(file.Name())
+		if matches == nil {
+			continue
+		}
+		b, err := n.dependencies.ReadFile(filepath.Join(hostRootPath, networkManagerLeasesDir, file.Name()))
+		if err != nil {
+			logrus.WithError(err).Debugf("Reading DHCP lease of %s", matches[1])
+			continue
+		}
+		ret = append(ret, parseNetworkManagerLease(string(b), matches[1]))
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Interface < ret[j].Interface })
+	return ret
+}
+
+func (n *networkConfiguration) getNetworkConfiguration() *NetworkConfiguration {
+	return &NetworkConfiguration{
+		DNS:        n.getDNS(),
+		Hosts:      n.getHosts(),
+		NTP:        n.getNTP(),
+		DHCPLeases: n.getDHCPLeases(),
+	}
+}
+
+// GetNetworkConfiguration returns the DNS and NTP configuration of the host, and the options of
+// its DHCP leases.
+func GetNetworkConfiguration(dependencies util.IDependencies) *NetworkConfiguration {
+	return newNetworkConfiguration(dependencies).getNetworkConfiguration()
+}
@@ -0,0 +1,135 @@
+package inventory
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/util"
+)
+
+const (
+	resolvConf = `# Generated by NetworkManager
+search example.com lab.example.com
+nameserver 192.168.122.1
+nameserver fd00::1
+options edns0 trust-ad
+`
+
+	etcHosts = `127.0.0.1   localhost localhost.localdomain localhost4 localhost4.localdomain4
+::1         localhost localhost.localdomain localhost6 localhost6.localdomain6
+192.168.122.100 api.ostest.example.com api-int.ostest.example.com # Added for the installation
+`
+
+	chronyConf = `# Use public servers from the pool.ntp.org project.
+pool 2.rhel.pool.ntp.org iburst
+server clock.example.com iburst prefer
+sourcedir /run/chrony-dhcp
+driftfile /var/lib/chrony/drift
+`
+
+	nmcliDeviceShow = `GENERAL.DEVICE:ens3
+IP4.DNS[1]:192.168.122.1
+IP4.DOMAIN[1]:example.com
+IP6.DNS[1]:fd00\:\:1
+
+GENERAL.DEVICE:lo
+
+GENERAL.DEVICE:ens4
+IP4.DOMAIN[1]:~.
+`
+
+	networkManagerLease = `# This is private data. Do not parse.
+ADDRESS=192.168.122.10
+NETMASK=255.255.255.0
+ROUTER=192.168.122.1
+SERVER_ADDRESS=192.168.122.1
+T1=1800
+T2=3150
+LIFETIME=3600
+DNS=192.168.122.1 192.168.122.2
+NTP=192.168.122.1
+DOMAINNAME=example.com
+DOMAIN_SEARCH_LIST=example.com lab.example.com
+HOSTNAME=master-0
+CLIENTID=ff00
+`
+)
+
+var _ = Describe("Network configuration", func() {
+	var dependencies *util.MockIDependencies
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+	})
+
+	AfterEach(func() {
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	It("reports the DNS, hosts, NTP and DHCP configuration", func() {
+		mockSysfs(dependencies, map[string]string{
+			hostRootPath + "/etc/resolv.conf":                                   resolvConf,
+			hostRootPath + "/etc/hosts":                                         etcHosts,
+			hostRootPath + "/etc/chrony.conf":                                   chronyConf,
+			hostRootPath + "/run/chrony-dhcp/ens3.sources":                      "server 192.168.122.1 iburst\n",
+			hostRootPath + "/etc/NetworkManager/NetworkManager.conf":            "[main]\n#plugins=keyfile\ndns=default\n",
+			hostRootPath + "/etc/NetworkManager/conf.d/99-dns.conf":             "[main]\ndns=none\nrc-manager=unmanaged\n",
+			hostRootPath + "/usr/lib/NetworkManager/conf.d/00-server.conf":      "[main]\nno-auto-default=*\n[logging]\ndns=foo\n",
+			hostRootPath + "/var/lib/NetworkManager/timestamps":                 "",
+			hostRootPath + "/var/lib/NetworkManager/internal-1f4d-ens3.lease":   networkManagerLease,
+			hostRootPath + "/var/lib/NetworkManager/dhclient6-ens3.lease":       "lease6 {}",
+			hostRootPath + "/var/lib/NetworkManager/NetworkManager.state":       "[main]\n",
+			hostRootPath + "/var/lib/NetworkManager/secret_key":                 "",
+			hostRootPath + "/var/lib/NetworkManager/NetworkManager-intern.conf": "",
+		})
+		dependencies.On("ExecutePrivileged", "resolvectl", "dns").Return("", "resolvectl: command not found", 127).Once()
+		dependencies.On("ExecutePrivileged", "nmcli", "-t", "-f", "GENERAL.DEVICE,IP4.DNS,IP4.DOMAIN,IP6.DNS,IP6.DOMAIN",
+			"device", "show").Return(nmcliDeviceShow, "", 0).Once()
+
+		Expect(GetNetworkConfiguration(dependencies)).To(Equal(&NetworkConfiguration{
+			DNS: &DNSConfiguration{
+				Nameservers:             []string{"192.168.122.1", "fd00::1"},
+				Searches:                []string{"example.com", "lab.example.com"},
+				Options:                 []string{"edns0", "trust-ad"},
+				NetworkManagerMode:      "none",
+				NetworkManagerRCManager: "unmanaged",
+				Links: []*DNSLink{
+					{Interface: "ens3", Source: dnsSourceNetworkManager, Nameservers: []string{"192.168.122.1", "fd00::1"}, Domains: []string{"example.com"}},
+					{Interface: "ens4", Source: dnsSourceNetworkManager, Domains: []string{"~."}},
+				},
+			},
+			Hosts: []*HostsEntry{{IP: "192.168.122.100", Names: []string{"api.ostest.example.com", "api-int.ostest.example.com"}}},
+			NTP: &NTPConfiguration{Sources: []*NTPSource{
+				{Type: "pool", Address: "2.rhel.pool.ntp.org", Options: []string{"iburst"}, File: "/etc/chrony.conf"},
+				{Type: "server", Address: "clock.example.com", Options: []string{"iburst", "prefer"}, File: "/etc/chrony.conf"},
+				{Type: "server", Address: "192.168.122.1", Options: []string{"iburst"}, File: "/run/chrony-dhcp/ens3.sources"},
+			}},
+			DHCPLeases: []*DHCPLease{{
+				Interface:    "ens3",
+				Address:      "192.168.122.10",
+				Server:       "192.168.122.1",
+				Routers:      []string{"192.168.122.1"},
+				Nameservers:  []string{"192.168.122.1", "192.168.122.2"},
+				NTPServers:   []string{"192.168.122.1"},
+				DomainName:   "example.com",
+				DomainSearch: []string{"example.com", "lab.example.com"},
+				Hostname:     "master-0",
+				LeaseSeconds: 3600,
+			}},
+		}))
+	})
+
+	It("reports the links of systemd-resolved", func() {
+		mockSysfs(dependencies, map[string]string{})
+		dependencies.On("ExecutePrivileged", "resolvectl", "dns").Return("Global:\nLink 2 (eth0): 10.0.0.1 10.0.0.2\nLink 3 (eth1):\n", "", 0).Once()
+		dependencies.On("ExecutePrivileged", "resolvectl", "domain").Return("Global:\nLink 2 (eth0): example.com\nLink 4 (wg0): ~corp.example.com\n", "", 0).Once()
+		dependencies.On("ExecutePrivileged", "nmcli", "-t", "-f", "GENERAL.DEVICE,IP4.DNS,IP4.DOMAIN,IP6.DNS,IP6.DOMAIN",
+			"device", "show").Return("", "Error: NetworkManager is not running.", 8).Once()
+
+		Expect(GetNetworkConfiguration(dependencies)).To(Equal(&NetworkConfiguration{
+			DNS: &DNSConfiguration{Links: []*DNSLink{
+				{Interface: "eth0", Source: dnsSourceSystemdResolved, Nameservers: []string{"10.0.0.1", "10.0.0.2"}, Domains: []string{"example.com"}},
+				{Interface: "wg0", Source: dnsSourceSystemdResolved, Domains: []string{"~corp.example.com"}},
+			}},
+		}))
+	})
+})
@@ -0,0 +1,119 @@
+package inventory
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/sirupsen/logrus"
+)
+
+var pciAddressRegex = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
+// NetworkDevice is the device behind a physical network interface: its driver, its place in the
+// PCI topology and its SR-IOV capabilities.
+type NetworkDevice struct {
+	Driver          string `json:"driver,omitempty"`
+	DriverVersion   string `json:"driver_version,omitempty"`
+	FirmwareVersion string `json:"firmware_version,omitempty"`
+	PCIAddress      string `json:"pci_address,omitempty"`
+	// NUMANode is omitted on hosts with a single NUMA node, where the kernel reports -1
+	NUMANode      *int64 `json:"numa_node,omitempty"`
+	SRIOVTotalVFs int64  `json:"sriov_totalvfs,omitempty"`
+	SRIOVNumVFs   int64  `json:"sriov_numvfs,omitempty"`
+	IsVF          bool   `json:"is_vf,omitempty"`
+	// PhysicalFunction is the PCI address of the physical function of a virtual function
+	PhysicalFunction string `json:"physical_function,omitempty"`
+}
+
+type networkDevices struct {
+	sysfsReader
+}
+
+func newNetworkDevices(dependencies util.IDependencies) *networkDevices {
+	return &networkDevices{sysfsReader: sysfsReader{dependencies: dependencies}}
+}
+
+// parseEthtoolDriverInfo parses the "key: value" lines of ethtool -i.
+func parseEthtoolDriverInfo(output string) map[string]string {
+	ret := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if found {
+			ret[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return ret
+}
+
+// netDeviceAttribute returns the path of an attribute of the device of the interface in sysfs.
+func netDeviceAttribute(name, field string) string {
+	return fmt.Sprintf("/sys/class/net/%s/device/%s", name, field)
+}
+
+func (n *networkDevices) getDevice(name string) *NetworkDevice {
+	ret := &NetworkDevice{}
+	stdout, stderr, exitCode := n.dependencies.Execute("ethtool", "-i", name)
+	if exitCode == 0 {
+		info := parseEthtoolDriverInfo(stdout)
+		ret.Driver = info["driver"]
+		ret.DriverVersion = info["version"]
+		ret.FirmwareVersion = info["firmware-version"]
+		ret.PCIAddress = info["bus-info"]
+	} else {
+		logrus.Debugf("ethtool -i %s failed: %s", name, stderr)
+	}
+	// Devices that aren't PCI devices report other bus information, or none
+	if !pciAddressRegex.MatchString(ret.PCIAddress) {
+		ret.PCIAddress = ""
+	}
+
+	if numaNode, ok := n.readInt(netDeviceAttribute(name, "numa_node")); ok && numaNode >= 0 {
+		ret.NUMANode = &numaNode
+	}
+	ret.SRIOVTotalVFs, _ = n.readInt(netDeviceAttribute(name, "sriov_totalvfs"))
+	ret.SRIOVNumVFs, _ = n.readInt(netDeviceAttribute(name, "sriov_numvfs"))
+	if physfn, err := n.dependencies.EvalSymlinks(fmt.Sprintf("/sys/class/net/%s/device/physfn", name)); err == nil {
+		ret.IsVF = true
+		ret.PhysicalFunction = filepath.Base(physfn)
+	}
+	if *ret == (NetworkDevice{}) {
+		return nil
+	}
+	return ret
+}
+
+func (n *networkDevices) getDevices() map[string]*NetworkDevice {
+	ins, err := n.dependencies.Interfaces()
+	if err != nil {
+		logrus.WithError(err).Warn("Retrieving interfaces")
+		return nil
+	}
+	ret := map[string]*NetworkDevice{}
+	for _, in := range ins {
+		if !in.IsPhysical() {
+			continue
+		}
+		if device := n.getDevice(in.Name()); device != nil {
+			ret[in.Name()] = device
+		}
+	}
+	return ret
+}
+
+// GetNetworkDevices returns the devices of the physical network interfaces, keyed by interface
+// name.
+func GetNetworkDevices(dependencies util.IDependencies) map[string]*NetworkDevice {
+	return newNetworkDevices(dependencies).getDevices()
+}
+
+// applyNetworkDevices attaches the devices to the interfaces.
+func applyNetworkDevices(inventory *Inventory) {
+	for _, in := range inventory.Interfaces {
+		if device, ok := inventory.networkDevices[in.Name]; ok {
+			in.Device = device
+		}
+	}
+}
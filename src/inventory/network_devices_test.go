@@ -0,0 +1,126 @@
+package inventory
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/openshift/assisted-service/models"
+	"github.com/stretchr/testify/mock"
+)
+
+const (
+	ethtoolI40e = `driver: i40e
+version: 5.14.0-284.36.1.el9_2.x86_64
+firmware-version: 8.50 0x8000b6c5 1.3082.0
+expansion-rom-version:
+bus-info: 0000:3b:00.0
+supports-statistics: yes
+supports-test: yes
+supports-eeprom-access: yes
+supports-register-dump: yes
+supports-priv-flags: yes
+`
+	ethtoolIAVF = `driver: iavf
+version: 5.14.0-284.36.1.el9_2.x86_64
+firmware-version: N/A
+expansion-rom-version:
+bus-info: 0000:3b:02.1
+supports-statistics: yes
+`
+	ethtoolVirtio = `driver: virtio_net
+version: 1.0.0
+firmware-version:
+expansion-rom-version:
+bus-info: virtio0
+`
+)
+
+var _ = Describe("Network devices", func() {
+	var dependencies *util.MockIDependencies
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+	})
+
+	AfterEach(func() {
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	mockInterface := func(name string, physical bool) util.Interface {
+		in := &util.MockInterface{}
+		in.On("Name").Return(name)
+		in.On("IsPhysical").Return(physical)
+		return in
+	}
+
+	It("reports the driver, PCI topology and SR-IOV details", func() {
+		dependencies.On("Interfaces").Return([]util.Interface{
+			mockInterface("ens1f0", true),
+			mockInterface("ens1f0v1", true),
+			mockInterface("eth0", true),
+			mockInterface("bond0", false),
+		}, nil).Once()
+		dependencies.On("Execute", "ethtool", "-i", "ens1f0").Return(ethtoolI40e, "", 0).Once()
+		dependencies.On("Execute", "ethtool", "-i", "ens1f0v1").Return(ethtoolIAVF, "", 0).Once()
+		dependencies.On("Execute", "ethtool", "-i", "eth0").Return(ethtoolVirtio, "", 0).Once()
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/net/ens1f0/device/numa_node":      "1\n",
+			"/sys/class/net/ens1f0/device/sriov_totalvfs": "64\n",
+			"/sys/class/net/ens1f0/device/sriov_numvfs":   "8\n",
+			"/sys/class/net/ens1f0v1/device/numa_node":    "1\n",
+			"/sys/class/net/eth0/device/numa_node":        "-1\n",
+		})
+		dependencies.On("EvalSymlinks", "/sys/class/net/ens1f0v1/device/physfn").
+			Return("/sys/devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0", nil).Once()
+		dependencies.On("EvalSymlinks", mock.Anything).Return("", errors.New("no such file or directory"))
+
+		numaNode := int64(1)
+		Expect(GetNetworkDevices(dependencies)).To(Equal(map[string]*NetworkDevice{
+			"ens1f0": {
+				Driver:          "i40e",
+				DriverVersion:   "5.14.0-284.36.1.el9_2.x86_64",
+				FirmwareVersion: "8.50 0x8000b6c5 1.3082.0",
+				PCIAddress:      "0000:3b:00.0",
+				NUMANode:        &numaNode,
+				SRIOVTotalVFs:   64,
+				SRIOVNumVFs:     8,
+			},
+			"ens1f0v1": {
+				Driver:           "iavf",
+				DriverVersion:    "5.14.0-284.36.1.el9_2.x86_64",
+				FirmwareVersion:  "N/A",
+				PCIAddress:       "0000:3b:02.1",
+				NUMANode:         &numaNode,
+				IsVF:             true,
+				PhysicalFunction: "0000:3b:00.0",
+			},
+			"eth0": {
+				Driver:        "virtio_net",
+				DriverVersion: "1.0.0",
+			},
+		}))
+	})
+
+	It("skips interfaces without any device details", func() {
+		dependencies.On("Interfaces").Return([]util.Interface{mockInterface("eth0", true)}, nil).Once()
+		dependencies.On("Execute", "ethtool", "-i", "eth0").Return("", "Cannot get driver information: Operation not supported", 71).Once()
+		dependencies.On("EvalSymlinks", "/sys/class/net/eth0/device/physfn").Return("", errors.New("no such file or directory")).Once()
+		mockSysfs(dependencies, map[string]string{})
+		Expect(GetNetworkDevices(dependencies)).To(BeEmpty())
+	})
+
+	It("attaches the devices to the interfaces", func() {
+		inventory := &Inventory{
+			Interfaces: []*Interface{
+				{Interface: &models.Interface{Name: "eth0"}},
+				{Interface: &models.Interface{Name: "eth1"}},
+			},
+			networkDevices: map[string]*NetworkDevice{"eth1": {Driver: "ixgbe"}},
+		}
+		applyNetworkDevices(inventory)
+		Expect(inventory.Interfaces[0].Device).To(BeNil())
+		Expect(inventory.Interfaces[1].Device.Driver).To(Equal("ixgbe"))
+	})
+})
@@ -0,0 +1,233 @@
+package inventory
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/openshift/assisted-service/models"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	nvmeClassPath          = "/sys/class/nvme"
+	nvmeSubsystemClassPath = "/sys/class/nvme-subsystem"
+	acpiTablesPath         = "/sys/firmware/acpi/tables"
+	// The NVMe boot firmware table describes the NVMe over fabrics namespaces the firmware boots from
+	nbftTablePrefix = "NBFT"
+
+	NVMeTransportPCIe = "pcie"
+	NVMeTransportTCP  = "tcp"
+	NVMeTransportRDMA = "rdma"
+	NVMeTransportFC   = "fc"
+
+	nvmeControllerLive  = "live"
+	nvmeANAOptimized    = "optimized"
+	nvmeANANonOptimized = "non-optimized"
+)
+
+var (
+	nvmeControllerRegex = regexp.MustCompile(`^nvme\d+$`)
+	nvmeNamespaceRegex  = regexp.MustCompile(`^nvme(\d+)n(\d+)$`)
+	// Per path devices of native NVMe multipath, hidden behind the namespace head nvme<subsys>n<ns>
+	nvmePathRegex = regexp.MustCompile(`^nvme(\d+)c\d+n(\d+)$`)
+)
+
+// NVMeNamespace describes how an NVMe namespace is reached: its subsystem and every controller path
+// to it, which are more than one with native NVMe multipath.
+type NVMeNamespace struct {
+	Transport    string      `json:"transport"`
+	SubsystemNQN string      `json:"subsystem_nqn,omitempty"`
+	Paths        []*NVMePath `json:"paths"`
+	// InNBFT is set for NVMe over TCP namespaces, it tells if the boot firmware knows the subsystem
+	InNBFT *bool `json:"in_nbft,omitempty"`
+}
+
+// NVMePath is a controller through which a namespace is reached.
+type NVMePath struct {
+	Controller string `json:"controller"`
+	Transport  string `json:"transport"`
+	Address    string `json:"address,omitempty"`
+	State      string `json:"state,omitempty"`
+	// ANAState is the asymmetric namespace access state of the path, only reported with native NVMe
+	// multipath
+	ANAState string `json:"ana_state,omitempty"`
+}
+
+type nvme struct {
+	sysfsReader
+}
+
+func newNVMe(dependencies util.IDependencies) *nvme {
+	return &nvme{sysfsReader: sysfsReader{dependencies: dependencies}}
+}
+
+func (n *nvme) getPath(controller string) *NVMePath {
+	controllerPath := filepath.Join(nvmeClassPath, controller)
+	return &NVMePath{
+		Controller: controller,
+		Transport:  n.readString(filepath.Join(controllerPath, "transport")),
+		Address:    n.readString(filepath.Join(controllerPath, "address")),
+		State:      n.readString(filepath.Join(controllerPath, "state")),
+	}
+}
+
+// getNBFTContent returns the content of the NVMe boot firmware tables of the host.
+func (n *nvme) getNBFTContent() []byte {
+	files, err := n.dependencies.ReadDir(acpiTablesPath)
+	if err != nil {
+		return nil
+	}
+	var ret []byte
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), nbftTablePrefix) {
+			continue
+		}
+		b, err := n.dependencies.ReadFile(filepath.Join(acpiTablesPath, file.Name()))
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to read %s", file.Name())
+			continue
+		}
+		ret = append(ret, b...)
+	}
+	return ret
+}
+
+func (n *nvme) getNamespaces() map[string]*NVMeNamespace {
+	subsystems, err := n.dependencies.ReadDir(nvmeSubsystemClassPath)
+	if err != nil {
+		logrus.WithError(err).Debug("No NVMe subsystems")
+		return nil
+	}
+
+	ret := map[string]*NVMeNamespace{}
+	var nbft []byte
+	for _, subsystem := range subsystems {
+		subsystemPath := filepath.Join(nvmeSubsystemClassPath, subsystem.Name())
+		nqn := n.readString(filepath.Join(subsystemPath, "subsysnqn"))
+		entries, err := n.dependencies.ReadDir(subsystemPath)
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to list NVMe subsystem %s", subsystem.Name())
+			continue
+		}
+		for _, entry := range entries {
+			controller := entry.Name()
+			if !nvmeControllerRegex.MatchString(controller) {
+				continue
+			}
+			devices, err := n.dependencies.ReadDir(filepath.Join(nvmeClassPath, controller))
+			if err != nil {
+				logrus.WithError(err).Warnf("Failed to list NVMe controller %s", controller)
+				continue
+			}
+			for _, device := range devices {
+				var head string
+				var anaState string
+				if matches := nvmePathRegex.FindStringSubmatch(device.Name()); matches != nil {
+					head = fmt.Sprintf("nvme%sn%s", matches[1], matches[2])
+					anaState = n.readString(filepath.Join(nvmeClassPath, controller, device.Name(), "ana_state"))
+				} else if nvmeNamespaceRegex.MatchString(device.Name()) {
+					head = device.Name()
+				} else {
+					continue
+				}
+
+				devicePath := filepath.Join("/dev", head)
+				namespace, ok := ret[devicePath]
+				if !ok {
+					namespace = &NVMeNamespace{SubsystemNQN: nqn}
+					ret[devicePath] = namespace
+				}
+				path := n.getPath(controller)
+				path.ANAState = anaState
+				namespace.Paths = append(namespace.Paths, path)
+				if namespace.Transport == "" {
+					namespace.Transport = path.Transport
+				}
+				if namespace.Transport == NVMeTransportTCP && namespace.InNBFT == nil {
+					if nbft == nil {
+						nbft = n.getNBFTContent()
+					}
+					inNBFT := nqn != "" && bytes.Contains(nbft, []byte(nqn))
+					namespace.InNBFT = &inNBFT
+				}
+			}
+		}
+	}
+	for _, namespace := range ret {
+		slices.SortFunc(namespace.Paths, func(a, b *NVMePath) int {
+			return strings.Compare(a.Controller, b.Controller)
+		})
+	}
+	return ret
+}
+
+// GetNVMeNamespaces returns the NVMe namespaces of the host keyed by the path of their block device.
+func GetNVMeNamespaces(dependencies util.IDependencies) map[string]*NVMeNamespace {
+	return newNVMe(dependencies).getNamespaces()
+}
+
+// IsRemote returns true if the namespace is reached through a fabric instead of the PCIe bus.
+func (n *NVMeNamespace) IsRemote() bool {
+	return n.Transport != NVMeTransportPCIe && n.Transport != ""
+}
+
+// notEligibleReasons returns the reasons why the namespace can't be used as installation disk.
+func (n *NVMeNamespace) notEligibleReasons() []string {
+	var reasons []string
+	if n.IsRemote() {
+		live := slices.ContainsFunc(n.Paths, func(path *NVMePath) bool { return path.State == nvmeControllerLive })
+		if !live {
+			reasons = append(reasons, fmt.Sprintf("NVMe over %s disk has no live controller", strings.ToUpper(n.Transport)))
+		}
+	}
+	switch n.Transport {
+	case NVMeTransportTCP:
+		if n.InNBFT == nil || !*n.InNBFT {
+			reasons = append(reasons, "NVMe over TCP disk is missing from NBFT")
+		}
+	case NVMeTransportRDMA:
+		reasons = append(reasons, "NVMe over RDMA disk can't be booted from")
+	}
+
+	hasANA := slices.ContainsFunc(n.Paths, func(path *NVMePath) bool { return path.ANAState != "" })
+	accessible := slices.ContainsFunc(n.Paths, func(path *NVMePath) bool {
+		return path.ANAState == nvmeANAOptimized || path.ANAState == nvmeANANonOptimized
+	})
+	if hasANA && !accessible {
+		reasons = append(reasons, "NVMe namespace has no accessible path")
+	}
+	return reasons
+}
+
+// applyNVMeNamespaces attaches the NVMe transport and paths to the disks, and marks the remote NVMe
+// disks that can't be booted from as not eligible for installation. Per path devices of native NVMe
+// multipath are removed, they are reported as paths of their namespace.
+func applyNVMeNamespaces(inventory *Inventory) {
+	isPath := func(name string) bool {
+		matches := nvmePathRegex.FindStringSubmatch(name)
+		if matches == nil {
+			return false
+		}
+		_, hasHead := inventory.nvmeNamespaces[filepath.Join("/dev", fmt.Sprintf("nvme%sn%s", matches[1], matches[2]))]
+		return hasHead
+	}
+	inventory.Disks = slices.DeleteFunc(inventory.Disks, func(disk *Disk) bool { return isPath(disk.Name) })
+	inventory.Inventory.Disks = slices.DeleteFunc(inventory.Inventory.Disks, func(disk *models.Disk) bool { return isPath(disk.Name) })
+
+	for _, disk := range inventory.Disks {
+		namespace, ok := inventory.nvmeNamespaces[disk.Path]
+		if !ok {
+			continue
+		}
+		disk.NVMe = namespace
+		if reasons := namespace.notEligibleReasons(); len(reasons) > 0 {
+			disk.InstallationEligibility.NotEligibleReasons = append(disk.InstallationEligibility.NotEligibleReasons, reasons...)
+			disk.InstallationEligibility.Eligible = false
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package inventory
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/openshift/assisted-service/models"
+)
+
+var _ = Describe("NVMe", func() {
+	const (
+		localNQN  = "nqn.2014.08.org.nvmexpress:144d144dS4EVNF0M123456     SAMSUNG MZVLB512HBJQ-000L7"
+		remoteNQN = "nqn.2010-06.com.purestorage:flasharray.2dbf1b26e8a4c7d9"
+	)
+
+	var dependencies *util.MockIDependencies
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+	})
+
+	It("reports local and native multipath fabric namespaces", func() {
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/nvme-subsystem/nvme-subsys0/subsysnqn": localNQN + "\n",
+			"/sys/class/nvme-subsystem/nvme-subsys0/nvme0":     "",
+			"/sys/class/nvme/nvme0/transport":                  "pcie\n",
+			"/sys/class/nvme/nvme0/address":                    "0000:01:00.0\n",
+			"/sys/class/nvme/nvme0/state":                      "live\n",
+			"/sys/class/nvme/nvme0/nvme0n1/size":               "1000215216\n",
+
+			"/sys/class/nvme-subsystem/nvme-subsys1/subsysnqn": remoteNQN + "\n",
+			"/sys/class/nvme-subsystem/nvme-subsys1/model":     "Pure Storage FlashArray\n",
+			"/sys/class/nvme-subsystem/nvme-subsys1/nvme1":     "",
+			"/sys/class/nvme-subsystem/nvme-subsys1/nvme2":     "",
+			"/sys/class/nvme-subsystem/nvme-subsys1/nvme1n1":   "",
+			"/sys/class/nvme/nvme1/transport":                  "tcp\n",
+			"/sys/class/nvme/nvme1/address":                    "traddr=192.168.10.10,trsvcid=4420,src_addr=192.168.10.2\n",
+			"/sys/class/nvme/nvme1/state":                      "live\n",
+			"/sys/class/nvme/nvme1/nvme1c1n1/ana_state":        "optimized\n",
+			"/sys/class/nvme/nvme2/transport":                  "tcp\n",
+			"/sys/class/nvme/nvme2/address":                    "traddr=192.168.20.10,trsvcid=4420,src_addr=192.168.20.2\n",
+			"/sys/class/nvme/nvme2/state":                      "connecting\n",
+			"/sys/class/nvme/nvme2/nvme1c2n1/ana_state":        "non-optimized\n",
+
+			"/sys/firmware/acpi/tables/DSDT":  "dsdt",
+			"/sys/firmware/acpi/tables/NBFT1": "NBFT\x00\x00" + remoteNQN + "\x00",
+		})
+
+		inNBFT := true
+		Expect(GetNVMeNamespaces(dependencies)).To(Equal(map[string]*NVMeNamespace{
+			"/dev/nvme0n1": {
+				Transport:    NVMeTransportPCIe,
+				SubsystemNQN: localNQN,
+				Paths:        []*NVMePath{{Controller: "nvme0", Transport: "pcie", Address: "0000:01:00.0", State: "live"}},
+			},
+			"/dev/nvme1n1": {
+				Transport:    NVMeTransportTCP,
+				SubsystemNQN: remoteNQN,
+				InNBFT:       &inNBFT,
+				Paths: []*NVMePath{
+					{Controller: "nvme1", Transport: "tcp", Address: "traddr=192.168.10.10,trsvcid=4420,src_addr=192.168.10.2",
+						State: "live", ANAState: "optimized"},
+					{Controller: "nvme2", Transport: "tcp", Address: "traddr=192.168.20.10,trsvcid=4420,src_addr=192.168.20.2",
+						State: "connecting", ANAState: "non-optimized"},
+				},
+			},
+		}))
+	})
+
+	It("reports NVMe over TCP namespaces missing from NBFT", func() {
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/nvme-subsystem/nvme-subsys0/subsysnqn": remoteNQN,
+			"/sys/class/nvme-subsystem/nvme-subsys0/nvme0":     "",
+			"/sys/class/nvme/nvme0/transport":                  "tcp",
+			"/sys/class/nvme/nvme0/state":                      "live",
+			"/sys/class/nvme/nvme0/nvme0c0n1/ana_state":        "optimized",
+		})
+		namespaces := GetNVMeNamespaces(dependencies)
+		Expect(namespaces).To(HaveKey("/dev/nvme0n1"))
+		Expect(*namespaces["/dev/nvme0n1"].InNBFT).To(BeFalse())
+	})
+
+	It("returns nil without NVMe subsystems", func() {
+		mockSysfs(dependencies, map[string]string{})
+		Expect(GetNVMeNamespaces(dependencies)).To(BeNil())
+	})
+
+	live := func(transport, anaState string) *NVMePath {
+		return &NVMePath{Transport: transport, State: "live", ANAState: anaState}
+	}
+	inNBFT := true
+	notInNBFT := false
+
+	DescribeTable("notEligibleReasons",
+		func(namespace *NVMeNamespace, expected []string) {
+			Expect(namespace.notEligibleReasons()).To(Equal(expected))
+		},
+		Entry("local", &NVMeNamespace{Transport: "pcie", Paths: []*NVMePath{live("pcie", "")}}, nil),
+		Entry("TCP in NBFT", &NVMeNamespace{Transport: "tcp", InNBFT: &inNBFT, Paths: []*NVMePath{live("tcp", "optimized")}}, nil),
+		Entry("TCP not in NBFT", &NVMeNamespace{Transport: "tcp", InNBFT: &notInNBFT, Paths: []*NVMePath{live("tcp", "optimized")}},
+			[]string{"NVMe over TCP disk is missing from NBFT"}),
+		Entry("RDMA", &NVMeNamespace{Transport: "rdma", Paths: []*NVMePath{live("rdma", "optimized")}},
+			[]string{"NVMe over RDMA disk can't be booted from"}),
+		Entry("FC with a live path", &NVMeNamespace{Transport: "fc", Paths: []*NVMePath{
+			{Transport: "fc", State: "connecting", ANAState: "optimized"}, live("fc", "non-optimized")}}, nil),
+		Entry("FC without a live path", &NVMeNamespace{Transport: "fc", Paths: []*NVMePath{{Transport: "fc", State: "resetting"}}},
+			[]string{"NVMe over FC disk has no live controller"}),
+		Entry("no accessible path", &NVMeNamespace{Transport: "fc", Paths: []*NVMePath{live("fc", "inaccessible"), live("fc", "change")}},
+			[]string{"NVMe namespace has no accessible path"}),
+	)
+
+	It("attaches the namespaces to the disks and folds the per path devices", func() {
+		disks := []*models.Disk{
+			{Name: "nvme0n1", Path: "/dev/nvme0n1", InstallationEligibility: models.DiskInstallationEligibility{Eligible: true}},
+			{Name: "nvme0c0n1", Path: "/dev/nvme0c0n1"},
+			{Name: "nvme1n1", Path: "/dev/nvme1n1", InstallationEligibility: models.DiskInstallationEligibility{Eligible: true}},
+			{Name: "sda", Path: "/dev/sda", InstallationEligibility: models.DiskInstallationEligibility{Eligible: true}},
+		}
+		inventory := &Inventory{
+			Inventory: models.Inventory{Disks: disks},
+			nvmeNamespaces: map[string]*NVMeNamespace{
+				"/dev/nvme0n1": {Transport: "rdma", Paths: []*NVMePath{live("rdma", "optimized")}},
+				"/dev/nvme1n1": {Transport: "pcie", Paths: []*NVMePath{live("pcie", "")}},
+			},
+		}
+		for _, disk := range disks {
+			inventory.Disks = append(inventory.Disks, &Disk{Disk: disk})
+		}
+		applyNVMeNamespaces(inventory)
+
+		Expect(inventory.Disks).To(HaveLen(3))
+		Expect(inventory.Inventory.Disks).To(HaveLen(3))
+		Expect(inventory.Disks[0].NVMe.Transport).To(Equal("rdma"))
+		Expect(inventory.Disks[0].InstallationEligibility.Eligible).To(BeFalse())
+		Expect(inventory.Disks[0].InstallationEligibility.NotEligibleReasons).To(ConsistOf("NVMe over RDMA disk can't be booted from"))
+		Expect(inventory.Disks[1].Name).To(Equal("nvme1n1"))
+		Expect(inventory.Disks[1].InstallationEligibility.Eligible).To(BeTrue())
+		Expect(inventory.Disks[2].NVMe).To(BeNil())
+	})
+})
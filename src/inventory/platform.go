@@ -0,0 +1,331 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// metadataServiceURL is the link local address of the metadata services of the cloud providers
+	metadataServiceURL = "http://169.254.169.254"
+
+	// platformMetadataTimeout bounds all the requests sent to the metadata service, hosts that
+	// aren't in a cloud don't answer and the requests would wait for the whole client timeout
+	platformMetadataTimeout = 5 * time.Second
+
+	// azureAssetTag is the chassis asset tag of the Azure virtual machines, it tells them apart from
+	// other Hyper-V virtual machines
+	azureAssetTag = "7783-7084-3265-9085-8269-3286-77"
+)
+
+// The platforms detected from the DMI strings
+const (
+	platformAWS       = "aws"
+	platformAzure     = "azure"
+	platformGCP       = "gcp"
+	platformOCI       = "oci"
+	platformOpenStack = "openstack"
+	platformNutanix   = "nutanix"
+	platformVSphere   = "vsphere"
+	platformOVirt     = "ovirt"
+	platformBareMetal = "baremetal"
+)
+
+// platformRule detects a platform when the DMI field contains the value, ignoring case.
+type platformRule struct {
+	platform string
+	field    string
+	value    string
+}
+
+// platformRules are checked in order, the first rule that matches gives the platform.
+var platformRules = []platformRule{
+	{platform: platformAWS, field: "sys_vendor", value: "Amazon EC2"},
+	{platform: platformAWS, field: "bios_vendor", value: "Amazon EC2"},
+	// Instances of the Xen generation
+	{platform: platformAWS, field: "bios_version", value: "amazon"},
+	{platform: platformAzure, field: "chassis_asset_tag", value: azureAssetTag},
+	{platform: platformGCP, field: "sys_vendor", value: "Google"},
+	{platform: platformGCP, field: "product_name", value: "Google Compute Engine"},
+	{platform: platformOCI, field: "chassis_asset_tag", value: "OracleCloud.com"},
+	{platform: platformOpenStack, field: "product_name", value: "OpenStack"},
+	{platform: platformOpenStack, field: "sys_vendor", value: "OpenStack Foundation"},
+	{platform: platformOpenStack, field: "chassis_asset_tag", value: "OpenStack Nova"},
+	{platform: platformNutanix, field: "sys_vendor", value: "Nutanix"},
+	{platform: platformNutanix, field: "product_name", value: "AHV"},
+	{platform: platformVSphere, field: "sys_vendor", value: "VMware"},
+	{platform: platformOVirt, field: "product_family", value: "oVirt"},
+	{platform: platformOVirt, field: "product_family", value: "RHV"},
+}
+
+// Platform is the cloud or virtualization platform that runs the host.
+type Platform struct {
+	// Provider is one of aws, azure, gcp, oci, openstack, nutanix, vsphere, ovirt or baremetal,
+	// empty for virtual machines of other platforms
+	Provider string `json:"provider,omitempty"`
+	// Hypervisor is the vendor of the hypervisor reported by CPUID, for example KVM or VMware
+	Hypervisor string `json:"hypervisor,omitempty"`
+	// Virtual is true when CPUID reports a hypervisor, bare metal instances of the cloud providers
+	// have a provider but aren't virtual
+	Virtual      bool   `json:"virtual"`
+	InstanceID   string `json:"instance_id,omitempty"`
+	InstanceType string `json:"instance_type,omitempty"`
+	Region       string `json:"region,omitempty"`
+	Zone         string `json:"zone,omitempty"`
+	// MetadataService is true when the instance details come from the metadata service
+	MetadataService bool `json:"metadata_service,omitempty"`
+}
+
+type platform struct {
+	sysfsReader
+	inventoryConfig *config.InventoryConfig
+	client          *http.Client
+	metadataURL     string
+}
+
+func newPlatform(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) *platform {
+	return &platform{
+		sysfsReader:     sysfsReader{dependencies: dependencies},
+		inventoryConfig: inventoryConfig,
+		client: &http.Client{
+			Timeout: platformMetadataTimeout,
+			// The metadata service is link local, it can't be reached through the cluster proxy
+			Transport: &http.Transport{Proxy: nil},
+		},
+		metadataURL: metadataServiceURL,
+	}
+}
+
+// getProvider returns the platform matched by the DMI strings.
+func (p *platform) getProvider() string {
+	values := map[string]string{}
+	for _, rule := range platformRules {
+		value, ok := values[rule.field]
+		if !ok {
+			value = strings.ToLower(p.readString(path.Join("/sys/class/dmi/id", rule.field)))
+			values[rule.field] = value
+		}
+		if value != "" && strings.Contains(value, strings.ToLower(rule.value)) {
+			return rule.platform
+		}
+	}
+	return ""
+}
+
+// getHypervisor returns the hypervisor vendor and whether the hypervisor CPUID flag is set, as
+// reported by lscpu.
+func (p *platform) getHypervisor() (string, bool) {
+	o, e, exitCode := p.dependencies.Execute("lscpu", "-J")
+	if exitCode != 0 {
+		logrus.Warnf("Error running lscpu: %s", e)
+		return "", false
+	}
+	var l lscpu
+	if err := json.Unmarshal([]byte(o), &l); err != nil {
+		logrus.Warnf("Error unmarshaling lscpu: %s", err.Error())
+		return "", false
+	}
+	var vendor string
+	var flag bool
+	for _, f := range l.Lscpu {
+		switch strings.TrimSuffix(f.Field, ":") {
+		case "Hypervisor vendor":
+			vendor = f.Data
+		case "Flags":
+			flag = slices.Contains(strings.Fields(f.Data), "hypervisor")
+		}
+	}
+	return vendor, flag || vendor != ""
+}
+
+// getMetadata sends a request to the metadata service and returns the body of the response.
+func (p *platform) getMetadata(ctx context.Context, method, path string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, p.metadataURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s returned %s", method, path, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (p *platform) getMetadataJSON(ctx context.Context, path string, headers map[string]string, result interface{}) error {
+	b, err := p.getMetadata(ctx, http.MethodGet, path, headers)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(b, result); err != nil {
+		return fmt.Errorf("failed to decode metadata %s: %w", path, err)
+	}
+	return nil
+}
+
+// probeAWS reads the instance details with IMDSv2, falling back to IMDSv1 when the service doesn't
+// give tokens.
+func (p *platform) probeAWS(ctx context.Context, ret *Platform) error {
+	headers := map[string]string{}
+	token, err := p.getMetadata(ctx, http.MethodPut, "/latest/api/token",
+		map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+	if err == nil {
+		headers["X-aws-ec2-metadata-token"] = string(token)
+	} else {
+		logrus.WithError(err).Debug("Could not get an IMDSv2 token, will use IMDSv1")
+	}
+	fields := []struct {
+		path  string
+		value *string
+	}{
+		{path: "instance-id", value: &ret.InstanceID},
+		{path: "instance-type", value: &ret.InstanceType},
+		{path: "placement/region", value: &ret.Region},
+		{path: "placement/availability-zone", value: &ret.Zone},
+	}
+	for _, field := range fields {
+		b, err := p.getMetadata(ctx, http.MethodGet, "/latest/meta-data/"+field.path, headers)
+		if err != nil {
+			return err
+		}
+		*field.value = strings.TrimSpace(string(b))
+	}
+	return nil
+}
+
+func (p *platform) probeAzure(ctx context.Context, ret *Platform) error {
+	var compute struct {
+		VMID     string `json:"vmId"`
+		VMSize   string `json:"vmSize"`
+		Location string `json:"location"`
+		Zone     string `json:"zone"`
+	}
+	if err := p.getMetadataJSON(ctx, "/metadata/instance/compute?api-version=2021-02-01&format=json",
+		map[string]string{"Metadata": "true"}, &compute); err != nil {
+		return err
+	}
+	ret.InstanceID = compute.VMID
+	ret.InstanceType = compute.VMSize
+	ret.Region = compute.Location
+	ret.Zone = compute.Zone
+	return nil
+}
+
+// probeGCP reads the instance details, the machine type and the zone are given as resource paths
+// like projects/123/zones/us-central1-a.
+func (p *platform) probeGCP(ctx context.Context, ret *Platform) error {
+	var instance struct {
+		ID          json.Number `json:"id"`
+		MachineType string      `json:"machineType"`
+		Zone        string      `json:"zone"`
+	}
+	if err := p.getMetadataJSON(ctx, "/computeMetadata/v1/instance/?recursive=true",
+		map[string]string{"Metadata-Flavor": "Google"}, &instance); err != nil {
+		return err
+	}
+	ret.InstanceID = instance.ID.String()
+	ret.InstanceType = path.Base(instance.MachineType)
+	if instance.Zone != "" {
+		ret.Zone = path.Base(instance.Zone)
+		if i := strings.LastIndex(ret.Zone, "-"); i > 0 {
+			ret.Region = ret.Zone[:i]
+		}
+	}
+	return nil
+}
+
+func (p *platform) probeOCI(ctx context.Context, ret *Platform) error {
+	var instance struct {
+		ID                  string `json:"id"`
+		Shape               string `json:"shape"`
+		CanonicalRegionName string `json:"canonicalRegionName"`
+		AvailabilityDomain  string `json:"availabilityDomain"`
+	}
+	if err := p.getMetadataJSON(ctx, "/opc/v2/instance/",
+		map[string]string{"Authorization": "Bearer Oracle"}, &instance); err != nil {
+		return err
+	}
+	ret.InstanceID = instance.ID
+	ret.InstanceType = instance.Shape
+	ret.Region = instance.CanonicalRegionName
+	ret.Zone = instance.AvailabilityDomain
+	return nil
+}
+
+// probeOpenStack reads the instance details, the flavor is only available through the EC2
+// compatible API, which isn't always enabled.
+func (p *platform) probeOpenStack(ctx context.Context, ret *Platform) error {
+	var metadata struct {
+		UUID             string `json:"uuid"`
+		AvailabilityZone string `json:"availability_zone"`
+	}
+	if err := p.getMetadataJSON(ctx, "/openstack/latest/meta_data.json", nil, &metadata); err != nil {
+		return err
+	}
+	ret.InstanceID = metadata.UUID
+	ret.Zone = metadata.AvailabilityZone
+	if b, err := p.getMetadata(ctx, http.MethodGet, "/latest/meta-data/instance-type", nil); err == nil {
+		ret.InstanceType = strings.TrimSpace(string(b))
+	}
+	return nil
+}
+
+// probeMetadataService completes the platform with the instance details of the metadata service.
+// Only the service of the provider found in the DMI strings is probed.
+func (p *platform) probeMetadataService(ret *Platform) {
+	probes := map[string]func(context.Context, *Platform) error{
+		platformAWS:       p.probeAWS,
+		platformAzure:     p.probeAzure,
+		platformGCP:       p.probeGCP,
+		platformOCI:       p.probeOCI,
+		platformOpenStack: p.probeOpenStack,
+	}
+	probe, ok := probes[ret.Provider]
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), platformMetadataTimeout)
+	defer cancel()
+	instance := *ret
+	if err := probe(ctx, &instance); err != nil {
+		logrus.WithError(err).Warnf("Failed to probe the metadata service of %s", ret.Provider)
+		return
+	}
+	instance.MetadataService = true
+	*ret = instance
+}
+
+func (p *platform) getPlatform() *Platform {
+	ret := &Platform{Provider: p.getProvider()}
+	ret.Hypervisor, ret.Virtual = p.getHypervisor()
+	if ret.Provider == "" && !ret.Virtual {
+		ret.Provider = platformBareMetal
+	}
+	if p.inventoryConfig.PlatformMetadataProbe && !p.inventoryConfig.DryRunEnabled {
+		p.probeMetadataService(ret)
+	}
+	return ret
+}
+
+// GetPlatform returns the platform of the host, detected from the DMI strings and the hypervisor
+// CPUID flags, and, when enabled, with the instance details of the metadata service.
+func GetPlatform(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) *Platform {
+	return newPlatform(inventoryConfig, dependencies).getPlatform()
+}
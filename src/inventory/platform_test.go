@@ -0,0 +1,230 @@
+package inventory
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+)
+
+const (
+	kvmLscpuOutput = `{
+   "lscpu": [
+      {"field": "Architecture:", "data": "x86_64"},
+      {"field": "Hypervisor vendor:", "data": "KVM"},
+      {"field": "Virtualization type:", "data": "full"},
+      {"field": "Flags:", "data": "fpu vme de pse tsc msr hypervisor lahf_lm"}
+   ]
+}`
+
+	bareMetalLscpuOutput = `{
+   "lscpu": [
+      {"field": "Architecture:", "data": "x86_64"},
+      {"field": "Virtualization:", "data": "VT-x"},
+      {"field": "Flags:", "data": "fpu vme de pse tsc msr vmx lahf_lm"}
+   ]
+}`
+)
+
+var _ = Describe("Platform", func() {
+	var (
+		dependencies    *util.MockIDependencies
+		inventoryConfig *config.InventoryConfig
+		server          *httptest.Server
+		responses       map[string]string
+		requests        []*http.Request
+	)
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+		inventoryConfig = &config.InventoryConfig{InventoryOptions: config.InventoryOptions{PlatformMetadataProbe: true}}
+		requests = nil
+		responses = map[string]string{
+			"PUT /latest/api/token":                             "AQAEAOh0ken",
+			"GET /latest/meta-data/instance-id":                 "i-0123456789abcdef0",
+			"GET /latest/meta-data/instance-type":               "m6i.2xlarge",
+			"GET /latest/meta-data/placement/region":            "us-east-1",
+			"GET /latest/meta-data/placement/availability-zone": "us-east-1b",
+			"GET /metadata/instance/compute":                    `{"vmId": "02aab8a4-74ef-476e-8182-f6d2ba4166a6", "vmSize": "Standard_D8s_v5", "location": "eastus", "zone": "2"}`,
+			"GET /computeMetadata/v1/instance/":                 `{"id": 4520031799277581759, "machineType": "projects/123/machineTypes/n2-standard-8", "zone": "projects/123/zones/us-central1-a"}`,
+			"GET /openstack/latest/meta_data.json":              `{"uuid": "d8e02d56-2648-49a3-bf97-6be8f1204f38", "availability_zone": "nova"}`,
+			"GET /opc/v2/instance/":                             `{"id": "ocid1.instance.oc1", "shape": "VM.Standard.E4.Flex", "canonicalRegionName": "us-ashburn-1", "availabilityDomain": "AD-1"}`,
+		}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r)
+			response, ok := responses[r.Method+" "+r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(response))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	getPlatform := func() *Platform {
+		p := newPlatform(inventoryConfig, dependencies)
+		p.metadataURL = server.URL
+		return p.getPlatform()
+	}
+
+	It("reports AWS instances with IMDSv2", func() {
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/dmi/id/sys_vendor":   "Amazon EC2\n",
+			"/sys/class/dmi/id/product_name": "m6i.2xlarge\n",
+		})
+		dependencies.On("Execute", "lscpu", "-J").Return(kvmLscpuOutput, "", 0).Once()
+
+		Expect(getPlatform()).To(Equal(&Platform{
+			Provider:        platformAWS,
+			Hypervisor:      "KVM",
+			Virtual:         true,
+			InstanceID:      "i-0123456789abcdef0",
+			InstanceType:    "m6i.2xlarge",
+			Region:          "us-east-1",
+			Zone:            "us-east-1b",
+			MetadataService: true,
+		}))
+		Expect(requests[0].Header.Get("X-aws-ec2-metadata-token-ttl-seconds")).To(Equal("60"))
+		Expect(requests[1].Header.Get("X-aws-ec2-metadata-token")).To(Equal("AQAEAOh0ken"))
+	})
+
+	It("reports AWS bare metal instances with IMDSv1", func() {
+		delete(responses, "PUT /latest/api/token")
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/dmi/id/bios_vendor": "Amazon EC2\n",
+		})
+		dependencies.On("Execute", "lscpu", "-J").Return(bareMetalLscpuOutput, "", 0).Once()
+
+		platform := getPlatform()
+		Expect(platform.Provider).To(Equal(platformAWS))
+		Expect(platform.Virtual).To(BeFalse())
+		Expect(platform.InstanceType).To(Equal("m6i.2xlarge"))
+		Expect(requests[1].Header.Get("X-aws-ec2-metadata-token")).To(BeEmpty())
+	})
+
+	It("reports Azure virtual machines", func() {
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/dmi/id/sys_vendor":        "Microsoft Corporation\n",
+			"/sys/class/dmi/id/product_name":      "Virtual Machine\n",
+			"/sys/class/dmi/id/chassis_asset_tag": azureAssetTag + "\n",
+		})
+		dependencies.On("Execute", "lscpu", "-J").Return(`{"lscpu": [{"field": "Hypervisor vendor:", "data": "Microsoft"}]}`, "", 0).Once()
+
+		platform := getPlatform()
+		Expect(platform).To(Equal(&Platform{
+			Provider:        platformAzure,
+			Hypervisor:      "Microsoft",
+			Virtual:         true,
+			InstanceID:      "02aab8a4-74ef-476e-8182-f6d2ba4166a6",
+			InstanceType:    "Standard_D8s_v5",
+			Region:          "eastus",
+			Zone:            "2",
+			MetadataService: true,
+		}))
+		Expect(requests[0].Header.Get("Metadata")).To(Equal("true"))
+		Expect(requests[0].URL.Query().Get("api-version")).ToNot(BeEmpty())
+	})
+
+	It("reports GCP instances", func() {
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/dmi/id/sys_vendor":   "Google\n",
+			"/sys/class/dmi/id/product_name": "Google Compute Engine\n",
+		})
+		dependencies.On("Execute", "lscpu", "-J").Return(kvmLscpuOutput, "", 0).Once()
+
+		platform := getPlatform()
+		Expect(platform.InstanceID).To(Equal("4520031799277581759"))
+		Expect(platform.InstanceType).To(Equal("n2-standard-8"))
+		Expect(platform.Region).To(Equal("us-central1"))
+		Expect(platform.Zone).To(Equal("us-central1-a"))
+		Expect(requests[0].Header.Get("Metadata-Flavor")).To(Equal("Google"))
+	})
+
+	It("reports OCI instances", func() {
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/dmi/id/chassis_asset_tag": "OracleCloud.com\n",
+		})
+		dependencies.On("Execute", "lscpu", "-J").Return(kvmLscpuOutput, "", 0).Once()
+
+		platform := getPlatform()
+		Expect(platform.Provider).To(Equal(platformOCI))
+		Expect(platform.InstanceType).To(Equal("VM.Standard.E4.Flex"))
+		Expect(platform.Region).To(Equal("us-ashburn-1"))
+		Expect(platform.Zone).To(Equal("AD-1"))
+	})
+
+	It("reports OpenStack instances without the EC2 API", func() {
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/dmi/id/sys_vendor":   "Red Hat\n",
+			"/sys/class/dmi/id/product_name": "OpenStack Compute\n",
+		})
+		dependencies.On("Execute", "lscpu", "-J").Return(kvmLscpuOutput, "", 0).Once()
+		delete(responses, "GET /latest/meta-data/instance-type")
+
+		Expect(getPlatform()).To(Equal(&Platform{
+			Provider:        platformOpenStack,
+			Hypervisor:      "KVM",
+			Virtual:         true,
+			InstanceID:      "d8e02d56-2648-49a3-bf97-6be8f1204f38",
+			Zone:            "nova",
+			MetadataService: true,
+		}))
+	})
+
+	It("reports vSphere without probing", func() {
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/dmi/id/sys_vendor":   "VMware, Inc.\n",
+			"/sys/class/dmi/id/product_name": "VMware7,1\n",
+		})
+		dependencies.On("Execute", "lscpu", "-J").Return(`{"lscpu": [{"field": "Hypervisor vendor:", "data": "VMware"}]}`, "", 0).Once()
+
+		Expect(getPlatform()).To(Equal(&Platform{Provider: platformVSphere, Hypervisor: "VMware", Virtual: true}))
+		Expect(requests).To(BeEmpty())
+	})
+
+	It("reports bare metal hosts", func() {
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/dmi/id/sys_vendor":   "Dell Inc.\n",
+			"/sys/class/dmi/id/product_name": "PowerEdge R650\n",
+		})
+		dependencies.On("Execute", "lscpu", "-J").Return(bareMetalLscpuOutput, "", 0).Once()
+
+		Expect(getPlatform()).To(Equal(&Platform{Provider: platformBareMetal}))
+		Expect(requests).To(BeEmpty())
+	})
+
+	It("keeps the DMI details when the metadata service fails", func() {
+		responses = map[string]string{}
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/dmi/id/sys_vendor": "Google\n",
+		})
+		dependencies.On("Execute", "lscpu", "-J").Return(kvmLscpuOutput, "", 0).Once()
+
+		Expect(getPlatform()).To(Equal(&Platform{Provider: platformGCP, Hypervisor: "KVM", Virtual: true}))
+	})
+
+	It("doesn't probe the metadata service unless enabled", func() {
+		inventoryConfig.PlatformMetadataProbe = false
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/dmi/id/sys_vendor": "Amazon EC2\n",
+		})
+		dependencies.On("Execute", "lscpu", "-J").Return(kvmLscpuOutput, "", 0).Once()
+
+		Expect(getPlatform()).To(Equal(&Platform{Provider: platformAWS, Hypervisor: "KVM", Virtual: true}))
+		Expect(requests).To(BeEmpty())
+	})
+
+	It("doesn't query the metadata service through a proxy", func() {
+		transport, ok := newPlatform(inventoryConfig, dependencies).client.Transport.(*http.Transport)
+		Expect(ok).To(BeTrue())
+		Expect(transport.Proxy).To(BeNil())
+	})
+})
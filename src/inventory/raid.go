@@ -0,0 +1,468 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/sirupsen/logrus"
+)
+
+// The families of hardware RAID controllers, each one is managed by its own vendor CLI
+const (
+	RAIDFamilyPERC       = "perc"
+	RAIDFamilyMegaRAID   = "megaraid"
+	RAIDFamilySmartArray = "smartarray"
+)
+
+// The states of the logical disks, normalized from the ones of the vendor CLIs
+const (
+	RAIDStateOptimal    = "optimal"
+	RAIDStateDegraded   = "degraded"
+	RAIDStateRebuilding = "rebuilding"
+	RAIDStateOffline    = "offline"
+	RAIDStateFailed     = "failed"
+)
+
+// PCI classes of RAID bus and Serial Attached SCSI controllers
+const (
+	pciClassRAID = "0104"
+	pciClassSAS  = "0107"
+)
+
+// raidControllerRule detects the family of a RAID controller by its PCI vendor and, for OEM
+// versions of the same chips, the vendor of its subsystem. Only RAID bus controllers match unless
+// the rule lists other classes.
+type raidControllerRule struct {
+	family          string
+	vendor          string
+	subsystemVendor string
+	classes         []string
+}
+
+// raidControllerRules are checked in order, the first one that matches gives the family.
+var raidControllerRules = []raidControllerRule{
+	// PERC controllers are Broadcom MegaRAID chips with Dell subsystems
+	{family: RAIDFamilyPERC, vendor: "1000", subsystemVendor: "1028"},
+	{family: RAIDFamilyMegaRAID, vendor: "1000"},
+	{family: RAIDFamilySmartArray, vendor: "103c"},
+	// Smart Array Gen10 controllers are Microsemi SmartPQI chips with HPE subsystems, which
+	// enumerate as SAS controllers
+	{family: RAIDFamilySmartArray, vendor: "9005", subsystemVendor: "103c", classes: []string{pciClassRAID, pciClassSAS}},
+}
+
+// raidTools are the vendor CLIs of each family, in order of preference. They are run in the host,
+// where they are usually installed out of the PATH.
+var raidTools = map[string][]string{
+	RAIDFamilyPERC:       {"perccli64", "/opt/MegaRAID/perccli/perccli64", "storcli64", "/opt/MegaRAID/storcli/storcli64"},
+	RAIDFamilyMegaRAID:   {"storcli64", "/opt/MegaRAID/storcli/storcli64", "storcli"},
+	RAIDFamilySmartArray: {"ssacli", "/usr/sbin/ssacli", "hpssacli"},
+}
+
+// storcliStates maps the abbreviated states of storcli and perccli to the normalized ones.
+var storcliStates = map[string]string{
+	"Optl":   RAIDStateOptimal,
+	"Onln":   "online",
+	"Dgrd":   RAIDStateDegraded,
+	"Pdgd":   RAIDStateDegraded,
+	"OfLn":   RAIDStateOffline,
+	"Offln":  RAIDStateOffline,
+	"Rec":    RAIDStateRebuilding,
+	"Rbld":   RAIDStateRebuilding,
+	"UBad":   RAIDStateFailed,
+	"Failed": RAIDStateFailed,
+	"DHS":    "hot_spare",
+	"GHS":    "hot_spare",
+	"UGood":  "unconfigured",
+}
+
+// ssacliStates maps the states of ssacli to the normalized ones.
+var ssacliStates = map[string]string{
+	"OK":                    RAIDStateOptimal,
+	"Interim Recovery Mode": RAIDStateDegraded,
+	"Ready for Rebuild":     RAIDStateDegraded,
+	"Recovering":            RAIDStateRebuilding,
+	"Rebuilding":            RAIDStateRebuilding,
+	"Failed":                RAIDStateFailed,
+}
+
+// ssacliDriveStates maps the states of the physical drives of ssacli to the normalized ones.
+var ssacliDriveStates = map[string]string{
+	"OK":         "online",
+	"Rebuilding": RAIDStateRebuilding,
+	"Failed":     RAIDStateFailed,
+}
+
+var (
+	storcliVirtualDriveRegex = regexp.MustCompile(`^/c\d+/v(\d+)$`)
+	ssacliPhysicalDriveRegex = regexp.MustCompile(`^physicaldrive (\S+) \((.*)\)$`)
+)
+
+// RAID is the hardware RAID configuration of the host: the controllers found in the PCI bus and
+// the logical disks reported by their vendor CLIs.
+type RAID struct {
+	Controllers  []*RAIDController `json:"controllers"`
+	LogicalDisks []*LogicalDisk    `json:"logical_disks,omitempty"`
+}
+
+type RAIDController struct {
+	Address string `json:"address"`
+	Family  string `json:"family"`
+	Vendor  string `json:"vendor,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Driver  string `json:"driver,omitempty"`
+	// Tool is the vendor CLI that reported the logical disks, empty when none is installed
+	Tool string `json:"tool,omitempty"`
+}
+
+// LogicalDisk is a disk exposed by a RAID controller. Path and WWN identify the disk of the
+// inventory that it backs.
+type LogicalDisk struct {
+	// Controller is the number or slot of the controller in the vendor CLI
+	Controller string `json:"controller"`
+	ID         string `json:"id"`
+	Name       string `json:"name,omitempty"`
+	Level      string `json:"level"`
+	State      string `json:"state"`
+	// VendorState is the state as reported by the vendor CLI
+	VendorState string        `json:"vendor_state"`
+	Size        string        `json:"size,omitempty"`
+	Path        string        `json:"path,omitempty"`
+	WWN         string        `json:"wwn,omitempty"`
+	Members     []*RAIDMember `json:"members,omitempty"`
+}
+
+type RAIDMember struct {
+	// ID is the enclosure and slot, or the port, box and bay, of the drive
+	ID          string `json:"id"`
+	Model       string `json:"model,omitempty"`
+	Media       string `json:"media,omitempty"`
+	Size        string `json:"size,omitempty"`
+	State       string `json:"state"`
+	VendorState string `json:"vendor_state"`
+}
+
+type storcliOutput struct {
+	Controllers []struct {
+		CommandStatus struct {
+			Controller interface{} `json:"Controller"`
+			Status     string      `json:"Status"`
+		} `json:"Command Status"`
+		ResponseData map[string]json.RawMessage `json:"Response Data"`
+	} `json:"Controllers"`
+}
+
+type storcliVirtualDrive struct {
+	Type  string `json:"TYPE"`
+	State string `json:"State"`
+	Size  string `json:"Size"`
+	Name  string `json:"Name"`
+}
+
+type storcliPhysicalDrive struct {
+	EIDSlot string `json:"EID:Slt"`
+	State   string `json:"State"`
+	Size    string `json:"Size"`
+	Media   string `json:"Med"`
+	Model   string `json:"Model"`
+}
+
+type storcliVirtualDriveProperties struct {
+	OSDriveName string `json:"OS Drive Name"`
+	SCSINAAID   string `json:"SCSI NAA Id"`
+}
+
+func normalizeRAIDState(states map[string]string, state string) string {
+	if normalized, ok := states[state]; ok {
+		return normalized
+	}
+	return strings.ToLower(strings.ReplaceAll(state, " ", "_"))
+}
+
+// parseStorcliLogicalDisks parses the output of storcli /call/vall show all J, which perccli shares.
+func parseStorcliLogicalDisks(output string) ([]*LogicalDisk, error) {
+	var parsed storcliOutput
+	if err := json.Unmarshal([]byte(output), &parsed); err != nil {
+		return nil, err
+	}
+	var ret []*LogicalDisk
+	for _, controller := range parsed.Controllers {
+		if controller.CommandStatus.Status != "Success" {
+			continue
+		}
+		for key, data := range controller.ResponseData {
+			matches := storcliVirtualDriveRegex.FindStringSubmatch(key)
+			if matches == nil {
+				continue
+			}
+			var drives []storcliVirtualDrive
+			if err := json.Unmarshal(data, &drives); err != nil || len(drives) == 0 {
+				continue
+			}
+			ld := &LogicalDisk{
+				Controller:  fmt.Sprint(controller.CommandStatus.Controller),
+				ID:          matches[1],
+				Name:        drives[0].Name,
+				Level:       drives[0].Type,
+				State:       normalizeRAIDState(storcliStates, drives[0].State),
+				VendorState: drives[0].State,
+				Size:        drives[0].Size,
+			}
+			var properties storcliVirtualDriveProperties
+			if raw, ok := controller.ResponseData[fmt.Sprintf("VD%s Properties", ld.ID)]; ok && json.Unmarshal(raw, &properties) == nil {
+				ld.Path = properties.OSDriveName
+				ld.WWN = strings.ToLower(properties.SCSINAAID)
+			}
+			var members []storcliPhysicalDrive
+			if raw, ok := controller.ResponseData["PDs for VD "+ld.ID]; ok && json.Unmarshal(raw, &members) == nil {
+				for _, member := range members {
+					ld.Members = append(ld.Members, &RAIDMember{
+						ID:          member.EIDSlot,
+						Model:       strings.TrimSpace(member.Model),
+						Media:       member.Media,
+						Size:        member.Size,
+						State:       normalizeRAIDState(storcliStates, member.State),
+						VendorState: member.State,
+					})
+				}
+			}
+			ret = append(ret, ld)
+		}
+	}
+	// The virtual drives are keys of a JSON object, sort them to get a deterministic order
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Controller != ret[j].Controller {
+			return ret[i].Controller < ret[j].Controller
+		}
+		a, _ := strconv.Atoi(ret[i].ID)
+		b, _ := strconv.Atoi(ret[j].ID)
+		return a < b
+	})
+	return ret, nil
+}
+
+// parseSsacliLogicalDisks parses the output of ssacli ctrl all show config detail, where the
+// properties of the logical drives and their member drives are nested by indentation:
+//
+//	Logical Drive: 1
+//	   Fault Tolerance: 1
+//	   Status: Interim Recovery Mode
+//	   Disk Name: /dev/sda
+//	   Mirror Group 1:
+//	      physicaldrive 1I:3:1 (port 1I:box 3:bay 1, SAS HDD, 600 GB, OK)
+func parseSsacliLogicalDisks(output string) []*LogicalDisk {
+	var ret []*LogicalDisk
+	var ld *LogicalDisk
+	var slot string
+	ldIndent := 0
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if ld != nil && indent <= ldIndent {
+			ld = nil
+		}
+		key, value, _ := strings.Cut(trimmed, ": ")
+		value = strings.TrimSpace(value)
+		if ld == nil {
+			switch key {
+			case "Slot":
+				slot = value
+			case "Logical Drive":
+				ld = &LogicalDisk{Controller: slot, ID: value}
+				ldIndent = indent
+				ret = append(ret, ld)
+			}
+			continue
+		}
+		switch key {
+		case "Fault Tolerance":
+			ld.Level = "RAID" + strings.ReplaceAll(value, "+", "")
+		case "Status":
+			ld.VendorState = value
+			ld.State = normalizeRAIDState(ssacliStates, value)
+		case "Size":
+			ld.Size = value
+		case "Disk Name":
+			ld.Path = value
+		case "Unique Identifier":
+			ld.WWN = strings.ToLower(value)
+		case "Logical Drive Label":
+			ld.Name = value
+		}
+		if matches := ssacliPhysicalDriveRegex.FindStringSubmatch(trimmed); matches != nil {
+			// The details are the location, the interface and media, the size and the state
+			details := strings.Split(matches[2], ", ")
+			member := &RAIDMember{ID: matches[1], VendorState: details[len(details)-1]}
+			member.State = normalizeRAIDState(ssacliDriveStates, member.VendorState)
+			if len(details) == 4 {
+				member.Media = details[1]
+				member.Size = details[2]
+			}
+			ld.Members = append(ld.Members, member)
+		}
+	}
+	return ret
+}
+
+type raid struct {
+	dependencies    util.IDependencies
+	inventoryConfig *config.InventoryConfig
+}
+
+func newRAID(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) *raid {
+	return &raid{dependencies: dependencies, inventoryConfig: inventoryConfig}
+}
+
+func raidControllerFamily(class, vendor, subsystemVendor string) string {
+	for _, rule := range raidControllerRules {
+		classes := rule.classes
+		if classes == nil {
+			classes = []string{pciClassRAID}
+		}
+		if !slices.Contains(classes, class) {
+			continue
+		}
+		if rule.vendor == vendor && (rule.subsystemVendor == "" || rule.subsystemVendor == subsystemVendor) {
+			return rule.family
+		}
+	}
+	return ""
+}
+
+// getControllers returns the RAID controllers of the PCI bus of a known family.
+func (r *raid) getControllers() []*RAIDController {
+	pciInfo, err := r.dependencies.PCI()
+	if err != nil {
+		logrus.Warnf("Error getting PCI info: %s", err)
+		return nil
+	}
+	var ret []*RAIDController
+	for _, device := range pciInfo.Devices {
+		if device.Class == nil || device.Subclass == nil || device.Vendor == nil || device.Product == nil {
+			continue
+		}
+		var subsystemVendor string
+		if device.Subsystem != nil {
+			subsystemVendor = device.Subsystem.VendorID
+		}
+		family := raidControllerFamily(device.Class.ID+device.Subclass.ID, device.Vendor.ID, subsystemVendor)
+		if family == "" {
+			continue
+		}
+		controller := &RAIDController{
+			Address: device.Address,
+			Family:  family,
+			Vendor:  device.Vendor.Name,
+			Name:    device.Product.Name,
+		}
+		if target, err := r.dependencies.EvalSymlinks(fmt.Sprintf("/sys/bus/pci/devices/%s/driver", device.Address)); err == nil {
+			controller.Driver = filepath.Base(target)
+		}
+		ret = append(ret, controller)
+	}
+	return ret
+}
+
+// getLogicalDisks runs the first vendor CLI of the family that is installed and returns the tool
+// and the logical disks it reports.
+func (r *raid) getLogicalDisks(family string) (string, []*LogicalDisk) {
+	for _, tool := range raidTools[family] {
+		var o, e string
+		var exitCode int
+		if family == RAIDFamilySmartArray {
+			o, e, exitCode = r.dependencies.ExecutePrivileged(tool, "ctrl", "all", "show", "config", "detail")
+		} else {
+			o, e, exitCode = r.dependencies.ExecutePrivileged(tool, "/call/vall", "show", "all", "J")
+		}
+		if exitCode != 0 {
+			logrus.Debugf("Could not run %s: %s", tool, e)
+			continue
+		}
+		if family == RAIDFamilySmartArray {
+			return tool, parseSsacliLogicalDisks(o)
+		}
+		lds, err := parseStorcliLogicalDisks(o)
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to parse the output of %s", tool)
+			continue
+		}
+		return tool, lds
+	}
+	return "", nil
+}
+
+func (r *raid) getRAID() *RAID {
+	controllers := r.getControllers()
+	if len(controllers) == 0 {
+		return nil
+	}
+	ret := &RAID{Controllers: controllers}
+	if r.inventoryConfig.DryRunEnabled {
+		return ret
+	}
+	tools := map[string]string{}
+	for _, controller := range controllers {
+		tool, ok := tools[controller.Family]
+		if !ok {
+			// One run of the CLI reports the logical disks of all the controllers of the family
+			var lds []*LogicalDisk
+			tool, lds = r.getLogicalDisks(controller.Family)
+			tools[controller.Family] = tool
+			ret.LogicalDisks = append(ret.LogicalDisks, lds...)
+		}
+		controller.Tool = tool
+	}
+	return ret
+}
+
+// GetRAID returns the hardware RAID controllers of the host and, when their vendor CLI is
+// installed, the logical disks they expose.
+func GetRAID(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) *RAID {
+	return newRAID(inventoryConfig, dependencies).getRAID()
+}
+
+// findLogicalDisk returns the logical disk that backs the disk, matched by path or WWN.
+func findLogicalDisk(raid *RAID, disk *Disk) *LogicalDisk {
+	wwn := strings.TrimPrefix(strings.ToLower(disk.Wwn), "0x")
+	for _, ld := range raid.LogicalDisks {
+		if (ld.Path != "" && ld.Path == disk.Path) || (ld.WWN != "" && ld.WWN == wwn) {
+			return ld
+		}
+	}
+	return nil
+}
+
+// applyRAID attaches the logical disks to the disks they back. Disks of degraded or rebuilding
+// arrays get a warning, the ones of failed or offline arrays are marked as not eligible for
+// installation.
+func applyRAID(inventory *Inventory) {
+	if inventory.RAID == nil {
+		return
+	}
+	for _, disk := range inventory.Disks {
+		ld := findLogicalDisk(inventory.RAID, disk)
+		if ld == nil {
+			continue
+		}
+		disk.RAID = ld
+		switch ld.State {
+		case RAIDStateOptimal:
+		case RAIDStateFailed, RAIDStateOffline:
+			disk.InstallationEligibility.NotEligibleReasons = append(disk.InstallationEligibility.NotEligibleReasons,
+				fmt.Sprintf("Disk is a %s logical disk in %s state", ld.Level, ld.State))
+			disk.InstallationEligibility.Eligible = false
+		default:
+			disk.Warnings = append(disk.Warnings, fmt.Sprintf("Disk is a %s logical disk in %s state", ld.Level, ld.State))
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package inventory
+
+import (
+	"errors"
+
+	"github.com/jaypipes/ghw"
+	"github.com/jaypipes/pcidb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/openshift/assisted-service/models"
+	"github.com/stretchr/testify/mock"
+)
+
+const (
+	// Captured on a Dell PowerEdge R640 with a PERC H730P and a failed mirror drive
+	perccliShowAll = `{
+"Controllers":[
+{
+	"Command Status" : {
+		"CLI Version" : "007.1623.0000.0000 Mar 07, 2021",
+		"Operating system" : "Linux 5.14.0-284.el9.x86_64",
+		"Controller" : 0,
+		"Status" : "Success",
+		"Description" : "None"
+	},
+	"Response Data" : {
+		"/c0/v1" : [
+			{"DG/VD":"1/1","TYPE":"RAID0","State":"Optl","Access":"RW","Consist":"No","Cache":"RWBD","Cac":"-","sCC":"ON","Size":"1.745 TB","Name":"data"}
+		],
+		"PDs for VD 1" : [
+			{"EID:Slt":"32:2","DID":2,"State":"Onln","DG":1,"Size":"1.745 TB","Intf":"SATA","Med":"SSD","SED":"N","PI":"N","SeSz":"512B","Model":"MZ7KH1T9HAJR0D3 ","Sp":"U","Type":"-"}
+		],
+		"VD1 Properties" : {
+			"Strip Size" : "64 KB",
+			"Span Depth" : 1,
+			"Number of Drives Per Span" : 1,
+			"OS Drive Name" : "/dev/sdb",
+			"SCSI NAA Id" : "6D0946606A8E1F0029A1B2C3D4E5F601"
+		},
+		"/c0/v0" : [
+			{"DG/VD":"0/0","TYPE":"RAID1","State":"Dgrd","Access":"RW","Consist":"Yes","Cache":"RWBD","Cac":"-","sCC":"ON","Size":"446.625 GB","Name":"os"}
+		],
+		"PDs for VD 0" : [
+			{"EID:Slt":"32:0","DID":0,"State":"Onln","DG":0,"Size":"446.625 GB","Intf":"SATA","Med":"SSD","SED":"N","PI":"N","SeSz":"512B","Model":"MZ7KH480HAHQ0D3 ","Sp":"U","Type":"-"},
+			{"EID:Slt":"32:1","DID":1,"State":"Offln","DG":0,"Size":"446.625 GB","Intf":"SATA","Med":"SSD","SED":"N","PI":"N","SeSz":"512B","Model":"MZ7KH480HAHQ0D3 ","Sp":"U","Type":"-"}
+		],
+		"VD0 Properties" : {
+			"Strip Size" : "64 KB",
+			"Span Depth" : 1,
+			"Number of Drives Per Span" : 2,
+			"SCSI NAA Id" : "6d0946606a8e1f00285b4bfb0a5e30f8"
+		}
+	}
+}
+]
+}
+`
+
+	// Captured on an HPE ProLiant DL380 Gen10 with a Smart Array P408i-a
+	ssacliShowConfigDetail = `
+Smart Array P408i-a SR Gen10 in Slot 0 (Embedded)
+   Bus Interface: PCI
+   Slot: 0
+   Serial Number: PEYHB0ARHBD0BL
+   Controller Status: OK
+
+   Array: A
+      Interface Type: SAS
+      Status: OK
+
+      Logical Drive: 1
+         Size: 558.88 GB
+         Fault Tolerance: 1
+         Heads: 255
+         Status: Interim Recovery Mode
+         Unique Identifier: 600508B1001C1B7A6A3AF7E8BD2E7D26
+         Disk Name: /dev/sda
+         Mount Points: None
+         Logical Drive Label: 0575B5D2PEYHB0ARHBD0BLB9F1
+         Mirror Group 1:
+            physicaldrive 1I:3:1 (port 1I:box 3:bay 1, SAS HDD, 600 GB, OK)
+         Mirror Group 2:
+            physicaldrive 1I:3:2 (port 1I:box 3:bay 2, SAS HDD, 600 GB, Failed)
+         Drive Type: Data
+
+      physicaldrive 1I:3:1
+         Port: 1I
+         Box: 3
+         Bay: 1
+         Status: OK
+
+   Array: B
+      Status: OK
+
+      Logical Drive: 2
+         Size: 3.49 TB
+         Fault Tolerance: 1+0
+         Status: OK
+         Disk Name: /dev/sdb
+         Mirror Group 1:
+            physicaldrive 1I:3:3 (port 1I:box 3:bay 3, SAS SSD, 1.9 TB, OK)
+            physicaldrive 1I:3:4 (port 1I:box 3:bay 4, SAS SSD, 1.9 TB, OK)
+         Mirror Group 2:
+            physicaldrive 2I:3:5 (port 2I:box 3:bay 5, SAS SSD, 1.9 TB, OK)
+            physicaldrive 2I:3:6 (port 2I:box 3:bay 6, SAS SSD, 1.9 TB, OK)
+`
+)
+
+var (
+	percH730P = ghw.PCIDevice{
+		Address:   "0000:18:00.0",
+		Class:     &pcidb.Class{ID: "01", Name: "Mass storage controller"},
+		Subclass:  &pcidb.Subclass{ID: "04", Name: "RAID bus controller"},
+		Product:   &pcidb.Product{VendorID: "1000", ID: "005d", Name: "MegaRAID SAS-3 3108 [Invader]"},
+		Subsystem: &pcidb.Product{VendorID: "1028", ID: "1f47", Name: "PERC H730P Mini"},
+		Vendor:    &pcidb.Vendor{ID: "1000", Name: "Broadcom / LSI"},
+	}
+	smartArrayP408i = ghw.PCIDevice{
+		Address:   "0000:5c:00.0",
+		Class:     &pcidb.Class{ID: "01", Name: "Mass storage controller"},
+		Subclass:  &pcidb.Subclass{ID: "07", Name: "Serial Attached SCSI controller"},
+		Product:   &pcidb.Product{VendorID: "9005", ID: "028f", Name: "Smart Storage PQI 12G SAS/PCIe 3"},
+		Subsystem: &pcidb.Product{VendorID: "103c", ID: "0600", Name: "Smart Array P408i-a SR Gen10"},
+		Vendor:    &pcidb.Vendor{ID: "9005", Name: "Adaptec"},
+	}
+	ahci = ghw.PCIDevice{
+		Address:  "0000:00:17.0",
+		Class:    &pcidb.Class{ID: "01", Name: "Mass storage controller"},
+		Subclass: &pcidb.Subclass{ID: "06", Name: "SATA controller"},
+		Product:  &pcidb.Product{VendorID: "8086", ID: "a182", Name: "C620 Series Chipset Family SATA Controller [AHCI mode]"},
+		Vendor:   &pcidb.Vendor{ID: "8086", Name: "Intel Corporation"},
+	}
+)
+
+var _ = Describe("RAID", func() {
+	var (
+		dependencies    *util.MockIDependencies
+		inventoryConfig *config.InventoryConfig
+	)
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+		inventoryConfig = &config.InventoryConfig{}
+	})
+
+	AfterEach(func() {
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	It("maps the logical disks of PERC controllers with perccli", func() {
+		dependencies.On("PCI").Return(&ghw.PCIInfo{Devices: []*ghw.PCIDevice{&ahci, &percH730P}}, nil).Once()
+		dependencies.On("EvalSymlinks", "/sys/bus/pci/devices/0000:18:00.0/driver").Return("/sys/bus/pci/drivers/megaraid_sas", nil).Once()
+		dependencies.On("ExecutePrivileged", "perccli64", "/call/vall", "show", "all", "J").Return("", "perccli64: command not found", 127).Once()
+		dependencies.On("ExecutePrivileged", "/opt/MegaRAID/perccli/perccli64", "/call/vall", "show", "all", "J").Return(perccliShowAll, "", 0).Once()
+
+		Expect(GetRAID(inventoryConfig, dependencies)).To(Equal(&RAID{
+			Controllers: []*RAIDController{{
+				Address: "0000:18:00.0",
+				Family:  RAIDFamilyPERC,
+				Vendor:  "Broadcom / LSI",
+				Name:    "MegaRAID SAS-3 3108 [Invader]",
+				Driver:  "megaraid_sas",
+				Tool:    "/opt/MegaRAID/perccli/perccli64",
+			}},
+			LogicalDisks: []*LogicalDisk{
+				{Controller: "0", ID: "0", Name: "os", Level: "RAID1", State: RAIDStateDegraded, VendorState: "Dgrd", Size: "446.625 GB",
+					WWN: "6d0946606a8e1f00285b4bfb0a5e30f8", Members: []*RAIDMember{
+						{ID: "32:0", Model: "MZ7KH480HAHQ0D3", Media: "SSD", Size: "446.625 GB", State: "online", VendorState: "Onln"},
+						{ID: "32:1", Model: "MZ7KH480HAHQ0D3", Media: "SSD", Size: "446.625 GB", State: RAIDStateOffline, VendorState: "Offln"},
+					}},
+				{Controller: "0", ID: "1", Name: "data", Level: "RAID0", State: RAIDStateOptimal, VendorState: "Optl", Size: "1.745 TB",
+					Path: "/dev/sdb", WWN: "6d0946606a8e1f0029a1b2c3d4e5f601", Members: []*RAIDMember{
+						{ID: "32:2", Model: "MZ7KH1T9HAJR0D3", Media: "SSD", Size: "1.745 TB", State: "online", VendorState: "Onln"},
+					}},
+			},
+		}))
+	})
+
+	It("maps the logical disks of Smart Array controllers with ssacli", func() {
+		dependencies.On("PCI").Return(&ghw.PCIInfo{Devices: []*ghw.PCIDevice{&smartArrayP408i}}, nil).Once()
+		dependencies.On("EvalSymlinks", mock.Anything).Return("", errors.New("no such file or directory")).Once()
+		dependencies.On("ExecutePrivileged", "ssacli", "ctrl", "all", "show", "config", "detail").Return(ssacliShowConfigDetail, "", 0).Once()
+
+		raid := GetRAID(inventoryConfig, dependencies)
+		Expect(raid.Controllers[0].Family).To(Equal(RAIDFamilySmartArray))
+		Expect(raid.Controllers[0].Tool).To(Equal("ssacli"))
+		Expect(raid.LogicalDisks).To(HaveLen(2))
+		Expect(raid.LogicalDisks[0]).To(Equal(&LogicalDisk{
+			Controller: "0", ID: "1", Name: "0575B5D2PEYHB0ARHBD0BLB9F1", Level: "RAID1", State: RAIDStateDegraded,
+			VendorState: "Interim Recovery Mode", Size: "558.88 GB", Path: "/dev/sda", WWN: "600508b1001c1b7a6a3af7e8bd2e7d26",
+			Members: []*RAIDMember{
+				{ID: "1I:3:1", Media: "SAS HDD", Size: "600 GB", State: "online", VendorState: "OK"},
+				{ID: "1I:3:2", Media: "SAS HDD", Size: "600 GB", State: RAIDStateFailed, VendorState: "Failed"},
+			},
+		}))
+		Expect(raid.LogicalDisks[1].Level).To(Equal("RAID10"))
+		Expect(raid.LogicalDisks[1].State).To(Equal(RAIDStateOptimal))
+		Expect(raid.LogicalDisks[1].Members).To(HaveLen(4))
+	})
+
+	It("reports the controllers when the vendor CLI isn't installed", func() {
+		dependencies.On("PCI").Return(&ghw.PCIInfo{Devices: []*ghw.PCIDevice{&smartArrayP408i}}, nil).Once()
+		dependencies.On("EvalSymlinks", mock.Anything).Return("/sys/bus/pci/drivers/smartpqi", nil).Once()
+		dependencies.On("ExecutePrivileged", mock.Anything, "ctrl", "all", "show", "config", "detail").Return("", "command not found", 127).Times(3)
+
+		Expect(GetRAID(inventoryConfig, dependencies)).To(Equal(&RAID{Controllers: []*RAIDController{{
+			Address: "0000:5c:00.0",
+			Family:  RAIDFamilySmartArray,
+			Vendor:  "Adaptec",
+			Name:    "Smart Storage PQI 12G SAS/PCIe 3",
+			Driver:  "smartpqi",
+		}}}))
+	})
+
+	It("returns nothing without RAID controllers", func() {
+		sas3008 := ghw.PCIDevice{
+			Address:   "0000:3b:00.0",
+			Class:     &pcidb.Class{ID: "01", Name: "Mass storage controller"},
+			Subclass:  &pcidb.Subclass{ID: "07", Name: "Serial Attached SCSI controller"},
+			Product:   &pcidb.Product{VendorID: "1000", ID: "0097", Name: "SAS3008 PCI-Express Fusion-MPT SAS-3"},
+			Subsystem: &pcidb.Product{VendorID: "1000", ID: "30e0", Name: "SAS9300-8i"},
+			Vendor:    &pcidb.Vendor{ID: "1000", Name: "Broadcom / LSI"},
+		}
+		dependencies.On("PCI").Return(&ghw.PCIInfo{Devices: []*ghw.PCIDevice{&ahci, &sas3008}}, nil).Once()
+		Expect(GetRAID(inventoryConfig, dependencies)).To(BeNil())
+	})
+
+	It("attaches the logical disks to the disks by path or WWN", func() {
+		inventory := &Inventory{
+			RAID: &RAID{LogicalDisks: []*LogicalDisk{
+				{Level: "RAID1", State: RAIDStateDegraded, WWN: "6d0946606a8e1f00285b4bfb0a5e30f8"},
+				{Level: "RAID0", State: RAIDStateOptimal, Path: "/dev/sdb"},
+				{Level: "RAID5", State: RAIDStateFailed, Path: "/dev/sdc"},
+			}},
+			Disks: []*Disk{
+				{Disk: &models.Disk{Path: "/dev/sda", Wwn: "0x6d0946606a8e1f00285b4bfb0a5e30f8", InstallationEligibility: models.DiskInstallationEligibility{Eligible: true}}},
+				{Disk: &models.Disk{Path: "/dev/sdb", InstallationEligibility: models.DiskInstallationEligibility{Eligible: true}}},
+				{Disk: &models.Disk{Path: "/dev/sdc", InstallationEligibility: models.DiskInstallationEligibility{Eligible: true}}},
+				{Disk: &models.Disk{Path: "/dev/nvme0n1", InstallationEligibility: models.DiskInstallationEligibility{Eligible: true}}},
+			},
+		}
+		applyRAID(inventory)
+
+		Expect(inventory.Disks[0].RAID).To(Equal(inventory.RAID.LogicalDisks[0]))
+		Expect(inventory.Disks[0].Warnings).To(Equal([]string{"Disk is a RAID1 logical disk in degraded state"}))
+		Expect(inventory.Disks[0].InstallationEligibility.Eligible).To(BeTrue())
+		Expect(inventory.Disks[1].RAID).To(Equal(inventory.RAID.LogicalDisks[1]))
+		Expect(inventory.Disks[1].Warnings).To(BeEmpty())
+		Expect(inventory.Disks[2].InstallationEligibility.Eligible).To(BeFalse())
+		Expect(inventory.Disks[2].InstallationEligibility.NotEligibleReasons).To(Equal([]string{"Disk is a RAID5 logical disk in failed state"}))
+		Expect(inventory.Disks[3].RAID).To(BeNil())
+	})
+})
@@ -0,0 +1,431 @@
+package inventory
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	redfishOverIPProtocol = "Redfish over IP"
+	redfishManagersPath   = "/redfish/v1/Managers"
+	redfishSessionsPath   = "/redfish/v1/SessionService/Sessions"
+	redfishAuthTokenKey   = "X-Auth-Token"
+	redfishRequestTimeout = 10 * time.Second
+)
+
+var (
+	redfishServiceAddressRegex = regexp.MustCompile(`^\s*(IPv4|IPv6)? ?Redfish Service Address:\s*(\S+)\s*$`)
+	redfishServicePortRegex    = regexp.MustCompile(`^\s*Redfish Service Port:\s*(\d+)\s*$`)
+	redfishUSBIDRegex          = regexp.MustCompile(`^\s*(idVendor|idProduct):\s*0x([0-9a-fA-F]+)\s*$`)
+)
+
+// BMC is the baseboard management controller as described by its Redfish service.
+type BMC struct {
+	Source          string   `json:"source"`
+	IPV4Addresses   []string `json:"ipv4_addresses,omitempty"`
+	IPV6Addresses   []string `json:"ipv6_addresses,omitempty"`
+	MacAddress      string   `json:"mac_address,omitempty"`
+	Vendor          string   `json:"vendor,omitempty"`
+	Model           string   `json:"model,omitempty"`
+	FirmwareVersion string   `json:"firmware_version,omitempty"`
+	Hostname        string   `json:"hostname,omitempty"`
+}
+
+type redfishLink struct {
+	ID string `json:"@odata.id"`
+}
+
+type redfishCollection struct {
+	Members []redfishLink `json:"Members"`
+}
+
+type redfishManager struct {
+	Manufacturer       string      `json:"Manufacturer"`
+	Model              string      `json:"Model"`
+	FirmwareVersion    string      `json:"FirmwareVersion"`
+	EthernetInterfaces redfishLink `json:"EthernetInterfaces"`
+}
+
+type redfishAddress struct {
+	Address string `json:"Address"`
+}
+
+type redfishEthernetInterface struct {
+	HostName      string           `json:"HostName"`
+	MACAddress    string           `json:"MACAddress"`
+	IPv4Addresses []redfishAddress `json:"IPv4Addresses"`
+	IPv6Addresses []redfishAddress `json:"IPv6Addresses"`
+}
+
+// redfishCredentials is the account used to open a session with the Redfish service, read from
+// the file given with --redfish-credentials-file.
+type redfishCredentials struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// redfishHostInterface is a Redfish service advertised by SMBIOS, with the USB identifiers of the
+// host side network device when the host interface is a USB one.
+type redfishHostInterface struct {
+	URL          string
+	USBVendorID  string
+	USBProductID string
+}
+
+type redfish struct {
+	sysfsReader
+	inventoryConfig *config.InventoryConfig
+	client          *http.Client
+	credentials     *redfishCredentials
+	token           string
+}
+
+// readRedfishCredentials reads the YAML file with the Redfish user name and password.
+func readRedfishCredentials(path string) (*redfishCredentials, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	var ret redfishCredentials
+	if err = yaml.Unmarshal(data, &ret); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal YAML: %v", err)
+	}
+	if ret.Username == "" {
+		return nil, fmt.Errorf("redfish credentials without username")
+	}
+	return &ret, nil
+}
+
+func newRedfish(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) *redfish {
+	var credentials *redfishCredentials
+	if inventoryConfig.RedfishCredentialsFile != "" {
+		var err error
+		if credentials, err = readRedfishCredentials(inventoryConfig.RedfishCredentialsFile); err != nil {
+			logrus.Warnf("Error getting Redfish credentials: %s", err)
+		}
+	}
+	return &redfish{
+		sysfsReader:     sysfsReader{dependencies: dependencies},
+		inventoryConfig: inventoryConfig,
+		credentials:     credentials,
+		client: &http.Client{
+			Timeout: redfishRequestTimeout,
+			Transport: &http.Transport{
+				// The host interface is a point to point link to the BMC, whose certificate is
+				// almost always self signed
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint: gosec
+			},
+		},
+	}
+}
+
+// getHostInterfaces returns the Redfish services advertised by the SMBIOS management controller
+// host interface records (type 42).
+func (r *redfish) getHostInterfaces() []*redfishHostInterface {
+	o, e, exitCode := r.dependencies.Execute("dmidecode", "-t", "42")
+	if exitCode != 0 {
+		logrus.Debugf("Could not run dmidecode: %s", e)
+		return nil
+	}
+	return parseRedfishHostInterfaces(o)
+}
+
+// parseRedfishHostInterfaces extracts the Redfish services from the output of dmidecode -t 42.
+func parseRedfishHostInterfaces(output string) []*redfishHostInterface {
+	var ret []*redfishHostInterface
+	var isRedfish bool
+	var address string
+	usbIDs := map[string]string{}
+	addURL := func() {
+		if isRedfish && address != "" {
+			ret = append(ret, &redfishHostInterface{
+				URL:          "https://" + address,
+				USBVendorID:  usbIDs["idVendor"],
+				USBProductID: usbIDs["idProduct"],
+			})
+		}
+		isRedfish = false
+		address = ""
+		usbIDs = map[string]string{}
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "Handle ") {
+			addURL()
+			continue
+		}
+		if strings.Contains(line, "Protocol ID:") && strings.Contains(line, redfishOverIPProtocol) {
+			isRedfish = true
+			continue
+		}
+		if matches := redfishUSBIDRegex.FindStringSubmatch(line); matches != nil {
+			usbIDs[matches[1]] = strings.ToLower(matches[2])
+			continue
+		}
+		if matches := redfishServiceAddressRegex.FindStringSubmatch(line); matches != nil {
+			ip := net.ParseIP(matches[2])
+			if ip != nil && !ip.IsUnspecified() {
+				address = ip.String()
+				if ip.To4() == nil {
+					address = "[" + address + "]"
+				}
+			}
+			continue
+		}
+		if matches := redfishServicePortRegex.FindStringSubmatch(line); matches != nil && address != "" && matches[1] != "443" {
+			address += ":" + matches[1]
+		}
+	}
+	addURL()
+	return ret
+}
+
+// isHostInterfaceUp checks that the network device of a USB host interface is up and has an
+// address, the Redfish service can't be reached before the OS configured it. Other kinds of host
+// interfaces can't be matched to a network device and are assumed to be usable.
+func (r *redfish) isHostInterfaceUp(hostInterface *redfishHostInterface) bool {
+	if hostInterface.USBVendorID == "" || hostInterface.USBProductID == "" {
+		return true
+	}
+	interfaces, err := r.dependencies.Interfaces()
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to list the network interfaces")
+		return false
+	}
+	for _, iface := range interfaces {
+		// The USB identifiers are attributes of the USB device, the parent of the network one
+		vendorID := strings.ToLower(r.readString(fmt.Sprintf("/sys/class/net/%s/device/../idVendor", iface.Name())))
+		productID := strings.ToLower(r.readString(fmt.Sprintf("/sys/class/net/%s/device/../idProduct", iface.Name())))
+		if vendorID != hostInterface.USBVendorID || productID != hostInterface.USBProductID {
+			continue
+		}
+		if iface.Flags()&net.FlagUp == 0 {
+			logrus.Debugf("Redfish host interface %s is down", iface.Name())
+			return false
+		}
+		addrs, err := iface.Addrs()
+		if err != nil || len(addrs) == 0 {
+			logrus.Debugf("Redfish host interface %s has no address", iface.Name())
+			return false
+		}
+		return true
+	}
+	logrus.Debugf("Redfish host interface %s:%s has no network device", hostInterface.USBVendorID, hostInterface.USBProductID)
+	return false
+}
+
+// resolve returns the absolute URL of a location returned by the service, which is usually only a
+// path.
+func resolve(serviceURL, location string) string {
+	if strings.HasPrefix(location, "/") {
+		return serviceURL + location
+	}
+	return location
+}
+
+// openSession creates a session with the configured credentials, its token authenticates the
+// following requests. It returns the URL of the session, to delete it when done.
+func (r *redfish) openSession(serviceURL string) (string, error) {
+	body, err := json.Marshal(map[string]string{
+		"UserName": r.credentials.Username,
+		"Password": r.credentials.Password,
+	})
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.client.Post(serviceURL+redfishSessionsPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to create a session with %s, it returned %s", serviceURL, resp.Status)
+	}
+	r.token = resp.Header.Get(redfishAuthTokenKey)
+	if r.token == "" {
+		return "", fmt.Errorf("redfish service %s returned a session without token", serviceURL)
+	}
+	return resolve(serviceURL, resp.Header.Get("Location")), nil
+}
+
+// closeSession deletes the session, BMCs only allow a few of them at the same time.
+func (r *redfish) closeSession(sessionURL string) {
+	defer func() { r.token = "" }()
+	if sessionURL == "" {
+		return
+	}
+	req, err := http.NewRequest(http.MethodDelete, sessionURL, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set(redfishAuthTokenKey, r.token)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		logrus.WithError(err).Warnf("Failed to delete Redfish session %s", sessionURL)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (r *redfish) get(url string, result interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if r.token != "" {
+		req.Header.Set(redfishAuthTokenKey, r.token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized && r.credentials == nil {
+		return fmt.Errorf("GET %s requires authentication, the Redfish credentials file isn't configured", url)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode response of %s: %w", url, err)
+	}
+	return nil
+}
+
+// getManager returns the first manager of the Redfish service at serviceURL with its addresses.
+func (r *redfish) getManager(serviceURL string) (*BMC, error) {
+	if r.credentials != nil {
+		sessionURL, err := r.openSession(serviceURL)
+		if err != nil {
+			return nil, err
+		}
+		defer r.closeSession(sessionURL)
+	}
+
+	var managers redfishCollection
+	if err := r.get(serviceURL+redfishManagersPath, &managers); err != nil {
+		return nil, err
+	}
+	if len(managers.Members) == 0 {
+		return nil, fmt.Errorf("redfish service %s has no managers", serviceURL)
+	}
+	var manager redfishManager
+	if err := r.get(serviceURL+managers.Members[0].ID, &manager); err != nil {
+		return nil, err
+	}
+	ret := &BMC{
+		Source:          "redfish",
+		Vendor:          manager.Manufacturer,
+		Model:           manager.Model,
+		FirmwareVersion: manager.FirmwareVersion,
+	}
+	if manager.EthernetInterfaces.ID == "" {
+		return ret, nil
+	}
+
+	var interfaces redfishCollection
+	if err := r.get(serviceURL+manager.EthernetInterfaces.ID, &interfaces); err != nil {
+		logrus.WithError(err).Warn("Failed to get the ethernet interfaces of the BMC")
+		return ret, nil
+	}
+	for _, member := range interfaces.Members {
+		var iface redfishEthernetInterface
+		if err := r.get(serviceURL+member.ID, &iface); err != nil {
+			logrus.WithError(err).Warnf("Failed to get BMC ethernet interface %s", member.ID)
+			continue
+		}
+		ipv4 := getRoutableAddresses(iface.IPv4Addresses)
+		ipv6 := getRoutableAddresses(iface.IPv6Addresses)
+		if len(ipv4) == 0 && len(ipv6) == 0 {
+			// The BMC side of the host interface only has link local addresses
+			continue
+		}
+		ret.IPV4Addresses = append(ret.IPV4Addresses, ipv4...)
+		ret.IPV6Addresses = append(ret.IPV6Addresses, ipv6...)
+		if ret.MacAddress == "" {
+			ret.MacAddress = strings.ToLower(iface.MACAddress)
+		}
+		if ret.Hostname == "" {
+			ret.Hostname = iface.HostName
+		}
+	}
+	return ret, nil
+}
+
+func getRoutableAddresses(addresses []redfishAddress) []string {
+	var ret []string
+	for _, address := range addresses {
+		ip := net.ParseIP(address.Address)
+		if ip == nil || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLoopback() {
+			continue
+		}
+		ret = append(ret, ip.String())
+	}
+	return ret
+}
+
+func (r *redfish) getBMC() *BMC {
+	if r.inventoryConfig.DryRunEnabled {
+		return nil
+	}
+	for _, hostInterface := range r.getHostInterfaces() {
+		if !r.isHostInterfaceUp(hostInterface) {
+			continue
+		}
+		ret, err := r.getManager(hostInterface.URL)
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to query Redfish service %s", hostInterface.URL)
+			continue
+		}
+		return ret
+	}
+	return nil
+}
+
+// GetRedfishBMC returns the BMC found through the Redfish host interface, or nil if the host
+// doesn't have one or it can't be queried.
+func GetRedfishBMC(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) *BMC {
+	return newRedfish(inventoryConfig, dependencies).getBMC()
+}
+
+// bmcAddresses is the BMC found through Redfish together with the addresses reported in the
+// inventory model.
+type bmcAddresses struct {
+	bmc       *BMC
+	v4Address string
+	v6Address string
+}
+
+// getBMCAddresses prefers the BMC addresses reported by Redfish. The ipmitool probes, which may
+// need several calls per channel, only run when Redfish didn't report any address.
+func getBMCAddresses(inventoryConfig *config.InventoryConfig, dependencies util.IDependencies) *bmcAddresses {
+	ret := &bmcAddresses{
+		bmc:       GetRedfishBMC(inventoryConfig, dependencies),
+		v4Address: "0.0.0.0",
+		v6Address: "::/0",
+	}
+	if ret.bmc == nil || len(ret.bmc.IPV4Addresses)+len(ret.bmc.IPV6Addresses) == 0 {
+		ret.v4Address = GetBmcAddress(inventoryConfig, dependencies)
+		ret.v6Address = GetBmcV6Address(inventoryConfig, dependencies)
+		return ret
+	}
+	if len(ret.bmc.IPV4Addresses) > 0 {
+		ret.v4Address = ret.bmc.IPV4Addresses[0]
+	}
+	if len(ret.bmc.IPV6Addresses) > 0 {
+		ret.v6Address = ret.bmc.IPV6Addresses[0]
+	}
+	return ret
+}
@@ -0,0 +1,282 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/config"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/stretchr/testify/mock"
+)
+
+const dmidecodeHostInterfaceTemplate = `# dmidecode 3.3
+Getting SMBIOS data from sysfs.
+SMBIOS 3.3.0 present.
+
+Handle 0x0053, DMI type 42, 12 bytes
+Management Controller Host Interface
+	Host Interface Type: KCS
+	Base Address: 0x0000000000000CA2 (I/O)
+	Register Spacing: Successive Byte Boundaries
+
+Handle 0x0054, DMI type 42, 129 bytes
+Management Controller Host Interface
+	Host Interface Type: Network
+	Device Type: USB
+		idVendor: 0x046b
+		idProduct: 0xffb0
+		SerialNumber:
+	Protocol ID: 04 (Redfish over IP)
+		Service UUID: 9a2b3c4d-0000-1000-8000-0000c0ffee00
+		Host IP Assignment Type: Static
+		Host IP Address Format: IPv4
+		IPv4 Address: 169.254.95.120
+		IPv4 Mask: 255.255.255.0
+		Redfish Service IP Discovery Type: Static
+		Redfish Service IP Address Format: IPv4
+		IPv4 Redfish Service Address: %s
+		IPv4 Redfish Service Mask: 255.255.255.0
+		Redfish Service Port: %s
+		Redfish Service Vlan: 0
+		Redfish Service Hostname: bmc
+`
+
+var _ = Describe("redfish", func() {
+	var (
+		dependencies    *util.MockIDependencies
+		inventoryConfig *config.InventoryConfig
+		server          *httptest.Server
+		responses       map[string]string
+		sessions        map[string]bool
+		credentialsFile string
+	)
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+		inventoryConfig = &config.InventoryConfig{}
+		responses = map[string]string{
+			"/redfish/v1/Managers": `{"Members": [{"@odata.id": "/redfish/v1/Managers/1"}]}`,
+			"/redfish/v1/Managers/1": `{"Manufacturer": "Lenovo", "Model": "XCC", "FirmwareVersion": "TGBT48K",
+				"EthernetInterfaces": {"@odata.id": "/redfish/v1/Managers/1/EthernetInterfaces"}}`,
+			"/redfish/v1/Managers/1/EthernetInterfaces": `{"Members": [
+				{"@odata.id": "/redfish/v1/Managers/1/EthernetInterfaces/ToHost"},
+				{"@odata.id": "/redfish/v1/Managers/1/EthernetInterfaces/NIC"}]}`,
+			"/redfish/v1/Managers/1/EthernetInterfaces/ToHost": `{"MACAddress": "0A:00:00:00:00:01",
+				"IPv4Addresses": [{"Address": "169.254.95.118"}], "IPv6Addresses": [{"Address": "fe80::1"}]}`,
+			"/redfish/v1/Managers/1/EthernetInterfaces/NIC": `{"MACAddress": "4C:D9:8F:03:E8:74", "HostName": "XCC-7X06-J30A1B2C",
+				"IPv4Addresses": [{"Address": "10.16.218.144"}], "IPv6Addresses": [{"Address": "2001:db8::10"}, {"Address": "fe80::2"}]}`,
+		}
+		sessions = map[string]bool{}
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost && r.URL.Path == "/redfish/v1/SessionService/Sessions" {
+				var credentials map[string]string
+				if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil ||
+					credentials["UserName"] != "root" || credentials["Password"] != "calvin" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				sessions["/redfish/v1/SessionService/Sessions/1"] = true
+				w.Header().Set("X-Auth-Token", "e2b3cc5f")
+				w.Header().Set("Location", "/redfish/v1/SessionService/Sessions/1")
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+			if r.Header.Get("X-Auth-Token") != "e2b3cc5f" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.Method == http.MethodDelete {
+				delete(sessions, r.URL.Path)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			response, ok := responses[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(response))
+		}))
+	})
+
+	AfterEach(func() {
+		if credentialsFile != "" {
+			os.Remove(credentialsFile)
+			credentialsFile = ""
+		}
+		server.Close()
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	mockDmidecode := func() {
+		u, err := url.Parse(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		host, port, err := net.SplitHostPort(u.Host)
+		Expect(err).NotTo(HaveOccurred())
+		dependencies.On("Execute", "dmidecode", "-t", "42").Return(fmt.Sprintf(dmidecodeHostInterfaceTemplate, host, port), "", 0).Once()
+	}
+
+	mockUSBInterface := func(flags net.Flags, addrs []string) {
+		dependencies.On("Interfaces").Return([]util.Interface{
+			util.NewFilledMockInterface(1500, "eno1", "f8:75:a4:a4:00:fe", net.FlagBroadcast|net.FlagUp, []string{"10.0.0.18/24"}, 1000, "physical"),
+			util.NewFilledMockInterface(1500, "usb0", "0a:00:00:00:00:02", flags, addrs, 480, "physical"),
+		}, nil).Once()
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/net/eno1/device/../idVendor":  "8086\n",
+			"/sys/class/net/eno1/device/../idProduct": "1572\n",
+			"/sys/class/net/usb0/device/../idVendor":  "046b\n",
+			"/sys/class/net/usb0/device/../idProduct": "ffb0\n",
+		})
+	}
+
+	mockHostInterface := func() {
+		mockDmidecode()
+		mockUSBInterface(net.FlagBroadcast|net.FlagUp, []string{"169.254.95.120/24"})
+	}
+
+	useCredentials := func(credentials string) {
+		f, err := os.CreateTemp("", "redfish-*.yaml")
+		Expect(err).ToNot(HaveOccurred())
+		credentialsFile = f.Name()
+		_, err = f.WriteString(credentials)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+		inventoryConfig.RedfishCredentialsFile = f.Name()
+	}
+
+	It("reports the BMC found through the host interface", func() {
+		useCredentials("username: root\npassword: calvin\n")
+		mockHostInterface()
+		Expect(GetRedfishBMC(inventoryConfig, dependencies)).To(Equal(&BMC{
+			Source:          "redfish",
+			IPV4Addresses:   []string{"10.16.218.144"},
+			IPV6Addresses:   []string{"2001:db8::10"},
+			MacAddress:      "4c:d9:8f:03:e8:74",
+			Vendor:          "Lenovo",
+			Model:           "XCC",
+			FirmwareVersion: "TGBT48K",
+			Hostname:        "XCC-7X06-J30A1B2C",
+		}))
+		Expect(sessions).To(BeEmpty())
+	})
+
+	It("reports the manager when its interfaces can't be read", func() {
+		useCredentials("username: root\npassword: calvin\n")
+		delete(responses, "/redfish/v1/Managers/1/EthernetInterfaces")
+		mockHostInterface()
+		bmc := GetRedfishBMC(inventoryConfig, dependencies)
+		Expect(bmc).NotTo(BeNil())
+		Expect(bmc.Vendor).To(Equal("Lenovo"))
+		Expect(bmc.IPV4Addresses).To(BeEmpty())
+	})
+
+	It("returns nil when the service requires authentication and there are no credentials", func() {
+		mockHostInterface()
+		Expect(GetRedfishBMC(inventoryConfig, dependencies)).To(BeNil())
+	})
+
+	It("returns nil when the credentials are rejected", func() {
+		useCredentials("username: root\npassword: wrong\n")
+		mockHostInterface()
+		Expect(GetRedfishBMC(inventoryConfig, dependencies)).To(BeNil())
+	})
+
+	It("doesn't query the service when the host interface is down", func() {
+		useCredentials("username: root\npassword: calvin\n")
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Fail("unexpected request to the Redfish service")
+		})
+		mockDmidecode()
+		mockUSBInterface(net.FlagBroadcast, nil)
+		Expect(GetRedfishBMC(inventoryConfig, dependencies)).To(BeNil())
+	})
+
+	It("doesn't query the service when the host interface has no address", func() {
+		useCredentials("username: root\npassword: calvin\n")
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Fail("unexpected request to the Redfish service")
+		})
+		mockDmidecode()
+		mockUSBInterface(net.FlagBroadcast|net.FlagUp, nil)
+		Expect(GetRedfishBMC(inventoryConfig, dependencies)).To(BeNil())
+	})
+
+	It("returns nil without a Redfish host interface", func() {
+		dependencies.On("Execute", "dmidecode", "-t", "42").Return("# dmidecode 3.3\n", "", 0).Once()
+		Expect(GetRedfishBMC(inventoryConfig, dependencies)).To(BeNil())
+	})
+
+	It("returns nil when dmidecode fails", func() {
+		dependencies.On("Execute", "dmidecode", "-t", "42").Return("", "permission denied", 1).Once()
+		Expect(GetRedfishBMC(inventoryConfig, dependencies)).To(BeNil())
+	})
+
+	It("is skipped in dry run", func() {
+		inventoryConfig.DryRunEnabled = true
+		Expect(GetRedfishBMC(inventoryConfig, dependencies)).To(BeNil())
+	})
+
+	Context("parseRedfishHostInterfaces", func() {
+		It("uses the default port implicitly", func() {
+			output := fmt.Sprintf(dmidecodeHostInterfaceTemplate, "169.254.95.118", "443")
+			Expect(parseRedfishHostInterfaces(output)).To(Equal([]*redfishHostInterface{
+				{URL: "https://169.254.95.118", USBVendorID: "046b", USBProductID: "ffb0"},
+			}))
+		})
+
+		It("brackets IPv6 service addresses", func() {
+			output := "Handle 0x0054, DMI type 42, 129 bytes\n" +
+				"\tProtocol ID: 04 (Redfish over IP)\n" +
+				"\t\tIPv6 Redfish Service Address: fe80::9e5c:8eff:fe7a:1\n" +
+				"\t\tRedfish Service Port: 8443\n"
+			Expect(parseRedfishHostInterfaces(output)).To(Equal([]*redfishHostInterface{
+				{URL: "https://[fe80::9e5c:8eff:fe7a:1]:8443"},
+			}))
+		})
+
+		It("ignores other protocols", func() {
+			output := "Handle 0x0054, DMI type 42, 129 bytes\n" +
+				"\tProtocol ID: 02 (IPMI)\n" +
+				"\t\tIPv4 Redfish Service Address: 169.254.95.118\n"
+			Expect(parseRedfishHostInterfaces(output)).To(BeEmpty())
+		})
+	})
+
+	Context("getBMCAddresses", func() {
+		It("doesn't run ipmitool when Redfish reports the addresses", func() {
+			useCredentials("username: root\npassword: calvin\n")
+			mockHostInterface()
+			ret := getBMCAddresses(inventoryConfig, dependencies)
+			Expect(ret.bmc).NotTo(BeNil())
+			Expect(ret.v4Address).To(Equal("10.16.218.144"))
+			Expect(ret.v6Address).To(Equal("2001:db8::10"))
+		})
+
+		It("keeps the default address of a family Redfish doesn't report", func() {
+			responses["/redfish/v1/Managers/1/EthernetInterfaces/NIC"] = `{"IPv4Addresses": [{"Address": "10.16.218.144"}]}`
+			useCredentials("username: root\npassword: calvin\n")
+			mockHostInterface()
+			ret := getBMCAddresses(inventoryConfig, dependencies)
+			Expect(ret.v4Address).To(Equal("10.16.218.144"))
+			Expect(ret.v6Address).To(Equal("::/0"))
+		})
+
+		It("falls back to ipmitool without Redfish", func() {
+			dependencies.On("Execute", "dmidecode", "-t", "42").Return("# dmidecode 3.3\n", "", 0).Once()
+			dependencies.On("Execute", "ipmitool", "lan", "print", "1").Return(bmcV4OkAnswer, "", 0).Once()
+			dependencies.On("Execute", "ipmitool", "lan6", "print", mock.Anything, "enables").
+				Return("", "Invalid data field in request", 1).Times(MaxIpmiChannel)
+			ret := getBMCAddresses(inventoryConfig, dependencies)
+			Expect(ret.bmc).To(BeNil())
+			Expect(ret.v4Address).To(Equal("10.16.218.144"))
+			Expect(ret.v6Address).To(Equal("::/0"))
+		})
+	})
+})
@@ -63,6 +63,8 @@ func GetRoutes(dependencies util.IDependencies) []*models.Route {
 	return routes
 }
 
+// getIPRoutes returns the routes of the main table. The route model has a single gateway, so ECMP
+// routes are reported with their first next hop; all of them are in the routing tables.
 func getIPRoutes(h handler) ([]*models.Route, error) {
 	rList, err := h.getRouteList()
 	if err != nil {
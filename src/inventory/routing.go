@@ -0,0 +1,251 @@
+package inventory
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// routingTableNames are the names of the tables reserved by the kernel
+var routingTableNames = map[int]string{
+	unix.RT_TABLE_DEFAULT: "default",
+	unix.RT_TABLE_MAIN:    "main",
+	unix.RT_TABLE_LOCAL:   "local",
+}
+
+var routeTypeNames = map[int]string{
+	unix.RTN_UNICAST:     "unicast",
+	unix.RTN_LOCAL:       "local",
+	unix.RTN_BROADCAST:   "broadcast",
+	unix.RTN_ANYCAST:     "anycast",
+	unix.RTN_MULTICAST:   "multicast",
+	unix.RTN_BLACKHOLE:   "blackhole",
+	unix.RTN_UNREACHABLE: "unreachable",
+	unix.RTN_PROHIBIT:    "prohibit",
+	unix.RTN_THROW:       "throw",
+	unix.RTN_NAT:         "nat",
+}
+
+// Routing is the policy routing configuration of the host: the routing tables, the rules that
+// select them and the VRFs. The routes of the inventory model only describe the main table and,
+// for ECMP routes, only their first next hop; the other next hops are only reported here. The
+// local table is left out, it only holds the routes the kernel adds for the host addresses.
+type Routing struct {
+	Tables []*RoutingTable `json:"tables,omitempty"`
+	Rules  []*RoutingRule  `json:"rules,omitempty"`
+	VRFs   []*VRF          `json:"vrfs,omitempty"`
+}
+
+type RoutingTable struct {
+	ID     int           `json:"id"`
+	Name   string        `json:"name,omitempty"`
+	Routes []*TableRoute `json:"routes"`
+}
+
+type TableRoute struct {
+	Family int `json:"family"`
+	// Destination is the destination in CIDR notation, 0.0.0.0/0 or ::/0 for default routes
+	Destination string `json:"destination"`
+	Gateway     string `json:"gateway,omitempty"`
+	Interface   string `json:"interface,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Metric      int    `json:"metric"`
+	Type        string `json:"type,omitempty"`
+	Protocol    string `json:"protocol,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+	// Nexthops are the paths of ECMP routes, traffic is balanced between them according to their
+	// weights
+	Nexthops []*Nexthop `json:"nexthops,omitempty"`
+}
+
+type Nexthop struct {
+	Gateway   string `json:"gateway,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	Weight    int    `json:"weight"`
+}
+
+// RoutingRule is a policy routing rule, as listed by ip rule.
+type RoutingRule struct {
+	Family          int    `json:"family"`
+	Priority        int    `json:"priority"`
+	Table           int    `json:"table,omitempty"`
+	Source          string `json:"source,omitempty"`
+	Destination     string `json:"destination,omitempty"`
+	InputInterface  string `json:"input_interface,omitempty"`
+	OutputInterface string `json:"output_interface,omitempty"`
+	FwMark          string `json:"fwmark,omitempty"`
+	Goto            int    `json:"goto,omitempty"`
+	Invert          bool   `json:"invert,omitempty"`
+}
+
+// VRF is a virtual routing and forwarding device, its interfaces use its routing table.
+type VRF struct {
+	Name       string   `json:"name"`
+	Table      int      `json:"table"`
+	Interfaces []string `json:"interfaces,omitempty"`
+}
+
+type routingHandler interface {
+	getRouteList(family int) ([]netlink.Route, error)
+	getRuleList(family int) ([]netlink.Rule, error)
+	getLinkList() ([]netlink.Link, error)
+}
+
+type netlinkRoutingHandler struct{}
+
+func (netlinkRoutingHandler) getRouteList(family int) ([]netlink.Route, error) {
+	return netlink.RouteListFiltered(family, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+}
+
+func (netlinkRoutingHandler) getRuleList(family int) ([]netlink.Rule, error) {
+	return netlink.RuleList(family)
+}
+
+func (netlinkRoutingHandler) getLinkList() ([]netlink.Link, error) {
+	return netlink.LinkList()
+}
+
+func ipNetString(ipNet *net.IPNet, family int) string {
+	if ipNet == nil {
+		if family == unix.AF_INET6 {
+			return "::/0"
+		}
+		return "0.0.0.0/0"
+	}
+	return ipNet.String()
+}
+
+func newTableRoute(r *netlink.Route, family int, linkNames map[int]string) *TableRoute {
+	ret := &TableRoute{
+		Family:      family,
+		Destination: ipNetString(r.Dst, family),
+		Interface:   linkNames[r.LinkIndex],
+		Metric:      r.Priority,
+		Type:        routeTypeNames[r.Type],
+		Protocol:    r.Protocol.String(),
+		Scope:       r.Scope.String(),
+	}
+	if r.Gw != nil {
+		ret.Gateway = r.Gw.String()
+	}
+	if r.Src != nil {
+		ret.Source = r.Src.String()
+	}
+	for _, hop := range r.MultiPath {
+		nexthop := &Nexthop{
+			Interface: linkNames[hop.LinkIndex],
+			// The kernel stores the weight minus one
+			Weight: hop.Hops + 1,
+		}
+		if hop.Gw != nil {
+			nexthop.Gateway = hop.Gw.String()
+		}
+		ret.Nexthops = append(ret.Nexthops, nexthop)
+	}
+	return ret
+}
+
+func newRoutingRule(r *netlink.Rule, family int) *RoutingRule {
+	ret := &RoutingRule{
+		Family:          family,
+		Priority:        r.Priority,
+		Table:           r.Table,
+		InputInterface:  r.IifName,
+		OutputInterface: r.OifName,
+		Invert:          r.Invert,
+	}
+	if r.Src != nil {
+		ret.Source = r.Src.String()
+	}
+	if r.Dst != nil {
+		ret.Destination = r.Dst.String()
+	}
+	if r.Mark > 0 {
+		ret.FwMark = fmt.Sprintf("0x%x", r.Mark)
+		if r.Mask > 0 && uint32(r.Mask) != 0xffffffff { //nolint: gosec
+			ret.FwMark = fmt.Sprintf("0x%x/0x%x", r.Mark, r.Mask)
+		}
+	}
+	if r.Goto > 0 {
+		ret.Goto = r.Goto
+	}
+	return ret
+}
+
+func getVRFs(links []netlink.Link) []*VRF {
+	var ret []*VRF
+	for _, link := range links {
+		vrf, ok := link.(*netlink.Vrf)
+		if !ok {
+			continue
+		}
+		v := &VRF{Name: vrf.Name, Table: int(vrf.Table)}
+		for _, member := range links {
+			if member.Attrs().MasterIndex == vrf.Index {
+				v.Interfaces = append(v.Interfaces, member.Attrs().Name)
+			}
+		}
+		sort.Strings(v.Interfaces)
+		ret = append(ret, v)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+func getRouting(h routingHandler) *Routing {
+	ret := &Routing{}
+	links, err := h.getLinkList()
+	if err != nil {
+		logrus.WithError(err).Warn("Unable to list the links")
+	}
+	linkNames := map[int]string{}
+	for _, link := range links {
+		linkNames[link.Attrs().Index] = link.Attrs().Name
+	}
+	ret.VRFs = getVRFs(links)
+
+	tables := map[int]*RoutingTable{}
+	for _, family := range []int{unix.AF_INET, unix.AF_INET6} {
+		routes, err := h.getRouteList(family)
+		if err != nil {
+			logrus.WithError(err).Warnf("Unable to list the routes of family %d", family)
+		}
+		for i := range routes {
+			if routes[i].Table == unix.RT_TABLE_LOCAL {
+				continue
+			}
+			table, ok := tables[routes[i].Table]
+			if !ok {
+				table = &RoutingTable{ID: routes[i].Table, Name: routingTableNames[routes[i].Table]}
+				tables[routes[i].Table] = table
+			}
+			table.Routes = append(table.Routes, newTableRoute(&routes[i], family, linkNames))
+		}
+		rules, err := h.getRuleList(family)
+		if err != nil {
+			logrus.WithError(err).Warnf("Unable to list the rules of family %d", family)
+		}
+		for i := range rules {
+			ret.Rules = append(ret.Rules, newRoutingRule(&rules[i], family))
+		}
+	}
+	for _, vrf := range ret.VRFs {
+		if table, ok := tables[vrf.Table]; ok && table.Name == "" {
+			table.Name = vrf.Name
+		}
+	}
+	for _, table := range tables {
+		ret.Tables = append(ret.Tables, table)
+	}
+	sort.Slice(ret.Tables, func(i, j int) bool { return ret.Tables[i].ID < ret.Tables[j].ID })
+	return ret
+}
+
+// GetRouting returns the routes of all the routing tables, the policy routing rules and the VRFs
+func GetRouting() *Routing {
+	return getRouting(netlinkRoutingHandler{})
+}
@@ -0,0 +1,126 @@
+package inventory
+
+import (
+	"errors"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+type testRoutingHandler struct {
+	routes map[int][]netlink.Route
+	rules  map[int][]netlink.Rule
+	links  []netlink.Link
+}
+
+func (th testRoutingHandler) getRouteList(family int) ([]netlink.Route, error) {
+	return th.routes[family], nil
+}
+
+func (th testRoutingHandler) getRuleList(family int) ([]netlink.Rule, error) {
+	if th.rules == nil {
+		return nil, errors.New("operation not supported")
+	}
+	return th.rules[family], nil
+}
+
+func (th testRoutingHandler) getLinkList() ([]netlink.Link, error) {
+	return th.links, nil
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, ret, err := net.ParseCIDR(cidr)
+	Expect(err).ToNot(HaveOccurred())
+	return ret
+}
+
+var _ = Describe("Routing", func() {
+	It("reports all the tables, the rules and the VRFs", func() {
+		handler := testRoutingHandler{
+			links: []netlink.Link{
+				&netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 2, Name: "eno1"}},
+				&netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 3, Name: "eno2"}},
+				&netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 4, Name: "ens3", MasterIndex: 10}},
+				&netlink.Vrf{LinkAttrs: netlink.LinkAttrs{Index: 10, Name: "storage"}, Table: 100},
+			},
+			routes: map[int][]netlink.Route{
+				unix.AF_INET: {
+					{Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST, Protocol: unix.RTPROT_STATIC, Priority: 100,
+						MultiPath: []*netlink.NexthopInfo{
+							{LinkIndex: 2, Gw: net.IPv4(10, 0, 0, 1), Hops: 0},
+							{LinkIndex: 3, Gw: net.IPv4(10, 0, 1, 1), Hops: 2},
+						}},
+					{Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST, Protocol: unix.RTPROT_KERNEL, Scope: netlink.SCOPE_LINK,
+						LinkIndex: 2, Dst: mustParseCIDR("10.0.0.0/24"), Src: net.IPv4(10, 0, 0, 5), Priority: 100},
+					{Table: 100, Type: unix.RTN_UNICAST, Protocol: unix.RTPROT_STATIC, LinkIndex: 4, Gw: net.IPv4(192, 168, 50, 1)},
+					{Table: 200, Type: unix.RTN_BLACKHOLE, Protocol: unix.RTPROT_BOOT, Dst: mustParseCIDR("172.16.0.0/12")},
+					{Table: unix.RT_TABLE_LOCAL, Type: unix.RTN_LOCAL, Protocol: unix.RTPROT_KERNEL, Scope: netlink.SCOPE_HOST,
+						LinkIndex: 2, Dst: mustParseCIDR("10.0.0.5/32"), Src: net.IPv4(10, 0, 0, 5)},
+				},
+				unix.AF_INET6: {
+					{Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST, Protocol: unix.RTPROT_RA, LinkIndex: 2,
+						Gw: net.ParseIP("fe80::1"), Priority: 1024},
+				},
+			},
+			rules: map[int][]netlink.Rule{
+				unix.AF_INET: {
+					{Priority: 0, Table: unix.RT_TABLE_LOCAL, Mark: -1, Mask: -1, Goto: -1},
+					{Priority: 100, Table: 200, Src: mustParseCIDR("10.0.0.0/24"), Mark: 0x10, Mask: 0xff, Goto: -1},
+					{Priority: 1000, Table: 100, IifName: "storage", Mark: -1, Mask: -1, Goto: -1},
+					{Priority: 32766, Table: unix.RT_TABLE_MAIN, Mark: -1, Mask: -1, Goto: -1},
+				},
+				unix.AF_INET6: {
+					{Priority: 32766, Table: unix.RT_TABLE_MAIN, Dst: mustParseCIDR("2001:db8::/32"), Invert: true, Mark: -1, Mask: -1, Goto: -1},
+				},
+			},
+		}
+
+		Expect(getRouting(handler)).To(Equal(&Routing{
+			Tables: []*RoutingTable{
+				{ID: 100, Name: "storage", Routes: []*TableRoute{
+					{Family: unix.AF_INET, Destination: "0.0.0.0/0", Gateway: "192.168.50.1", Interface: "ens3", Type: "unicast",
+						Protocol: "static", Scope: "universe"},
+				}},
+				{ID: 200, Routes: []*TableRoute{
+					{Family: unix.AF_INET, Destination: "172.16.0.0/12", Type: "blackhole", Protocol: "boot", Scope: "universe"},
+				}},
+				{ID: unix.RT_TABLE_MAIN, Name: "main", Routes: []*TableRoute{
+					{Family: unix.AF_INET, Destination: "0.0.0.0/0", Metric: 100, Type: "unicast", Protocol: "static", Scope: "universe",
+						Nexthops: []*Nexthop{
+							{Gateway: "10.0.0.1", Interface: "eno1", Weight: 1},
+							{Gateway: "10.0.1.1", Interface: "eno2", Weight: 3},
+						}},
+					{Family: unix.AF_INET, Destination: "10.0.0.0/24", Interface: "eno1", Source: "10.0.0.5", Metric: 100, Type: "unicast",
+						Protocol: "kernel", Scope: "link"},
+					{Family: unix.AF_INET6, Destination: "::/0", Gateway: "fe80::1", Interface: "eno1", Metric: 1024, Type: "unicast",
+						Protocol: "ra", Scope: "universe"},
+				}},
+			},
+			Rules: []*RoutingRule{
+				{Family: unix.AF_INET, Priority: 0, Table: unix.RT_TABLE_LOCAL},
+				{Family: unix.AF_INET, Priority: 100, Table: 200, Source: "10.0.0.0/24", FwMark: "0x10/0xff"},
+				{Family: unix.AF_INET, Priority: 1000, Table: 100, InputInterface: "storage"},
+				{Family: unix.AF_INET, Priority: 32766, Table: unix.RT_TABLE_MAIN},
+				{Family: unix.AF_INET6, Priority: 32766, Table: unix.RT_TABLE_MAIN, Destination: "2001:db8::/32", Invert: true},
+			},
+			VRFs: []*VRF{{Name: "storage", Table: 100, Interfaces: []string{"ens3"}}},
+		}))
+	})
+
+	It("reports the routes when the rules can't be listed", func() {
+		handler := testRoutingHandler{
+			routes: map[int][]netlink.Route{
+				unix.AF_INET: {{Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST, Protocol: unix.RTPROT_KERNEL, Scope: netlink.SCOPE_LINK, Dst: mustParseCIDR("10.0.0.0/24")}},
+			},
+		}
+		routing := getRouting(handler)
+		Expect(routing.Rules).To(BeEmpty())
+		Expect(routing.VRFs).To(BeEmpty())
+		Expect(routing.Tables).To(Equal([]*RoutingTable{{ID: unix.RT_TABLE_MAIN, Name: "main", Routes: []*TableRoute{
+			{Family: unix.AF_INET, Destination: "10.0.0.0/24", Type: "unicast", Protocol: "kernel", Scope: "link"},
+		}}}))
+	})
+})
@@ -0,0 +1,54 @@
+package inventory
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/openshift/assisted-installer-agent/src/util"
+)
+
+// sysfsReader reads the attributes of sysfs objects through the dependencies. The collectors that
+// read sysfs embed it. Drivers only create the attributes they support, so a missing attribute
+// reads as an empty value rather than an error.
+type sysfsReader struct {
+	dependencies util.IDependencies
+}
+
+// readString returns the value of the attribute without the trailing new line.
+func (s sysfsReader) readString(fname string) string {
+	b, err := s.dependencies.ReadFile(fname)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// readInt returns the integer value of the attribute, false if it is missing or isn't a number.
+func (s sysfsReader) readInt(fname string) (int64, bool) {
+	value, err := strconv.ParseInt(s.readString(fname), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// readFloat returns the value of the attribute divided by the divisor, for example 1000 to convert
+// the millidegrees of a temperature sensor. Returns false if it is missing or isn't a number.
+func (s sysfsReader) readFloat(fname string, divisor float64) (float64, bool) {
+	value, err := strconv.ParseFloat(s.readString(fname), 64)
+	if err != nil {
+		return 0, false
+	}
+	return value / divisor, true
+}
+
+// readLink returns the name of the sysfs object the link points to, for example the driver or the
+// IOMMU group of a device.
+func (s sysfsReader) readLink(fname string) string {
+	target, err := s.dependencies.EvalSymlinks(fname)
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
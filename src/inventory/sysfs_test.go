@@ -0,0 +1,58 @@
+package inventory
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/util"
+)
+
+var _ = Describe("sysfs reader", func() {
+	var (
+		dependencies *util.MockIDependencies
+		reader       sysfsReader
+	)
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+		reader = sysfsReader{dependencies: dependencies}
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/hwmon/hwmon0/name":        "coretemp\n",
+			"/sys/class/hwmon/hwmon0/temp1_input": "45000\n",
+			"/sys/class/hwmon/hwmon0/temp1_label": "Package id 0\n",
+		})
+	})
+
+	AfterEach(func() {
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	It("reads the attributes without the new line", func() {
+		Expect(reader.readString("/sys/class/hwmon/hwmon0/name")).To(Equal("coretemp"))
+		Expect(reader.readString("/sys/class/hwmon/hwmon0/temp1_max")).To(BeEmpty())
+	})
+
+	It("reads numbers", func() {
+		value, ok := reader.readInt("/sys/class/hwmon/hwmon0/temp1_input")
+		Expect(ok).To(BeTrue())
+		Expect(value).To(Equal(int64(45000)))
+		temperature, ok := reader.readFloat("/sys/class/hwmon/hwmon0/temp1_input", 1000)
+		Expect(ok).To(BeTrue())
+		Expect(temperature).To(Equal(45.0))
+	})
+
+	It("tells when an attribute isn't a number", func() {
+		_, ok := reader.readInt("/sys/class/hwmon/hwmon0/temp1_label")
+		Expect(ok).To(BeFalse())
+		_, ok = reader.readFloat("/sys/class/hwmon/hwmon0/temp1_max", 1000)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("reads the name of the object a link points to", func() {
+		dependencies.On("EvalSymlinks", "/sys/bus/pci/devices/0000:3b:00.0/driver").Return("/sys/bus/pci/drivers/mlx5_core", nil).Once()
+		dependencies.On("EvalSymlinks", "/sys/bus/pci/devices/0000:3b:00.0/iommu_group").Return("", errors.New("no such file")).Once()
+		Expect(reader.readLink(pciDeviceAttribute("0000:3b:00.0", "driver"))).To(Equal("mlx5_core"))
+		Expect(reader.readLink(pciDeviceAttribute("0000:3b:00.0", "iommu_group"))).To(BeEmpty())
+	})
+})
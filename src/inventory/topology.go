@@ -0,0 +1,162 @@
+package inventory
+
+import (
+	"sort"
+
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// InterfaceTopology describes how an interface is related to the other interfaces of the host: the
+// members of a bond, bridge or team, the parent of a VLAN, and the interface it is a member of.
+type InterfaceTopology struct {
+	// Master is the bond, bridge or team the interface is a member of
+	Master string          `json:"master,omitempty"`
+	Bond   *BondTopology   `json:"bond,omitempty"`
+	VLAN   *VLANTopology   `json:"vlan,omitempty"`
+	Bridge *BridgeTopology `json:"bridge,omitempty"`
+	Team   *TeamTopology   `json:"team,omitempty"`
+}
+
+type BondTopology struct {
+	Mode        string        `json:"mode"`
+	Miimon      int           `json:"miimon"`
+	ActiveSlave string        `json:"active_slave,omitempty"`
+	Members     []*BondMember `json:"members"`
+}
+
+type BondMember struct {
+	Name             string `json:"name"`
+	LinkState        string `json:"link_state"`
+	State            string `json:"state,omitempty"`
+	MiiStatus        string `json:"mii_status,omitempty"`
+	LinkFailureCount uint32 `json:"link_failure_count"`
+}
+
+type VLANTopology struct {
+	ID       int    `json:"id"`
+	Protocol string `json:"protocol"`
+	Parent   string `json:"parent,omitempty"`
+}
+
+type BridgeTopology struct {
+	Ports []string `json:"ports"`
+}
+
+// TeamTopology lists the ports of a team. The runner configuration is only known by teamd, it
+// isn't available through netlink.
+type TeamTopology struct {
+	Ports []string `json:"ports"`
+}
+
+type topology struct {
+	dependencies util.IDependencies
+}
+
+func newTopology(dependencies util.IDependencies) *topology {
+	return &topology{dependencies: dependencies}
+}
+
+func (t *topology) getLinks() []netlink.Link {
+	ins, err := t.dependencies.Interfaces()
+	if err != nil {
+		logrus.WithError(err).Warn("Retrieving interfaces")
+		return nil
+	}
+	var ret []netlink.Link
+	for _, in := range ins {
+		link, err := t.dependencies.LinkByName(in.Name())
+		if err != nil || link.Attrs() == nil {
+			logrus.WithError(err).Warnf("Could not find netlink for interface %s", in.Name())
+			continue
+		}
+		ret = append(ret, link)
+	}
+	return ret
+}
+
+func (t *topology) getTopology() map[string]*InterfaceTopology {
+	links := t.getLinks()
+	names := map[int]string{}
+	members := map[string][]netlink.Link{}
+	for _, link := range links {
+		names[link.Attrs().Index] = link.Attrs().Name
+	}
+	for _, link := range links {
+		if master, ok := names[link.Attrs().MasterIndex]; ok && link.Attrs().MasterIndex != 0 {
+			members[master] = append(members[master], link)
+		}
+	}
+
+	ret := map[string]*InterfaceTopology{}
+	get := func(name string) *InterfaceTopology {
+		if ret[name] == nil {
+			ret[name] = &InterfaceTopology{}
+		}
+		return ret[name]
+	}
+	for _, link := range links {
+		attrs := link.Attrs()
+		if attrs.MasterIndex != 0 {
+			if master, ok := names[attrs.MasterIndex]; ok {
+				get(attrs.Name).Master = master
+			}
+		}
+		ports := members[attrs.Name]
+		portNames := make([]string, 0, len(ports))
+		for _, port := range ports {
+			portNames = append(portNames, port.Attrs().Name)
+		}
+		sort.Strings(portNames)
+
+		switch l := link.(type) {
+		case *netlink.Bond:
+			bond := &BondTopology{
+				Mode:        l.Mode.String(),
+				Miimon:      l.Miimon,
+				ActiveSlave: names[l.ActiveSlave],
+				Members:     make([]*BondMember, 0, len(ports)),
+			}
+			for _, port := range ports {
+				member := &BondMember{Name: port.Attrs().Name, LinkState: port.Attrs().OperState.String()}
+				if slave, ok := port.Attrs().Slave.(*netlink.BondSlave); ok {
+					member.State = slave.State.String()
+					member.MiiStatus = slave.MiiStatus.String()
+					member.LinkFailureCount = slave.LinkFailureCount
+				}
+				bond.Members = append(bond.Members, member)
+			}
+			sort.Slice(bond.Members, func(i, j int) bool { return bond.Members[i].Name < bond.Members[j].Name })
+			get(attrs.Name).Bond = bond
+		case *netlink.Vlan:
+			get(attrs.Name).VLAN = &VLANTopology{
+				ID:       l.VlanId,
+				Protocol: l.VlanProtocol.String(),
+				Parent:   names[attrs.ParentIndex],
+			}
+		case *netlink.Bridge:
+			get(attrs.Name).Bridge = &BridgeTopology{Ports: portNames}
+		default:
+			if link.Type() == "team" {
+				get(attrs.Name).Team = &TeamTopology{Ports: portNames}
+			}
+		}
+	}
+	return ret
+}
+
+// GetTopology returns the L2 topology of the interfaces that are related to other interfaces, keyed
+// by interface name.
+func GetTopology(dependencies util.IDependencies) map[string]*InterfaceTopology {
+	return newTopology(dependencies).getTopology()
+}
+
+// applyTopology attaches the topology to the interfaces.
+func applyTopology(inventory *Inventory) {
+	for _, in := range inventory.Interfaces {
+		if topology, ok := inventory.topology[in.Name]; ok {
+			in.Topology = topology
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package inventory
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/openshift/assisted-service/models"
+	"github.com/vishvananda/netlink"
+)
+
+var _ = Describe("Topology", func() {
+	var dependencies *util.MockIDependencies
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+	})
+
+	AfterEach(func() {
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	mockLinks := func(links ...netlink.Link) {
+		var ins []util.Interface
+		for _, link := range links {
+			in := &util.MockInterface{}
+			in.On("Name").Return(link.Attrs().Name)
+			ins = append(ins, in)
+			dependencies.On("LinkByName", link.Attrs().Name).Return(link, nil).Once()
+		}
+		dependencies.On("Interfaces").Return(ins, nil).Once()
+	}
+
+	It("reports bonds, VLANs, bridges and teams", func() {
+		mockLinks(
+			&netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 2, Name: "eno1", MasterIndex: 10, OperState: netlink.OperUp,
+				Slave: &netlink.BondSlave{State: netlink.BondStateActive, MiiStatus: netlink.BondLinkUp}}},
+			&netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 3, Name: "eno2", MasterIndex: 10, OperState: netlink.OperDown,
+				Slave: &netlink.BondSlave{State: netlink.BondStateBackup, MiiStatus: netlink.BondLinkDown, LinkFailureCount: 3}}},
+			&netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 4, Name: "eno3", MasterIndex: 12}},
+			&netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 5, Name: "eno4", MasterIndex: 13}},
+			&netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 6, Name: "eno5", MasterIndex: 13}},
+			&netlink.Bond{LinkAttrs: netlink.LinkAttrs{Index: 10, Name: "bond0"}, Mode: netlink.BOND_MODE_ACTIVE_BACKUP,
+				Miimon: 100, ActiveSlave: 2},
+			&netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Index: 11, Name: "bond0.100", ParentIndex: 10}, VlanId: 100,
+				VlanProtocol: netlink.VLAN_PROTOCOL_8021Q},
+			&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Index: 12, Name: "br-ex"}},
+			&netlink.GenericLink{LinkAttrs: netlink.LinkAttrs{Index: 13, Name: "team0"}, LinkType: "team"},
+			&netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 14, Name: "eno6"}},
+		)
+
+		Expect(GetTopology(dependencies)).To(Equal(map[string]*InterfaceTopology{
+			"eno1": {Master: "bond0"},
+			"eno2": {Master: "bond0"},
+			"eno3": {Master: "br-ex"},
+			"eno4": {Master: "team0"},
+			"eno5": {Master: "team0"},
+			"bond0": {Bond: &BondTopology{
+				Mode:        "active-backup",
+				Miimon:      100,
+				ActiveSlave: "eno1",
+				Members: []*BondMember{
+					{Name: "eno1", LinkState: "up", State: "ACTIVE", MiiStatus: "UP"},
+					{Name: "eno2", LinkState: "down", State: "BACKUP", MiiStatus: "DOWN", LinkFailureCount: 3},
+				},
+			}},
+			"bond0.100": {VLAN: &VLANTopology{ID: 100, Protocol: "802.1q", Parent: "bond0"}},
+			"br-ex":     {Bridge: &BridgeTopology{Ports: []string{"eno3"}}},
+			"team0":     {Team: &TeamTopology{Ports: []string{"eno4", "eno5"}}},
+		}))
+	})
+
+	It("reports empty bonds and bridges", func() {
+		mockLinks(
+			&netlink.Bond{LinkAttrs: netlink.LinkAttrs{Index: 10, Name: "bond0"}, Mode: netlink.BOND_MODE_802_3AD},
+			&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Index: 11, Name: "br0"}},
+		)
+		Expect(GetTopology(dependencies)).To(Equal(map[string]*InterfaceTopology{
+			"bond0": {Bond: &BondTopology{Mode: "802.3ad", Members: []*BondMember{}}},
+			"br0":   {Bridge: &BridgeTopology{Ports: []string{}}},
+		}))
+	})
+
+	It("attaches the topology to the interfaces", func() {
+		inventory := &Inventory{
+			Interfaces: []*Interface{
+				{Interface: &models.Interface{Name: "eno1"}},
+				{Interface: &models.Interface{Name: "eno6"}},
+			},
+			topology: map[string]*InterfaceTopology{"eno1": {Master: "bond0"}},
+		}
+		applyTopology(inventory)
+		Expect(inventory.Interfaces[0].Topology.Master).To(Equal("bond0"))
+		Expect(inventory.Interfaces[1].Topology).To(BeNil())
+	})
+})
@@ -0,0 +1,402 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/openshift/assisted-installer-agent/src/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	tpmSysfsPath = "/sys/class/tpm/tpm0"
+	// The security file system isn't mounted in containers, the one of the host is mounted under
+	// /host when it exists
+	tpmEventLogPath = "/host/sys/kernel/security/tpm0/binary_bios_measurements"
+
+	tpmEventLogFormatTCG12       = "tcg_1.2"
+	tpmEventLogFormatCryptoAgile = "crypto_agile"
+	tpmEventLogSpecIDSignature   = "Spec ID Event03\x00"
+	tpmSHA1DigestLength          = 20
+	tpmKernelCommandLinePrefix   = "kernel_cmdline: "
+
+	// tpmEFIImageLoadEventDevicePathOffset is the offset of the device path in the event of a loaded
+	// EFI image
+	tpmEFIImageLoadEventDevicePathOffset = 32
+)
+
+// Event types of the TCG PC Client and EFI platform specifications
+const (
+	tpmEventNoAction                   = 0x00000003
+	tpmEventIPL                        = 0x0000000d
+	tpmEventEFIVariableDriverConfig    = 0x80000001
+	tpmEventEFIBootServicesApplication = 0x80000003
+)
+
+var (
+	tpmPCRBankRegex = regexp.MustCompile(`^pcr-(\w+)$`)
+	tpmCapsRegex    = regexp.MustCompile(`(?m)^(Manufacturer|Firmware version):\s*(\S+)\s*$`)
+
+	// tpmAlgorithms are the names of the TPM hash algorithms, as the kernel names the PCR banks
+	tpmAlgorithms = map[uint16]string{
+		0x0004: "sha1",
+		0x000b: "sha256",
+		0x000c: "sha384",
+		0x000d: "sha512",
+		0x0012: "sm3_256",
+	}
+)
+
+// TPM is the TPM of the host as described by the kernel.
+type TPM struct {
+	Version string `json:"version"`
+	// Manufacturer and FirmwareVersion are only reported by the kernel for TPM 1.2 devices
+	Manufacturer    string       `json:"manufacturer,omitempty"`
+	FirmwareVersion string       `json:"firmware_version,omitempty"`
+	PCRBanks        []string     `json:"pcr_banks,omitempty"`
+	EventLog        *TPMEventLog `json:"event_log,omitempty"`
+}
+
+// TPMEventLog is the measured boot event log that the firmware passes to the kernel.
+type TPMEventLog struct {
+	// Format is tcg_1.2 for logs with only SHA-1 digests and crypto_agile for logs with the digests
+	// of all the PCR banks
+	Format       string           `json:"format,omitempty"`
+	Algorithms   []string         `json:"algorithms,omitempty"`
+	Events       int              `json:"events"`
+	Parseable    bool             `json:"parseable"`
+	Error        string           `json:"error,omitempty"`
+	Measurements *TPMMeasurements `json:"measurements,omitempty"`
+}
+
+// TPMMeasurements are the components that were measured during the boot.
+type TPMMeasurements struct {
+	// SecureBootVariables are the measured Secure Boot configuration variables, like PK, KEK, db and dbx
+	SecureBootVariables []string `json:"secure_boot_variables,omitempty"`
+	// BootApplications are the device paths of the EFI applications loaded by the firmware, like
+	// shim, the boot loader or a unified kernel image
+	BootApplications  []string `json:"boot_applications,omitempty"`
+	Kernel            string   `json:"kernel,omitempty"`
+	KernelCommandLine string   `json:"kernel_command_line,omitempty"`
+}
+
+type tpmDetails struct {
+	dependencies util.IDependencies
+}
+
+func newTPMDetails(dependencies util.IDependencies) *tpmDetails {
+	return &tpmDetails{dependencies: dependencies}
+}
+
+// decodeTPMManufacturer decodes the manufacturer ID, 4 ASCII characters like IFX or STM.
+func decodeTPMManufacturer(id string) string {
+	b, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(id), "0x"))
+	if err != nil {
+		return id
+	}
+	return strings.TrimSpace(string(bytes.Trim(b, "\x00")))
+}
+
+func (t *tpmDetails) readCaps(ret *TPM) {
+	b, err := t.dependencies.ReadFile(tpmSysfsPath + "/device/caps")
+	if err != nil {
+		return
+	}
+	for _, matches := range tpmCapsRegex.FindAllStringSubmatch(string(b), -1) {
+		switch matches[1] {
+		case "Manufacturer":
+			ret.Manufacturer = decodeTPMManufacturer(matches[2])
+		case "Firmware version":
+			ret.FirmwareVersion = matches[2]
+		}
+	}
+}
+
+func (t *tpmDetails) getPCRBanks() []string {
+	files, err := t.dependencies.ReadDir(tpmSysfsPath)
+	if err != nil {
+		return nil
+	}
+	var ret []string
+	for _, file := range files {
+		if matches := tpmPCRBankRegex.FindStringSubmatch(file.Name()); matches != nil {
+			ret = append(ret, matches[1])
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (t *tpmDetails) getTPM() *TPM {
+	b, err := t.dependencies.ReadFile(tpmSysfsPath + "/tpm_version_major")
+	if err != nil {
+		logrus.WithError(err).Debug("No TPM")
+		return nil
+	}
+	ret := &TPM{Version: strings.TrimSpace(string(b))}
+	switch ret.Version {
+	case "1":
+		ret.Version = "1.2"
+	case "2":
+		ret.Version = "2.0"
+	}
+	t.readCaps(ret)
+	ret.PCRBanks = t.getPCRBanks()
+	if log, err := t.dependencies.ReadFile(tpmEventLogPath); err == nil {
+		ret.EventLog = parseTPMEventLog(log)
+	}
+	// Kernels older than 5.12 don't have the PCR banks in sysfs, the log has those it measured
+	if ret.PCRBanks == nil && ret.EventLog != nil {
+		ret.PCRBanks = ret.EventLog.Algorithms
+	}
+	return ret
+}
+
+// GetTPMDetails returns the TPM of the host and a summary of its measured boot event log, read from
+// sysfs and securityfs.
+func GetTPMDetails(dependencies util.IDependencies) *TPM {
+	return newTPMDetails(dependencies).getTPM()
+}
+
+// tpmEventLogReader reads the little endian fields of the event log.
+type tpmEventLogReader struct {
+	data   []byte
+	offset int
+}
+
+func (r *tpmEventLogReader) read(n int) ([]byte, error) {
+	if n < 0 || r.offset+n > len(r.data) {
+		return nil, fmt.Errorf("event log truncated at offset %d", r.offset)
+	}
+	ret := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return ret, nil
+}
+
+func (r *tpmEventLogReader) uint16() (uint16, error) {
+	b, err := r.read(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (r *tpmEventLogReader) uint32() (uint32, error) {
+	b, err := r.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (r *tpmEventLogReader) done() bool {
+	return r.offset >= len(r.data)
+}
+
+// tpmEvent is an event of the log, without its digests.
+type tpmEvent struct {
+	pcr       uint32
+	eventType uint32
+	data      []byte
+}
+
+// readTCG12Event reads an event with a single SHA-1 digest, the format of all the events of TCG 1.2
+// logs and of the first event of crypto agile logs.
+func (r *tpmEventLogReader) readTCG12Event() (*tpmEvent, error) {
+	ret := &tpmEvent{}
+	var err error
+	if ret.pcr, err = r.uint32(); err != nil {
+		return nil, err
+	}
+	if ret.eventType, err = r.uint32(); err != nil {
+		return nil, err
+	}
+	if _, err = r.read(tpmSHA1DigestLength); err != nil {
+		return nil, err
+	}
+	size, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if ret.data, err = r.read(int(size)); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// readCryptoAgileEvent reads an event with the digests of the algorithms of the log header.
+func (r *tpmEventLogReader) readCryptoAgileEvent(digestSizes map[uint16]int) (*tpmEvent, error) {
+	ret := &tpmEvent{}
+	var err error
+	if ret.pcr, err = r.uint32(); err != nil {
+		return nil, err
+	}
+	if ret.eventType, err = r.uint32(); err != nil {
+		return nil, err
+	}
+	count, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < count; i++ {
+		algorithm, err := r.uint16()
+		if err != nil {
+			return nil, err
+		}
+		size, ok := digestSizes[algorithm]
+		if !ok {
+			return nil, fmt.Errorf("digest of unknown algorithm 0x%04x at offset %d", algorithm, r.offset)
+		}
+		if _, err = r.read(size); err != nil {
+			return nil, err
+		}
+	}
+	size, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if ret.data, err = r.read(int(size)); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// parseSpecIDEvent parses the header of crypto agile logs, and returns the digest sizes of the
+// algorithms of the log.
+func parseSpecIDEvent(data []byte) (map[uint16]int, []string, error) {
+	// Signature, platform class, version, errata and size of UINTN
+	r := &tpmEventLogReader{data: data, offset: len(tpmEventLogSpecIDSignature) + 8}
+	count, err := r.uint32()
+	if err != nil {
+		return nil, nil, err
+	}
+	digestSizes := map[uint16]int{}
+	var algorithms []string
+	for i := uint32(0); i < count; i++ {
+		algorithm, err := r.uint16()
+		if err != nil {
+			return nil, nil, err
+		}
+		size, err := r.uint16()
+		if err != nil {
+			return nil, nil, err
+		}
+		digestSizes[algorithm] = int(size)
+		name, ok := tpmAlgorithms[algorithm]
+		if !ok {
+			name = fmt.Sprintf("0x%04x", algorithm)
+		}
+		algorithms = append(algorithms, name)
+	}
+	return digestSizes, algorithms, nil
+}
+
+// efiVariableName returns the name of the variable of an UEFI_VARIABLE_DATA: its vendor GUID, the
+// lengths of its name and data, and its UCS-2 name.
+func efiVariableName(data []byte) string {
+	if len(data) < 32 {
+		return ""
+	}
+	length := binary.LittleEndian.Uint64(data[16:])
+	if length > uint64(len(data)-32)/2 {
+		return ""
+	}
+	name, _ := decodeUCS2(append(slices.Clone(data[32:32+2*length]), 0, 0))
+	return name
+}
+
+// efiImageDevicePath returns the device path of an UEFI_IMAGE_LOAD_EVENT: the location, length and
+// link time address of the image, the length of the device path and the device path.
+func efiImageDevicePath(data []byte) string {
+	if len(data) < tpmEFIImageLoadEventDevicePathOffset {
+		return ""
+	}
+	length := binary.LittleEndian.Uint64(data[24:])
+	if length > uint64(len(data)-tpmEFIImageLoadEventDevicePathOffset) {
+		return ""
+	}
+	return formatEFIDevicePath(data[tpmEFIImageLoadEventDevicePathOffset : tpmEFIImageLoadEventDevicePathOffset+length])
+}
+
+func (m *TPMMeasurements) add(event *tpmEvent) {
+	switch event.eventType {
+	case tpmEventEFIVariableDriverConfig:
+		if name := efiVariableName(event.data); name != "" && !slices.Contains(m.SecureBootVariables, name) {
+			m.SecureBootVariables = append(m.SecureBootVariables, name)
+		}
+	case tpmEventEFIBootServicesApplication:
+		if path := efiImageDevicePath(event.data); path != "" {
+			m.BootApplications = append(m.BootApplications, path)
+		}
+	case tpmEventIPL:
+		// GRUB measures its commands and the kernel command line to PCR 8, and the files it loads
+		// to PCR 9
+		text := strings.TrimRight(string(event.data), "\x00")
+		switch {
+		case event.pcr == 8 && strings.HasPrefix(text, tpmKernelCommandLinePrefix):
+			m.KernelCommandLine = strings.TrimPrefix(text, tpmKernelCommandLinePrefix)
+		case event.pcr == 9 && strings.Contains(text, "vmlinuz"):
+			m.Kernel = text
+		}
+	}
+}
+
+// parseTPMEventLog parses the binary measured boot event log, in the TCG 1.2 format or in the crypto
+// agile format of TPM 2.0.
+func parseTPMEventLog(data []byte) *TPMEventLog {
+	ret := &TPMEventLog{}
+	measurements := &TPMMeasurements{}
+	err := func() error {
+		r := &tpmEventLogReader{data: data}
+		first, err := r.readTCG12Event()
+		if err != nil {
+			return err
+		}
+		ret.Events++
+		if first.eventType != tpmEventNoAction || !bytes.HasPrefix(first.data, []byte(tpmEventLogSpecIDSignature)) {
+			ret.Format = tpmEventLogFormatTCG12
+			ret.Algorithms = []string{"sha1"}
+			measurements.add(first)
+			for !r.done() {
+				event, err := r.readTCG12Event()
+				if err != nil {
+					return err
+				}
+				ret.Events++
+				measurements.add(event)
+			}
+			return nil
+		}
+		ret.Format = tpmEventLogFormatCryptoAgile
+		digestSizes, algorithms, err := parseSpecIDEvent(first.data)
+		if err != nil {
+			return err
+		}
+		ret.Algorithms = algorithms
+		for !r.done() {
+			event, err := r.readCryptoAgileEvent(digestSizes)
+			if err != nil {
+				return err
+			}
+			ret.Events++
+			measurements.add(event)
+		}
+		return nil
+	}()
+	if err != nil {
+		ret.Error = err.Error()
+	} else {
+		ret.Parseable = true
+	}
+	if measurements.SecureBootVariables != nil || measurements.BootApplications != nil ||
+		measurements.Kernel != "" || measurements.KernelCommandLine != "" {
+		ret.Measurements = measurements
+	}
+	return ret
+}
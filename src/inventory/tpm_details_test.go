@@ -0,0 +1,153 @@
+package inventory
+
+import (
+	"encoding/binary"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-installer-agent/src/util"
+)
+
+const tpm12Caps = `Manufacturer: 0x49465800
+TCG version: 1.2
+Firmware version: 3.17
+`
+
+func tpmTCG12Event(pcr, eventType uint32, data []byte) []byte {
+	ret := binary.LittleEndian.AppendUint32(nil, pcr)
+	ret = binary.LittleEndian.AppendUint32(ret, eventType)
+	ret = append(ret, make([]byte, tpmSHA1DigestLength)...)
+	ret = binary.LittleEndian.AppendUint32(ret, uint32(len(data)))
+	return append(ret, data...)
+}
+
+// tpmCryptoAgileEvent returns an event with SHA-1 and SHA-256 digests.
+func tpmCryptoAgileEvent(pcr, eventType uint32, data []byte) []byte {
+	ret := binary.LittleEndian.AppendUint32(nil, pcr)
+	ret = binary.LittleEndian.AppendUint32(ret, eventType)
+	ret = binary.LittleEndian.AppendUint32(ret, 2)
+	ret = binary.LittleEndian.AppendUint16(ret, 0x0004)
+	ret = append(ret, make([]byte, 20)...)
+	ret = binary.LittleEndian.AppendUint16(ret, 0x000b)
+	ret = append(ret, make([]byte, 32)...)
+	ret = binary.LittleEndian.AppendUint32(ret, uint32(len(data)))
+	return append(ret, data...)
+}
+
+func tpmSpecIDEvent() []byte {
+	data := append([]byte(tpmEventLogSpecIDSignature), 0, 0, 0, 0, 0, 2, 0, 2)
+	data = binary.LittleEndian.AppendUint32(data, 2)
+	data = binary.LittleEndian.AppendUint16(data, 0x0004)
+	data = binary.LittleEndian.AppendUint16(data, 20)
+	data = binary.LittleEndian.AppendUint16(data, 0x000b)
+	data = binary.LittleEndian.AppendUint16(data, 32)
+	return tpmTCG12Event(0, tpmEventNoAction, append(data, 0))
+}
+
+func tpmVariableData(name string) []byte {
+	ucs2 := efiUCS2(name)
+	ret := append(make([]byte, 16), binary.LittleEndian.AppendUint64(nil, uint64(len(ucs2)/2-1))...)
+	ret = binary.LittleEndian.AppendUint64(ret, 1)
+	return append(append(ret, ucs2[:len(ucs2)-2]...), 0x01)
+}
+
+func tpmImageLoadData(nodes ...[]byte) []byte {
+	var path []byte
+	for _, node := range append(nodes, efiDevicePathNode(0x7f, 0xff)) {
+		path = append(path, node...)
+	}
+	ret := binary.LittleEndian.AppendUint64(make([]byte, 24), uint64(len(path)))
+	return append(ret, path...)
+}
+
+func tpmEventLog(events ...[]byte) string {
+	var ret []byte
+	for _, event := range events {
+		ret = append(ret, event...)
+	}
+	return string(ret)
+}
+
+var _ = Describe("TPM details", func() {
+	var dependencies *util.MockIDependencies
+
+	BeforeEach(func() {
+		dependencies = newDependenciesMock()
+	})
+
+	AfterEach(func() {
+		dependencies.AssertExpectations(GinkgoT())
+	})
+
+	It("reports the PCR banks and the measurements of a crypto agile log", func() {
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/tpm/tpm0/tpm_version_major":  "2\n",
+			"/sys/class/tpm/tpm0/pcr-sha256/0":       "0000\n",
+			"/sys/class/tpm/tpm0/pcr-sha1/0":         "0000\n",
+			"/sys/class/tpm/tpm0/device/description": "TPM 2.0 Device\n",
+			tpmEventLogPath: tpmEventLog(
+				tpmSpecIDEvent(),
+				tpmCryptoAgileEvent(7, tpmEventEFIVariableDriverConfig, tpmVariableData("SecureBoot")),
+				tpmCryptoAgileEvent(7, tpmEventEFIVariableDriverConfig, tpmVariableData("PK")),
+				tpmCryptoAgileEvent(7, tpmEventEFIVariableDriverConfig, tpmVariableData("db")),
+				tpmCryptoAgileEvent(4, tpmEventEFIBootServicesApplication, tpmImageLoadData(efiPciRoot, efiPci, efiNVMe, efiGPT, efiShim)),
+				tpmCryptoAgileEvent(8, tpmEventIPL, []byte("kernel_cmdline: (hd0,gpt3)/ostree/rhcos-1/vmlinuz-5.14.0 rw ignition.platform.id=metal\x00")),
+				tpmCryptoAgileEvent(9, tpmEventIPL, []byte("(hd0,gpt3)/ostree/rhcos-1/vmlinuz-5.14.0\x00")),
+				tpmCryptoAgileEvent(9, tpmEventIPL, []byte("(hd0,gpt3)/ostree/rhcos-1/initramfs-5.14.0.img\x00")),
+			),
+		})
+
+		Expect(GetTPMDetails(dependencies)).To(Equal(&TPM{
+			Version:  "2.0",
+			PCRBanks: []string{"sha1", "sha256"},
+			EventLog: &TPMEventLog{
+				Format:     tpmEventLogFormatCryptoAgile,
+				Algorithms: []string{"sha1", "sha256"},
+				Events:     8,
+				Parseable:  true,
+				Measurements: &TPMMeasurements{
+					SecureBootVariables: []string{"SecureBoot", "PK", "db"},
+					BootApplications: []string{`PciRoot(0x0)/Pci(0x1d,0x0)/NVMe(0x1,00-25-38-5a-91-b0-12-34)/` +
+						`HD(1,GPT,c12a7328-f81f-11d2-ba4b-00a0c93ec93b,0x800,0x100000)/File(\EFI\redhat\shimx64.efi)`},
+					Kernel:            "(hd0,gpt3)/ostree/rhcos-1/vmlinuz-5.14.0",
+					KernelCommandLine: "(hd0,gpt3)/ostree/rhcos-1/vmlinuz-5.14.0 rw ignition.platform.id=metal",
+				},
+			},
+		}))
+	})
+
+	It("reports the manufacturer of TPM 1.2 and the banks of the log of old kernels", func() {
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/tpm/tpm0/tpm_version_major": "1\n",
+			"/sys/class/tpm/tpm0/device/caps":       tpm12Caps,
+			tpmEventLogPath:                         tpmEventLog(tpmTCG12Event(0, 0x08, []byte("1.0")), tpmTCG12Event(0, 0x04, make([]byte, 4))),
+		})
+
+		Expect(GetTPMDetails(dependencies)).To(Equal(&TPM{
+			Version:         "1.2",
+			Manufacturer:    "IFX",
+			FirmwareVersion: "3.17",
+			PCRBanks:        []string{"sha1"},
+			EventLog:        &TPMEventLog{Format: tpmEventLogFormatTCG12, Algorithms: []string{"sha1"}, Events: 2, Parseable: true},
+		}))
+	})
+
+	It("reports logs that can't be parsed", func() {
+		log := tpmEventLog(tpmSpecIDEvent(), tpmCryptoAgileEvent(7, tpmEventEFIVariableDriverConfig, tpmVariableData("SecureBoot")))
+		mockSysfs(dependencies, map[string]string{
+			"/sys/class/tpm/tpm0/tpm_version_major": "2",
+			tpmEventLogPath:                         log[:len(log)-10],
+		})
+
+		tpm := GetTPMDetails(dependencies)
+		Expect(tpm.EventLog.Parseable).To(BeFalse())
+		Expect(tpm.EventLog.Error).To(ContainSubstring("truncated"))
+		Expect(tpm.EventLog.Events).To(Equal(1))
+		Expect(tpm.PCRBanks).To(Equal([]string{"sha1", "sha256"}))
+	})
+
+	It("returns nothing without TPM", func() {
+		mockSysfs(dependencies, map[string]string{})
+		Expect(GetTPMDetails(dependencies)).To(BeNil())
+	})
+})